@@ -0,0 +1,225 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/history"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+func printHistoryUsage() {
+	fmt.Printf("Usage: %s history <command> [options]\n\n", os.Args[0])
+	fmt.Println("Commands:")
+	fmt.Println("  list      List archived runs, oldest first")
+	fmt.Println("  changes   Show the runs where the path fingerprint changed, and the hops that did")
+	fmt.Println("  show ID   Extract a run as JSON, e.g. to use with -compare or -input-json")
+	fmt.Println()
+	fmt.Println("Runs are archived by tracing with -history. Run a command with -h for its options.")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("    dublin-traceroute -target example.com -count 5 -history")
+	fmt.Println("    dublin-traceroute history list -target example.com")
+	fmt.Println("    dublin-traceroute history changes -target example.com")
+	fmt.Println("    dublin-traceroute history show -o baseline.json 20261017T081502Z-93.184.215.14")
+}
+
+// runHistory runs a history subcommand and returns the exit status
+func runHistory(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		printHistoryUsage()
+		return 2
+	}
+
+	command := args[0]
+	flags := flag.NewFlagSet("history "+command, flag.ContinueOnError)
+	dir := flags.String("dir", "", "History directory (default: $"+history.EnvDir+" or dublin-traceroute/history in the user configuration directory)")
+	var targetName, output *string
+	var asJSON *bool
+	switch command {
+	case "list", "changes":
+		targetName = flags.String("target", "", "Only runs to this host name or address")
+		asJSON = flags.Bool("json", false, "Print JSON")
+	case "show":
+		output = flags.String("o", "", "Write the run to this file instead of standard output")
+	default:
+		fmt.Fprintf(os.Stderr, "ERROR: unknown history command %q\n\n", command)
+		printHistoryUsage()
+		return 2
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	archive, err := openHistory(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 1
+	}
+
+	switch command {
+	case "list":
+		err = listRuns(archive, *targetName, *asJSON)
+	case "changes":
+		err = listChanges(archive, *targetName, *asJSON)
+	case "show":
+		if flags.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "ERROR: history show needs one run ID (see: history list)\n")
+			return 2
+		}
+		err = showRun(archive, flags.Arg(0), *output)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// openHistory opens the archive in dir, or in the default directory
+func openHistory(dir string) (*history.Archive, error) {
+	if dir == "" {
+		var err error
+		if dir, err = history.DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+	return history.Open(dir)
+}
+
+// archiveRuns adds traces run for name to the history, if -history is set
+func archiveRuns(name string, runs ...*results.TracerouteResult) {
+	if !*saveHistory {
+		return
+	}
+	archive, err := openHistory(*historyDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Failed to archive the run: %v\n", err)
+		return
+	}
+	for _, run := range runs {
+		entry, err := archive.Add(name, run)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+			continue
+		}
+		fmt.Printf("\nRun archived as %s (path fingerprint %s) in %s\n", entry.ID, entry.Fingerprint, archive.Dir())
+	}
+}
+
+func listRuns(archive *history.Archive, target string, asJSON bool) error {
+	entries, err := archive.List(target)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(entries)
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No runs archived in %s\n", archive.Dir())
+		return nil
+	}
+
+	fmt.Printf("%-42s %-20s %-24s %4s %-7s %5s  %s\n", "ID", "Started (UTC)", "Target", "Hops", "Reached", "Paths", "Fingerprint")
+	fmt.Println(strings.Repeat("-", 120))
+	for _, e := range entries {
+		fmt.Printf("%-42s %-20s %-24s %4d %-7s %5d  %s\n", e.ID, e.StartTime.Format(time.DateTime),
+			targetLabel(e), e.Hops, yesNo(e.Reached), e.Paths, e.Fingerprint)
+	}
+	return nil
+}
+
+func listChanges(archive *history.Archive, target string, asJSON bool) error {
+	changes, err := archive.Changes(target)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(changes)
+	}
+	if len(changes) == 0 {
+		fmt.Println("No path changes in the archived runs")
+		return nil
+	}
+
+	for _, c := range changes {
+		fmt.Printf("%s  %s: fingerprint %s -> %s\n", c.After.StartTime.Format(time.DateTime), targetLabel(c.After),
+			c.Before.Fingerprint, c.After.Fingerprint)
+		fmt.Printf("  runs %s -> %s\n", c.Before.ID, c.After.ID)
+
+		// The hops that changed, from the runs themselves
+		before, err := archive.Load(c.Before.ID)
+		if err != nil {
+			return err
+		}
+		after, err := archive.Load(c.After.ID)
+		if err != nil {
+			return err
+		}
+		for _, hop := range results.CompareTraces(before, after).Hops {
+			if hop.Status != results.HopSame {
+				fmt.Printf("  hop %-3d %-8s %s -> %s\n", hop.TTL, hop.Status, routers(hop.BaselineRouters), routers(hop.CurrentRouters))
+			}
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func showRun(archive *history.Archive, id, output string) error {
+	data, err := archive.Extract(id)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(data)
+		fmt.Println()
+		return err
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+	fmt.Printf("Run %s saved to: %s\n", id, output)
+	return nil
+}
+
+func targetLabel(e history.Entry) string {
+	if e.Name == "" {
+		return e.Target
+	}
+	return fmt.Sprintf("%s (%s)", e.Name, e.Target)
+}
+
+func routers(ips []string) string {
+	if len(ips) == 0 {
+		return "no answer"
+	}
+	return strings.Join(ips, ", ")
+}
+
+func yesNo(ok bool) string {
+	if ok {
+		return "yes"
+	}
+	return "no"
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal to JSON: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
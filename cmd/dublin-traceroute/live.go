@@ -0,0 +1,230 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/internal/platform"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+// Escape sequences of the live view, understood by Linux terminals and by
+// the Windows console once platform.RawInput turned them on
+const (
+	cursorHome = "\x1b[H"
+	clearLine  = "\x1b[K" // To the end of the line
+	clearBelow = "\x1b[J" // To the end of the screen
+	clearAll   = "\x1b[2J"
+	hideCursor = "\x1b[?25l"
+	showCursor = "\x1b[?25h"
+)
+
+// validateContinuous rejects the options that -continuous does not combine
+// with: it prints running statistics only, and never ends on its own
+func validateContinuous() error {
+	conflicts := []struct {
+		set  bool
+		name string
+	}{
+		{*dualStack, "-dual-stack"},
+		{*inputJSON != "", "-input-json"},
+		{*compareWith != "", "-compare"},
+		{*saveHistory, "-history"},
+		{*mda != 0, "-mda"},
+		{*classifyLB, "-classify-lb"},
+		{*hashFields, "-hash-fields"},
+		{*ecmpSplit != 0, "-ecmp-split"},
+		{flagSet("count"), "-count"},
+		{*showDiagram, "-diagram"},
+		{*flowMap || *flowMapJSON != "", "-flow-map"},
+		{*outputJSON != "" || *outputDOT != "" || *outputHTML != "" || *outputGraphML != "", "the -output options"},
+	}
+	for _, c := range conflicts {
+		if c.set {
+			return fmt.Errorf("-continuous shows live statistics only and cannot be used with %s", c.name)
+		}
+	}
+	if *interval < 100 || *interval > 3600000 {
+		return fmt.Errorf("invalid interval: %d (must be 100-3600000 ms)", *interval)
+	}
+	return nil
+}
+
+// liveScreen is the live view of a continuous trace. The probe goroutine
+// adds rounds and the main goroutine handles keys; both redraw.
+type liveScreen struct {
+	mu      sync.Mutex
+	stats   *results.LiveStats
+	view    results.LiveView
+	names   *nameCache
+	stopped bool
+}
+
+// add accumulates a round and redraws, and returns false once stopped
+func (s *liveScreen) add(round *results.TracerouteResult) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return false
+	}
+	s.stats.Add(round)
+	s.draw()
+	return true
+}
+
+// stop leaves the last table on the screen and draws no more
+func (s *liveScreen) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+}
+
+// key handles a key press, and returns false for q
+func (s *liveScreen) key(k byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch k {
+	case 'q', 'Q':
+		return false
+	case 'r', 'R':
+		s.stats.Reset()
+	case 'n', 'N':
+		s.view.ShowNames = !s.view.ShowNames
+	case 'd', 'D':
+		s.view.PerFlow = !s.view.PerFlow
+	default:
+		return true
+	}
+	s.draw()
+	return true
+}
+
+// draw redraws the table over the previous one; the caller holds s.mu
+func (s *liveScreen) draw() {
+	s.view.Width = platform.TerminalWidth()
+	table := strings.ReplaceAll(s.stats.Render(s.view), "\n", clearLine+"\n")
+	fmt.Print(cursorHome + table + clearBelow)
+}
+
+// runLive traces probeTarget a round every -interval and redraws the
+// statistics until q or Ctrl+C
+func runLive(probeTarget string) error {
+	prober, closeProbe, err := newTracer(probeTarget)
+	if err != nil {
+		return err
+	}
+	defer closeProbe()
+
+	restore, err := platform.RawInput()
+	if err != nil {
+		return fmt.Errorf("-continuous needs an interactive terminal: %w", err)
+	}
+	defer restore()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	screen := &liveScreen{
+		stats: results.NewLiveStats(),
+		view:  results.LiveView{ShowNames: true},
+		names: &nameCache{names: make(map[string]string)},
+	}
+	screen.view.Names = screen.names.lookup
+	fmt.Print(hideCursor + clearAll)
+	defer fmt.Print(showCursor)
+	screen.mu.Lock()
+	screen.draw()
+	screen.mu.Unlock()
+
+	keys := make(chan byte)
+	go readKeys(keys)
+
+	// q and Ctrl+C close stop, and the probe drops the round being probed
+	// rather than waiting up to the reply timeout for it. The probe must
+	// return before the deferred closeProbe releases its socket.
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- prober.TraceContinuously(time.Duration(*interval)*time.Millisecond, screen.add, stop)
+	}()
+	quit := func() error {
+		screen.stop()
+		close(stop)
+		<-done
+		return nil
+	}
+
+	for {
+		select {
+		case <-interrupt:
+			return quit()
+		case k, ok := <-keys:
+			if !ok {
+				keys = nil // Standard input closed: only Ctrl+C stops
+			} else if !screen.key(k) {
+				return quit()
+			}
+		case err := <-done:
+			screen.stop()
+			if err != nil {
+				return fmt.Errorf("traceroute failed: %w", err)
+			}
+			return nil
+		}
+	}
+}
+
+// readKeys sends the bytes read from standard input to keys
+func readKeys(keys chan<- byte) {
+	buf := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(buf); err != nil {
+			close(keys)
+			return
+		} else if n == 1 {
+			keys <- buf[0]
+		}
+	}
+}
+
+// nameCache resolves router names in the background, so that a slow
+// reverse lookup never holds up a redraw
+type nameCache struct {
+	mu    sync.Mutex
+	names map[string]string // "" while resolving, or if there is no name
+}
+
+// lookup returns the name of ip, or "" until it is resolved
+func (c *nameCache) lookup(ip string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name, ok := c.names[ip]
+	if !ok {
+		c.names[ip] = ""
+		go c.resolve(ip)
+	}
+	return name
+}
+
+func (c *nameCache) resolve(ip string) {
+	names, err := net.LookupAddr(ip)
+	if err != nil || len(names) == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.names[ip] = strings.TrimSuffix(names[0], ".")
+	c.mu.Unlock()
+}
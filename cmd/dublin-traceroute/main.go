@@ -10,8 +10,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/atlanticbb/dublin-traceroute-windows/internal/platform"
@@ -27,38 +29,61 @@ const (
 var (
 	// Target parameters
 	target = flag.String("target", "", "Target host or IP address (required)")
-	
+
 	// Protocol parameters
-	useTCP = flag.Bool("tcp", false, "Use TCP SYN packets instead of UDP (better firewall traversal)")
-	
+	useTCP    = flag.Bool("tcp", false, "Use TCP SYN packets instead of UDP (better firewall traversal)")
+	useICMP   = flag.Bool("icmp", false, "Use ICMP Echo Request packets instead of UDP (networks that only pass ICMP)")
+	useIPv6   = flag.Bool("6", false, "Trace the IPv6 address of a dual-stack target (UDP and TCP)")
+	dualStack = flag.Bool("dual-stack", false, "Trace both the IPv4 and the IPv6 address of the target and compare them")
+
 	// Port parameters
 	srcPort = flag.Uint("sport", 33434, "Starting source port")
 	dstPort = flag.Uint("dport", 33434, "Destination port (UDP) or target port (TCP: 80, 443, etc.)")
-	
+	flowBy  = flag.String("flow-strategy", probe.FlowSrcPort, "Where UDP and TCP flows are encoded: sport (vary the source port), dport (vary the destination port, as the original dublin-traceroute), paris (fixed ports, probes told apart by UDP checksum) or ipid (fixed ports and checksum, probes told apart by IPv4 ID)")
+
 	// TTL parameters
 	minTTL = flag.Uint("min-ttl", 1, "Minimum TTL")
 	maxTTL = flag.Uint("max-ttl", 30, "Maximum TTL")
-	
+
 	// Path parameters
-	numPaths = flag.Uint("npaths", 4, "Number of paths to probe (parallel flows)")
+	numPaths   = flag.Uint("npaths", 4, "Number of paths to probe (parallel flows)")
 	probeCount = flag.Uint("count", 1, "Number of probes per hop for MTR-style statistics (1-10)")
-	timeout = flag.Uint("timeout", 0, "Probe timeout in milliseconds (UDP=3000ms, TCP=1000ms)")
-	
+	delay      = flag.Uint("delay", 10, "Delay between the probes of a round, in milliseconds")
+	timeout    = flag.Uint("timeout", 0, "Reply timeout after the last probe of a round, in milliseconds (UDP/ICMP=3000ms, TCP=1000ms)")
+	classifyLB = flag.Bool("classify-lb", false, "Re-probe load-balancing hops and neighbouring target addresses to classify balancers as per-flow, per-packet or per-destination")
+	hashFields = flag.Bool("hash-fields", false, "Vary source port, destination port, IP ID, TOS and flow label one at a time to find what each load balancer hashes on")
+	hashSrcs   = flag.String("hash-sources", "", "Extra local addresses (comma-separated) to also vary the source address with -hash-fields")
+	ecmpSplit  = flag.Uint("ecmp-split", 0, "Send this many flows (e.g. 64) through each load-balancing hop to estimate the share of flows each next hop gets, with confidence intervals that hold at 95% together")
+	continuous = flag.Bool("continuous", false, "Probe until Ctrl+C or q, redrawing live MTR-style statistics per hop or per flow (keys: r reset, n names, d per-flow/per-hop)")
+	interval   = flag.Uint("interval", 1000, "Time from the start of one -continuous round to the next, in milliseconds")
+	mda        = flag.Float64("mda", 0, "Multipath Detection Algorithm: add flows per hop until the next hops of every router are found with this confidence in percent (e.g. 95, 99); -npaths is then the most flows per hop (default 128)")
+
 	// Output parameters
-	outputJSON = flag.String("output-json", "", "Save results to JSON file")
-	showVersion = flag.Bool("version", false, "Show version information")
-	showAnalysis = flag.Bool("analyze", true, "Show detailed network analysis")
-	showHelp = flag.Bool("help-routing", false, "Explain return path routing and asymmetric paths")
-	showTips = flag.Bool("tips", false, "Show tips for comparing routes over time")
-	verbose = flag.Bool("verbose", false, "Show verbose output including timeouts")
-	
+	inputJSON     = flag.String("input-json", "", "Re-analyze a trace saved with -output-json instead of probing (no administrator rights needed)")
+	compareWith   = flag.String("compare", "", "Compare the trace with a baseline saved with -output-json and report changed hops, diamonds, latency, loss and reachability")
+	compareJSON   = flag.String("compare-json", "", "Save the -compare report to a JSON file")
+	outputJSON    = flag.String("output-json", "", "Save results to JSON file")
+	outputDOT     = flag.String("output-dot", "", "Save the multipath topology as a Graphviz DOT file (render with: dot -Tpng trace.dot -o trace.png)")
+	outputHTML    = flag.String("output-html", "", "Save a self-contained HTML report (interactive path graph, hop statistics, analysis) to attach to tickets")
+	outputGraphML = flag.String("output-graphml", "", "Save the multipath topology as a GraphML file for yEd, Gephi or networkx")
+	showDiagram   = flag.Bool("diagram", false, "Draw the paths as a box-and-line diagram showing where they split and merge, sized to the terminal")
+	flowMap       = flag.Bool("flow-map", false, "Show which source/destination ports took each distinct path")
+	flowMapJSON   = flag.String("flow-map-json", "", "Save the flow-to-path map to a JSON file")
+	saveHistory   = flag.Bool("history", false, "Archive the run in the local trace history (query it with: dublin-traceroute history)")
+	historyDir    = flag.String("history-dir", "", "History directory for -history (default: $DUBLIN_TRACEROUTE_HISTORY or dublin-traceroute/history in the user configuration directory)")
+	showVersion   = flag.Bool("version", false, "Show version information")
+	showAnalysis  = flag.Bool("analyze", true, "Show detailed network analysis")
+	showHelp      = flag.Bool("help-routing", false, "Explain return path routing and asymmetric paths")
+	showTips      = flag.Bool("tips", false, "Show tips for comparing routes over time")
+	verbose       = flag.Bool("verbose", false, "Show verbose output including timeouts")
+
 	// Debug parameters
 	listDevices = flag.Bool("list-devices", false, "List available network devices and exit")
-	device = flag.String("device", "", "Network device to use for capture (auto-detect if not specified)")
+	device      = flag.String("device", "", "Network device to use for capture (auto-detect if not specified)")
 )
 
 func printBanner() {
-	fmt.Printf("Dublin Traceroute for Windows v%s\n", version)
+	fmt.Printf("Dublin Traceroute v%s (%s backend)\n", version, platform.Current().Name())
 	fmt.Printf("Go %s on %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
 	fmt.Printf("NAT-aware multipath traceroute\n")
 	fmt.Println()
@@ -66,7 +91,8 @@ func printBanner() {
 
 func printUsage() {
 	printBanner()
-	fmt.Printf("Usage: %s [options] -target <host>\n\n", os.Args[0])
+	fmt.Printf("Usage: %s [options] -target <host>\n", os.Args[0])
+	fmt.Printf("       %s history list|changes|show [options]\n\n", os.Args[0])
 	fmt.Println("Options:")
 	flag.PrintDefaults()
 	fmt.Println()
@@ -80,18 +106,75 @@ func printUsage() {
 	fmt.Println("  TCP trace to port 80 (HTTP) - better firewall traversal:")
 	fmt.Println("    dublin-traceroute -target example.com -tcp -dport 80 -npaths 6")
 	fmt.Println()
+	fmt.Println("  ICMP Echo trace (Paris-style, one checksum per flow):")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -icmp")
+	fmt.Println()
+	fmt.Println("  IPv6 trace of a dual-stack host:")
+	fmt.Println("    dublin-traceroute -target google.com -6")
+	fmt.Println()
+	fmt.Println("  Compare the IPv4 and IPv6 paths of a dual-stack host:")
+	fmt.Println("    dublin-traceroute -target google.com -dual-stack")
+	fmt.Println()
 	fmt.Println("  Detect load balancing with more paths:")
 	fmt.Println("    dublin-traceroute -target 8.8.8.8 -npaths 8")
 	fmt.Println()
+	fmt.Println("  Enumerate every load-balanced next hop with 95% confidence (MDA):")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -mda 95")
+	fmt.Println()
+	fmt.Println("  Vary the destination port per flow, as the original dublin-traceroute:")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -flow-strategy dport")
+	fmt.Println()
+	fmt.Println("  Follow a single path with a fixed 5-tuple (Paris traceroute):")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -flow-strategy paris -npaths 1")
+	fmt.Println()
+	fmt.Println("  Tell per-flow, per-packet and per-destination load balancers apart:")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -npaths 8 -classify-lb")
+	fmt.Println()
+	fmt.Println("  Draw where load-balanced paths split and merge, e.g. over SSH:")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -npaths 8 -diagram")
+	fmt.Println()
+	fmt.Println("  Show which source ports take each path, to steer around a bad branch:")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -npaths 16 -flow-map -flow-map-json flows.json")
+	fmt.Println()
+	fmt.Println("  Estimate how evenly each load balancer splits flows:")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -npaths 8 -ecmp-split 64")
+	fmt.Println()
+	fmt.Println("  Find which header fields each load balancer hashes on:")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -hash-fields")
+	fmt.Println()
 	fmt.Println("  MTR mode - multiple probes per hop for statistics:")
 	fmt.Println("    dublin-traceroute -target google.com -count 5 -max-ttl 15")
 	fmt.Println()
+	fmt.Println("  Live MTR view, redrawn every round until q or Ctrl+C:")
+	fmt.Println("    dublin-traceroute -target google.com -continuous -npaths 4 -timeout 1000")
+	fmt.Println()
 	fmt.Println("  MTR mode with TCP for return path analysis:")
 	fmt.Println("    dublin-traceroute -target example.com -tcp -dport 443 -count 3")
 	fmt.Println()
+	fmt.Println("  HTML report to attach to a ticket (opens offline in any browser):")
+	fmt.Println("    dublin-traceroute -target example.com -npaths 8 -count 3 -output-html report.html")
+	fmt.Println()
+	fmt.Println("  Draw the path graph for an incident report (needs Graphviz):")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -npaths 8 -output-dot trace.dot")
+	fmt.Println("    dot -Tpng trace.dot -o trace.png")
+	fmt.Println()
+	fmt.Println("  Open the topology in yEd, Gephi or networkx:")
+	fmt.Println("    dublin-traceroute -target 8.8.8.8 -npaths 8 -output-graphml trace.graphml")
+	fmt.Println()
 	fmt.Println("  Save for later comparison:")
 	fmt.Println("    dublin-traceroute -target example.com -output-json baseline.json")
 	fmt.Println()
+	fmt.Println("  Compare with a baseline when \"it was fine yesterday\":")
+	fmt.Println("    dublin-traceroute -target example.com -compare baseline.json -compare-json changes.json")
+	fmt.Println("    dublin-traceroute -input-json problem.json -compare baseline.json")
+	fmt.Println()
+	fmt.Println("  Keep every run in the local history, then see when the path changed:")
+	fmt.Println("    dublin-traceroute -target example.com -count 5 -history")
+	fmt.Println("    dublin-traceroute history changes -target example.com")
+	fmt.Println()
+	fmt.Println("  Re-analyze a saved or customer-submitted trace, without admin rights:")
+	fmt.Println("    dublin-traceroute -input-json baseline.json -diagram -output-html report.html")
+	fmt.Println()
 	fmt.Println("  Quick trace (fewer hops):")
 	fmt.Println("    dublin-traceroute -target 192.168.1.1 -max-ttl 10")
 	fmt.Println()
@@ -100,43 +183,79 @@ func printUsage() {
 	fmt.Println("  -tips             Tips for comparing routes over time")
 	fmt.Println()
 	fmt.Println("Requirements:")
+	fmt.Println("  Windows:")
 	fmt.Println("  - Administrator privileges (required for raw sockets)")
 	fmt.Println("  - Npcap installed (https://npcap.com)")
+	fmt.Println("  Linux:")
+	fmt.Println("  - root or CAP_NET_RAW (sudo setcap cap_net_raw+ep dublin-traceroute)")
 	fmt.Println()
 }
 
 func checkPrerequisites() error {
+	backend := platform.Current()
+
 	// Check admin privileges
-	isAdmin, err := platform.IsAdmin()
+	isAdmin, err := backend.IsAdmin()
 	if err != nil {
 		return fmt.Errorf("failed to check administrator privileges: %w", err)
 	}
 	if !isAdmin {
+		if backend.Name() != "windows" {
+			return backend.RequireAdmin()
+		}
 		return fmt.Errorf(
 			"dublin-traceroute requires administrator privileges\n\n" +
-			"Please run from an elevated PowerShell or Command Prompt:\n" +
-			"1. Right-click PowerShell and select 'Run as administrator'\n" +
-			"2. Navigate to the directory containing dublin-traceroute.exe\n" +
-			"3. Run the command again\n")
-	}
-
-	// Check Npcap installation
-	npcapInstalled, err := platform.CheckNpcapInstalled()
-	if err != nil {
-		return fmt.Errorf("failed to check Npcap installation: %w", err)
-	}
-	if !npcapInstalled {
-		return fmt.Errorf("%s", platform.GetNpcapInstallMessage())
+				"Please run from an elevated PowerShell or Command Prompt:\n" +
+				"1. Right-click PowerShell and select 'Run as administrator'\n" +
+				"2. Navigate to the directory containing dublin-traceroute.exe\n" +
+				"3. Run the command again\n")
 	}
 
-	return nil
+	// Check packet capture support (Npcap on Windows)
+	return backend.CheckCapture()
 }
 
 func validateParameters() error {
-	if *target == "" && !*listDevices && !*showVersion {
+	if *target == "" && !*listDevices && !*showVersion && *inputJSON == "" {
 		return fmt.Errorf("target host is required")
 	}
 
+	if *compareWith != "" && *dualStack {
+		return fmt.Errorf("-compare compares a single trace and cannot be used with -dual-stack")
+	}
+
+	if *continuous {
+		if err := validateContinuous(); err != nil {
+			return err
+		}
+	} else if flagSet("interval") {
+		return fmt.Errorf("-interval sets the pace of -continuous rounds and needs -continuous")
+	}
+
+	if *historyDir != "" && !*saveHistory {
+		return fmt.Errorf("-history-dir sets where -history archives runs and needs -history")
+	}
+
+	if *compareJSON != "" && *compareWith == "" {
+		return fmt.Errorf("-compare-json needs a baseline to compare with (-compare)")
+	}
+
+	if *inputJSON != "" && (*target != "" || *dualStack) {
+		return fmt.Errorf("-input-json analyzes a saved trace and cannot be used with -target or -dual-stack")
+	}
+
+	if *useTCP && *useICMP {
+		return fmt.Errorf("-tcp and -icmp cannot be used together")
+	}
+
+	if *useIPv6 && *useICMP {
+		return fmt.Errorf("-icmp supports IPv4 only and cannot be used with -6")
+	}
+
+	if *dualStack && (*useICMP || *useIPv6) {
+		return fmt.Errorf("-dual-stack traces both families with UDP or TCP and cannot be used with -icmp or -6")
+	}
+
 	if *srcPort < 1 || *srcPort > 65535 {
 		return fmt.Errorf("invalid source port: %d (must be 1-65535)", *srcPort)
 	}
@@ -160,21 +279,70 @@ func validateParameters() error {
 	if *numPaths < 1 || *numPaths > 256 {
 		return fmt.Errorf("invalid npaths: %d (must be 1-256)", *numPaths)
 	}
-	
+
 	if *probeCount < 1 || *probeCount > 10 {
 		return fmt.Errorf("invalid count: %d (must be 1-10)", *probeCount)
 	}
 
-	// Check if source port range is valid
-	maxSrcPort := *srcPort + *numPaths - 1
-	if maxSrcPort > 65535 {
-		return fmt.Errorf("source port range overflow: %d-%d exceeds 65535", *srcPort, maxSrcPort)
+	if *mda != 0 && (*mda < 50 || *mda >= 100) {
+		return fmt.Errorf("invalid mda confidence: %g (must be at least 50 and below 100)", *mda)
+	}
+
+	if _, err := parseSources(*hashSrcs); err != nil {
+		return err
+	}
+
+	if *ecmpSplit > 1024 {
+		return fmt.Errorf("invalid ecmp-split: %d (must be at most 1024)", *ecmpSplit)
+	}
+
+	if *dualStack && (*outputDOT != "" || *outputGraphML != "" || *outputHTML != "") {
+		return fmt.Errorf("-output-dot, -output-graphml and -output-html export a single trace and cannot be used with -dual-stack")
+	}
+
+	if *mda != 0 && *probeCount > 1 {
+		return fmt.Errorf("-mda probes each flow once and cannot be used with -count")
+	}
+
+	if !contains(probe.FlowStrategies(), *flowBy) {
+		return fmt.Errorf("invalid flow strategy: %q (must be one of %s)", *flowBy, strings.Join(probe.FlowStrategies(), ", "))
+	}
+
+	if *useICMP && *flowBy != probe.FlowSrcPort {
+		return fmt.Errorf("-flow-strategy applies to UDP and TCP probes; ICMP flows always vary the checksum")
+	}
+
+	if *flowBy == probe.FlowIPID && !*useTCP && (*useIPv6 || *dualStack) {
+		return fmt.Errorf("-flow-strategy ipid needs the IPv4 ID and cannot be used with UDP over IPv6")
+	}
+
+	// Check if the port range of the flows is valid
+	flows := *numPaths
+	if *ecmpSplit > flows {
+		flows = *ecmpSplit
+	}
+	switch *flowBy {
+	case probe.FlowSrcPort:
+		maxSrcPort := *srcPort + flows - 1
+		if maxSrcPort > 65535 {
+			return fmt.Errorf("source port range overflow: %d-%d exceeds 65535", *srcPort, maxSrcPort)
+		}
+	case probe.FlowDstPort:
+		maxDstPort := *dstPort + flows - 1
+		if maxDstPort > 65535 {
+			return fmt.Errorf("destination port range overflow: %d-%d exceeds 65535", *dstPort, maxDstPort)
+		}
 	}
 
 	return nil
 }
 
 func main() {
+	// The history subcommands query the archive and have flags of their own
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		os.Exit(runHistory(os.Args[2:]))
+	}
+
 	// Parse command-line flags
 	flag.Parse()
 
@@ -183,14 +351,14 @@ func main() {
 		printBanner()
 		os.Exit(0)
 	}
-	
+
 	// Handle help flags
 	if *showHelp {
 		printBanner()
 		fmt.Println(results.ExplainReturnPath())
 		os.Exit(0)
 	}
-	
+
 	if *showTips {
 		printBanner()
 		results.PrintComparisonHelp()
@@ -205,7 +373,7 @@ func main() {
 			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
 			os.Exit(1)
 		}
-		
+
 		if err := capture.PrintDeviceList(); err != nil {
 			fmt.Fprintf(os.Stderr, "ERROR: Failed to list devices: %v\n", err)
 			os.Exit(1)
@@ -213,6 +381,11 @@ func main() {
 		os.Exit(0)
 	}
 
+	// MDA needs room to add flows unless the user set the limit
+	if *mda != 0 && !flagSet("npaths") {
+		*numPaths = mdaMaxFlows
+	}
+
 	// Validate parameters
 	if err := validateParameters(); err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: %v\n\n", err)
@@ -223,6 +396,31 @@ func main() {
 	// Print banner
 	printBanner()
 
+	// Load the baseline first, so that a bad file fails before probing
+	var baseline *results.TracerouteResult
+	if *compareWith != "" {
+		var err error
+		if baseline, err = results.LoadFromFile(*compareWith); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// A saved trace needs no probes, so no privileges or capture driver
+	if *inputJSON != "" {
+		result, err := results.LoadFromFile(*inputJSON)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Loaded trace to %s from %s (started %s)\n", result.Target, *inputJSON, result.StartTime.Format(time.RFC1123))
+		result.PrintSummary()
+		result.PrintMTRStyle()
+		writeOutputs(result, baseline)
+		archiveRuns("", result)
+		os.Exit(0)
+	}
+
 	// Check prerequisites
 	fmt.Println("Checking prerequisites...")
 	if err := checkPrerequisites(); err != nil {
@@ -230,18 +428,188 @@ func main() {
 		os.Exit(1)
 	}
 	fmt.Println("✓ Administrator privileges: OK")
-	fmt.Println("✓ Npcap installation: OK")
-	fmt.Println()
-
-	// Create probe (UDP or TCP)
-	fmt.Printf("Initializing probe to %s...\n", *target)
-	
-	var result *results.TracerouteResult
-	
-	if *useTCP {
-		// Create TCP probe
-		prober, err := probe.NewTCPProbe(
-			*target,
+	if platform.Current().Name() == "windows" {
+		fmt.Println("✓ Npcap installation: OK")
+	} else {
+		fmt.Println("✓ Raw socket capture: OK")
+	}
+	fmt.Println()
+
+	if *dualStack {
+		runDualStack()
+		os.Exit(0)
+	}
+
+	// Hostnames resolve to IPv4 by default; -6 picks the AAAA record instead
+	probeTarget := *target
+	if *useIPv6 {
+		ip, err := probe.ResolveTarget(*target, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		if ip.To4() != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s has no IPv6 address\n", *target)
+			os.Exit(1)
+		}
+		probeTarget = ip.String()
+	}
+
+	if *continuous {
+		if err := runLive(probeTarget); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	result, err := runTraceroute(probeTarget)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Print summary or MTR-style output based on probe count
+	if *probeCount > 1 {
+		// MTR-style statistics table
+		result.PrintMTRStyle()
+	} else {
+		// Traditional summary
+		result.PrintSummary()
+	}
+
+	writeOutputs(result, baseline)
+	archiveRuns(*target, result)
+	os.Exit(0)
+}
+
+// writeOutputs prints the optional views of a trace, and its comparison
+// with baseline if any, and saves the requested files
+func writeOutputs(result, baseline *results.TracerouteResult) {
+	// Draw where the paths split and merge if requested
+	if *showDiagram {
+		result.PrintDiagram(platform.TerminalWidth())
+	}
+
+	// Compare with the baseline if requested
+	if baseline != nil {
+		diff := results.CompareTraces(baseline, result)
+		diff.PrintReport()
+		if *compareJSON != "" {
+			saveOutput(*compareJSON, diff.ToJSON)
+		}
+	}
+
+	// Map ports to paths if requested
+	if *flowMap || *flowMapJSON != "" {
+		flows := result.MapFlows()
+		if *flowMap {
+			flows.PrintReport()
+		}
+		if *flowMapJSON != "" {
+			saveOutput(*flowMapJSON, flows.ToJSON)
+		}
+	}
+
+	// Save to JSON if requested
+	if *outputJSON != "" {
+		saveOutput(*outputJSON, result.ToJSON)
+	}
+
+	// Export the report and the topology graph if requested
+	if *outputHTML != "" {
+		saveOutput(*outputHTML, result.ToHTML)
+	}
+	if *outputDOT != "" {
+		saveOutput(*outputDOT, result.ToDOT)
+	}
+	if *outputGraphML != "" {
+		saveOutput(*outputGraphML, result.ToGraphML)
+	}
+}
+
+// mdaMaxFlows is the default limit of flows per hop in MDA mode, enough to
+// enumerate 16 next hops at 99% confidence
+const mdaMaxFlows = 128
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// tracer is the part of the UDP, TCP and ICMP probes that main drives
+type tracer interface {
+	Traceroute() (*results.TracerouteResult, error)
+	TraceContinuously(interval time.Duration, handle probe.RoundHandler, stop <-chan struct{}) error
+	SetDelay(delay time.Duration)
+	SetTimeout(timeout time.Duration)
+	SetMDA(confidence float64)
+	SetClassifyLB(classify bool)
+	SetECMPSplit(flows uint16)
+	SetHashFields(enable bool, sources []net.IP)
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSources parses the -hash-sources list
+func parseSources(list string) ([]net.IP, error) {
+	var sources []net.IP
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		ip := net.ParseIP(field)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid hash source address: %q", field)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		sources = append(sources, ip)
+	}
+	return sources, nil
+}
+
+// runTraceroute creates the probe selected on the command line and traces
+// probeTarget with it
+func runTraceroute(probeTarget string) (*results.TracerouteResult, error) {
+	prober, closeProbe, err := newTracer(probeTarget)
+	if err != nil {
+		return nil, err
+	}
+	defer closeProbe()
+
+	result, err := prober.Traceroute()
+	if err != nil {
+		return nil, fmt.Errorf("traceroute failed: %w", err)
+	}
+	return result, nil
+}
+
+// newTracer creates and configures the probe selected on the command line;
+// closeProbe releases its socket and capture handle
+func newTracer(probeTarget string) (prober tracer, closeProbe func(), err error) {
+	fmt.Printf("Initializing probe to %s...\n", probeTarget)
+
+	switch {
+	case *useTCP:
+		p, err := probe.NewTCPProbe(
+			probeTarget,
 			uint16(*srcPort),
 			uint16(*dstPort),
 			uint16(*numPaths),
@@ -250,30 +618,28 @@ func main() {
 			int(*probeCount),
 		)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: Failed to create TCP probe: %v\n", err)
-			os.Exit(1)
+			return nil, nil, fmt.Errorf("failed to create TCP probe: %w", err)
 		}
-		defer prober.Close()
-		
-		// Set custom timeout if specified
-		if *timeout > 0 {
-			prober.SetTimeout(time.Duration(*timeout) * time.Millisecond)
+		if err := p.SetFlowStrategy(*flowBy); err != nil {
+			p.Close()
+			return nil, nil, err
 		}
-		
-		fmt.Println("✓ Raw socket created")
-		fmt.Println("✓ Packet capture initialized")
-		fmt.Println()
-		
-		// Run TCP traceroute
-		result, err = prober.Traceroute()
+		prober, closeProbe = p, func() { p.Close() }
+	case *useICMP:
+		p, err := probe.NewICMPProbe(
+			probeTarget,
+			uint16(*numPaths),
+			uint8(*minTTL),
+			uint8(*maxTTL),
+			int(*probeCount),
+		)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: Traceroute failed: %v\n", err)
-			os.Exit(1)
+			return nil, nil, fmt.Errorf("failed to create ICMP probe: %w", err)
 		}
-	} else {
-		// Create UDP probe
-		prober, err := probe.NewUDPProbe(
-			*target,
+		prober, closeProbe = p, p.Close
+	default:
+		p, err := probe.NewUDPProbe(
+			probeTarget,
 			uint16(*srcPort),
 			uint16(*dstPort),
 			uint16(*numPaths),
@@ -282,51 +648,76 @@ func main() {
 			int(*probeCount),
 		)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: Failed to create UDP probe: %v\n", err)
-			os.Exit(1)
+			return nil, nil, fmt.Errorf("failed to create UDP probe: %w", err)
 		}
-		defer prober.Close()
-		
-		// Set custom timeout if specified
-		if *timeout > 0 {
-			prober.SetTimeout(time.Duration(*timeout) * time.Millisecond)
-		}
-		
-		fmt.Println("✓ Raw socket created")
-		fmt.Println("✓ Packet capture initialized")
-		fmt.Println()
-		
-		// Run UDP traceroute
-		result, err = prober.Traceroute()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: Traceroute failed: %v\n", err)
-			os.Exit(1)
+		if err := p.SetFlowStrategy(*flowBy); err != nil {
+			p.Close()
+			return nil, nil, err
 		}
+		prober, closeProbe = p, p.Close
 	}
 
-	// Print summary or MTR-style output based on probe count
-	if *probeCount > 1 {
-		// MTR-style statistics table
-		result.PrintMTRStyle()
-	} else {
-		// Traditional summary
-		result.PrintSummary()
+	prober.SetDelay(time.Duration(*delay) * time.Millisecond)
+	// Set custom timeout if specified
+	if *timeout > 0 {
+		prober.SetTimeout(time.Duration(*timeout) * time.Millisecond)
+	}
+	if *mda != 0 {
+		prober.SetMDA(*mda / 100)
 	}
+	prober.SetClassifyLB(*classifyLB)
+	prober.SetECMPSplit(uint16(*ecmpSplit))
+	sources, _ := parseSources(*hashSrcs) // validated with the other flags
+	prober.SetHashFields(*hashFields, sources)
+
+	fmt.Println("✓ Raw socket created")
+	fmt.Println("✓ Packet capture initialized")
+	fmt.Println()
+
+	return prober, closeProbe, nil
+}
+
+// runDualStack traces the A and the AAAA address of the target one after
+// the other and prints how the two paths compare
+func runDualStack() {
+	ipv4, ipv6, err := probe.ResolveDualStack(*target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	ipv4Result, err := runTraceroute(ipv4.String())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: IPv4 trace: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println()
+
+	ipv6Result, err := runTraceroute(ipv6.String())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: IPv6 trace: %v\n", err)
+		os.Exit(1)
+	}
+
+	comparison := results.CompareDualStack(*target, ipv4Result, ipv6Result)
+	comparison.PrintReport()
 
-	// Save to JSON if requested
 	if *outputJSON != "" {
-		jsonData, err := result.ToJSON()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "WARNING: Failed to convert to JSON: %v\n", err)
-		} else {
-			err = os.WriteFile(*outputJSON, []byte(jsonData), 0644)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "WARNING: Failed to write JSON file: %v\n", err)
-			} else {
-				fmt.Printf("\nResults saved to: %s\n", *outputJSON)
-			}
-		}
+		saveOutput(*outputJSON, comparison.ToJSON)
 	}
+	archiveRuns(*target, ipv4Result, ipv6Result)
+}
 
-	os.Exit(0)
+// saveOutput writes the output of render (JSON, DOT or GraphML) to filename
+func saveOutput(filename string, render func() (string, error)) {
+	data, err := render()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Failed to render %s: %v\n", filename, err)
+		return
+	}
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: Failed to write %s: %v\n", filename, err)
+		return
+	}
+	fmt.Printf("\nResults saved to: %s\n", filename)
 }
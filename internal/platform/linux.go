@@ -0,0 +1,295 @@
+//go:build linux
+// +build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package platform
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// capNetRaw is the bit of CAP_NET_RAW in the effective capability set
+const capNetRaw = 13
+
+// linuxPlatform implements Platform using AF_INET raw sockets and netlink
+type linuxPlatform struct{}
+
+var current Platform = linuxPlatform{}
+
+// Name returns the backend identifier
+func (linuxPlatform) Name() string {
+	return "linux"
+}
+
+// IsAdmin reports whether the process is root or holds CAP_NET_RAW
+func (linuxPlatform) IsAdmin() (bool, error) {
+	if os.Geteuid() == 0 {
+		return true, nil
+	}
+
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false, fmt.Errorf("failed to read process status: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse capabilities: %w", err)
+		}
+		return caps&(1<<capNetRaw) != 0, nil
+	}
+	return false, scanner.Err()
+}
+
+// RequireAdmin checks for raw socket privileges and returns a helpful error if missing
+func (p linuxPlatform) RequireAdmin() error {
+	isAdmin, err := p.IsAdmin()
+	if err != nil {
+		return fmt.Errorf("failed to check privileges: %w", err)
+	}
+	if !isAdmin {
+		return fmt.Errorf("dublin-traceroute requires root or CAP_NET_RAW on Linux\n" +
+			"Please run with sudo, or grant the capability once with:\n" +
+			"  sudo setcap cap_net_raw+ep $(which dublin-traceroute)")
+	}
+	return nil
+}
+
+// CheckCapture is a no-op on Linux: replies are read from raw sockets,
+// so no capture driver is needed
+func (linuxPlatform) CheckCapture() error {
+	return nil
+}
+
+// CreateRawSocket creates a raw IPv4 socket with IP_HDRINCL set
+func (p linuxPlatform) CreateRawSocket(protocol int) (int, error) {
+	if err := p.RequireAdmin(); err != nil {
+		return 0, err
+	}
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, protocol)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create raw socket (protocol %d): %w", protocol, err)
+	}
+
+	err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_HDRINCL, 1)
+	if err != nil {
+		unix.Close(fd)
+		return 0, fmt.Errorf("failed to set IP_HDRINCL: %w", err)
+	}
+
+	return fd, nil
+}
+
+// CreateRawSocket6 creates a raw IPv6 socket with IPV6_HDRINCL set
+// (Linux 4.5 and later)
+func (p linuxPlatform) CreateRawSocket6(protocol int) (int, error) {
+	if err := p.RequireAdmin(); err != nil {
+		return 0, err
+	}
+
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, protocol)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create raw IPv6 socket (protocol %d): %w", protocol, err)
+	}
+
+	err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_HDRINCL, 1)
+	if err != nil {
+		unix.Close(fd)
+		return 0, fmt.Errorf("failed to set IPV6_HDRINCL: %w", err)
+	}
+
+	return fd, nil
+}
+
+// SendPacket sends a raw IP packet
+func (linuxPlatform) SendPacket(fd int, packet []byte, dst net.IP) error {
+	var dest unix.Sockaddr
+	if dst4 := dst.To4(); dst4 != nil {
+		sa := &unix.SockaddrInet4{}
+		copy(sa.Addr[:], dst4)
+		dest = sa
+	} else if dst16 := dst.To16(); dst16 != nil {
+		sa := &unix.SockaddrInet6{}
+		copy(sa.Addr[:], dst16)
+		dest = sa
+	} else {
+		return fmt.Errorf("invalid destination: %s", dst)
+	}
+
+	if err := unix.Sendto(fd, packet, 0, dest); err != nil {
+		return fmt.Errorf("failed to send packet: %w", err)
+	}
+	return nil
+}
+
+// CloseSocket closes a raw socket
+func (linuxPlatform) CloseSocket(fd int) error {
+	return unix.Close(fd)
+}
+
+// GetLocalAddress returns the preferred source address of the route to dst,
+// as reported by a netlink RTM_GETROUTE query
+func (linuxPlatform) GetLocalAddress(dst net.IP) (net.IP, error) {
+	ip, err := routeSourceAddress(dst)
+	if err == nil && ip != nil {
+		return ip, nil
+	}
+	// Routes without a preferred source (e.g. some point-to-point links)
+	// still resolve through the kernel's own source selection
+	return localAddressByDial(dst)
+}
+
+// TerminalWidth asks the terminal on standard output for its size
+func (linuxPlatform) TerminalWidth() (int, error) {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, fmt.Errorf("standard output is not a terminal: %w", err)
+	}
+	return int(ws.Col), nil
+}
+
+// RawInput turns off canonical mode and echo on the terminal of standard
+// input; ISIG stays on, so that Ctrl+C still sends SIGINT
+func (linuxPlatform) RawInput() (func() error, error) {
+	fd := int(os.Stdin.Fd())
+	saved, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, fmt.Errorf("standard input is not a terminal: %w", err)
+	}
+
+	raw := *saved
+	raw.Lflag &^= unix.ICANON | unix.ECHO
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, fmt.Errorf("failed to set terminal mode: %w", err)
+	}
+	return func() error {
+		return unix.IoctlSetTermios(fd, unix.TCSETS, saved)
+	}, nil
+}
+
+// routeSourceAddress asks the kernel routing table for the RTA_PREFSRC of the
+// route used to reach dst
+func routeSourceAddress(dst net.IP) (net.IP, error) {
+	family := unix.AF_INET
+	addr := dst.To4()
+	if addr == nil {
+		family = unix.AF_INET6
+		addr = dst.To16()
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("invalid destination address: %s", dst)
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+
+	// nlmsghdr | rtmsg | rtattr(RTA_DST)
+	attrLen := unix.SizeofRtAttr + len(addr)
+	msgLen := unix.NLMSG_HDRLEN + unix.SizeofRtMsg + rtaAlign(attrLen)
+	req := make([]byte, msgLen)
+	binary.LittleEndian.PutUint32(req[0:4], uint32(msgLen))
+	binary.LittleEndian.PutUint16(req[4:6], unix.RTM_GETROUTE)
+	binary.LittleEndian.PutUint16(req[6:8], unix.NLM_F_REQUEST)
+	binary.LittleEndian.PutUint32(req[8:12], 1)
+
+	rtm := req[unix.NLMSG_HDRLEN:]
+	rtm[0] = byte(family)
+	rtm[1] = byte(len(addr) * 8)
+
+	rta := rtm[unix.SizeofRtMsg:]
+	binary.LittleEndian.PutUint16(rta[0:2], uint16(attrLen))
+	binary.LittleEndian.PutUint16(rta[2:4], unix.RTA_DST)
+	copy(rta[unix.SizeofRtAttr:], addr)
+
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("failed to send route query: %w", err)
+	}
+
+	buf := make([]byte, 8192)
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read route reply: %w", err)
+	}
+
+	return parseRouteReply(buf[:n])
+}
+
+// parseRouteReply extracts RTA_PREFSRC from an RTM_NEWROUTE message
+func parseRouteReply(buf []byte) (net.IP, error) {
+	if len(buf) < unix.NLMSG_HDRLEN {
+		return nil, fmt.Errorf("short netlink reply")
+	}
+	msgLen := int(binary.LittleEndian.Uint32(buf[0:4]))
+	msgType := binary.LittleEndian.Uint16(buf[4:6])
+	if msgLen > len(buf) {
+		return nil, fmt.Errorf("truncated netlink reply")
+	}
+
+	if msgType == unix.NLMSG_ERROR {
+		if msgLen >= unix.NLMSG_HDRLEN+4 {
+			errno := int32(binary.LittleEndian.Uint32(buf[unix.NLMSG_HDRLEN:]))
+			if errno != 0 {
+				return nil, fmt.Errorf("route lookup failed: %w", unix.Errno(-errno))
+			}
+		}
+		return nil, fmt.Errorf("route lookup failed")
+	}
+	if msgType != unix.RTM_NEWROUTE {
+		return nil, fmt.Errorf("unexpected netlink message type %d", msgType)
+	}
+
+	attrs := buf[unix.NLMSG_HDRLEN+unix.SizeofRtMsg : msgLen]
+	for len(attrs) >= unix.SizeofRtAttr {
+		attrLen := int(binary.LittleEndian.Uint16(attrs[0:2]))
+		attrType := binary.LittleEndian.Uint16(attrs[2:4])
+		if attrLen < unix.SizeofRtAttr || attrLen > len(attrs) {
+			break
+		}
+		if attrType == unix.RTA_PREFSRC {
+			return net.IP(append([]byte(nil), attrs[unix.SizeofRtAttr:attrLen]...)), nil
+		}
+		next := rtaAlign(attrLen)
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	return nil, fmt.Errorf("route has no preferred source address")
+}
+
+// rtaAlign rounds a netlink attribute length up to the 4-byte boundary
+func rtaAlign(n int) int {
+	return (n + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+}
@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package platform
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// buildRouteReply builds an RTM_NEWROUTE message carrying the given attributes
+func buildRouteReply(attrs map[uint16][]byte) []byte {
+	body := make([]byte, unix.SizeofRtMsg)
+	for typ, val := range attrs {
+		attr := make([]byte, rtaAlign(unix.SizeofRtAttr+len(val)))
+		binary.LittleEndian.PutUint16(attr[0:2], uint16(unix.SizeofRtAttr+len(val)))
+		binary.LittleEndian.PutUint16(attr[2:4], typ)
+		copy(attr[unix.SizeofRtAttr:], val)
+		body = append(body, attr...)
+	}
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(body))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(body)))
+	binary.LittleEndian.PutUint16(msg[4:6], unix.RTM_NEWROUTE)
+	return append(msg, body...)
+}
+
+func TestParseRouteReply(t *testing.T) {
+	reply := buildRouteReply(map[uint16][]byte{
+		unix.RTA_PREFSRC: net.ParseIP("192.168.1.20").To4(),
+	})
+
+	ip, err := parseRouteReply(reply)
+	if err != nil {
+		t.Fatalf("parseRouteReply: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("192.168.1.20")) {
+		t.Errorf("got %s, want 192.168.1.20", ip)
+	}
+}
+
+func TestParseRouteReplyWithoutPrefSrc(t *testing.T) {
+	reply := buildRouteReply(map[uint16][]byte{
+		unix.RTA_DST: net.ParseIP("8.8.8.8").To4(),
+	})
+
+	if _, err := parseRouteReply(reply); err == nil {
+		t.Error("expected an error for a route without RTA_PREFSRC")
+	}
+}
+
+func TestParseRouteReplyError(t *testing.T) {
+	msg := make([]byte, unix.NLMSG_HDRLEN+4)
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	binary.LittleEndian.PutUint16(msg[4:6], unix.NLMSG_ERROR)
+	errno := int32(-int32(unix.ENETUNREACH))
+	binary.LittleEndian.PutUint32(msg[unix.NLMSG_HDRLEN:], uint32(errno))
+
+	if _, err := parseRouteReply(msg); err == nil {
+		t.Error("expected an error for NLMSG_ERROR reply")
+	}
+}
+
+func TestLinuxGetLocalAddressLoopback(t *testing.T) {
+	ip, err := Current().GetLocalAddress(net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Skipf("no route to loopback in this environment: %v", err)
+	}
+	if !ip.IsLoopback() {
+		t.Errorf("got %s, want a loopback address", ip)
+	}
+}
+
+func TestGetLocalAddressIPv6Loopback(t *testing.T) {
+	ip, err := Current().GetLocalAddress(net.IPv6loopback)
+	if err != nil {
+		t.Skipf("no IPv6 route to loopback: %v", err)
+	}
+	if !ip.Equal(net.IPv6loopback) {
+		t.Errorf("got %s, want ::1", ip)
+	}
+}
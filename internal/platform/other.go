@@ -0,0 +1,70 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package platform
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// unsupportedPlatform is used on operating systems without a raw socket backend
+type unsupportedPlatform struct{}
+
+var current Platform = unsupportedPlatform{}
+
+func (unsupportedPlatform) Name() string {
+	return runtime.GOOS
+}
+
+func (unsupportedPlatform) IsAdmin() (bool, error) {
+	return false, errUnsupported()
+}
+
+func (unsupportedPlatform) RequireAdmin() error {
+	return errUnsupported()
+}
+
+func (unsupportedPlatform) CheckCapture() error {
+	return errUnsupported()
+}
+
+func (unsupportedPlatform) CreateRawSocket(protocol int) (int, error) {
+	return 0, errUnsupported()
+}
+
+func (unsupportedPlatform) CreateRawSocket6(protocol int) (int, error) {
+	return 0, errUnsupported()
+}
+
+func (unsupportedPlatform) SendPacket(fd int, packet []byte, dst net.IP) error {
+	return errUnsupported()
+}
+
+func (unsupportedPlatform) CloseSocket(fd int) error {
+	return errUnsupported()
+}
+
+func (unsupportedPlatform) GetLocalAddress(dst net.IP) (net.IP, error) {
+	return localAddressByDial(dst)
+}
+
+func (unsupportedPlatform) TerminalWidth() (int, error) {
+	return 0, errUnsupported()
+}
+
+func (unsupportedPlatform) RawInput() (func() error, error) {
+	return nil, errUnsupported()
+}
+
+func errUnsupported() error {
+	return fmt.Errorf("raw sockets are not supported on %s (only Windows and Linux)", runtime.GOOS)
+}
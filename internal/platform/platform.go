@@ -0,0 +1,161 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package platform
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// IP protocol numbers used when opening raw sockets
+const (
+	ProtocolICMP = 1
+	ProtocolTCP  = 6
+	ProtocolUDP  = 17
+	ProtocolRaw  = 255
+)
+
+// Platform abstracts the operating system specific operations needed to
+// send hand-crafted probes. Each supported OS provides one implementation,
+// selected at build time and returned by Current.
+type Platform interface {
+	// Name returns a short identifier for the backend ("windows", "linux")
+	Name() string
+
+	// IsAdmin reports whether the process may open raw sockets
+	IsAdmin() (bool, error)
+
+	// RequireAdmin returns a descriptive error if IsAdmin is false
+	RequireAdmin() error
+
+	// CheckCapture verifies that the packet capture backend is usable
+	CheckCapture() error
+
+	// CreateRawSocket opens a raw IPv4 socket with IP_HDRINCL set
+	CreateRawSocket(protocol int) (int, error)
+
+	// CreateRawSocket6 opens a raw IPv6 socket with IPV6_HDRINCL set
+	CreateRawSocket6(protocol int) (int, error)
+
+	// SendPacket sends a complete IPv4 or IPv6 packet (header included) to dst
+	SendPacket(fd int, packet []byte, dst net.IP) error
+
+	// CloseSocket closes a socket returned by CreateRawSocket or CreateRawSocket6
+	CloseSocket(fd int) error
+
+	// GetLocalAddress returns the source address the OS would use to reach dst
+	GetLocalAddress(dst net.IP) (net.IP, error)
+
+	// TerminalWidth returns the columns of the terminal on standard output
+	TerminalWidth() (int, error)
+
+	// RawInput makes standard input deliver key presses one at a time,
+	// without echo, and returns a function that restores it. Ctrl+C still
+	// interrupts the process.
+	RawInput() (restore func() error, err error)
+}
+
+// defaultTerminalWidth is used when standard output is not a terminal
+const defaultTerminalWidth = 80
+
+// Current returns the platform backend for the running operating system
+func Current() Platform {
+	return current
+}
+
+// IsAdmin checks if the current process has the privileges required for raw sockets
+func IsAdmin() (bool, error) {
+	return current.IsAdmin()
+}
+
+// RequireAdmin checks for raw socket privileges and returns a helpful error if missing
+func RequireAdmin() error {
+	return current.RequireAdmin()
+}
+
+// CreateRawSocket creates a raw IP socket for packet crafting
+func CreateRawSocket(protocol int) (int, error) {
+	return current.CreateRawSocket(protocol)
+}
+
+// CreateRawSocket6 creates a raw IPv6 socket for packet crafting
+func CreateRawSocket6(protocol int) (int, error) {
+	return current.CreateRawSocket6(protocol)
+}
+
+// CreateICMPSocket creates a raw socket specifically for ICMP
+func CreateICMPSocket() (int, error) {
+	return current.CreateRawSocket(ProtocolICMP)
+}
+
+// CreateUDPSocket creates a raw socket for UDP packet crafting
+func CreateUDPSocket() (int, error) {
+	return current.CreateRawSocket(ProtocolUDP)
+}
+
+// SendPacket sends a raw IP packet to dst
+func SendPacket(fd int, packet []byte, dst net.IP) error {
+	return current.SendPacket(fd, packet, dst)
+}
+
+// CloseSocket closes a raw socket
+func CloseSocket(fd int) error {
+	return current.CloseSocket(fd)
+}
+
+// GetLocalAddress returns the source address used to reach dst, IPv4 or IPv6
+func GetLocalAddress(dst net.IP) (net.IP, error) {
+	return current.GetLocalAddress(dst)
+}
+
+// TerminalWidth returns the width of the terminal on standard output, or
+// $COLUMNS, or 80 columns when the output is redirected
+func TerminalWidth() int {
+	if width, err := current.TerminalWidth(); err == nil && width > 0 {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return defaultTerminalWidth
+}
+
+// RawInput reads standard input key by key until restore is called
+func RawInput() (restore func() error, err error) {
+	return current.RawInput()
+}
+
+// GetLocalIPv4Address retrieves the local IPv4 address for the default route
+func GetLocalIPv4Address() (string, error) {
+	// Any public address works here, it is only used for the route lookup
+	ip, err := current.GetLocalAddress(net.IPv4(8, 8, 8, 8))
+	if err != nil {
+		return "", err
+	}
+	if ip.To4() == nil {
+		return "", fmt.Errorf("no suitable IPv4 address found")
+	}
+	return ip.String(), nil
+}
+
+// localAddressByDial asks the kernel for the source address of a route to dst
+// by connecting an unbound UDP socket. No packets are sent.
+func localAddressByDial(dst net.IP) (net.IP, error) {
+	network := "udp4"
+	if dst.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.DialUDP(network, nil, &net.UDPAddr{IP: dst, Port: 33434})
+	if err != nil {
+		return nil, fmt.Errorf("no route to %s: %w", dst, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -1,3 +1,4 @@
+//go:build windows
 // +build windows
 
 /* SPDX-License-Identifier: BSD-2-Clause */
@@ -11,6 +12,8 @@ package platform
 
 import (
 	"fmt"
+	"net"
+	"os"
 	"syscall"
 	"unsafe"
 
@@ -18,21 +21,34 @@ import (
 )
 
 const (
+	// IPV6_HDRINCL from ws2ipdef.h, not exported by x/sys/windows
+	ipv6HdrIncl = 2
+
 	// GetAdaptersAddresses flags
 	GAA_FLAG_SKIP_ANYCAST   = 0x0002
 	GAA_FLAG_SKIP_MULTICAST = 0x0004
 )
 
 var (
-	modadvapi32                  = windows.NewLazySystemDLL("advapi32.dll")
-	procGetTokenInformation      = modadvapi32.NewProc("GetTokenInformation")
-	modshell32                   = windows.NewLazySystemDLL("shell32.dll")
-	procIsUserAnAdmin            = modshell32.NewProc("IsUserAnAdmin")
+	modadvapi32             = windows.NewLazySystemDLL("advapi32.dll")
+	procGetTokenInformation = modadvapi32.NewProc("GetTokenInformation")
+	modshell32              = windows.NewLazySystemDLL("shell32.dll")
+	procIsUserAnAdmin       = modshell32.NewProc("IsUserAnAdmin")
 )
 
+// windowsPlatform implements Platform using Winsock raw sockets and Npcap
+type windowsPlatform struct{}
+
+var current Platform = windowsPlatform{}
+
+// Name returns the backend identifier
+func (windowsPlatform) Name() string {
+	return "windows"
+}
+
 // IsAdmin checks if the current process is running with administrator privileges
 // This is required for raw socket operations on Windows
-func IsAdmin() (bool, error) {
+func (windowsPlatform) IsAdmin() (bool, error) {
 	// Method 1: Use Shell32 IsUserAnAdmin (simple check)
 	ret, _, _ := procIsUserAnAdmin.Call()
 	if ret != 0 {
@@ -64,8 +80,8 @@ func IsAdmin() (bool, error) {
 }
 
 // RequireAdmin checks if running as admin and returns a helpful error if not
-func RequireAdmin() error {
-	isAdmin, err := IsAdmin()
+func (p windowsPlatform) RequireAdmin() error {
+	isAdmin, err := p.IsAdmin()
 	if err != nil {
 		return fmt.Errorf("failed to check administrator privileges: %w", err)
 	}
@@ -76,11 +92,23 @@ func RequireAdmin() error {
 	return nil
 }
 
+// CheckCapture verifies that Npcap is installed
+func (windowsPlatform) CheckCapture() error {
+	installed, err := CheckNpcapInstalled()
+	if err != nil {
+		return fmt.Errorf("failed to check Npcap installation: %w", err)
+	}
+	if !installed {
+		return fmt.Errorf("%s", GetNpcapInstallMessage())
+	}
+	return nil
+}
+
 // CreateRawSocket creates a raw IP socket for packet crafting
 // Requires administrator privileges on Windows
-func CreateRawSocket(protocol int) (int, error) {
+func (p windowsPlatform) CreateRawSocket(protocol int) (int, error) {
 	// Verify admin privileges first
-	if err := RequireAdmin(); err != nil {
+	if err := p.RequireAdmin(); err != nil {
 		return 0, err
 	}
 
@@ -101,14 +129,26 @@ func CreateRawSocket(protocol int) (int, error) {
 	return int(fd), nil
 }
 
-// CreateICMPSocket creates a raw socket specifically for ICMP
-func CreateICMPSocket() (int, error) {
-	return CreateRawSocket(windows.IPPROTO_ICMP)
-}
+// CreateRawSocket6 creates a raw IPv6 socket with IPV6_HDRINCL set
+// Requires administrator privileges on Windows
+func (p windowsPlatform) CreateRawSocket6(protocol int) (int, error) {
+	if err := p.RequireAdmin(); err != nil {
+		return 0, err
+	}
+
+	fd, err := windows.Socket(windows.AF_INET6, windows.SOCK_RAW, protocol)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create raw IPv6 socket (protocol %d): %w", protocol, err)
+	}
+
+	// Set IPV6_HDRINCL so the probe supplies its own hop limit and flow label
+	err = windows.SetsockoptInt(fd, windows.IPPROTO_IPV6, ipv6HdrIncl, 1)
+	if err != nil {
+		windows.Close(fd)
+		return 0, fmt.Errorf("failed to set IPV6_HDRINCL: %w", err)
+	}
 
-// CreateUDPSocket creates a raw socket for UDP packet crafting
-func CreateUDPSocket() (int, error) {
-	return CreateRawSocket(windows.IPPROTO_UDP)
+	return int(fd), nil
 }
 
 // SetSocketTimeout sets the receive timeout on a socket
@@ -122,7 +162,20 @@ func SetSocketTimeout(fd int, timeoutMs int) error {
 }
 
 // SendPacket sends a raw IP packet
-func SendPacket(fd int, packet []byte, dest *windows.SockaddrInet4) error {
+func (windowsPlatform) SendPacket(fd int, packet []byte, dst net.IP) error {
+	var dest windows.Sockaddr
+	if dst4 := dst.To4(); dst4 != nil {
+		sa := &windows.SockaddrInet4{}
+		copy(sa.Addr[:], dst4)
+		dest = sa
+	} else if dst16 := dst.To16(); dst16 != nil {
+		sa := &windows.SockaddrInet6{}
+		copy(sa.Addr[:], dst16)
+		dest = sa
+	} else {
+		return fmt.Errorf("invalid destination: %s", dst)
+	}
+
 	err := windows.Sendto(windows.Handle(fd), packet, 0, dest)
 	if err != nil {
 		return fmt.Errorf("failed to send packet: %w", err)
@@ -151,12 +204,68 @@ func ReceivePacket(fd int, buffer []byte) (int, *windows.SockaddrInet4, error) {
 }
 
 // CloseSocket closes a raw socket
-func CloseSocket(fd int) error {
+func (windowsPlatform) CloseSocket(fd int) error {
 	return windows.Close(windows.Handle(fd))
 }
 
-// GetLocalIPv4Address retrieves the local IPv4 address for the default route
-func GetLocalIPv4Address() (string, error) {
+// GetLocalAddress returns the local address used to reach dst. The routing
+// table is consulted first; if that fails the first operational adapter
+// address is used for IPv4, as earlier versions did.
+func (windowsPlatform) GetLocalAddress(dst net.IP) (net.IP, error) {
+	ip, dialErr := localAddressByDial(dst)
+	if dialErr == nil {
+		return ip, nil
+	}
+	if dst.To4() == nil {
+		return nil, dialErr
+	}
+
+	addr, err := firstAdapterIPv4Address()
+	if err != nil {
+		return nil, err
+	}
+	return net.ParseIP(addr), nil
+}
+
+// TerminalWidth returns the width of the console window on standard output
+func (windowsPlatform) TerminalWidth() (int, error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info); err != nil {
+		return 0, fmt.Errorf("standard output is not a console: %w", err)
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, nil
+}
+
+// RawInput turns off line input and echo on the console, and turns on
+// escape sequences on standard output so that the screen can be redrawn.
+// Processed input stays on, so that Ctrl+C still interrupts.
+func (windowsPlatform) RawInput() (func() error, error) {
+	in, out := windows.Handle(os.Stdin.Fd()), windows.Handle(os.Stdout.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(in, &inMode); err != nil {
+		return nil, fmt.Errorf("standard input is not a console: %w", err)
+	}
+	if err := windows.GetConsoleMode(out, &outMode); err != nil {
+		return nil, fmt.Errorf("standard output is not a console: %w", err)
+	}
+
+	if err := windows.SetConsoleMode(in, inMode&^(windows.ENABLE_LINE_INPUT|windows.ENABLE_ECHO_INPUT)); err != nil {
+		return nil, fmt.Errorf("failed to set console input mode: %w", err)
+	}
+	if err := windows.SetConsoleMode(out, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		windows.SetConsoleMode(in, inMode)
+		return nil, fmt.Errorf("console does not support escape sequences: %w", err)
+	}
+	return func() error {
+		if err := windows.SetConsoleMode(out, outMode); err != nil {
+			return err
+		}
+		return windows.SetConsoleMode(in, inMode)
+	}, nil
+}
+
+// firstAdapterIPv4Address returns the first unicast IPv4 address of an operational adapter
+func firstAdapterIPv4Address() (string, error) {
 	// Get adapter addresses
 	var size uint32
 	err := windows.GetAdaptersAddresses(windows.AF_INET, GAA_FLAG_SKIP_ANYCAST|GAA_FLAG_SKIP_MULTICAST, 0, nil, &size)
@@ -0,0 +1,334 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Capture receives the ICMP responses triggered by probes. NewCapture returns
+// the implementation for the running operating system.
+type Capture interface {
+	// SetBPFFilter narrows the packets delivered by the capture
+	SetBPFFilter(filter string) error
+
+	// CaptureICMPResponse waits for an ICMP response to a probe from srcIP to dstIP
+	// Returns the response packet and the source IP
+	CaptureICMPResponse(srcIP net.IP, dstIP net.IP, expectedType layers.ICMPv4TypeCode) (gopacket.Packet, net.IP, error)
+
+	// NextReply returns the next ICMP packet received within timeout, decoded.
+	// It returns ErrTimeout if nothing arrived in time.
+	NextReply(timeout time.Duration) (*Reply, error)
+
+	// Close releases the capture handle
+	Close()
+
+	// GetInterface returns the interface name being captured
+	GetInterface() string
+
+	// GetStats returns capture statistics
+	GetStats() (received, dropped, ifDropped uint, err error)
+}
+
+// Device represents a network device available for capture
+type Device struct {
+	Name        string
+	Description string
+	Addresses   []string
+}
+
+// ErrTimeout is returned when no reply arrives before the deadline
+var ErrTimeout = errors.New("timeout waiting for ICMP response")
+
+// Reply is an ICMP or ICMPv6 response, or the TCP answer of a target to a
+// SYN probe, decoded from a captured packet
+type Reply struct {
+	From      net.IP    // Host that sent the reply
+	To        net.IP    // Destination of the reply (our address)
+	Timestamp time.Time // When the reply was received
+	IPv6      bool      // ICMPv6 reply; ICMPType and ICMPCode use ICMPv6 numbering
+	ICMPType  uint8
+	ICMPCode  uint8
+
+	// Headers of the probe quoted inside ICMP error messages.
+	// Nil for messages that carry no quoted packet, such as Echo Reply.
+	InnerSrc      net.IP
+	InnerDst      net.IP
+	InnerProtocol uint8 // IP protocol (IPv6 next header) of the quoted probe
+
+	// Ports of a quoted UDP or TCP probe, and the TCP sequence number
+	InnerSrcPort uint16
+	InnerDstPort uint16
+	InnerSeq     uint32
+
+	// IPv4 ID and UDP or ICMP checksum of the quoted probe. NAT boxes
+	// restore the addresses in returning errors but usually leave these as
+	// rewritten, so they reveal translation along the path.
+	InnerID       uint16
+	InnerChecksum uint16
+
+	// Identifier and sequence number of an Echo Reply, or of the Echo
+	// Request quoted inside an error message
+	EchoID  uint16
+	EchoSeq uint16
+
+	// TCP segment from the target: a SYN-ACK from an open port or a RST from
+	// a closed one. ICMPType and ICMPCode are zero.
+	TCP        bool
+	TCPSrcPort uint16
+	TCPDstPort uint16
+	TCPAck     uint32
+	SYN        bool
+	RST        bool
+}
+
+// DecodeReply extracts a Reply from a captured ICMP or ICMPv6 packet, or
+// from a TCP SYN-ACK or RST. It returns false for any other packet.
+func DecodeReply(packet gopacket.Packet, timestamp time.Time) (*Reply, bool) {
+	if icmp6, ok := packet.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6); ok {
+		return decodeReply6(packet, icmp6, timestamp)
+	}
+
+	icmpLayer := packet.Layer(layers.LayerTypeICMPv4)
+	if icmpLayer == nil {
+		if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+			return decodeTCPReply(packet, tcp, timestamp)
+		}
+		return nil, false
+	}
+	icmp, _ := icmpLayer.(*layers.ICMPv4)
+
+	ipLayer := packet.Layer(layers.LayerTypeIPv4)
+	if ipLayer == nil {
+		return nil, false
+	}
+	ip, _ := ipLayer.(*layers.IPv4)
+
+	reply := &Reply{
+		From:      ip.SrcIP,
+		To:        ip.DstIP,
+		Timestamp: timestamp,
+		ICMPType:  icmp.TypeCode.Type(),
+		ICMPCode:  icmp.TypeCode.Code(),
+	}
+
+	// Error messages carry the original IP header + 8 bytes of data
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv4TypeTimeExceeded, layers.ICMPv4TypeDestinationUnreachable:
+		if len(icmp.Payload) < 20 {
+			break // Not enough data for IP header
+		}
+		embeddedIP := &layers.IPv4{}
+		if err := embeddedIP.DecodeFromBytes(icmp.Payload, gopacket.NilDecodeFeedback); err != nil {
+			break
+		}
+		reply.InnerSrc = embeddedIP.SrcIP
+		reply.InnerDst = embeddedIP.DstIP
+		reply.InnerID = embeddedIP.Id
+		reply.InnerProtocol = uint8(embeddedIP.Protocol)
+
+		// The quoted 8 bytes hold the whole UDP header, the TCP ports and
+		// sequence number, or the checksum, identifier and sequence of an
+		// Echo Request
+		quoted := embeddedIP.Payload
+		if embeddedIP.Protocol == layers.IPProtocolICMPv4 && len(quoted) >= 8 &&
+			quoted[0] == layers.ICMPv4TypeEchoRequest {
+			reply.InnerChecksum = binary.BigEndian.Uint16(quoted[2:4])
+			reply.EchoID = binary.BigEndian.Uint16(quoted[4:6])
+			reply.EchoSeq = binary.BigEndian.Uint16(quoted[6:8])
+		} else {
+			reply.decodeQuotedTransport(quoted)
+		}
+	case layers.ICMPv4TypeEchoReply:
+		reply.EchoID = icmp.Id
+		reply.EchoSeq = icmp.Seq
+	}
+
+	return reply, true
+}
+
+// decodeReply6 extracts a Reply from an ICMPv6 packet
+func decodeReply6(packet gopacket.Packet, icmp *layers.ICMPv6, timestamp time.Time) (*Reply, bool) {
+	ip, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	if !ok {
+		return nil, false
+	}
+
+	reply := &Reply{
+		From:      ip.SrcIP,
+		To:        ip.DstIP,
+		Timestamp: timestamp,
+		IPv6:      true,
+		ICMPType:  icmp.TypeCode.Type(),
+		ICMPCode:  icmp.TypeCode.Code(),
+	}
+
+	// The payload starts with 4 unused bytes (MTU for Packet Too Big), then
+	// as much of the original packet as fits in the minimum MTU (RFC 4443)
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv6TypeTimeExceeded, layers.ICMPv6TypeDestinationUnreachable:
+		if len(icmp.Payload) < 4+40 {
+			break // Not enough data for IPv6 header
+		}
+		embeddedIP := &layers.IPv6{}
+		if err := embeddedIP.DecodeFromBytes(icmp.Payload[4:], gopacket.NilDecodeFeedback); err != nil {
+			break
+		}
+		reply.InnerSrc = embeddedIP.SrcIP
+		reply.InnerDst = embeddedIP.DstIP
+		reply.InnerProtocol = uint8(embeddedIP.NextHeader)
+		reply.decodeQuotedTransport(embeddedIP.Payload)
+	case layers.ICMPv6TypeEchoReply:
+		if len(icmp.Payload) >= 4 {
+			reply.EchoID = binary.BigEndian.Uint16(icmp.Payload[0:2])
+			reply.EchoSeq = binary.BigEndian.Uint16(icmp.Payload[2:4])
+		}
+	}
+
+	return reply, true
+}
+
+// decodeTCPReply extracts a Reply from a SYN-ACK or RST segment
+func decodeTCPReply(packet gopacket.Packet, tcp *layers.TCP, timestamp time.Time) (*Reply, bool) {
+	if !(tcp.SYN && tcp.ACK) && !tcp.RST {
+		return nil, false
+	}
+
+	reply := &Reply{
+		Timestamp:  timestamp,
+		TCP:        true,
+		TCPSrcPort: uint16(tcp.SrcPort),
+		TCPDstPort: uint16(tcp.DstPort),
+		TCPAck:     tcp.Ack,
+		SYN:        tcp.SYN,
+		RST:        tcp.RST,
+	}
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		reply.From, reply.To = ip.SrcIP, ip.DstIP
+	case *layers.IPv6:
+		reply.From, reply.To, reply.IPv6 = ip.SrcIP, ip.DstIP, true
+	default:
+		return nil, false
+	}
+	return reply, true
+}
+
+// decodeQuotedTransport reads the ports of a quoted UDP or TCP probe, plus
+// the UDP checksum or TCP sequence number, from the first 8 bytes
+func (r *Reply) decodeQuotedTransport(quoted []byte) {
+	if len(quoted) < 8 {
+		return
+	}
+	switch layers.IPProtocol(r.InnerProtocol) {
+	case layers.IPProtocolUDP:
+		r.InnerChecksum = binary.BigEndian.Uint16(quoted[6:8])
+	case layers.IPProtocolTCP:
+		r.InnerSeq = binary.BigEndian.Uint32(quoted[4:8])
+	default:
+		return
+	}
+	r.InnerSrcPort = binary.BigEndian.Uint16(quoted[0:2])
+	r.InnerDstPort = binary.BigEndian.Uint16(quoted[2:4])
+}
+
+// TimeExceeded reports whether the reply is an ICMP or ICMPv6 Time Exceeded
+func (r *Reply) TimeExceeded() bool {
+	if r.IPv6 {
+		return r.ICMPType == layers.ICMPv6TypeTimeExceeded
+	}
+	return r.ICMPType == layers.ICMPv4TypeTimeExceeded
+}
+
+// DestinationUnreachable reports whether the reply is an ICMP or ICMPv6
+// Destination Unreachable
+func (r *Reply) DestinationUnreachable() bool {
+	if r.IPv6 {
+		return r.ICMPType == layers.ICMPv6TypeDestinationUnreachable
+	}
+	return r.ICMPType == layers.ICMPv4TypeDestinationUnreachable
+}
+
+// EchoReply reports whether the reply is an ICMP or ICMPv6 Echo Reply
+func (r *Reply) EchoReply() bool {
+	if r.TCP {
+		return false
+	}
+	if r.IPv6 {
+		return r.ICMPType == layers.ICMPv6TypeEchoReply
+	}
+	return r.ICMPType == layers.ICMPv4TypeEchoReply
+}
+
+// Matches reports whether the reply was triggered by a probe sent from
+// srcIP to dstIP
+func (r *Reply) Matches(srcIP net.IP, dstIP net.IP) bool {
+	switch {
+	case r.TCP:
+		// Only the target answers a SYN directly
+		return r.From.Equal(dstIP) && r.To.Equal(srcIP)
+	case r.TimeExceeded():
+		// Check if the embedded packet matches our probe
+		return r.InnerSrc != nil && r.InnerSrc.Equal(srcIP) && r.InnerDst.Equal(dstIP)
+	case r.DestinationUnreachable(), r.EchoReply():
+		// For other ICMP types, just check the outer IP addresses
+		return r.To.Equal(srcIP)
+	}
+	return false
+}
+
+// matchICMPResponse checks whether packet is an ICMP response to a probe sent
+// from srcIP to dstIP and returns the address of the responding host
+func matchICMPResponse(packet gopacket.Packet, srcIP net.IP, dstIP net.IP, expectedType layers.ICMPv4TypeCode) (net.IP, bool) {
+	reply, ok := DecodeReply(packet, time.Time{})
+	if !ok {
+		return nil, false
+	}
+
+	// Check if this is the expected ICMP type (expectedType uses ICMPv4 numbering)
+	if expectedType != 0 && (reply.IPv6 || layers.CreateICMPv4TypeCode(reply.ICMPType, reply.ICMPCode) != expectedType) {
+		return nil, false
+	}
+
+	if !reply.Matches(srcIP, dstIP) {
+		return nil, false
+	}
+	return reply.From, true
+}
+
+// PrintDeviceList prints all available devices in a user-friendly format
+func PrintDeviceList() error {
+	devices, err := ListDevices()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nAvailable Network Devices:")
+	fmt.Println(strings.Repeat("=", 80))
+
+	for i, dev := range devices {
+		fmt.Printf("\n[%d] %s\n", i+1, dev.Name)
+		if dev.Description != "" {
+			fmt.Printf("    Description: %s\n", dev.Description)
+		}
+		if len(dev.Addresses) > 0 {
+			fmt.Printf("    IP Addresses: %s\n", strings.Join(dev.Addresses, ", "))
+		}
+	}
+
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,240 @@
+package capture
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	probeSrc = net.ParseIP("192.168.1.10").To4()
+	probeDst = net.ParseIP("8.8.8.8").To4()
+	router   = net.ParseIP("10.0.0.1").To4()
+)
+
+// buildTimeExceeded returns a decoded ICMP Time Exceeded from router quoting
+// a UDP probe from src to dst
+func buildTimeExceeded(t *testing.T, src, dst net.IP) gopacket.Packet {
+	t.Helper()
+
+	inner := &layers.IPv4{Version: 4, IHL: 5, TTL: 1, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	udp := &layers.UDP{SrcPort: 33434, DstPort: 33434}
+	udp.SetNetworkLayerForChecksum(inner)
+	return quoteInTimeExceeded(t, inner, udp)
+}
+
+// quoteInTimeExceeded serializes a probe and wraps it in a Time Exceeded
+// sent by router back to the probe's source
+func quoteInTimeExceeded(t *testing.T, inner *layers.IPv4, probe ...gopacket.SerializableLayer) gopacket.Packet {
+	t.Helper()
+
+	quoted := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(quoted, opts, append([]gopacket.SerializableLayer{inner}, probe...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	outer := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: router, DstIP: inner.SrcIP}
+	icmp := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeTimeExceeded, 0)}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, opts, outer, icmp, gopacket.Payload(quoted.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+}
+
+func TestMatchICMPResponseTimeExceeded(t *testing.T) {
+	packet := buildTimeExceeded(t, probeSrc, probeDst)
+
+	from, ok := matchICMPResponse(packet, probeSrc, probeDst, 0)
+	if !ok {
+		t.Fatal("expected Time Exceeded to match probe")
+	}
+	if !from.Equal(router) {
+		t.Errorf("got responder %s, want %s", from, router)
+	}
+}
+
+func TestMatchICMPResponseOtherProbe(t *testing.T) {
+	packet := buildTimeExceeded(t, probeSrc, net.ParseIP("1.1.1.1").To4())
+
+	if _, ok := matchICMPResponse(packet, probeSrc, probeDst, 0); ok {
+		t.Error("Time Exceeded for another destination must not match")
+	}
+}
+
+func TestMatchICMPResponseExpectedType(t *testing.T) {
+	packet := buildTimeExceeded(t, probeSrc, probeDst)
+	want := layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodePort)
+
+	if _, ok := matchICMPResponse(packet, probeSrc, probeDst, want); ok {
+		t.Error("Time Exceeded must not match when Destination Unreachable is expected")
+	}
+}
+
+func TestDecodeReply(t *testing.T) {
+	packet := buildTimeExceeded(t, probeSrc, probeDst)
+	now := time.Now()
+
+	reply, ok := DecodeReply(packet, now)
+	if !ok {
+		t.Fatal("DecodeReply rejected an ICMP packet")
+	}
+	if !reply.From.Equal(router) || !reply.To.Equal(probeSrc) {
+		t.Errorf("got %s -> %s, want %s -> %s", reply.From, reply.To, router, probeSrc)
+	}
+	if reply.ICMPType != layers.ICMPv4TypeTimeExceeded || !reply.Timestamp.Equal(now) {
+		t.Errorf("got type %d at %v", reply.ICMPType, reply.Timestamp)
+	}
+	if !reply.InnerSrc.Equal(probeSrc) || !reply.InnerDst.Equal(probeDst) {
+		t.Errorf("got quoted %s -> %s", reply.InnerSrc, reply.InnerDst)
+	}
+	if !reply.Matches(probeSrc, probeDst) {
+		t.Error("reply should match its probe")
+	}
+}
+
+func TestDecodeReplyQuotedEcho(t *testing.T) {
+	inner := &layers.IPv4{Version: 4, IHL: 5, TTL: 1, Protocol: layers.IPProtocolICMPv4, SrcIP: probeSrc, DstIP: probeDst}
+	echo := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: 4242, Seq: 7}
+	packet := quoteInTimeExceeded(t, inner, echo, gopacket.Payload([]byte("dublin")))
+
+	reply, ok := DecodeReply(packet, time.Now())
+	if !ok {
+		t.Fatal("DecodeReply rejected an ICMP packet")
+	}
+	if reply.EchoID != 4242 || reply.EchoSeq != 7 {
+		t.Errorf("got quoted echo id=%d seq=%d, want id=4242 seq=7", reply.EchoID, reply.EchoSeq)
+	}
+}
+
+func TestDecodeReplyQuotedIDAndChecksum(t *testing.T) {
+	inner := &layers.IPv4{Version: 4, IHL: 5, Id: 0xbeef, TTL: 1, Protocol: layers.IPProtocolUDP, SrcIP: probeSrc, DstIP: probeDst}
+	udp := &layers.UDP{SrcPort: 33434, DstPort: 33434}
+	udp.SetNetworkLayerForChecksum(inner)
+	packet := quoteInTimeExceeded(t, inner, udp, gopacket.Payload([]byte("dublin")))
+
+	reply, ok := DecodeReply(packet, time.Now())
+	if !ok {
+		t.Fatal("DecodeReply rejected an ICMP packet")
+	}
+	if reply.InnerID != 0xbeef {
+		t.Errorf("got quoted IP ID %#04x, want 0xbeef", reply.InnerID)
+	}
+	if reply.InnerChecksum == 0 || reply.InnerChecksum != udp.Checksum {
+		t.Errorf("got quoted UDP checksum %#04x, want %#04x", reply.InnerChecksum, udp.Checksum)
+	}
+	if reply.InnerProtocol != uint8(layers.IPProtocolUDP) || reply.InnerSrcPort != 33434 || reply.InnerDstPort != 33434 {
+		t.Errorf("got quoted protocol %d ports %d -> %d", reply.InnerProtocol, reply.InnerSrcPort, reply.InnerDstPort)
+	}
+}
+
+func TestDecodeReplyQuotedTCP(t *testing.T) {
+	inner := &layers.IPv4{Version: 4, IHL: 5, TTL: 1, Protocol: layers.IPProtocolTCP, SrcIP: probeSrc, DstIP: probeDst}
+	tcp := &layers.TCP{SrcPort: 50001, DstPort: 443, Seq: 0xdeadbeef, SYN: true, Window: 65535}
+	tcp.SetNetworkLayerForChecksum(inner)
+	packet := quoteInTimeExceeded(t, inner, tcp)
+
+	reply, ok := DecodeReply(packet, time.Now())
+	if !ok {
+		t.Fatal("DecodeReply rejected an ICMP packet")
+	}
+	if reply.InnerSrcPort != 50001 || reply.InnerDstPort != 443 || reply.InnerSeq != 0xdeadbeef {
+		t.Errorf("got quoted ports %d -> %d seq %#x", reply.InnerSrcPort, reply.InnerDstPort, reply.InnerSeq)
+	}
+	if reply.InnerChecksum != 0 {
+		t.Error("the TCP checksum lies beyond the quoted 8 bytes")
+	}
+}
+
+func TestDecodeTCPReply(t *testing.T) {
+	segment := func(tcp *layers.TCP) gopacket.Packet {
+		ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: probeDst, DstIP: probeSrc}
+		tcp.SetNetworkLayerForChecksum(ip)
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, ip, tcp); err != nil {
+			t.Fatal(err)
+		}
+		return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	}
+
+	reply, ok := DecodeReply(segment(&layers.TCP{SrcPort: 443, DstPort: 50001, Ack: 0x1001, SYN: true, ACK: true}), time.Now())
+	if !ok {
+		t.Fatal("DecodeReply rejected a SYN-ACK")
+	}
+	if !reply.TCP || !reply.SYN || reply.RST || reply.TCPSrcPort != 443 || reply.TCPDstPort != 50001 || reply.TCPAck != 0x1001 {
+		t.Errorf("got %+v", reply)
+	}
+	if !reply.Matches(probeSrc, probeDst) || reply.Matches(probeSrc, router) {
+		t.Error("a SYN-ACK must match only probes to the host that sent it")
+	}
+	if reply.EchoReply() {
+		t.Error("a TCP reply is not an Echo Reply")
+	}
+
+	if reply, ok := DecodeReply(segment(&layers.TCP{SrcPort: 443, DstPort: 50001, Ack: 0x1001, RST: true, ACK: true}), time.Now()); !ok || !reply.RST {
+		t.Errorf("got %+v, want a RST", reply)
+	}
+	if _, ok := DecodeReply(segment(&layers.TCP{SrcPort: 443, DstPort: 50001, ACK: true}), time.Now()); ok {
+		t.Error("a bare ACK is not an answer to a probe")
+	}
+}
+
+func TestDecodeReplyICMPv6TimeExceeded(t *testing.T) {
+	src := net.ParseIP("2001:db8::10")
+	dst := net.ParseIP("2001:4860:4860::8888")
+	hop := net.ParseIP("2001:db8::1")
+
+	inner := &layers.IPv6{Version: 6, FlowLabel: 33434, NextHeader: layers.IPProtocolUDP, HopLimit: 1, SrcIP: src, DstIP: dst}
+	udp := &layers.UDP{SrcPort: 33434, DstPort: 33434}
+	udp.SetNetworkLayerForChecksum(inner)
+	quoted := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(quoted, opts, inner, udp); err != nil {
+		t.Fatal(err)
+	}
+
+	outer := &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolICMPv6, HopLimit: 64, SrcIP: hop, DstIP: src}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeTimeExceeded, 0)}
+	icmp.SetNetworkLayerForChecksum(outer)
+	body := append(make([]byte, 4), quoted.Bytes()...) // 4 unused bytes, then the quote
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, opts, outer, icmp, gopacket.Payload(body)); err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv6, gopacket.Default)
+
+	reply, ok := DecodeReply(packet, time.Now())
+	if !ok {
+		t.Fatal("DecodeReply rejected an ICMPv6 packet")
+	}
+	if !reply.IPv6 || !reply.TimeExceeded() || reply.DestinationUnreachable() {
+		t.Errorf("got IPv6=%v type %d, want ICMPv6 Time Exceeded", reply.IPv6, reply.ICMPType)
+	}
+	if !reply.From.Equal(hop) || !reply.InnerSrc.Equal(src) || !reply.InnerDst.Equal(dst) {
+		t.Errorf("got %s quoting %s -> %s", reply.From, reply.InnerSrc, reply.InnerDst)
+	}
+	if !reply.Matches(src, dst) {
+		t.Error("reply should match its IPv6 probe")
+	}
+	if reply.Matches(src, net.ParseIP("2001:db8::99")) {
+		t.Error("reply must not match a probe to another destination")
+	}
+}
+
+func TestReplyTypesDependOnFamily(t *testing.T) {
+	// Type 3 is Destination Unreachable in ICMP but Time Exceeded in ICMPv6
+	v4 := &Reply{ICMPType: 3}
+	v6 := &Reply{ICMPType: 3, IPv6: true}
+
+	if !v4.DestinationUnreachable() || v4.TimeExceeded() {
+		t.Error("ICMP type 3 is Destination Unreachable")
+	}
+	if !v6.TimeExceeded() || v6.DestinationUnreachable() {
+		t.Error("ICMPv6 type 3 is Time Exceeded")
+	}
+}
@@ -0,0 +1,375 @@
+//go:build linux
+// +build linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/internal/platform"
+)
+
+// pollInterval bounds each blocking read so deadlines are honoured
+const pollInterval = 100 * time.Millisecond
+
+// LinuxCapture receives ICMP responses on Linux from raw ICMP and ICMPv6
+// sockets. The kernel delivers a copy of every inbound ICMP packet to raw
+// sockets, so no libpcap or capture driver is needed.
+type LinuxCapture struct {
+	fd       int // -1 once closed
+	fd6      int // -1 when IPv6 is disabled on the host
+	tcp      int // Raw TCP sockets for SYN-ACK and RST, -1 unless opened
+	tcp6     int // by NewTCPCapture
+	iface    string
+	timeout  time.Duration
+	received uint
+}
+
+// ListDevices returns all available network devices
+func ListDevices() ([]Device, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate network devices: %w", err)
+	}
+
+	result := make([]Device, 0, len(ifaces))
+	for _, iface := range ifaces {
+		addrs := make([]string, 0)
+		ifAddrs, err := iface.Addrs()
+		if err == nil {
+			for _, addr := range ifAddrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+					addrs = append(addrs, ipNet.IP.String())
+				}
+			}
+		}
+
+		result = append(result, Device{
+			Name:        iface.Name,
+			Description: iface.Flags.String(),
+			Addresses:   addrs,
+		})
+	}
+
+	return result, nil
+}
+
+// FindDeviceByIP finds a network device that has the specified IP address
+func FindDeviceByIP(targetIP string) (string, error) {
+	devices, err := ListDevices()
+	if err != nil {
+		return "", err
+	}
+
+	for _, dev := range devices {
+		for _, addr := range dev.Addresses {
+			if addr == targetIP {
+				return dev.Name, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no device found with IP address %s", targetIP)
+}
+
+// FindDefaultDevice finds the device associated with the default route
+func FindDefaultDevice() (string, error) {
+	localIP, err := platform.GetLocalIPv4Address()
+	if err != nil {
+		return "", fmt.Errorf("failed to get local IP: %w", err)
+	}
+
+	devName, err := FindDeviceByIP(localIP)
+	if err != nil {
+		return "", fmt.Errorf("failed to find device for IP %s: %w", localIP, err)
+	}
+
+	return devName, nil
+}
+
+// NewCapture creates a new packet capture instance. If device is empty,
+// responses are received on all interfaces.
+func NewCapture(device string, timeout time.Duration) (Capture, error) {
+	if err := platform.RequireAdmin(); err != nil {
+		return nil, err
+	}
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raw ICMP socket: %w", err)
+	}
+
+	if device != "" {
+		if err := unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, device); err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("failed to bind to device %s: %w", device, err)
+		}
+	}
+
+	// ICMPv6 arrives without its IPv6 header, so ask for the destination
+	// address as ancillary data. Hosts without IPv6 capture IPv4 only.
+	fd6, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMPV6)
+	if err == nil {
+		err = unix.SetsockoptInt(fd6, unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO, 1)
+		if err == nil && device != "" {
+			err = unix.SetsockoptString(fd6, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, device)
+		}
+		if err != nil {
+			unix.Close(fd)
+			unix.Close(fd6)
+			return nil, fmt.Errorf("failed to configure ICMPv6 socket: %w", err)
+		}
+	} else {
+		fd6 = -1
+	}
+
+	return &LinuxCapture{
+		fd:      fd,
+		fd6:     fd6,
+		tcp:     -1,
+		tcp6:    -1,
+		iface:   device,
+		timeout: timeout,
+	}, nil
+}
+
+// NewTCPCapture is NewCapture that also receives the SYN-ACK and RST
+// segments targets send in answer to TCP probes
+func NewTCPCapture(device string, timeout time.Duration) (Capture, error) {
+	c, err := NewCapture(device, timeout)
+	if err != nil {
+		return nil, err
+	}
+	lc := c.(*LinuxCapture)
+
+	lc.tcp, err = openRawTCPSocket(unix.AF_INET, device)
+	if err == nil && lc.fd6 >= 0 {
+		lc.tcp6, err = openRawTCPSocket(unix.AF_INET6, device)
+	}
+	if err != nil {
+		lc.Close()
+		return nil, err
+	}
+	return lc, nil
+}
+
+// openRawTCPSocket opens a raw socket receiving a copy of inbound TCP
+// segments of the given family
+func openRawTCPSocket(family int, device string) (int, error) {
+	fd, err := unix.Socket(family, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_TCP)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open raw TCP socket: %w", err)
+	}
+
+	if family == unix.AF_INET6 {
+		err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO, 1)
+	}
+	if err == nil && device != "" {
+		err = unix.SetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE, device)
+	}
+	if err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to configure raw TCP socket: %w", err)
+	}
+	return fd, nil
+}
+
+// SetBPFFilter is accepted for compatibility; the raw sockets already only
+// deliver ICMP packets, and TCP ones when opened
+func (lc *LinuxCapture) SetBPFFilter(filter string) error {
+	return nil
+}
+
+// readPacket waits for a packet on any socket until deadline, at most
+// pollInterval at a time, and decodes it. It returns nil when the poll
+// ends without a packet, and ErrTimeout once the deadline has passed.
+func (lc *LinuxCapture) readPacket(buf []byte, deadline time.Time) (gopacket.Packet, error) {
+	var fds []unix.PollFd
+	for _, fd := range []int{lc.fd, lc.fd6, lc.tcp, lc.tcp6} {
+		if fd >= 0 {
+			fds = append(fds, unix.PollFd{Fd: int32(fd), Events: unix.POLLIN})
+		}
+	}
+
+	n, err := unix.Poll(fds, pollTimeout(time.Until(deadline)))
+	if err != nil {
+		if errors.Is(err, unix.EINTR) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to poll sockets: %w", err)
+	}
+	if n == 0 {
+		if !time.Now().Before(deadline) {
+			return nil, ErrTimeout
+		}
+		return nil, nil
+	}
+
+	for _, pfd := range fds {
+		if pfd.Revents&unix.POLLIN == 0 {
+			continue
+		}
+		switch fd := int(pfd.Fd); fd {
+		case lc.fd6:
+			return lc.readPacket6(fd, unix.IPPROTO_ICMPV6, buf)
+		case lc.tcp6:
+			return lc.readPacket6(fd, unix.IPPROTO_TCP, buf)
+		default:
+			return lc.readPacket4(fd, buf)
+		}
+	}
+	return nil, nil
+}
+
+// readPacket4 reads an IPv4 packet, header included
+func (lc *LinuxCapture) readPacket4(fd int, buf []byte) (gopacket.Packet, error) {
+	n, _, err := unix.Recvfrom(fd, buf, unix.MSG_DONTWAIT)
+	if err != nil {
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to receive packet: %w", err)
+	}
+	lc.received++
+
+	data := make([]byte, n)
+	copy(data, buf[:n])
+	return gopacket.NewPacket(data, layers.LayerTypeIPv4, gopacket.Default), nil
+}
+
+// readPacket6 reads an ICMPv6 message or TCP segment (next header proto)
+// and rebuilds the IPv6 header from the sender address and IPV6_PKTINFO, so
+// both families decode the same way
+func (lc *LinuxCapture) readPacket6(fd int, proto byte, buf []byte) (gopacket.Packet, error) {
+	oob := make([]byte, unix.CmsgSpace(unix.SizeofInet6Pktinfo))
+	n, oobn, _, from, err := unix.Recvmsg(fd, buf, oob, unix.MSG_DONTWAIT)
+	if err != nil {
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to receive IPv6 packet: %w", err)
+	}
+	lc.received++
+
+	src, ok := from.(*unix.SockaddrInet6)
+	if !ok {
+		return nil, nil
+	}
+
+	data := make([]byte, 40+n)
+	data[0] = 6 << 4
+	binary.BigEndian.PutUint16(data[4:6], uint16(n))
+	data[6] = proto
+	data[7] = 64 // Hop limit is not reported; it is not used for matching
+	copy(data[8:24], src.Addr[:])
+	copy(data[40:], buf[:n])
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err == nil {
+		for _, msg := range msgs {
+			if msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_PKTINFO &&
+				len(msg.Data) >= unix.SizeofInet6Pktinfo {
+				copy(data[24:40], msg.Data[:16])
+			}
+		}
+	}
+
+	return gopacket.NewPacket(data, layers.LayerTypeIPv6, gopacket.Default), nil
+}
+
+// pollTimeout returns the milliseconds to poll for with wait left until the
+// deadline: rounded up, so that a short wait still blocks, and no more than
+// pollInterval. A deadline already passed polls without blocking, so that
+// packets already queued are still read.
+func pollTimeout(wait time.Duration) int {
+	if wait <= 0 {
+		return 0
+	}
+	if wait > pollInterval {
+		wait = pollInterval
+	}
+	return int((wait + time.Millisecond - 1) / time.Millisecond)
+}
+
+// CaptureICMPResponse captures ICMP responses matching the specified criteria
+// Returns the response packet and the source IP
+func (lc *LinuxCapture) CaptureICMPResponse(srcIP net.IP, dstIP net.IP, expectedType layers.ICMPv4TypeCode) (gopacket.Packet, net.IP, error) {
+	buf := make([]byte, 65535)
+	deadline := time.Now().Add(lc.timeout)
+
+	for time.Now().Before(deadline) {
+		packet, err := lc.readPacket(buf, deadline)
+		if errors.Is(err, ErrTimeout) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if packet == nil {
+			continue
+		}
+
+		if from, ok := matchICMPResponse(packet, srcIP, dstIP, expectedType); ok {
+			return packet, from, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("timeout waiting for ICMP response")
+}
+
+// NextReply returns the next ICMP packet received within timeout, decoded
+func (lc *LinuxCapture) NextReply(timeout time.Duration) (*Reply, error) {
+	buf := make([]byte, 65535)
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		packet, err := lc.readPacket(buf, deadline)
+		if err != nil {
+			return nil, err
+		}
+		if packet == nil {
+			continue
+		}
+
+		if reply, ok := DecodeReply(packet, time.Now()); ok {
+			return reply, nil
+		}
+	}
+
+	return nil, ErrTimeout
+}
+
+// Close closes the raw sockets
+func (lc *LinuxCapture) Close() {
+	for _, fd := range []*int{&lc.fd, &lc.fd6, &lc.tcp, &lc.tcp6} {
+		if *fd >= 0 {
+			unix.Close(*fd)
+			*fd = -1
+		}
+	}
+}
+
+// GetInterface returns the interface name being captured
+func (lc *LinuxCapture) GetInterface() string {
+	return lc.iface
+}
+
+// GetStats returns capture statistics. Raw sockets do not report drops.
+func (lc *LinuxCapture) GetStats() (received, dropped, ifDropped uint, err error) {
+	return lc.received, 0, 0, nil
+}
@@ -0,0 +1,48 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPollTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		wait time.Duration
+		want int
+	}{
+		{-time.Second, 0},
+		{0, 0},
+		{500 * time.Microsecond, 1},
+		{10 * time.Millisecond, 10},
+		{10*time.Millisecond + time.Microsecond, 11},
+		{time.Second, int(pollInterval / time.Millisecond)},
+	} {
+		if got := pollTimeout(tc.wait); got != tc.want {
+			t.Errorf("pollTimeout(%v) = %d, want %d", tc.wait, got, tc.want)
+		}
+	}
+}
+
+func TestNextReplyHonoursTimeout(t *testing.T) {
+	c, err := NewCapture("", time.Second)
+	if err != nil {
+		t.Skipf("no raw sockets: %v", err)
+	}
+	defer c.Close()
+
+	// ICMP from elsewhere on the host may answer early, never late
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		_, err := c.NextReply(10 * time.Millisecond)
+		if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+			t.Fatalf("NextReply(10ms) took %v", elapsed)
+		}
+		if err != nil && !errors.Is(err, ErrTimeout) {
+			t.Fatalf("NextReply: %v", err)
+		}
+	}
+}
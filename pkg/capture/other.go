@@ -0,0 +1,32 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package capture
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// ListDevices is not supported on this platform
+func ListDevices() ([]Device, error) {
+	return nil, fmt.Errorf("packet capture is not supported on %s", runtime.GOOS)
+}
+
+// NewCapture is not supported on this platform
+func NewCapture(device string, timeout time.Duration) (Capture, error) {
+	return nil, fmt.Errorf("packet capture is not supported on %s", runtime.GOOS)
+}
+
+// NewTCPCapture is not supported on this platform
+func NewTCPCapture(device string, timeout time.Duration) (Capture, error) {
+	return NewCapture(device, timeout)
+}
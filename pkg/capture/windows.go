@@ -1,3 +1,4 @@
+//go:build windows
 // +build windows
 
 /* SPDX-License-Identifier: BSD-2-Clause */
@@ -12,7 +13,6 @@ package capture
 import (
 	"fmt"
 	"net"
-	"strings"
 	"time"
 
 	"github.com/google/gopacket"
@@ -27,13 +27,7 @@ type WindowsCapture struct {
 	handle  *pcap.Handle
 	iface   string
 	timeout time.Duration
-}
-
-// Device represents a network device available for capture
-type Device struct {
-	Name        string
-	Description string
-	Addresses   []string
+	packets chan gopacket.Packet // Shared packet source used by NextReply
 }
 
 // ListDevices returns all available network devices
@@ -106,7 +100,7 @@ func FindDefaultDevice() (string, error) {
 }
 
 // NewCapture creates a new packet capture instance
-func NewCapture(device string, timeout time.Duration) (*WindowsCapture, error) {
+func NewCapture(device string, timeout time.Duration) (Capture, error) {
 	// Verify admin privileges
 	if err := platform.RequireAdmin(); err != nil {
 		return nil, err
@@ -144,6 +138,12 @@ func NewCapture(device string, timeout time.Duration) (*WindowsCapture, error) {
 	}, nil
 }
 
+// NewTCPCapture is NewCapture; Npcap already sees the SYN-ACK and RST
+// segments targets send in answer to TCP probes
+func NewTCPCapture(device string, timeout time.Duration) (Capture, error) {
+	return NewCapture(device, timeout)
+}
+
 // SetBPFFilter applies a BPF filter to the capture
 func (wc *WindowsCapture) SetBPFFilter(filter string) error {
 	err := wc.handle.SetBPFFilter(filter)
@@ -168,52 +168,8 @@ func (wc *WindowsCapture) CaptureICMPResponse(srcIP net.IP, dstIP net.IP, expect
 				return nil, nil, fmt.Errorf("packet capture closed unexpectedly")
 			}
 
-			// Parse ICMP layer
-			icmpLayer := packet.Layer(layers.LayerTypeICMPv4)
-			if icmpLayer == nil {
-				continue
-			}
-
-			icmp, _ := icmpLayer.(*layers.ICMPv4)
-
-			// Check if this is the expected ICMP type
-			if expectedType != 0 && icmp.TypeCode != expectedType {
-				continue
-			}
-
-			// Parse IP layer to check source/destination
-			ipLayer := packet.Layer(layers.LayerTypeIPv4)
-			if ipLayer == nil {
-				continue
-			}
-
-			ip, _ := ipLayer.(*layers.IPv4)
-
-			// For Time Exceeded messages, check the embedded IP packet
-			if icmp.TypeCode.Type() == layers.ICMPv4TypeTimeExceeded {
-				// The payload contains the original IP header + 8 bytes of data
-				// We want to verify this was our packet
-				if len(icmp.Payload) < 20 {
-					continue // Not enough data for IP header
-				}
-
-				// Parse embedded IP header
-				embeddedIP := &layers.IPv4{}
-				err := embeddedIP.DecodeFromBytes(icmp.Payload, gopacket.NilDecodeFeedback)
-				if err != nil {
-					continue
-				}
-
-				// Check if embedded packet matches our probe
-				if embeddedIP.SrcIP.Equal(srcIP) && embeddedIP.DstIP.Equal(dstIP) {
-					return packet, ip.SrcIP, nil
-				}
-			} else if icmp.TypeCode.Type() == layers.ICMPv4TypeDestinationUnreachable ||
-				icmp.TypeCode.Type() == layers.ICMPv4TypeEchoReply {
-				// For other ICMP types, just check the outer IP addresses
-				if ip.DstIP.Equal(srcIP) {
-					return packet, ip.SrcIP, nil
-				}
+			if from, ok := matchICMPResponse(packet, srcIP, dstIP, expectedType); ok {
+				return packet, from, nil
 			}
 
 		case <-time.After(time.Until(deadline)):
@@ -227,6 +183,35 @@ func (wc *WindowsCapture) CaptureICMPResponse(srcIP net.IP, dstIP net.IP, expect
 	}
 }
 
+// NextReply returns the next ICMP packet received within timeout, decoded
+func (wc *WindowsCapture) NextReply(timeout time.Duration) (*Reply, error) {
+	// Reuse one packet source so no reader goroutine is started per call
+	if wc.packets == nil {
+		wc.packets = gopacket.NewPacketSource(wc.handle, wc.handle.LinkType()).Packets()
+	}
+
+	expired := time.After(timeout)
+	for {
+		select {
+		case packet, ok := <-wc.packets:
+			if !ok || packet == nil {
+				return nil, fmt.Errorf("packet capture closed unexpectedly")
+			}
+
+			timestamp := packet.Metadata().Timestamp
+			if timestamp.IsZero() {
+				timestamp = time.Now()
+			}
+			if reply, ok := DecodeReply(packet, timestamp); ok {
+				return reply, nil
+			}
+
+		case <-expired:
+			return nil, ErrTimeout
+		}
+	}
+}
+
 // CaptureMultipleResponses captures multiple ICMP responses within the timeout period
 func (wc *WindowsCapture) CaptureMultipleResponses(srcIP net.IP, dstIP net.IP, count int) ([]gopacket.Packet, []net.IP, error) {
 	packets := make([]gopacket.Packet, 0, count)
@@ -312,27 +297,3 @@ func (wc *WindowsCapture) GetStats() (received, dropped, ifDropped uint, err err
 	}
 	return uint(stats.PacketsReceived), uint(stats.PacketsDropped), uint(stats.PacketsIfDropped), nil
 }
-
-// PrintDeviceList prints all available devices in a user-friendly format
-func PrintDeviceList() error {
-	devices, err := ListDevices()
-	if err != nil {
-		return err
-	}
-
-	fmt.Println("\nAvailable Network Devices:")
-	fmt.Println(strings.Repeat("=", 80))
-
-	for i, dev := range devices {
-		fmt.Printf("\n[%d] %s\n", i+1, dev.Name)
-		if dev.Description != "" {
-			fmt.Printf("    Description: %s\n", dev.Description)
-		}
-		if len(dev.Addresses) > 0 {
-			fmt.Printf("    IP Addresses: %s\n", strings.Join(dev.Addresses, ", "))
-		}
-	}
-
-	fmt.Println()
-	return nil
-}
@@ -0,0 +1,280 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+// Package history keeps a local archive of traces. Each run is a JSON file
+// under runs/, written once and never modified, and index.jsonl lists one
+// run per line with its target, start time and path fingerprint, so that
+// queries read the index alone. Run files are never rewritten and the
+// index is only appended to, so the archive can be copied or backed up at
+// any time.
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+const (
+	indexFile = "index.jsonl"
+	runsDir   = "runs"
+
+	// EnvDir overrides the default location of the archive
+	EnvDir = "DUBLIN_TRACEROUTE_HISTORY"
+
+	idTime = "20060102T150405Z" // Start time in run IDs and file names
+)
+
+// ErrNotFound is returned for a run ID that is not in the archive
+var ErrNotFound = errors.New("run not found")
+
+// Entry is the index record of an archived run
+type Entry struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"` // Host name given for the trace, if not the address
+	Target      string    `json:"target"`
+	StartTime   time.Time `json:"start_time"`
+	Fingerprint string    `json:"fingerprint"` // See results.NetworkGraph.Fingerprint
+	Hops        int       `json:"hops"`
+	Reached     bool      `json:"reached"`
+	Paths       int       `json:"paths"`
+	File        string    `json:"file"` // Relative to the archive directory
+}
+
+// Matches reports whether the run was a trace to target, by name or address
+func (e Entry) Matches(target string) bool {
+	return target == "" || strings.EqualFold(e.Name, target) || e.Target == target
+}
+
+// Change is a run whose path fingerprint differs from the previous run to
+// the same target
+type Change struct {
+	Before Entry `json:"before"`
+	After  Entry `json:"after"`
+}
+
+// Archive is a trace archive in a directory
+type Archive struct {
+	dir string
+}
+
+// DefaultDir returns $DUBLIN_TRACEROUTE_HISTORY, or dublin-traceroute/history
+// in the user's configuration directory (%AppData% on Windows)
+func DefaultDir() (string, error) {
+	if dir := os.Getenv(EnvDir); dir != "" {
+		return dir, nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("no history directory (set %s): %w", EnvDir, err)
+	}
+	return filepath.Join(config, "dublin-traceroute", "history"), nil
+}
+
+// Open opens the archive in dir, creating the directory if needed
+func Open(dir string) (*Archive, error) {
+	if err := os.MkdirAll(filepath.Join(dir, runsDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	return &Archive{dir: dir}, nil
+}
+
+// Dir returns the directory of the archive
+func (a *Archive) Dir() string {
+	return a.dir
+}
+
+// Add archives a trace. name is the host name it was run for, or "" if
+// that was the address.
+func (a *Archive) Add(name string, tr *results.TracerouteResult) (Entry, error) {
+	data, err := tr.ToJSON()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	graph := tr.Graph()
+	entry := Entry{
+		Target:      tr.Target,
+		StartTime:   tr.StartTime.UTC(),
+		Fingerprint: graph.Fingerprint(),
+		Hops:        tr.GetHopCount(),
+		Reached:     tr.ReachedTarget(),
+		Paths:       len(graph.Paths()),
+	}
+	if name != tr.Target {
+		entry.Name = name
+	}
+
+	// Runs started in the same second get a suffix
+	base := entry.StartTime.Format(idTime) + "-" + fileSafe(tr.Target)
+	for n := 1; ; n++ {
+		entry.ID = base
+		if n > 1 {
+			entry.ID = fmt.Sprintf("%s-%d", base, n)
+		}
+		entry.File = filepath.ToSlash(filepath.Join(runsDir, entry.ID+".json"))
+		err = writeNew(filepath.Join(a.dir, entry.File), []byte(data))
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to archive run: %w", err)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to marshal index entry: %w", err)
+	}
+	index, err := os.OpenFile(filepath.Join(a.dir, indexFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to open history index: %w", err)
+	}
+	defer index.Close()
+	// One write per line, so that concurrent runs do not interleave
+	if _, err := index.Write(append(line, '\n')); err != nil {
+		return Entry{}, fmt.Errorf("failed to update history index: %w", err)
+	}
+	return entry, nil
+}
+
+// writeNew writes data to a file that must not exist yet
+func writeNew(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(filename)
+		return err
+	}
+	return f.Close()
+}
+
+// fileSafe replaces the characters of an address that file names cannot
+// hold on Windows
+func fileSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '-'
+		}
+		return r
+	}, s)
+}
+
+// Entries returns every archived run, oldest first. A last line cut short
+// by a run that was interrupted while writing it is skipped.
+func (a *Archive) Entries() ([]Entry, error) {
+	data, err := os.ReadFile(filepath.Join(a.dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history index: %w", err)
+	}
+
+	var entries []Entry
+	lines := bytes.Split(data, []byte("\n"))
+	for n, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			if n == len(lines)-1 {
+				break // No newline yet: the run is still being, or was never, written
+			}
+			return nil, fmt.Errorf("history index line %d: %w", n+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].StartTime.Before(entries[j].StartTime) })
+	return entries, nil
+}
+
+// List returns the runs to target, by name or address, oldest first; all
+// runs if target is ""
+func (a *Archive) List(target string) ([]Entry, error) {
+	entries, err := a.Entries()
+	if err != nil {
+		return nil, err
+	}
+	matching := make([]Entry, 0)
+	for _, entry := range entries {
+		if entry.Matches(target) {
+			matching = append(matching, entry)
+		}
+	}
+	return matching, nil
+}
+
+// Changes returns, oldest first, the runs to target whose path fingerprint
+// differs from the run before them to the same address
+func (a *Archive) Changes(target string) ([]Change, error) {
+	entries, err := a.List(target)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]Change, 0)
+	last := make(map[string]Entry)
+	for _, entry := range entries {
+		if before, ok := last[entry.Target]; ok && before.Fingerprint != entry.Fingerprint {
+			changes = append(changes, Change{Before: before, After: entry})
+		}
+		last[entry.Target] = entry
+	}
+	return changes, nil
+}
+
+// Find returns the index entry of a run
+func (a *Archive) Find(id string) (Entry, error) {
+	entries, err := a.Entries()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return Entry{}, fmt.Errorf("%s: %w", id, ErrNotFound)
+}
+
+// Extract returns the JSON of a run, as saved by -output-json
+func (a *Archive) Extract(id string) ([]byte, error) {
+	entry, err := a.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(a.dir, filepath.FromSlash(entry.File)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run %s: %w", id, err)
+	}
+	return data, nil
+}
+
+// Load returns an archived run
+func (a *Archive) Load(id string) (*results.TracerouteResult, error) {
+	data, err := a.Extract(id)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := results.FromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("run %s: %w", id, err)
+	}
+	return tr, nil
+}
@@ -0,0 +1,131 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+// run returns a trace started at minute past midnight through routers
+func run(target string, minute int, routers ...string) *results.TracerouteResult {
+	tr := &results.TracerouteResult{
+		Target:    target,
+		StartTime: time.Date(2026, 10, 17, 0, minute, 0, 0, time.UTC),
+		Hops:      make(map[uint8]*results.HopResult),
+	}
+	for i, ip := range append(routers, target) {
+		ttl := uint8(i + 1)
+		tr.Hops[ttl] = &results.HopResult{TTL: ttl, Flows: map[uint16]*results.FlowResult{
+			0: {FlowID: 0, SrcPort: 33434, DstPort: 33434, ResponseIP: ip, RTT: time.Duration(ttl) * time.Millisecond},
+		}}
+	}
+	return tr
+}
+
+func ids(entries []Entry) []string {
+	var list []string
+	for _, e := range entries {
+		list = append(list, e.ID)
+	}
+	return list
+}
+
+func TestArchive(t *testing.T) {
+	archive, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Added out of order, and twice in the same second
+	for _, add := range []struct {
+		name string
+		tr   *results.TracerouteResult
+	}{
+		{"example.com", run("93.184.215.14", 2, "10.0.0.1", "10.1.0.1")},
+		{"example.com", run("93.184.215.14", 1, "10.0.0.1", "10.1.0.1")},
+		{"", run("8.8.8.8", 1, "10.0.0.1")},
+		{"example.com", run("93.184.215.14", 3, "10.0.0.1", "10.2.0.1")},
+		{"example.com", run("93.184.215.14", 3, "10.0.0.1", "10.2.0.1")},
+	} {
+		if _, err := archive.Add(add.name, add.tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := archive.List("EXAMPLE.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"20261017T000100Z-93.184.215.14",
+		"20261017T000200Z-93.184.215.14",
+		"20261017T000300Z-93.184.215.14",
+		"20261017T000300Z-93.184.215.14-2",
+	}
+	if !reflect.DeepEqual(ids(entries), want) {
+		t.Errorf("got runs %v, want %v", ids(entries), want)
+	}
+	if e := entries[0]; e.Name != "example.com" || e.Hops != 3 || !e.Reached || e.Paths != 1 {
+		t.Errorf("got entry %+v", e)
+	}
+
+	changes, err := archive.Changes("93.184.215.14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Before.ID != want[1] || changes[0].After.ID != want[2] {
+		t.Errorf("got changes %+v", changes)
+	}
+
+	tr, err := archive.Load(want[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Hops[2].Flows[0].ResponseIP != "10.2.0.1" {
+		t.Errorf("loaded the wrong run: %+v", tr.Hops[2].Flows[0])
+	}
+	if _, err := archive.Extract("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestEntriesSkipsUnfinishedLine(t *testing.T) {
+	dir := t.TempDir()
+	archive, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archive.Add("", run("8.8.8.8", 1)); err != nil {
+		t.Fatal(err)
+	}
+	index := filepath.Join(dir, indexFile)
+	f, err := os.OpenFile(index, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"2026`)
+	f.Close()
+
+	entries, err := archive.Entries()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("got %d entries, %v", len(entries), err)
+	}
+
+	// A broken line before others is corruption, not a run being written
+	if err := os.WriteFile(index, []byte("{\"id\":\n{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archive.Entries(); err == nil {
+		t.Error("corrupt index accepted")
+	}
+}
+
+func TestFileSafe(t *testing.T) {
+	if got := fileSafe("2001:db8::1"); got != "2001-db8--1" {
+		t.Errorf("got %q", got)
+	}
+}
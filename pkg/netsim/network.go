@@ -0,0 +1,570 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+// Package netsim is an in-process network simulator for deterministic
+// traceroute tests. A Network implements the probe.PacketConn and
+// probe.Clock interfaces: probes written to it are forwarded hop by hop
+// through a YAML-defined topology and answered the way real routers answer,
+// on a virtual clock, so a full multipath trace runs in milliseconds.
+package netsim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+)
+
+// maxForwardingSteps guards against forwarding loops in a topology
+const maxForwardingSteps = 255
+
+// epoch is the virtual time at which every simulation starts
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ErrClosed is returned when using a closed Network
+var ErrClosed = errors.New("simulated network closed")
+
+// node is a router or host in the simulated network
+type node struct {
+	cfg      NodeConfig
+	addr     net.IP
+	prefixes []*net.IPNet
+	next     []*link
+
+	// ICMP rate limiter state
+	tokens     float64
+	lastRefill time.Time
+
+	// Next IP ID assigned by a NAT box
+	natID uint16
+}
+
+// link is a forwarding link to the next node
+type link struct {
+	cfg LinkConfig
+	to  *node
+}
+
+// pendingReply is a reply scheduled for delivery on the virtual clock
+type pendingReply struct {
+	arrival time.Time
+	seq     int // Tie-breaker keeping delivery order stable
+	reply   *capture.Reply
+}
+
+// Network is a simulated network bound to one probing host
+type Network struct {
+	mu      sync.Mutex
+	source  net.IP
+	origin  *node
+	nodes   map[string]*node
+	rng     *rand.Rand
+	now     time.Time
+	pending []pendingReply
+	seq     int
+	ipID    uint16
+	sent    int
+	closed  bool
+
+	users   int       // Goroutines sharing the clock, see Join
+	waiting []*waiter // Goroutines blocked until the clock reaches their time
+	moved   *sync.Cond
+}
+
+// waiter is a goroutine blocked in Sleep or ReadReply
+type waiter struct {
+	until    time.Time // When it wakes, or gives up on a reply
+	reading  bool      // Woken early by the next reply
+	released bool
+}
+
+// Load builds a Network from a YAML topology file
+func Load(filename string) (*Network, error) {
+	topo, err := LoadTopology(filename)
+	if err != nil {
+		return nil, err
+	}
+	return New(topo)
+}
+
+// New builds a Network from a validated topology
+func New(topo *Topology) (*Network, error) {
+	if err := topo.Validate(); err != nil {
+		return nil, err
+	}
+
+	n := &Network{
+		source: net.ParseIP(topo.Source),
+		nodes:  make(map[string]*node),
+		rng:    rand.New(rand.NewSource(topo.Seed)),
+		now:    epoch,
+		users:  1,
+	}
+	n.moved = sync.NewCond(&n.mu)
+	if v4 := n.source.To4(); v4 != nil {
+		n.source = v4
+	}
+
+	n.origin = &node{cfg: NodeConfig{Name: SourceNode}, addr: n.source}
+	n.nodes[SourceNode] = n.origin
+
+	for _, cfg := range topo.Nodes {
+		nd := &node{
+			cfg:        cfg,
+			addr:       net.ParseIP(cfg.Address),
+			lastRefill: epoch,
+			natID:      0x8000,
+		}
+		if v4 := nd.addr.To4(); v4 != nil {
+			nd.addr = v4
+		}
+		for _, prefix := range cfg.Prefixes {
+			_, ipNet, _ := net.ParseCIDR(prefix)
+			nd.prefixes = append(nd.prefixes, ipNet)
+		}
+		if cfg.RateLimit != nil {
+			nd.tokens = float64(cfg.RateLimit.Burst)
+		}
+		n.nodes[cfg.Name] = nd
+	}
+
+	for _, cfg := range topo.Links {
+		from := n.nodes[cfg.From]
+		from.next = append(from.next, &link{cfg: cfg, to: n.nodes[cfg.To]})
+	}
+
+	if len(n.origin.next) == 0 {
+		return nil, fmt.Errorf("topology has no link from %s", SourceNode)
+	}
+
+	return n, nil
+}
+
+// Source returns the address of the simulated probing host
+func (n *Network) Source() net.IP {
+	return n.source
+}
+
+// Sent returns the number of packets written to the network
+func (n *Network) Sent() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.sent
+}
+
+// Now returns the current virtual time
+func (n *Network) Now() time.Time {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.now
+}
+
+// Sleep advances the virtual clock by d; with other goroutines sharing
+// the clock it blocks until they all wait for a later time
+func (n *Network) Sleep(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.wait(&waiter{until: n.now.Add(max(d, 0))})
+}
+
+// Join adds a goroutine sharing the clock, such as the sender of a probing
+// round, until it calls Leave. The clock only moves once every goroutine
+// sharing it waits in Sleep or ReadReply, and then wakes the one due first,
+// so goroutines that send and read at once see the same times on every run.
+func (n *Network) Join() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.users++
+}
+
+// Leave removes a goroutine added by Join
+func (n *Network) Leave() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.users--
+	n.advance()
+}
+
+// wait blocks until the clock reaches w; the caller holds n.mu
+func (n *Network) wait(w *waiter) {
+	n.waiting = append(n.waiting, w)
+	n.advance()
+	for !w.released && !n.closed {
+		n.moved.Wait()
+	}
+}
+
+// advance moves the clock to the waiter due first once every goroutine
+// sharing it waits, and wakes that waiter. A reader goes first on a tie,
+// so replies due at a send time are read before it.
+func (n *Network) advance() {
+	if len(n.waiting) == 0 || len(n.waiting) < n.users {
+		return
+	}
+	first := 0
+	for i, w := range n.waiting {
+		due, firstDue := n.due(w), n.due(n.waiting[first])
+		if due.Before(firstDue) || due.Equal(firstDue) && w.reading && !n.waiting[first].reading {
+			first = i
+		}
+	}
+	w := n.waiting[first]
+	n.waiting = append(n.waiting[:first], n.waiting[first+1:]...)
+	if due := n.due(w); due.After(n.now) {
+		n.now = due
+	}
+	w.released = true
+	n.moved.Broadcast()
+}
+
+// due is when w wakes: at its time, or for a reader when the next reply
+// arrives if that is sooner
+func (n *Network) due(w *waiter) time.Time {
+	if w.reading && len(n.pending) > 0 && n.pending[0].arrival.Before(w.until) {
+		return n.pending[0].arrival
+	}
+	return w.until
+}
+
+// WritePacket forwards a probe through the topology and schedules the
+// reply, if any, on the virtual clock
+func (n *Network) WritePacket(packet []byte, dst net.IP) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return ErrClosed
+	}
+	n.sent++
+
+	pkt, err := parseIPv4(packet)
+	if err != nil {
+		return fmt.Errorf("simulated network: %w", err)
+	}
+
+	reply, rtt, ok := n.forward(pkt)
+	if !ok {
+		return nil
+	}
+
+	n.seq++
+	n.pending = append(n.pending, pendingReply{arrival: n.now.Add(rtt), seq: n.seq, reply: reply})
+	sort.Slice(n.pending, func(i, j int) bool {
+		if n.pending[i].arrival.Equal(n.pending[j].arrival) {
+			return n.pending[i].seq < n.pending[j].seq
+		}
+		return n.pending[i].arrival.Before(n.pending[j].arrival)
+	})
+	return nil
+}
+
+// ReadReply returns the next reply arriving within timeout, advancing the
+// virtual clock to its arrival time, or advances the clock by timeout and
+// returns capture.ErrTimeout. Like Sleep, it waits for the goroutines that
+// share the clock.
+func (n *Network) ReadReply(timeout time.Duration) (*capture.Reply, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return nil, ErrClosed
+	}
+
+	n.wait(&waiter{until: n.now.Add(timeout), reading: true})
+	if n.closed {
+		return nil, ErrClosed
+	}
+	if len(n.pending) > 0 && !n.pending[0].arrival.After(n.now) {
+		next := n.pending[0]
+		n.pending = n.pending[1:]
+		return next.reply, nil
+	}
+	return nil, capture.ErrTimeout
+}
+
+// Close stops the network; further reads and writes fail
+func (n *Network) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	n.moved.Broadcast()
+	return nil
+}
+
+// forward walks the packet hop by hop and returns the reply it triggers and
+// the round-trip time, or false if the probe or its reply was lost
+func (n *Network) forward(pkt ipv4Packet) (*capture.Reply, time.Duration, bool) {
+	original := pkt.quote()
+	dst := append(net.IP(nil), pkt.dst()...)
+
+	var path []*link
+	var delay time.Duration
+	from := n.origin
+
+	for step := 0; step < maxForwardingSteps; step++ {
+		l := n.choose(from, pkt)
+		if l == nil {
+			return nil, 0, false // no route
+		}
+		if n.lost(l) {
+			return nil, 0, false
+		}
+		delay += n.linkDelay(l)
+		path = append(path, l)
+		nd := l.to
+
+		if nd.owns(dst) {
+			return n.destinationReply(nd, pkt, original, path, delay)
+		}
+
+		if pkt.ttl() <= 1 {
+			quoted := n.translateQuote(pkt.quote(), original, path)
+			return n.icmpReply(nd, nd.addr, layers.ICMPv4TypeTimeExceeded, layers.ICMPv4CodeTTLExceeded, nil, quoted, path, delay)
+		}
+
+		pkt.setTTL(pkt.ttl() - 1)
+		if nd.cfg.NAT != nil {
+			pkt.setSrc(net.ParseIP(nd.cfg.NAT.Public))
+			if nd.cfg.NAT.RewriteID {
+				pkt.setID(nd.natID)
+				nd.natID++
+			}
+		}
+		from = nd
+	}
+
+	return nil, 0, false
+}
+
+// destinationReply answers a probe that reached its destination host
+func (n *Network) destinationReply(nd *node, pkt, original ipv4Packet, path []*link, delay time.Duration) (*capture.Reply, time.Duration, bool) {
+	switch pkt.protocol() {
+	case protoUDP:
+		quoted := n.translateQuote(pkt.quote(), original, path)
+		return n.icmpReply(nd, pkt.dst(), layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodePort, nil, quoted, path, delay)
+	case protoICMP:
+		echo := pkt.transport()
+		if len(echo) < 8 || echo[0] != layers.ICMPv4TypeEchoRequest {
+			return nil, 0, false
+		}
+		return n.icmpReply(nd, pkt.dst(), layers.ICMPv4TypeEchoReply, 0, echo[4:8], echo[8:], path, delay)
+	case protoTCP:
+		return n.tcpReply(nd, pkt, path, delay)
+	}
+	return nil, 0, false
+}
+
+// tcpReply answers a SYN at the destination: SYN-ACK from an open port, RST
+// from a closed one. TCP answers are not subject to ICMP rate limiting.
+func (n *Network) tcpReply(nd *node, pkt ipv4Packet, path []*link, delay time.Duration) (*capture.Reply, time.Duration, bool) {
+	seg := pkt.transport()
+	if len(seg) < 20 || seg[13]&tcpSYN == 0 {
+		return nil, 0, false
+	}
+	srcPort := binary.BigEndian.Uint16(seg[0:2])
+	dstPort := binary.BigEndian.Uint16(seg[2:4])
+	ack := binary.BigEndian.Uint32(seg[4:8]) + 1
+
+	flags := byte(tcpRST | tcpACK)
+	for _, port := range nd.cfg.OpenPorts {
+		if port == dstPort {
+			flags = tcpSYN | tcpACK
+		}
+	}
+
+	delay, ok := n.returnTrip(path, delay)
+	if !ok {
+		return nil, 0, false
+	}
+
+	n.ipID++
+	raw := buildTCPv4(pkt.dst(), n.source, n.ipID, dstPort, srcPort, ack, flags)
+	packet := gopacket.NewPacket(raw, layers.LayerTypeIPv4, gopacket.Default)
+	reply, ok := capture.DecodeReply(packet, n.now.Add(delay))
+	if !ok {
+		return nil, 0, false
+	}
+	return reply, delay, true
+}
+
+// icmpReply builds the ICMP message sent by nd from address from, applying
+// silence, rate limiting and the return trip over path
+func (n *Network) icmpReply(nd *node, from net.IP, icmpType, icmpCode uint8, rest, body []byte, path []*link, delay time.Duration) (*capture.Reply, time.Duration, bool) {
+	if nd.cfg.Silent || !n.allowICMP(nd, delay) {
+		return nil, 0, false
+	}
+
+	delay, ok := n.returnTrip(path, delay)
+	if !ok {
+		return nil, 0, false
+	}
+
+	n.ipID++
+	raw := buildICMPv4(from, n.source, n.ipID, icmpType, icmpCode, rest, body)
+	packet := gopacket.NewPacket(raw, layers.LayerTypeIPv4, gopacket.Default)
+	reply, ok := capture.DecodeReply(packet, n.now.Add(delay))
+	if !ok {
+		return nil, 0, false
+	}
+	return reply, delay, true
+}
+
+// returnTrip adds the delay of the reply travelling back over path to the
+// forward delay, or returns false if the reply was lost
+func (n *Network) returnTrip(path []*link, delay time.Duration) (time.Duration, bool) {
+	for i := len(path) - 1; i >= 0; i-- {
+		if n.lost(path[i]) {
+			return 0, false
+		}
+		delay += n.linkDelay(path[i])
+	}
+	return delay, true
+}
+
+// translateQuote undoes source NAT on the packet quoted in an ICMP error,
+// as NAT boxes do for returning errors. Addresses are restored, but the
+// IP ID and transport checksum stay as rewritten.
+func (n *Network) translateQuote(quoted, original ipv4Packet, path []*link) []byte {
+	for _, l := range path[:len(path)-1] {
+		if l.to.cfg.NAT != nil {
+			copy(quoted[12:16], original[12:16])
+			quoted.updateHeaderChecksum()
+			break
+		}
+	}
+	return quoted
+}
+
+// allowICMP applies the node's token bucket at the time the probe arrives
+func (n *Network) allowICMP(nd *node, delay time.Duration) bool {
+	limit := nd.cfg.RateLimit
+	if limit == nil {
+		return true
+	}
+
+	at := n.now.Add(delay)
+	if at.After(nd.lastRefill) {
+		nd.tokens += at.Sub(nd.lastRefill).Seconds() * limit.Rate
+		if nd.tokens > float64(limit.Burst) {
+			nd.tokens = float64(limit.Burst)
+		}
+		nd.lastRefill = at
+	}
+
+	if nd.tokens < 1 {
+		return false
+	}
+	nd.tokens--
+	return true
+}
+
+// choose selects the outgoing link for pkt, hashing over the node's ECMP
+// fields when there are several next hops
+func (n *Network) choose(from *node, pkt ipv4Packet) *link {
+	switch len(from.next) {
+	case 0:
+		return nil
+	case 1:
+		return from.next[0]
+	}
+
+	total := 0
+	for _, l := range from.next {
+		total += l.weight()
+	}
+
+	var slot int
+	fields := from.cfg.Hash
+	if len(fields) == 0 {
+		fields = defaultHash
+	}
+	if len(fields) == 1 && fields[0] == HashPerPacket {
+		slot = n.rng.Intn(total)
+	} else {
+		slot = int(flowHash(from.cfg.Name, fields, pkt) % uint32(total))
+	}
+
+	for _, l := range from.next {
+		slot -= l.weight()
+		if slot < 0 {
+			return l
+		}
+	}
+	return from.next[len(from.next)-1]
+}
+
+// flowHash hashes the selected header fields, salted with the router name
+// so that consecutive load balancers make independent choices
+func flowHash(salt string, fields []string, pkt ipv4Packet) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(salt))
+	l4 := pkt.transport()
+	for _, field := range fields {
+		switch field {
+		case HashSrc:
+			h.Write(pkt.src())
+		case HashDst:
+			h.Write(pkt.dst())
+		case HashSrcPort:
+			h.Write(l4[0:2])
+		case HashDstPort:
+			h.Write(l4[2:4])
+		case HashProto:
+			h.Write([]byte{pkt.protocol()})
+		case HashTOS:
+			h.Write([]byte{pkt.tos()})
+		case HashID:
+			h.Write(pkt.id())
+		}
+	}
+	// Mix the FNV output so small input changes spread over the low bits
+	return (h.Sum32() * 0x9e3779b1) >> 7
+}
+
+// lost decides whether a traversal of l drops the packet
+func (n *Network) lost(l *link) bool {
+	return l.cfg.Loss > 0 && n.rng.Float64() < l.cfg.Loss
+}
+
+// linkDelay returns the one-way delay of l including jitter
+func (n *Network) linkDelay(l *link) time.Duration {
+	delay := l.cfg.Delay
+	if l.cfg.Jitter > 0 {
+		delay += time.Duration(n.rng.Int63n(int64(l.cfg.Jitter)))
+	}
+	return delay
+}
+
+// owns reports whether nd is the host addressed by dst
+func (nd *node) owns(dst net.IP) bool {
+	if nd.addr.Equal(dst) {
+		return true
+	}
+	for _, prefix := range nd.prefixes {
+		if prefix.Contains(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *link) weight() int {
+	if l.cfg.Weight <= 0 {
+		return 1
+	}
+	return l.cfg.Weight
+}
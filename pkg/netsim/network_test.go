@@ -0,0 +1,689 @@
+package netsim
+
+import (
+	"math"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/probe"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+var target = net.ParseIP("8.8.8.8").To4()
+
+// Network must be usable wherever the probes expect real I/O
+var (
+	_ probe.PacketConn = (*Network)(nil)
+	_ probe.Clock      = (*Network)(nil)
+)
+
+func loadNetwork(t *testing.T, name string) *Network {
+	t.Helper()
+	n, err := Load(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Load(%s): %v", name, err)
+	}
+	return n
+}
+
+// runUDPTrace runs a UDP multipath trace to 8.8.8.8 over a topology
+func runUDPTrace(t *testing.T, name string, numPaths uint16, count int) *results.TracerouteResult {
+	t.Helper()
+	n := loadNetwork(t, name)
+	p := probe.NewUDPProbeWithConn(n, target, n.Source(), 33434, 33434, numPaths, 1, 30, count)
+	p.SetClock(n)
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+	return result
+}
+
+// udpProbe crafts a UDP probe from the topology source
+func udpProbe(t *testing.T, src net.IP, ttl uint8, srcPort uint16) []byte {
+	t.Helper()
+	ip := &layers.IPv4{Version: 4, IHL: 5, Id: 1234, TTL: ttl, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: target}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: 33434}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload([]byte("dublin"))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLinearTopology(t *testing.T) {
+	result := runUDPTrace(t, "linear.yaml", 4, 1)
+
+	want := []string{"192.168.1.1", "10.0.0.1", "172.16.0.1", "8.8.8.8"}
+	paths := result.Graph().Paths()
+	if len(paths) != 1 {
+		t.Fatalf("got %d paths, want the single route shared by every flow", len(paths))
+	}
+	for _, path := range paths {
+		var got []string
+		for _, hop := range path.Hops {
+			got = append(got, hop.IP)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("path %d: got %v, want %v", path.PathID, got, want)
+		}
+	}
+
+	// Without jitter the RTT is exactly twice the one-way link delays
+	if rtt := result.Hops[2].Flows[0].RTT; rtt != 10*time.Millisecond {
+		t.Errorf("TTL 2 RTT: got %v, want 10ms", rtt)
+	}
+	if result.AnalyzeNetwork().HasLoadBalancing {
+		t.Error("linear topology must not report load balancing")
+	}
+}
+
+func TestLateRepliesAreNotMisattributed(t *testing.T) {
+	n := loadNetwork(t, "linear.yaml")
+	p := probe.NewUDPProbeWithConn(n, target, n.Source(), 33434, 33434, 2, 1, 3, 2)
+	p.SetClock(n)
+	p.SetDelay(0)
+	p.ResolveNames = false
+
+	// TTL 3 answers after 20ms, so the first round's replies arrive during
+	// the second round
+	p.SetTimeout(12 * time.Millisecond)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	for _, flow := range result.Hops[3].Flows {
+		if flow.Error != "timeout" {
+			t.Errorf("TTL 3 flow %d: got reply from %s, want a timeout", flow.FlowID, flow.ResponseIP)
+		}
+	}
+	if len(result.LateReplies) != 2 {
+		t.Fatalf("got late replies %+v, want those of the first round", result.LateReplies)
+	}
+	for i, late := range result.LateReplies {
+		if late.TTL != 3 || late.FlowID != uint16(i) || late.From != "172.16.0.1" || late.RTT != 20*time.Millisecond {
+			t.Errorf("got late reply %+v", late)
+		}
+	}
+	if len(result.UnmatchedReplies) != 0 {
+		t.Errorf("got unmatched replies %+v", result.UnmatchedReplies)
+	}
+}
+
+func TestTCPTraceStopsAtTarget(t *testing.T) {
+	topo, err := LoadTopology(filepath.Join("testdata", "linear.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	topo.Nodes[len(topo.Nodes)-1].OpenPorts = []uint16{443}
+
+	for port, want := range map[uint16]string{443: results.PortOpen, 80: results.PortClosed} {
+		n, err := New(topo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := probe.NewTCPProbeWithConn(n, target, n.Source(), 50000, port, 2, 1, 30, 2)
+		p.SetClock(n)
+		p.ResolveNames = false
+
+		result, err := p.Traceroute()
+		if err != nil {
+			t.Fatalf("Traceroute: %v", err)
+		}
+		if result.PortState != want {
+			t.Errorf("port %d: got %q, want %q", port, result.PortState, want)
+		}
+		if len(result.Hops) != 4 {
+			t.Errorf("port %d: got %d hops, want the trace to end at the target on TTL 4", port, len(result.Hops))
+		}
+		for _, flow := range result.Hops[4].Flows {
+			if flow.ResponseIP != "8.8.8.8" || flow.TCPState != want || flow.RTT != 40*time.Millisecond {
+				t.Errorf("port %d: flow %d got %s state %q RTT %v", port, flow.FlowID, flow.ResponseIP, flow.TCPState, flow.RTT)
+			}
+		}
+	}
+}
+
+func TestDiamondTopology(t *testing.T) {
+	result := runUDPTrace(t, "diamond.yaml", 8, 1)
+
+	analysis := result.AnalyzeNetwork()
+	lbHops := append([]uint8(nil), analysis.LoadBalancingHops...)
+	sort.Slice(lbHops, func(i, j int) bool { return lbHops[i] < lbHops[j] })
+	if !reflect.DeepEqual(lbHops, []uint8{3, 4}) {
+		t.Errorf("got load balancing hops %v, want [3 4]", lbHops)
+	}
+	if !result.HasMultiplePaths() {
+		t.Error("expected multiple distinct paths")
+	}
+
+	graph := result.Graph()
+	if paths := graph.Paths(); len(paths) != 2 {
+		t.Errorf("got %d distinct paths, want 2: %+v", len(paths), paths)
+	}
+	want := []results.Diamond{{
+		Divergence:  results.NodeKey{TTL: 2, IP: "10.0.0.1"},
+		Convergence: &results.NodeKey{TTL: 5, IP: "10.3.0.1"},
+		Width:       2,
+		Length:      3,
+	}}
+	if !reflect.DeepEqual(graph.Diamonds, want) {
+		t.Errorf("got diamonds %+v, want %+v", graph.Diamonds, want)
+	}
+
+	// Both branches converge again before the destination
+	for _, flow := range result.Hops[5].Flows {
+		if flow.ResponseIP != "10.3.0.1" {
+			t.Errorf("TTL 5: got %s, want convergence at 10.3.0.1", flow.ResponseIP)
+		}
+	}
+}
+
+func TestICMPParisTraceroute(t *testing.T) {
+	n := loadNetwork(t, "diamond.yaml")
+	p := probe.NewICMPProbeWithConn(n, target, n.Source(), 4242, 8, 1, 30, 3)
+	p.SetClock(n)
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	if !result.AnalyzeNetwork().HasLoadBalancing {
+		t.Error("Echo flows with different checksums should spread over both branches")
+	}
+
+	// A constant checksum keeps every round of a flow on the same branch
+	byEchoID := make(map[uint16]string)
+	for _, flow := range result.Hops[3].Flows {
+		if prev, ok := byEchoID[flow.EchoID]; ok && prev != flow.ResponseIP {
+			t.Errorf("echo id %d moved from %s to %s", flow.EchoID, prev, flow.ResponseIP)
+		}
+		byEchoID[flow.EchoID] = flow.ResponseIP
+	}
+
+	last := result.Hops[uint8(len(result.Hops))]
+	for _, flow := range last.Flows {
+		if flow.ICMPType != layers.ICMPv4TypeEchoReply || flow.ResponseIP != "8.8.8.8" {
+			t.Errorf("last hop: got type %d from %s, want Echo Reply from target", flow.ICMPType, flow.ResponseIP)
+		}
+	}
+}
+
+func TestPerFlowHashIsStable(t *testing.T) {
+	result := runUDPTrace(t, "diamond.yaml", 4, 3)
+
+	// Same source port in every round must hit the same branch
+	bySrcPort := make(map[uint16]string)
+	for _, flow := range result.Hops[3].Flows {
+		if prev, ok := bySrcPort[flow.SrcPort]; ok && prev != flow.ResponseIP {
+			t.Errorf("port %d moved from %s to %s", flow.SrcPort, prev, flow.ResponseIP)
+		}
+		bySrcPort[flow.SrcPort] = flow.ResponseIP
+	}
+}
+
+func TestHostilePathIsDeterministic(t *testing.T) {
+	first := runUDPTrace(t, "lossy.yaml", 4, 3)
+	second := runUDPTrace(t, "lossy.yaml", 4, 3)
+
+	if !reflect.DeepEqual(first.Hops, second.Hops) {
+		t.Error("same seed must produce identical results")
+	}
+	if first.Duration != second.Duration {
+		t.Errorf("virtual durations differ: %v vs %v", first.Duration, second.Duration)
+	}
+}
+
+func TestProbesOfARoundAreInFlightTogether(t *testing.T) {
+	result := runUDPTrace(t, "diamond.yaml", 8, 1)
+
+	// Waiting for each reply in turn would take one round trip per probe;
+	// sending them all at once takes little more than the 10ms pacing
+	if sending := 8 * 30 * 10 * time.Millisecond; result.Duration > sending+100*time.Millisecond {
+		t.Errorf("8-path trace took %v, want about %v", result.Duration, sending)
+	}
+	if len(result.Hops) != 6 {
+		t.Errorf("got %d hops, want the trace to end at the target on TTL 6", len(result.Hops))
+	}
+}
+
+func TestSilentAndRateLimitedHops(t *testing.T) {
+	result := runUDPTrace(t, "lossy.yaml", 4, 3)
+
+	for _, flow := range result.Hops[2].Flows {
+		if flow.Error != "timeout" {
+			t.Errorf("silent hop answered from %s", flow.ResponseIP)
+		}
+	}
+
+	answered := 0
+	for _, flow := range result.Hops[3].Flows {
+		if flow.Error == "" {
+			answered++
+		}
+	}
+	if answered == 0 || answered == len(result.Hops[3].Flows) {
+		t.Errorf("rate limited hop answered %d/%d probes, want some but not all",
+			answered, len(result.Hops[3].Flows))
+	}
+}
+
+func TestPerPacketLoadBalancer(t *testing.T) {
+	result := runUDPTrace(t, "lossy.yaml", 1, 10)
+
+	seen := make(map[string]bool)
+	for _, flow := range result.Hops[5].Flows {
+		if flow.ResponseIP != "" {
+			seen[flow.ResponseIP] = true
+		}
+	}
+	if len(seen) != 2 {
+		t.Errorf("a single flow should be sprayed over both branches, saw %v", seen)
+	}
+}
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Now()
+	result := runUDPTrace(t, "lossy.yaml", 8, 3)
+
+	// Rounds with silent hops wait out the timeout on the virtual clock
+	if result.Duration < 10*time.Second {
+		t.Errorf("virtual duration %v, expected timeouts to advance the clock", result.Duration)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("simulation took %v of real time", elapsed)
+	}
+}
+
+func TestNATTranslatesQuotedSource(t *testing.T) {
+	n := loadNetwork(t, "nat.yaml")
+	src := n.Source()
+
+	// TTL 3 expires at the ISP router, behind both NATs
+	if err := n.WritePacket(udpProbe(t, src, 3, 40000), target); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := n.ReadReply(time.Second)
+	if err != nil {
+		t.Fatalf("ReadReply: %v", err)
+	}
+	if !reply.From.Equal(net.ParseIP("198.51.100.1")) {
+		t.Errorf("got reply from %s, want 198.51.100.1", reply.From)
+	}
+	if !reply.To.Equal(src) || !reply.InnerSrc.Equal(src) {
+		t.Errorf("NAT must translate the error back: outer dst %s, quoted src %s", reply.To, reply.InnerSrc)
+	}
+	if !reply.Matches(src, target) {
+		t.Error("translated reply should match the original probe")
+	}
+}
+
+func TestUDPTraceDetectsNATs(t *testing.T) {
+	result := runUDPTrace(t, "nat.yaml", 4, 1)
+
+	// The CPE's own Time Exceeded quotes the probe as sent
+	for _, flow := range result.Hops[1].Flows {
+		if flow.NATID != 0 || len(flow.NATRewritten) != 0 {
+			t.Errorf("TTL 1 flow %d: got NAT ID %d rewritten %v before any NAT", flow.FlowID, flow.NATID, flow.NATRewritten)
+		}
+	}
+	for _, flow := range result.Hops[2].Flows {
+		if flow.NATID == 0 || len(flow.NATRewritten) != 2 {
+			t.Errorf("TTL 2 flow %d: got NAT ID %d rewritten %v behind the CPE", flow.FlowID, flow.NATID, flow.NATRewritten)
+		}
+	}
+
+	analysis := result.AnalyzeNetwork()
+	if !analysis.NATDetected || len(analysis.NATHops) != 2 {
+		t.Fatalf("got NAT hops %+v, want the CPE and the CGNAT", analysis.NATHops)
+	}
+	cpe, cgnat := analysis.NATHops[0], analysis.NATHops[1]
+	if cpe.TTL != 1 || cpe.IP != "192.168.1.1" || !reflect.DeepEqual(cpe.Rewritten, []string{"checksum", "ip_id"}) {
+		t.Errorf("got first NAT %+v, want the CPE rewriting checksum and IP ID", cpe)
+	}
+	if cgnat.TTL != 2 || cgnat.IP != "100.64.0.1" || !reflect.DeepEqual(cgnat.Rewritten, []string{"checksum"}) {
+		t.Errorf("got second NAT %+v, want the CGNAT rewriting the checksum", cgnat)
+	}
+	if cpe.NATID == cgnat.NATID {
+		t.Error("each NAT layer should change the NAT ID")
+	}
+}
+
+func TestUDPTraceWithoutNAT(t *testing.T) {
+	result := runUDPTrace(t, "diamond.yaml", 8, 1)
+	if analysis := result.AnalyzeNetwork(); analysis.NATDetected {
+		t.Errorf("got NAT hops %+v on a network without NAT", analysis.NATHops)
+	}
+}
+
+func TestDestinationPrefix(t *testing.T) {
+	n := loadNetwork(t, "nat.yaml")
+	neighbour := net.ParseIP("8.8.8.9").To4()
+
+	probe := udpProbe(t, n.Source(), 64, 40000)
+	copy(probe[16:20], neighbour)
+	ipv4Packet(probe).updateHeaderChecksum()
+	if err := n.WritePacket(probe, neighbour); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := n.ReadReply(time.Second)
+	if err != nil {
+		t.Fatalf("ReadReply: %v", err)
+	}
+	if !reply.From.Equal(neighbour) || reply.ICMPType != layers.ICMPv4TypeDestinationUnreachable {
+		t.Errorf("got type %d from %s, want port unreachable from %s", reply.ICMPType, reply.From, neighbour)
+	}
+}
+
+func TestReadReplyAdvancesClock(t *testing.T) {
+	n := loadNetwork(t, "linear.yaml")
+	start := n.Now()
+
+	if _, err := n.ReadReply(3 * time.Second); err != capture.ErrTimeout {
+		t.Fatalf("got %v, want timeout on an idle network", err)
+	}
+	if got := n.Now().Sub(start); got != 3*time.Second {
+		t.Errorf("clock advanced %v, want 3s", got)
+	}
+
+	n.Sleep(time.Second)
+	if got := n.Now().Sub(start); got != 4*time.Second {
+		t.Errorf("clock advanced %v, want 4s", got)
+	}
+}
+
+func TestParseTopologyErrors(t *testing.T) {
+	cases := map[string]string{
+		"bad source":   "source: nope\n",
+		"unknown link": "source: 10.0.0.1\nlinks:\n  - {from: source, to: ghost}\n",
+		"bad hash":     "source: 10.0.0.1\nnodes:\n  - {name: r, address: 10.0.0.2, hash: [colour]}\n",
+		"bad loss":     "source: 10.0.0.1\nnodes:\n  - {name: r, address: 10.0.0.2}\nlinks:\n  - {from: source, to: r, loss: 2}\n",
+		"duplicate":    "source: 10.0.0.1\nnodes:\n  - {name: r, address: 10.0.0.2}\n  - {name: r, address: 10.0.0.3}\n",
+	}
+	for name, data := range cases {
+		if _, err := ParseTopology([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMDAEnumeratesWideECMP(t *testing.T) {
+	n := loadNetwork(t, "wide.yaml")
+	p := probe.NewUDPProbeWithConn(n, target, n.Source(), 33434, 33434, 128, 1, 30, 1)
+	p.SetClock(n)
+	p.SetMDA(0.95)
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	routers := make(map[string]bool)
+	for _, flow := range result.Hops[3].Flows {
+		routers[flow.ResponseIP] = true
+	}
+	if len(routers) != 6 {
+		t.Errorf("TTL 3: found %d next hops, want all 6", len(routers))
+	}
+	// Six next hops take n_6 = 33 answered flows at 95%; single routers take 6
+	if hop := result.Hops[3]; hop.MDAFlows < 33 || hop.MDAConfidence < 0.95 {
+		t.Errorf("TTL 3: %d flows at %.3f confidence, want at least 33 at 95%%", hop.MDAFlows, hop.MDAConfidence)
+	}
+	// The join is a next hop of each of the six, and each needs 6 flows
+	through := make(map[string]int)
+	for id, flow := range result.Hops[4].Flows {
+		if flow.ResponseIP == "10.3.0.1" {
+			through[result.Hops[3].Flows[id].ResponseIP]++
+		}
+	}
+	for router, flows := range through {
+		if flows < 6 {
+			t.Errorf("TTL 4: %d flows through %s, want at least 6", flows, router)
+		}
+	}
+	if len(through) != 6 || result.Hops[4].MDAConfidence < 0.95 {
+		t.Errorf("TTL 4: flows through %v at %.3f confidence, want all six at 95%%", through, result.Hops[4].MDAConfidence)
+	}
+	for _, ttl := range []uint8{1, 2, 5} {
+		if hop := result.Hops[ttl]; hop.MDAFlows != 6 {
+			t.Errorf("TTL %d: %d flows, want 6 for a single router", ttl, hop.MDAFlows)
+		}
+	}
+	if len(result.Hops) != 5 {
+		t.Errorf("got %d hops, want the trace to end at the target on TTL 5", len(result.Hops))
+	}
+
+	lbHops := result.AnalyzeNetwork().LoadBalancingHops
+	if !reflect.DeepEqual(lbHops, []uint8{3}) {
+		t.Errorf("got load balancing hops %v, want [3]", lbHops)
+	}
+}
+
+func TestClassifyLoadBalancers(t *testing.T) {
+	n := loadNetwork(t, "balancers.yaml")
+	p := probe.NewUDPProbeWithConn(n, target, n.Source(), 33434, 33434, 8, 1, 30, 1)
+	p.SetClock(n)
+	p.SetClassifyLB(true)
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	analysis := result.AnalyzeNetwork()
+	kinds := make(map[uint8]string)
+	for _, lb := range analysis.LoadBalancers {
+		kinds[lb.TTL] = lb.Kind
+		if len(lb.NextHops) != 2 || lb.Evidence == "" {
+			t.Errorf("TTL %d: next hops %v, evidence %q", lb.TTL, lb.NextHops, lb.Evidence)
+		}
+	}
+	want := map[uint8]string{3: results.PerFlow, 6: results.PerPacket, 9: results.PerDestination}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("got balancers %v, want %v", kinds, want)
+	}
+
+	// Flows to the target itself never see the per-destination balancer
+	for _, ttl := range analysis.LoadBalancingHops {
+		if ttl == 9 {
+			t.Error("TTL 9 counted as load balancing for flows to the target")
+		}
+	}
+	if len(result.Hops) != 10 {
+		t.Errorf("got %d hops, want the classification probes kept out of the trace", len(result.Hops))
+	}
+}
+
+func TestHashFieldDiscovery(t *testing.T) {
+	n := loadNetwork(t, "hashfields.yaml")
+	p := probe.NewUDPProbeWithConn(n, target, n.Source(), 33434, 33434, 4, 1, 30, 1)
+	p.SetClock(n)
+	p.SetHashFields(true, []net.IP{net.ParseIP("192.168.1.11").To4(), net.ParseIP("192.168.1.12").To4()})
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	// Varying the source port alone never shows the first balancer
+	if result.AnalyzeNetwork().HasLoadBalancing {
+		t.Error("flows differing by source port should all take the same branches")
+	}
+
+	got := make(map[uint8][]string)
+	for _, hf := range result.AnalyzeNetwork().HashFields {
+		if hf.PerPacket || len(hf.Inherited) > 0 {
+			t.Errorf("TTL %d: %+v, want neither per-packet nor inherited fields", hf.TTL, hf)
+		}
+		got[hf.TTL] = hf.Hashed
+	}
+	want := map[uint8][]string{
+		3: {results.FieldDstPort},
+		6: {results.FieldTOS, results.FieldSrc},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got hashed fields %v, want %v", got, want)
+	}
+}
+
+func TestHashFieldsFollowingABalancerAreInherited(t *testing.T) {
+	n := loadNetwork(t, "diamond.yaml")
+	p := probe.NewTCPProbeWithConn(n, target, n.Source(), 33434, 443, 4, 1, 30, 1)
+	p.SetClock(n)
+	p.SetHashFields(true, nil)
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	byTTL := make(map[uint8]results.HashFields)
+	for _, hf := range result.HashFields {
+		byTTL[hf.TTL] = hf
+	}
+	five := []string{results.FieldSrcPort, results.FieldDstPort}
+	if hf := byTTL[3]; !reflect.DeepEqual(hf.Hashed, five) || !reflect.DeepEqual(hf.Ignored, []string{results.FieldIPID, results.FieldTOS}) {
+		t.Errorf("TTL 3: hashed %v ignored %v, want the ports only", hf.Hashed, hf.Ignored)
+	}
+	if hf := byTTL[4]; len(hf.Hashed) != 0 || !reflect.DeepEqual(hf.Inherited, five) {
+		t.Errorf("TTL 4: hashed %v inherited %v, want the ports inherited from TTL 3", hf.Hashed, hf.Inherited)
+	}
+	if len(byTTL) != 2 {
+		t.Errorf("got hash fields at %d hops, want the two branch hops", len(byTTL))
+	}
+}
+
+func TestFlowStrategies(t *testing.T) {
+	for _, tc := range []struct {
+		topology string
+		strategy string
+		want     []uint8 // Load-balancing hops seen
+	}{
+		// Only flows differing by destination port split at the first balancer
+		{"hashfields.yaml", probe.FlowSrcPort, nil},
+		{"hashfields.yaml", probe.FlowDstPort, []uint8{3}},
+		// A fixed 5-tuple follows a single branch of the diamond
+		{"diamond.yaml", probe.FlowSrcPort, []uint8{3, 4}},
+		{"diamond.yaml", probe.FlowParis, nil},
+		{"diamond.yaml", probe.FlowIPID, nil},
+	} {
+		n := loadNetwork(t, tc.topology)
+		p := probe.NewUDPProbeWithConn(n, target, n.Source(), 33434, 33434, 8, 1, 30, 1)
+		p.SetClock(n)
+		p.ResolveNames = false
+		if err := p.SetFlowStrategy(tc.strategy); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := p.Traceroute()
+		if err != nil {
+			t.Fatalf("%s with %s: %v", tc.topology, tc.strategy, err)
+		}
+		got := result.AnalyzeNetwork().LoadBalancingHops
+		if len(got) != len(tc.want) || (len(got) > 0 && !reflect.DeepEqual(got, tc.want)) {
+			t.Errorf("%s with %s: load balancing at %v, want %v", tc.topology, tc.strategy, got, tc.want)
+		}
+		answered := 0
+		for _, flow := range result.Hops[2].Flows {
+			if flow.ResponseIP != "" {
+				answered++
+			}
+		}
+		if answered != 8 {
+			t.Errorf("%s with %s: %d flows answered at TTL 2, want every probe told apart", tc.topology, tc.strategy, answered)
+		}
+	}
+}
+
+func TestECMPSplitEstimation(t *testing.T) {
+	for _, tc := range []struct {
+		topology string
+		big      string  // Next hop expected to get the most flows
+		share    float64 // Its configured share of flows
+		unequal  bool
+	}{
+		{"weighted.yaml", "10.1.0.1", 0.75, true},
+		{"diamond.yaml", "", 0.5, false},
+	} {
+		n := loadNetwork(t, tc.topology)
+		p := probe.NewUDPProbeWithConn(n, target, n.Source(), 33434, 33434, 8, 1, 30, 1)
+		p.SetClock(n)
+		p.SetECMPSplit(200)
+		p.ResolveNames = false
+
+		result, err := p.Traceroute()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.topology, err)
+		}
+
+		splits := result.AnalyzeNetwork().ECMPSplits
+		if len(splits) == 0 {
+			t.Fatalf("%s: no split estimated", tc.topology)
+		}
+		split := splits[0]
+		if split.Unequal != tc.unequal {
+			t.Errorf("%s: unequal = %v, want %v (%+v)", tc.topology, split.Unequal, tc.unequal, split.Shares)
+		}
+		if split.Flows != 200 {
+			t.Errorf("%s: split estimated from %d flows, want 200", tc.topology, split.Flows)
+		}
+		top := split.Shares[0]
+		if tc.big != "" && top.IP != tc.big {
+			t.Errorf("%s: largest share at %s, want %s", tc.topology, top.IP, tc.big)
+		}
+		if math.Abs(top.Fraction-tc.share) > 0.1 {
+			t.Errorf("%s: largest share %.3f, want about %.2f", tc.topology, top.Fraction, tc.share)
+		}
+	}
+}
+
+func TestFlowMapAcrossMTRRounds(t *testing.T) {
+	n := loadNetwork(t, "diamond.yaml")
+	p := probe.NewUDPProbeWithConn(n, target, n.Source(), 33434, 33434, 8, 1, 30, 3)
+	p.SetClock(n)
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	report := result.MapFlows()
+	if len(report.Paths) != 2 {
+		t.Fatalf("got %d paths, want one per branch of the diamond", len(report.Paths))
+	}
+	tuples := 0
+	for _, path := range report.Paths {
+		tuples += len(path.Flows)
+		if !path.Reached {
+			t.Errorf("path %d did not reach the target", path.PathID)
+		}
+	}
+	if tuples != 8 {
+		t.Errorf("got %d 5-tuples, want the 8 source ports once each across 3 rounds", tuples)
+	}
+	if len(report.Unstable) != 0 {
+		t.Errorf("per-flow balancing left unstable flows: %+v", report.Unstable)
+	}
+}
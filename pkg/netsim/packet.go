@@ -0,0 +1,188 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package netsim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// IP protocol numbers understood by the simulator
+const (
+	protoICMP = 1
+	protoTCP  = 6
+	protoUDP  = 17
+)
+
+// TCP flags
+const (
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpACK = 0x10
+)
+
+// ipv4Packet is a mutable view of a raw IPv4 packet as it travels through
+// the simulated network
+type ipv4Packet []byte
+
+// parseIPv4 validates a raw IPv4 packet and returns a private copy
+func parseIPv4(data []byte) (ipv4Packet, error) {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return nil, fmt.Errorf("not an IPv4 packet")
+	}
+	ihl := int(data[0]&0x0f) * 4
+	if ihl < 20 || len(data) < ihl+4 {
+		return nil, fmt.Errorf("truncated IPv4 packet")
+	}
+	return ipv4Packet(append([]byte(nil), data...)), nil
+}
+
+func (p ipv4Packet) headerLen() int    { return int(p[0]&0x0f) * 4 }
+func (p ipv4Packet) tos() byte         { return p[1] }
+func (p ipv4Packet) id() []byte        { return p[4:6] }
+func (p ipv4Packet) ttl() uint8        { return p[8] }
+func (p ipv4Packet) protocol() byte    { return p[9] }
+func (p ipv4Packet) src() net.IP       { return net.IP(p[12:16]) }
+func (p ipv4Packet) dst() net.IP       { return net.IP(p[16:20]) }
+func (p ipv4Packet) transport() []byte { return p[p.headerLen():] }
+
+// setTTL updates the TTL and the header checksum, as a router does
+func (p ipv4Packet) setTTL(ttl uint8) {
+	p[8] = ttl
+	p.updateHeaderChecksum()
+}
+
+// setSrc rewrites the source address and fixes the IP and transport checksums
+func (p ipv4Packet) setSrc(src net.IP) {
+	copy(p[12:16], src.To4())
+	p.updateHeaderChecksum()
+	p.updateTransportChecksum()
+}
+
+// setID rewrites the IP identification field
+func (p ipv4Packet) setID(id uint16) {
+	binary.BigEndian.PutUint16(p[4:6], id)
+	p.updateHeaderChecksum()
+}
+
+func (p ipv4Packet) updateHeaderChecksum() {
+	hdr := p[:p.headerLen()]
+	hdr[10], hdr[11] = 0, 0
+	binary.BigEndian.PutUint16(hdr[10:12], checksum(hdr, 0))
+}
+
+// updateTransportChecksum recomputes the UDP or TCP checksum over the
+// pseudo-header, as NAT devices do after rewriting addresses
+func (p ipv4Packet) updateTransportChecksum() {
+	l4 := p.transport()
+	var offset int
+	switch p.protocol() {
+	case protoUDP:
+		offset = 6
+		if len(l4) < 8 || binary.BigEndian.Uint16(l4[6:8]) == 0 {
+			return // checksum disabled
+		}
+	case protoTCP:
+		offset = 16
+		if len(l4) < 20 {
+			return
+		}
+	default:
+		return
+	}
+
+	l4[offset], l4[offset+1] = 0, 0
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], p[12:16])
+	copy(pseudo[4:8], p[16:20])
+	pseudo[9] = p.protocol()
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(l4)))
+	sum := checksum(l4, checksumPartial(pseudo, 0))
+	if sum == 0 && p.protocol() == protoUDP {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(l4[offset:offset+2], sum)
+}
+
+// quote returns the part of the packet copied into ICMP error messages:
+// the IP header plus the first 8 bytes of payload (RFC 792)
+func (p ipv4Packet) quote() ipv4Packet {
+	n := p.headerLen() + 8
+	if n > len(p) {
+		n = len(p)
+	}
+	return ipv4Packet(append([]byte(nil), p[:n]...))
+}
+
+// buildICMPv4 assembles an IPv4 packet carrying an ICMP message
+func buildICMPv4(src, dst net.IP, id uint16, icmpType, icmpCode uint8, rest []byte, body []byte) []byte {
+	icmp := make([]byte, 8+len(body))
+	icmp[0] = icmpType
+	icmp[1] = icmpCode
+	copy(icmp[4:8], rest)
+	copy(icmp[8:], body)
+	binary.BigEndian.PutUint16(icmp[2:4], checksum(icmp, 0))
+
+	pkt := make([]byte, 20+len(icmp))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	binary.BigEndian.PutUint16(pkt[4:6], id)
+	pkt[8] = 64
+	pkt[9] = protoICMP
+	copy(pkt[12:16], src.To4())
+	copy(pkt[16:20], dst.To4())
+	copy(pkt[20:], icmp)
+	ipv4Packet(pkt).updateHeaderChecksum()
+	return pkt
+}
+
+// buildTCPv4 assembles an IPv4 packet carrying a TCP segment without options
+// or data
+func buildTCPv4(src, dst net.IP, id, srcPort, dstPort uint16, ack uint32, flags byte) []byte {
+	pkt := make([]byte, 40)
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	binary.BigEndian.PutUint16(pkt[4:6], id)
+	pkt[8] = 64
+	pkt[9] = protoTCP
+	copy(pkt[12:16], src.To4())
+	copy(pkt[16:20], dst.To4())
+
+	tcp := pkt[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[8:12], ack)
+	tcp[12] = 5 << 4 // data offset
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+
+	ipv4Packet(pkt).updateHeaderChecksum()
+	ipv4Packet(pkt).updateTransportChecksum()
+	return pkt
+}
+
+// checksumPartial adds data to a running one's complement sum
+func checksumPartial(data []byte, sum uint32) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// checksum computes the Internet checksum of data on top of a partial sum
+func checksum(data []byte, sum uint32) uint16 {
+	sum = checksumPartial(data, sum)
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,156 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package netsim
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SourceNode is the reserved node name of the probing host in link definitions
+const SourceNode = "source"
+
+// ECMP hash fields accepted in NodeConfig.Hash
+const (
+	HashSrc       = "src"        // IP source address
+	HashDst       = "dst"        // IP destination address
+	HashSrcPort   = "sport"      // Transport bytes 0-1 (UDP/TCP source port, ICMP type/code)
+	HashDstPort   = "dport"      // Transport bytes 2-3 (UDP/TCP destination port, ICMP checksum)
+	HashProto     = "proto"      // IP protocol
+	HashTOS       = "tos"        // IP type of service
+	HashID        = "id"         // IP identification
+	HashPerPacket = "per-packet" // Random choice for every packet
+)
+
+// defaultHash is the classic 5-tuple used when a node does not configure one
+var defaultHash = []string{HashSrc, HashDst, HashSrcPort, HashDstPort, HashProto}
+
+// Topology describes a simulated network as loaded from YAML
+type Topology struct {
+	Seed   int64        `yaml:"seed"`   // RNG seed for loss and jitter
+	Source string       `yaml:"source"` // Address of the probing host
+	Nodes  []NodeConfig `yaml:"nodes"`
+	Links  []LinkConfig `yaml:"links"`
+}
+
+// NodeConfig describes a router or destination host
+type NodeConfig struct {
+	Name      string           `yaml:"name"`
+	Address   string           `yaml:"address"`              // Address used in ICMP replies
+	Prefixes  []string         `yaml:"prefixes,omitempty"`   // Destinations answered by this host
+	Silent    bool             `yaml:"silent,omitempty"`     // Forwards but never sends ICMP
+	Hash      []string         `yaml:"hash,omitempty"`       // ECMP hash fields for multiple next hops
+	NAT       *NATConfig       `yaml:"nat,omitempty"`        // Source NAT applied to forwarded probes
+	RateLimit *RateLimitConfig `yaml:"rate_limit,omitempty"` // ICMP generation limit
+	OpenPorts []uint16         `yaml:"open_ports,omitempty"` // TCP ports answering SYN-ACK; others answer RST
+}
+
+// NATConfig describes a NAT box rewriting forwarded probes
+type NATConfig struct {
+	Public    string `yaml:"public"`               // Address probes are rewritten to
+	RewriteID bool   `yaml:"rewrite_id,omitempty"` // Replace the IP ID of forwarded probes
+}
+
+// RateLimitConfig is a token bucket limiting ICMP replies from a node
+type RateLimitConfig struct {
+	Rate  float64 `yaml:"rate"`  // Replies per second
+	Burst int     `yaml:"burst"` // Bucket size
+}
+
+// LinkConfig describes a unidirectional forwarding link. Replies travel
+// back over the same links with the same characteristics.
+type LinkConfig struct {
+	From   string        `yaml:"from"`
+	To     string        `yaml:"to"`
+	Delay  time.Duration `yaml:"delay,omitempty"`
+	Jitter time.Duration `yaml:"jitter,omitempty"` // Extra random delay in [0, jitter)
+	Loss   float64       `yaml:"loss,omitempty"`   // Drop probability per traversal
+	Weight int           `yaml:"weight,omitempty"` // ECMP weight, default 1
+}
+
+// LoadTopology reads a topology from a YAML file
+func LoadTopology(filename string) (*Topology, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topology: %w", err)
+	}
+	return ParseTopology(data)
+}
+
+// ParseTopology parses and validates a YAML topology
+func ParseTopology(data []byte) (*Topology, error) {
+	topo := &Topology{}
+	if err := yaml.Unmarshal(data, topo); err != nil {
+		return nil, fmt.Errorf("failed to parse topology: %w", err)
+	}
+	if err := topo.Validate(); err != nil {
+		return nil, err
+	}
+	return topo, nil
+}
+
+// Validate checks addresses, names and link endpoints
+func (t *Topology) Validate() error {
+	if net.ParseIP(t.Source) == nil {
+		return fmt.Errorf("invalid source address %q", t.Source)
+	}
+
+	names := map[string]bool{SourceNode: true}
+	for _, node := range t.Nodes {
+		if node.Name == "" || node.Name == SourceNode {
+			return fmt.Errorf("invalid node name %q", node.Name)
+		}
+		if names[node.Name] {
+			return fmt.Errorf("duplicate node %q", node.Name)
+		}
+		names[node.Name] = true
+
+		if net.ParseIP(node.Address) == nil {
+			return fmt.Errorf("node %s: invalid address %q", node.Name, node.Address)
+		}
+		for _, prefix := range node.Prefixes {
+			if _, _, err := net.ParseCIDR(prefix); err != nil {
+				return fmt.Errorf("node %s: invalid prefix %q", node.Name, prefix)
+			}
+		}
+		for _, field := range node.Hash {
+			switch field {
+			case HashSrc, HashDst, HashSrcPort, HashDstPort, HashProto, HashTOS, HashID, HashPerPacket:
+			default:
+				return fmt.Errorf("node %s: unknown hash field %q", node.Name, field)
+			}
+		}
+		if node.NAT != nil && net.ParseIP(node.NAT.Public).To4() == nil {
+			return fmt.Errorf("node %s: invalid NAT address %q", node.Name, node.NAT.Public)
+		}
+		if node.RateLimit != nil && (node.RateLimit.Rate <= 0 || node.RateLimit.Burst < 1) {
+			return fmt.Errorf("node %s: rate limit needs a positive rate and burst", node.Name)
+		}
+	}
+
+	for _, link := range t.Links {
+		if !names[link.From] || !names[link.To] {
+			return fmt.Errorf("link %s -> %s: unknown node", link.From, link.To)
+		}
+		if link.To == SourceNode {
+			return fmt.Errorf("link %s -> %s: cannot forward to the source", link.From, link.To)
+		}
+		if link.Loss < 0 || link.Loss > 1 {
+			return fmt.Errorf("link %s -> %s: loss must be between 0 and 1", link.From, link.To)
+		}
+		if link.Weight < 0 {
+			return fmt.Errorf("link %s -> %s: negative weight", link.From, link.To)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+const (
+	classifyRepeats    = 3 // Extra probes of each flow at a load-balancing hop
+	classifyFlows      = 8 // Flows repeated at each load-balancing hop
+	classifyNeighbours = 6 // Addresses next to the target probed for per-destination balancing
+)
+
+// reprobe is a classification probe: a repeat of a flow of the trace, or
+// flow 0 sent to a neighbour of the target
+type reprobe struct {
+	ttl    uint8
+	flowID uint16 // FlowID of the repeated flow in the trace
+	dst    net.IP // Neighbour probed, nil for repeats
+}
+
+// classify re-probes the trace to tell load balancers apart. Each flow of a
+// hop with several routers is sent again: a flow changing next hop means
+// per-packet balancing, flows that all keep theirs mean per-flow. Hops with
+// a single router are probed towards addresses next to the target; a router
+// that changes with the address means per-destination balancing.
+func (e *engine) classify(result *results.TracerouteResult) error {
+	last := e.maxTTL
+	if dest := destinationTTL(result, e.reached); dest != 0 {
+		last = dest - 1
+	}
+	neighbours := neighbourAddresses(e.target, classifyNeighbours)
+
+	var slots []slot
+	var reprobes []reprobe
+	add := func(ttl uint8, flowID uint16, dst net.IP) {
+		slots = append(slots, slot{ttl: ttl, flowID: flowID % e.numPaths, id: uint16(len(slots)), dst: dst})
+		reprobes = append(reprobes, reprobe{ttl: ttl, flowID: flowID, dst: dst})
+	}
+	for ttl := e.minTTL; ttl <= last && ttl != 0; ttl++ {
+		nextHops, _ := census(result.Hops[ttl])
+		switch {
+		case nextHops > 1:
+			for _, flow := range answeredFlows(result.Hops[ttl], classifyFlows) {
+				for i := 0; i < classifyRepeats; i++ {
+					add(ttl, flow.FlowID, nil)
+				}
+			}
+		case nextHops == 1:
+			for _, dst := range neighbours {
+				add(ttl, 0, dst)
+			}
+		}
+	}
+	if len(slots) == 0 {
+		return nil
+	}
+
+	scratch := &results.TracerouteResult{Hops: make(map[uint8]*results.HopResult)}
+	if err := e.round(slots, newCorrelator(e.src, e.target, scratch), scratch); err != nil {
+		return err
+	}
+
+	// Routers answering the repeats of each flow, and each neighbour
+	repeats := make(map[uint8]map[uint16][]string)
+	reached := make(map[uint8][]reprobe)
+	answers := make(map[uint8][]string)
+	for i, rp := range reprobes {
+		flow := scratch.Hops[rp.ttl].Flows[uint16(i)]
+		if flow.Error != "" || flow.ResponseIP == "" {
+			continue
+		}
+		if rp.dst != nil {
+			reached[rp.ttl] = append(reached[rp.ttl], rp)
+			answers[rp.ttl] = append(answers[rp.ttl], flow.ResponseIP)
+			continue
+		}
+		if repeats[rp.ttl] == nil {
+			repeats[rp.ttl] = make(map[uint16][]string)
+		}
+		repeats[rp.ttl][rp.flowID] = append(repeats[rp.ttl][rp.flowID], flow.ResponseIP)
+	}
+
+	result.LoadBalancers = nil
+	for ttl := e.minTTL; ttl <= last && ttl != 0; ttl++ {
+		var lb *results.LoadBalancer
+		if len(repeats[ttl]) > 0 {
+			lb = perFlowOrPacket(result.Hops[ttl], repeats[ttl])
+		} else if len(reached[ttl]) > 0 {
+			lb = perDestination(result.Hops[ttl], e.target, reached[ttl], answers[ttl])
+		}
+		if lb == nil {
+			continue
+		}
+		fmt.Printf("TTL=%2d %s load balancing: %s\n", ttl, lb.Kind, lb.Evidence)
+		result.LoadBalancers = append(result.LoadBalancers, *lb)
+	}
+	return nil
+}
+
+// perFlowOrPacket classifies a hop with several routers from the repeats of
+// its flows
+func perFlowOrPacket(hop *results.HopResult, repeats map[uint16][]string) *results.LoadBalancer {
+	flowIDs := make([]uint16, 0, len(repeats))
+	for flowID := range repeats {
+		flowIDs = append(flowIDs, flowID)
+	}
+	sort.Slice(flowIDs, func(i, j int) bool { return flowIDs[i] < flowIDs[j] })
+
+	lb := &results.LoadBalancer{TTL: hop.TTL, Kind: results.PerFlow}
+	var routers []string
+	for _, flowID := range flowIDs {
+		seen := distinct(append([]string{hop.Flows[flowID].ResponseIP}, repeats[flowID]...))
+		routers = append(routers, seen...)
+		lb.Probes += 1 + len(repeats[flowID])
+		if len(seen) > 1 && lb.Kind == results.PerFlow {
+			lb.Kind = results.PerPacket
+			lb.Evidence = fmt.Sprintf("flow %d was answered by %s over %d probes",
+				flowID, strings.Join(seen, ", "), 1+len(repeats[flowID]))
+		}
+	}
+	if lb.Kind == results.PerFlow {
+		lb.Evidence = fmt.Sprintf("%d flows each kept their next hop over up to %d probes", len(flowIDs), 1+classifyRepeats)
+	}
+	lb.NextHops = distinct(append(routers, hopRouters(hop)...))
+	return lb
+}
+
+// perDestination classifies a hop with a single router from the routers
+// that answered probes to neighbours of the target, or returns nil if they
+// all reached the same router
+func perDestination(hop *results.HopResult, target net.IP, reached []reprobe, answers []string) *results.LoadBalancer {
+	router := hopRouters(hop)[0]
+
+	var others []string
+	for i, rp := range reached {
+		if answers[i] != router {
+			others = append(others, fmt.Sprintf("%s reached %s", rp.dst, answers[i]))
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+
+	_, answered := census(hop)
+	return &results.LoadBalancer{
+		TTL:      hop.TTL,
+		Kind:     results.PerDestination,
+		NextHops: distinct(append([]string{router}, answers...)),
+		Probes:   answered + len(reached),
+		Evidence: fmt.Sprintf("every flow to %s reached %s; %s", target, router, strings.Join(others, ", ")),
+	}
+}
+
+// answeredFlows returns up to n answered flows of a hop in FlowID order
+func answeredFlows(hop *results.HopResult, n int) []*results.FlowResult {
+	var flows []*results.FlowResult
+	for _, flow := range hop.Flows {
+		if flow.Error == "" && flow.ResponseIP != "" {
+			flows = append(flows, flow)
+		}
+	}
+	sort.Slice(flows, func(i, j int) bool { return flows[i].FlowID < flows[j].FlowID })
+	if len(flows) > n {
+		flows = flows[:n]
+	}
+	return flows
+}
+
+// hopRouters returns the routers that answered at a hop, sorted
+func hopRouters(hop *results.HopResult) []string {
+	var ips []string
+	for _, flow := range answeredFlows(hop, len(hop.Flows)) {
+		ips = append(ips, flow.ResponseIP)
+	}
+	return distinct(ips)
+}
+
+// distinct returns the sorted unique strings of list
+func distinct(list []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// neighbourAddresses returns up to n addresses next to the target, counting
+// the last byte up from the target's and skipping the network and broadcast
+// addresses of an IPv4 /24
+func neighbourAddresses(target net.IP, n int) []net.IP {
+	if v4 := target.To4(); v4 != nil {
+		target = v4
+	}
+	last := len(target) - 1
+
+	var addrs []net.IP
+	for d := 1; d < 256 && len(addrs) < n; d++ {
+		b := byte(int(target[last]) + d)
+		if b == 0 || (b == 255 && len(target) == net.IPv4len) {
+			continue
+		}
+		ip := append(net.IP(nil), target...)
+		ip[last] = b
+		addrs = append(addrs, ip)
+	}
+	return addrs
+}
@@ -0,0 +1,53 @@
+package probe
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+func TestNeighbourAddresses(t *testing.T) {
+	got := neighbourAddresses(net.ParseIP("10.0.0.253"), 4)
+	want := []net.IP{
+		net.ParseIP("10.0.0.254").To4(),
+		net.ParseIP("10.0.0.1").To4(),
+		net.ParseIP("10.0.0.2").To4(),
+		net.ParseIP("10.0.0.3").To4(),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestClassifyPerFlow(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"10.0.0.1", "10.0.0.2"},
+		3: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 4, 10)
+	p.SetClassifyLB(true)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	if len(result.LoadBalancers) != 1 {
+		t.Fatalf("got balancers %+v, want one at TTL 2", result.LoadBalancers)
+	}
+	lb := result.LoadBalancers[0]
+	if lb.TTL != 2 || lb.Kind != results.PerFlow || lb.Probes != 4*(1+classifyRepeats) {
+		t.Errorf("got %+v, want per-flow at TTL 2 from %d probes", lb, 4*(1+classifyRepeats))
+	}
+	if !reflect.DeepEqual(lb.NextHops, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("got next hops %v", lb.NextHops)
+	}
+	for _, flow := range result.Hops[2].Flows {
+		if flow.FlowID >= 4 {
+			t.Errorf("classification probe %d recorded in the trace", flow.FlowID)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/internal/platform"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+)
+
+// PacketConn is the packet I/O used by the probes: raw IP packets go out,
+// decoded replies come back. The default implementation pairs a platform raw
+// socket with a packet capture; tests substitute scripted fakes.
+type PacketConn interface {
+	// WritePacket sends a complete IP packet (header included) to dst
+	WritePacket(packet []byte, dst net.IP) error
+
+	// ReadReply returns the next reply received within timeout, or
+	// capture.ErrTimeout if none arrived
+	ReadReply(timeout time.Duration) (*capture.Reply, error)
+
+	// Close releases the underlying resources
+	Close() error
+}
+
+// rawConn is the PacketConn backed by a raw socket and a packet capture
+// (Npcap on Windows, a raw ICMP socket on Linux)
+type rawConn struct {
+	socket  int
+	capture capture.Capture
+}
+
+// NewRawConn opens a raw socket for protocol and a packet capture on device.
+// An empty device selects the default interface. TCP connections also
+// capture the SYN-ACK and RST answers of the target.
+func NewRawConn(protocol int, device string) (PacketConn, error) {
+	sock, err := platform.CreateRawSocket(protocol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create raw socket: %w", err)
+	}
+	return newRawConn(sock, protocol, device)
+}
+
+// NewRawConn6 is NewRawConn for IPv6 probes
+func NewRawConn6(protocol int, device string) (PacketConn, error) {
+	sock, err := platform.CreateRawSocket6(protocol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create raw IPv6 socket: %w", err)
+	}
+	return newRawConn(sock, protocol, device)
+}
+
+// newRawConn pairs an open raw socket with a packet capture
+func newRawConn(sock, protocol int, device string) (PacketConn, error) {
+	// BPF filter disabled - Npcap on Windows seems to have issues with "icmp" filter
+	// We'll filter ICMP in software which is slightly less efficient but works reliably
+	newCapture := capture.NewCapture
+	if protocol == platform.ProtocolTCP {
+		newCapture = capture.NewTCPCapture
+	}
+	cap, err := newCapture(device, 3*time.Second)
+	if err != nil {
+		platform.CloseSocket(sock)
+		return nil, fmt.Errorf("failed to create packet capture: %w", err)
+	}
+
+	return &rawConn{
+		socket:  sock,
+		capture: cap,
+	}, nil
+}
+
+// WritePacket sends a raw IP packet through the platform socket
+func (c *rawConn) WritePacket(packet []byte, dst net.IP) error {
+	return platform.SendPacket(c.socket, packet, dst)
+}
+
+// ReadReply returns the next ICMP reply seen by the capture
+func (c *rawConn) ReadReply(timeout time.Duration) (*capture.Reply, error) {
+	return c.capture.NextReply(timeout)
+}
+
+// Close closes the capture and the raw socket
+func (c *rawConn) Close() error {
+	if c.capture != nil {
+		c.capture.Close()
+	}
+	if c.socket != 0 {
+		return platform.CloseSocket(c.socket)
+	}
+	return nil
+}
+
+// GetStats returns capture statistics
+func (c *rawConn) GetStats() (received, dropped, ifDropped uint, err error) {
+	return c.capture.GetStats()
+}
+
+// lookupHostname performs reverse DNS lookup
+func lookupHostname(ip net.IP) string {
+	names, err := net.LookupAddr(ip.String())
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
@@ -0,0 +1,183 @@
+package probe
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+var (
+	testSrc     = net.ParseIP("192.168.1.10").To4()
+	testTarget  = net.ParseIP("8.8.8.8").To4()
+	testSrc6    = net.ParseIP("2001:db8::10")
+	testTarget6 = net.ParseIP("2001:4860:4860::8888")
+)
+
+// fakeConn is a scripted PacketConn. Each TTL maps to the routers that
+// answer at that distance; a flow picks a router by its source port (Echo
+// identifier for ICMP), so several routers at one TTL behave like a per-flow
+// load balancer. The target answers TCP probes according to port, one of
+// the results.Port states. The engine writes and reads from two goroutines.
+type fakeConn struct {
+	mu      sync.Mutex
+	hops    map[uint8][]string
+	port    string
+	rtt     time.Duration
+	sent    [][]byte
+	pending []*capture.Reply
+	closed  bool
+}
+
+func newFakeConn(hops map[uint8][]string) *fakeConn {
+	return &fakeConn{hops: hops, port: results.PortOpen, rtt: 5 * time.Millisecond}
+}
+
+// WritePacket decodes the probe and queues the reply a router would send
+func (c *fakeConn) WritePacket(packet []byte, to net.IP) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, append([]byte(nil), packet...))
+
+	var (
+		ttl      uint8
+		src, dst net.IP
+		id       uint16
+		decoded  gopacket.Packet
+	)
+	if len(packet) > 0 && packet[0]>>4 == 6 {
+		decoded = gopacket.NewPacket(packet, layers.LayerTypeIPv6, gopacket.Default)
+		ip, _ := decoded.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+		if ip == nil {
+			return nil
+		}
+		ttl, src, dst = ip.HopLimit, ip.SrcIP, ip.DstIP
+	} else {
+		decoded = gopacket.NewPacket(packet, layers.LayerTypeIPv4, gopacket.Default)
+		ip, _ := decoded.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		if ip == nil {
+			return nil
+		}
+		ttl, src, dst, id = ip.TTL, ip.SrcIP, ip.DstIP, ip.Id
+	}
+
+	var srcPort uint16
+	echo, _ := decoded.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	if udp, ok := decoded.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		srcPort = uint16(udp.SrcPort)
+	} else if tcp, ok := decoded.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		srcPort = uint16(tcp.SrcPort)
+	} else if echo != nil {
+		srcPort = echo.Id
+	}
+
+	routers := c.hops[ttl]
+	if len(routers) == 0 {
+		return nil // silent hop
+	}
+	from := net.ParseIP(routers[int(srcPort)%len(routers)])
+	if from.To4() != nil {
+		from = from.To4()
+	}
+
+	tcp, _ := decoded.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if tcp != nil && from.Equal(dst) {
+		return c.answerSYN(tcp, from, src, ttl)
+	}
+
+	reply := &capture.Reply{
+		From:      from,
+		To:        src,
+		Timestamp: time.Now().Add(c.rtt * time.Duration(ttl)),
+		ICMPType:  layers.ICMPv4TypeTimeExceeded,
+		InnerSrc:  src,
+		InnerDst:  dst,
+	}
+	// Quote the probe the way routers do
+	reply.InnerID = id
+	if udp, ok := decoded.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		reply.InnerProtocol = uint8(layers.IPProtocolUDP)
+		reply.InnerSrcPort, reply.InnerDstPort = uint16(udp.SrcPort), uint16(udp.DstPort)
+		reply.InnerChecksum = udp.Checksum
+	} else if tcp, ok := decoded.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		reply.InnerProtocol = uint8(layers.IPProtocolTCP)
+		reply.InnerSrcPort, reply.InnerDstPort = uint16(tcp.SrcPort), uint16(tcp.DstPort)
+		reply.InnerSeq = tcp.Seq
+	}
+	if echo != nil {
+		reply.InnerProtocol = uint8(layers.IPProtocolICMPv4)
+		reply.InnerChecksum = echo.Checksum
+		reply.EchoID, reply.EchoSeq = echo.Id, echo.Seq
+	}
+	if from.Equal(dst) {
+		reply.ICMPType = layers.ICMPv4TypeDestinationUnreachable
+		reply.ICMPCode = layers.ICMPv4CodePort
+		if echo != nil {
+			reply.ICMPType, reply.ICMPCode = layers.ICMPv4TypeEchoReply, 0
+			reply.InnerSrc, reply.InnerDst = nil, nil
+		}
+	}
+	if dst.To4() == nil {
+		reply.IPv6 = true
+		reply.ICMPType = layers.ICMPv6TypeTimeExceeded
+		if from.Equal(dst) {
+			reply.ICMPType = layers.ICMPv6TypeDestinationUnreachable
+			reply.ICMPCode = layers.ICMPv6CodePortUnreachable
+		}
+	}
+	c.pending = append(c.pending, reply)
+	return nil
+}
+
+// answerSYN queues the SYN-ACK or RST of the target
+func (c *fakeConn) answerSYN(tcp *layers.TCP, from, to net.IP, ttl uint8) error {
+	if c.port == results.PortFiltered {
+		return nil
+	}
+	c.pending = append(c.pending, &capture.Reply{
+		From:       from,
+		To:         to,
+		Timestamp:  time.Now().Add(c.rtt * time.Duration(ttl)),
+		IPv6:       from.To4() == nil,
+		TCP:        true,
+		TCPSrcPort: uint16(tcp.DstPort),
+		TCPDstPort: uint16(tcp.SrcPort),
+		TCPAck:     tcp.Seq + 1,
+		SYN:        c.port == results.PortOpen,
+		RST:        c.port == results.PortClosed,
+	})
+	return nil
+}
+
+// ReadReply returns the first queued reply, waiting up to timeout for one
+// like a real capture
+func (c *fakeConn) ReadReply(timeout time.Duration) (*capture.Reply, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		c.mu.Lock()
+		if len(c.pending) > 0 {
+			reply := c.pending[0]
+			c.pending = c.pending[1:]
+			c.mu.Unlock()
+			return reply, nil
+		}
+		c.mu.Unlock()
+
+		if !time.Now().Before(deadline) {
+			return nil, capture.ErrTimeout
+		}
+		time.Sleep(min(time.Millisecond, time.Until(deadline)))
+	}
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
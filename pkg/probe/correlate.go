@@ -0,0 +1,244 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+// Reasons recorded for unmatched replies
+const (
+	reasonUnknownProbe = "no probe with the quoted ports and identifiers"
+	reasonDuplicate    = "probe already answered"
+	reasonAmbiguous    = "several probes of the flow could have triggered it"
+)
+
+// idSequence hands out per-probe identifiers, skipping 0 and 0xffff:
+// neither can be a UDP checksum, and the kernel replaces a zero IP ID
+type idSequence uint16
+
+func (s *idSequence) next() uint16 {
+	*s++
+	if *s == 0 || *s == 0xffff {
+		*s = 1
+	}
+	return uint16(*s)
+}
+
+// sentProbe is a probe whose reply is being, or has been, waited for
+type sentProbe struct {
+	flow     *results.FlowResult
+	ttl      uint8
+	src      net.IP     // Source, the local address unless varied
+	dst      net.IP     // Destination, the target unless probing its neighbours
+	vary     *variation // Header field varied for hash-field discovery
+	protocol layers.IPProtocol
+	srcPort  uint16 // Echo identifier for ICMP probes
+	dstPort  uint16
+	id       uint16 // IPv4 ID, or Echo sequence number
+	seq      uint32 // TCP sequence number
+	checksum uint16 // UDP checksum
+	fixedID  bool   // IPv4 ID shared with other probes, not identifying this one
+	fixedSum bool   // UDP checksum shared with other probes, not identifying this one
+	answered bool
+	expired  bool
+}
+
+// identifiedBy reports whether reply quotes this exact probe, or for a TCP
+// answer from the target, acknowledges it
+func (sp *sentProbe) identifiedBy(reply *capture.Reply) bool {
+	if reply.TCP {
+		return sp.protocol == layers.IPProtocolTCP && reply.TCPAck == sp.seq+1
+	}
+	switch sp.protocol {
+	case layers.IPProtocolUDP:
+		return (!reply.IPv6 && !sp.fixedID && reply.InnerID == sp.id) || (!sp.fixedSum && reply.InnerChecksum == sp.checksum)
+	case layers.IPProtocolTCP:
+		return reply.InnerSeq == sp.seq || (!reply.IPv6 && !sp.fixedID && reply.InnerID == sp.id)
+	default:
+		return reply.EchoSeq == sp.id
+	}
+}
+
+// correlator maps each reply to the probe that triggered it, using the
+// ports and identifiers quoted back. Replies for probes that already timed
+// out, and replies matching no probe, are recorded on the result instead of
+// being attributed to an open probe.
+type correlator struct {
+	srcs   []net.IP // Sources probed from, the local address first
+	dsts   []net.IP // Destinations probed, the target first
+	result *results.TracerouteResult
+	flows  map[[2]uint16][]*sentProbe
+	natIDs map[uint16]bool // NAT IDs seen so far, to match probes behind NATs
+}
+
+func newCorrelator(src, dst net.IP, result *results.TracerouteResult) *correlator {
+	return &correlator{
+		srcs:   []net.IP{src},
+		dsts:   []net.IP{dst},
+		result: result,
+		flows:  make(map[[2]uint16][]*sentProbe),
+		natIDs: map[uint16]bool{0: true},
+	}
+}
+
+// add registers a probe that was just sent
+func (c *correlator) add(sp *sentProbe) {
+	if sp.src != nil && !contains(c.srcs, sp.src) {
+		c.srcs = append(c.srcs, sp.src)
+	}
+	if sp.dst != nil && !contains(c.dsts, sp.dst) {
+		c.dsts = append(c.dsts, sp.dst)
+	}
+	key := [2]uint16{sp.srcPort, sp.dstPort}
+	c.flows[key] = append(c.flows[key], sp)
+}
+
+// forget drops the probes sent before since, so that continuous traces do
+// not keep every probe: replies to them are then reported as unmatched
+func (c *correlator) forget(since time.Time) {
+	for key, probes := range c.flows {
+		kept := probes[:0]
+		for _, sp := range probes {
+			if !sp.flow.SentTime.Before(since) {
+				kept = append(kept, sp)
+			}
+		}
+		if len(kept) == 0 {
+			delete(c.flows, key)
+		} else {
+			c.flows[key] = kept
+		}
+	}
+}
+
+// matches reports whether reply was triggered by a probe between one of the
+// sources and one of the destinations of the trace
+func (c *correlator) matches(reply *capture.Reply) bool {
+	for _, src := range c.srcs {
+		for _, dst := range c.dsts {
+			if reply.Matches(src, dst) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contains reports whether ip is in ips
+func contains(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// flowKey returns the ports (or Echo identifier) of the probe reply answers
+func flowKey(reply *capture.Reply) [2]uint16 {
+	if reply.TCP {
+		return [2]uint16{reply.TCPDstPort, reply.TCPSrcPort}
+	}
+	switch layers.IPProtocol(reply.InnerProtocol) {
+	case layers.IPProtocolUDP, layers.IPProtocolTCP:
+		return [2]uint16{reply.InnerSrcPort, reply.InnerDstPort}
+	}
+	return [2]uint16{reply.EchoID, 0}
+}
+
+// lookup returns the probe reply answers, or nil and the reason none was found
+func (c *correlator) lookup(reply *capture.Reply) (*sentProbe, string) {
+	candidates := c.flows[flowKey(reply)]
+
+	// Exact match on the quoted identifiers, preferring unanswered probes
+	var exact *sentProbe
+	for _, sp := range candidates {
+		if sp.identifiedBy(reply) && (exact == nil || exact.answered) {
+			exact = sp
+		}
+	}
+	if exact != nil {
+		return exact, ""
+	}
+
+	// Behind a NAT both the IP ID and the UDP checksum may be rewritten.
+	// The checksum moves by the same NAT ID for every probe, so an open
+	// probe whose NAT ID was already seen is the one; otherwise the flow
+	// must have a single open probe.
+	var open, known []*sentProbe
+	for _, sp := range candidates {
+		if sp.answered || sp.protocol != layers.IPProtocolUDP || reply.InnerChecksum == 0 {
+			continue
+		}
+		open = append(open, sp)
+		if c.natIDs[natID(sp.checksum, reply.InnerChecksum)] {
+			known = append(known, sp)
+		}
+	}
+	switch {
+	case len(known) == 1:
+		return known[0], ""
+	case len(open) == 1:
+		return open[0], ""
+	case len(open) > 1:
+		return nil, reasonAmbiguous
+	}
+	return nil, reasonUnknownProbe
+}
+
+// dispatch attributes reply to the probe that triggered it and returns that
+// probe if it was still waiting. Replies to probes that already timed out are
+// recorded as late, those matching no open probe as unmatched.
+func (c *correlator) dispatch(reply *capture.Reply) *sentProbe {
+	if !c.matches(reply) {
+		return nil // not triggered by this trace
+	}
+
+	match, reason := c.lookup(reply)
+	switch {
+	case match == nil:
+		c.result.UnmatchedReplies = append(c.result.UnmatchedReplies, strayReply(reply, reason))
+		return nil
+	case match.answered:
+		c.result.UnmatchedReplies = append(c.result.UnmatchedReplies, strayReply(reply, reasonDuplicate))
+		return nil
+	}
+
+	match.answered = true
+	if match.protocol == layers.IPProtocolUDP && reply.InnerChecksum != 0 {
+		c.natIDs[natID(match.checksum, reply.InnerChecksum)] = true
+	}
+	if !match.expired {
+		return match
+	}
+
+	late := strayReply(reply, "")
+	late.TTL = match.ttl
+	late.FlowID = match.flow.FlowID
+	late.RTT = reply.Timestamp.Sub(match.flow.SentTime)
+	c.result.LateReplies = append(c.result.LateReplies, late)
+	return nil
+}
+
+// strayReply records a reply that answers no open probe
+func strayReply(reply *capture.Reply, reason string) results.StrayReply {
+	return results.StrayReply{
+		From:     reply.From.String(),
+		RecvTime: reply.Timestamp,
+		ICMPType: reply.ICMPType,
+		ICMPCode: reply.ICMPCode,
+		Reason:   reason,
+	}
+}
@@ -0,0 +1,184 @@
+package probe
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+func TestIDSequenceSkipsReservedValues(t *testing.T) {
+	ids := idSequence(0xfffd)
+	for _, want := range []uint16{0xfffe, 1, 2} {
+		if got := ids.next(); got != want {
+			t.Errorf("got ID %#04x, want %#04x", got, want)
+		}
+	}
+}
+
+// quotedUDP returns a Time Exceeded from router quoting a UDP probe with
+// the given source port, IP ID and checksum
+func quotedUDP(router string, srcPort, id, checksum uint16) *capture.Reply {
+	return &capture.Reply{
+		From:          net.ParseIP(router).To4(),
+		To:            testSrc,
+		Timestamp:     time.Now(),
+		ICMPType:      layers.ICMPv4TypeTimeExceeded,
+		InnerSrc:      testSrc,
+		InnerDst:      testTarget,
+		InnerProtocol: uint8(layers.IPProtocolUDP),
+		InnerSrcPort:  srcPort,
+		InnerDstPort:  33434,
+		InnerID:       id,
+		InnerChecksum: checksum,
+	}
+}
+
+// sendUDP registers a UDP probe of flowID with the correlator
+func sendUDP(c *correlator, ttl uint8, flowID, id uint16) *sentProbe {
+	sp := &sentProbe{
+		flow:     &results.FlowResult{FlowID: flowID, SentTime: time.Now()},
+		ttl:      ttl,
+		protocol: layers.IPProtocolUDP,
+		srcPort:  33434 + flowID,
+		dstPort:  33434,
+		id:       id,
+		checksum: id,
+	}
+	c.add(sp)
+	return sp
+}
+
+func TestCorrelatorLateReply(t *testing.T) {
+	result := &results.TracerouteResult{}
+	c := newCorrelator(testSrc, testTarget, result)
+
+	// The first probe timed out; its reply shows up after the second probe
+	first := sendUDP(c, 1, 0, 1)
+	first.expired = true
+	second := sendUDP(c, 1, 1, 2)
+
+	if sp := c.dispatch(quotedUDP("192.168.1.1", 33434, 1, 1)); sp != nil {
+		t.Errorf("late reply attributed to probe %+v", sp)
+	}
+	if sp := c.dispatch(quotedUDP("192.168.1.1", 33435, 2, 2)); sp != second {
+		t.Errorf("got probe %+v, want the second one", sp)
+	}
+	if len(result.LateReplies) != 1 || result.LateReplies[0].TTL != 1 || result.LateReplies[0].FlowID != 0 {
+		t.Errorf("got late replies %+v, want the first probe's", result.LateReplies)
+	}
+}
+
+func TestCorrelatorUnmatchedReplies(t *testing.T) {
+	result := &results.TracerouteResult{}
+	c := newCorrelator(testSrc, testTarget, result)
+
+	first := sendUDP(c, 1, 0, 1)
+	if sp := c.dispatch(quotedUDP("192.168.1.1", 33434, 1, 1)); sp != first {
+		t.Fatalf("got probe %+v, want the first one", sp)
+	}
+
+	second := sendUDP(c, 2, 0, 2)
+	foreign := quotedUDP("10.0.0.1", 33434, 9, 9)
+	foreign.InnerDst = net.ParseIP("1.1.1.1").To4()
+	for _, reply := range []*capture.Reply{
+		foreign,                               // another trace: ignored
+		quotedUDP("10.0.0.1", 40000, 2, 2),    // no such flow
+		quotedUDP("192.168.1.1", 33434, 1, 1), // duplicate of the first reply
+	} {
+		if sp := c.dispatch(reply); sp != nil {
+			t.Errorf("reply from %s attributed to probe %+v", reply.From, sp)
+		}
+	}
+	if sp := c.dispatch(quotedUDP("10.0.0.1", 33434, 2, 2)); sp != second {
+		t.Errorf("got probe %+v, want the second one", sp)
+	}
+
+	if len(result.UnmatchedReplies) != 2 {
+		t.Fatalf("got unmatched replies %+v, want 2", result.UnmatchedReplies)
+	}
+	if result.UnmatchedReplies[0].Reason != reasonUnknownProbe || result.UnmatchedReplies[1].Reason != reasonDuplicate {
+		t.Errorf("got reasons %q and %q", result.UnmatchedReplies[0].Reason, result.UnmatchedReplies[1].Reason)
+	}
+	if len(result.LateReplies) != 0 {
+		t.Errorf("got late replies %+v", result.LateReplies)
+	}
+}
+
+func TestCorrelatorForget(t *testing.T) {
+	c := newCorrelator(testSrc, testTarget, &results.TracerouteResult{})
+	old := sendUDP(c, 1, 0, 1)
+	old.flow.SentTime = time.Now().Add(-time.Minute)
+	recent := sendUDP(c, 2, 0, 2)
+	sendUDP(c, 1, 1, 3).flow.SentTime = old.flow.SentTime
+
+	c.forget(time.Now().Add(-time.Second))
+	if len(c.flows) != 1 || len(c.flows[[2]uint16{33434, 33434}]) != 1 || c.flows[[2]uint16{33434, 33434}][0] != recent {
+		t.Errorf("got flows %+v, want only the recent probe", c.flows)
+	}
+}
+
+func TestCorrelatorTCPAnswer(t *testing.T) {
+	c := newCorrelator(testSrc, testTarget, &results.TracerouteResult{})
+	var probes []*sentProbe
+	for ttl, seq := range []uint32{0x10, 0x11} {
+		sp := &sentProbe{
+			flow:     &results.FlowResult{SentTime: time.Now()},
+			ttl:      uint8(ttl + 5),
+			protocol: layers.IPProtocolTCP,
+			srcPort:  50000,
+			dstPort:  443,
+			seq:      seq,
+		}
+		c.add(sp)
+		probes = append(probes, sp)
+	}
+
+	// The SYN-ACK acknowledges the second SYN of the flow
+	synAck := &capture.Reply{
+		From:       testTarget,
+		To:         testSrc,
+		Timestamp:  time.Now(),
+		TCP:        true,
+		TCPSrcPort: 443,
+		TCPDstPort: 50000,
+		TCPAck:     0x12,
+		SYN:        true,
+	}
+	if sp := c.dispatch(synAck); sp != probes[1] {
+		t.Errorf("got probe %+v, want the one with sequence 0x11", sp)
+	}
+
+	// An answer from another host is not the target's
+	synAck.From = net.ParseIP("10.0.0.1").To4()
+	synAck.TCPAck = 0x11
+	if sp := c.dispatch(synAck); sp != nil {
+		t.Errorf("SYN-ACK from a router attributed to %+v", sp)
+	}
+}
+
+func TestCorrelatorBehindNAT(t *testing.T) {
+	c := newCorrelator(testSrc, testTarget, &results.TracerouteResult{})
+	const shift = 0x0100 // checksum change made by the NAT
+
+	// Two probes of the flow are open: the first one timed out
+	first := sendUDP(c, 3, 0, 0x1010)
+	first.expired = true
+	sendUDP(c, 4, 0, 0x1011)
+
+	// The NAT rewrote the IP ID and shifted the checksum
+	late := quotedUDP("10.0.0.1", 33434, 0x8001, 0x1010-shift)
+	if sp, reason := c.lookup(late); sp != nil || reason != reasonAmbiguous {
+		t.Fatalf("got %v (%q), want an ambiguous reply while the NAT ID is unknown", sp, reason)
+	}
+
+	// Once another flow revealed the NAT ID, the reply is attributed
+	c.natIDs[natID(0x2020, 0x2020-shift)] = true
+	if sp, _ := c.lookup(late); sp != first {
+		t.Errorf("got probe %+v, want the timed out one", sp)
+	}
+}
@@ -0,0 +1,499 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+// prober builds the probes of one protocol for the engine
+type prober interface {
+	// craft returns the probe of flowID described by sp, filling in the
+	// identifiers of sp and of sp.flow
+	craft(sp *sentProbe, flowID uint16) ([]byte, error)
+
+	// reached reports whether flow was answered by the target itself
+	reached(flow *results.FlowResult) bool
+}
+
+// engine sends all probes of a round at once instead of waiting for each
+// reply in turn. A sender goroutine fires the probes at the pace set by
+// delay while a single receiver goroutine matches replies; the round ends
+// when every probe up to the target is answered, or timeout after the last
+// probe went out.
+type engine struct {
+	prober
+	conn           PacketConn
+	clock          Clock
+	src, target    net.IP
+	numPaths       uint16
+	minTTL, maxTTL uint8
+	probeCount     int
+	delay, timeout time.Duration
+	resolveNames   bool
+	mda            float64 // MDA confidence; numPaths is then the most flows per hop
+	classifyLB     bool
+	hashFields     bool
+	splitFlows     uint16 // Flows sent through each load-balancing hop to estimate its split
+	protocol       layers.IPProtocol
+	sources        []net.IP        // Extra local addresses for hash-field discovery
+	stop           <-chan struct{} // Closed to end a continuous trace, nil otherwise
+}
+
+// stopPoll is how often a continuous trace looks for a stop while it waits
+const stopPoll = 100 * time.Millisecond
+
+// slot is a probe waiting to be sent
+type slot struct {
+	ttl    uint8
+	flowID uint16
+	id     uint16 // FlowID recorded in the result
+	dst    net.IP // Destination if not the target
+	vary   *variation
+}
+
+// run traces the target into result, then classifies its load balancers,
+// finds the header fields they hash on and estimates how they split flows
+// if asked to
+func (e *engine) run(result *results.TracerouteResult) error {
+	trace := e.runRounds
+	if e.mda > 0 {
+		trace = e.runMDA
+	}
+	if err := trace(result); err != nil {
+		return err
+	}
+
+	if e.classifyLB {
+		if err := e.classify(result); err != nil {
+			return err
+		}
+	}
+	if e.hashFields {
+		if err := e.discoverHashFields(result); err != nil {
+			return err
+		}
+	}
+	if e.splitFlows > 0 {
+		return e.estimateSplits(result)
+	}
+	return nil
+}
+
+// runRounds sends probeCount rounds and fills in result. The first round
+// probes every TTL; later rounds stop at the target once it has been reached.
+func (e *engine) runRounds(result *results.TracerouteResult) error {
+	replies := newCorrelator(e.src, e.target, result)
+
+	horizon := e.maxTTL
+	for round := 0; round < e.probeCount; round++ {
+		var slots []slot
+		for ttl := e.minTTL; ttl <= horizon && ttl != 0; ttl++ {
+			for flowID := uint16(0); flowID < e.numPaths; flowID++ {
+				id := flowID + uint16(round)*e.numPaths
+				slots = append(slots, slot{ttl: ttl, flowID: flowID, id: id})
+			}
+		}
+		if err := e.round(slots, replies, result); err != nil {
+			return err
+		}
+
+		dest := destinationTTL(result, e.reached)
+		if dest != 0 {
+			horizon = dest
+		}
+		if round == 0 {
+			e.trim(result, dest)
+			if e.resolveNames {
+				resolveHostnames(result)
+			}
+			e.print(result, dest)
+		}
+	}
+
+	e.trim(result, destinationTTL(result, e.reached))
+	return nil
+}
+
+// RoundHandler receives each round of a continuous trace, as a result of
+// its own, and returns false to stop probing
+type RoundHandler func(round *results.TracerouteResult) bool
+
+// runContinuous sends a round every interval until handle returns false
+// or stop is closed; the round under way when stop closes is dropped.
+// Each round is a fresh result, so memory stays flat however long the trace
+// runs, but replies are matched across rounds: a reply to the previous
+// round is late, not an answer to the current one. Rounds probe up to the
+// TTL where the target last answered, or every TTL until it does.
+func (e *engine) runContinuous(interval time.Duration, handle RoundHandler, stop <-chan struct{}) error {
+	e.stop = stop
+	replies := newCorrelator(e.src, e.target, nil)
+
+	horizon := e.maxTTL
+	var previous time.Time
+	for {
+		start := e.clock.Now()
+		round := &results.TracerouteResult{
+			Target:    e.target.String(),
+			SrcIP:     e.src.String(),
+			StartTime: start,
+			Hops:      make(map[uint8]*results.HopResult),
+		}
+		replies.result = round
+		replies.forget(previous)
+
+		var slots []slot
+		for ttl := e.minTTL; ttl <= horizon && ttl != 0; ttl++ {
+			for flowID := uint16(0); flowID < e.numPaths; flowID++ {
+				slots = append(slots, slot{ttl: ttl, flowID: flowID, id: flowID})
+			}
+		}
+		if err := e.round(slots, replies, round); err != nil {
+			return err
+		}
+		if e.stopped() {
+			return nil
+		}
+
+		dest := destinationTTL(round, e.reached)
+		e.trim(round, dest)
+		horizon = e.maxTTL
+		if dest != 0 {
+			horizon = dest
+		}
+		round.EndTime = e.clock.Now()
+		round.Duration = round.EndTime.Sub(round.StartTime)
+
+		if !handle(round) {
+			return nil
+		}
+		previous = start
+		for wait := interval - e.clock.Now().Sub(start); wait > 0; wait -= stopPoll {
+			if e.stopped() {
+				return nil
+			}
+			e.clock.Sleep(min(wait, stopPoll))
+		}
+	}
+}
+
+// stopped reports whether stop was closed
+func (e *engine) stopped() bool {
+	select {
+	case <-e.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// round sends the probes of slots and collects their replies. A sender
+// goroutine fires the probes delay apart while the calling goroutine, the
+// receiver, matches replies as they come. The round ends once every probe
+// that can matter is answered and all are sent, or at its deadline, timeout
+// after the last probe went out.
+func (e *engine) round(slots []slot, replies *correlator, result *results.TracerouteResult) error {
+	r := &roundState{}
+	dest := destinationTTL(result, e.reached)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+
+	e.join()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer e.leave()
+		e.sendAll(slots, replies, result, r, quit)
+	}()
+
+	err := e.receive(replies, r, dest)
+
+	// Stop the sender, if still sending, without holding up the clock
+	close(quit)
+	e.leave()
+	wg.Wait()
+	e.join()
+
+	for _, sp := range r.probes {
+		if !sp.answered {
+			sp.expired = true
+			sp.flow.Error = "timeout"
+		}
+	}
+	return err
+}
+
+// roundState is what the sender and the receiver of a round share
+type roundState struct {
+	mu       sync.Mutex // Also guards the correlator and the result
+	probes   []*sentProbe
+	lastSent time.Time
+	sent     bool // Every probe went out, or the sender gave up
+}
+
+// sendAll is the sender goroutine: it sends the probes of slots delay
+// apart until all are sent or quit is closed. Each probe is registered
+// with the correlator as it goes out, so its reply always finds it.
+func (e *engine) sendAll(slots []slot, replies *correlator, result *results.TracerouteResult, r *roundState, quit <-chan struct{}) {
+	defer func() {
+		r.mu.Lock()
+		r.sent = true
+		r.mu.Unlock()
+	}()
+
+	for i, s := range slots {
+		if i > 0 {
+			e.clock.Sleep(e.delay)
+		}
+		select {
+		case <-quit:
+			return
+		default:
+		}
+
+		r.mu.Lock()
+		sp := e.send(s)
+		storeFlow(result, sp)
+		if sp.flow.Error == "" {
+			replies.add(sp)
+			r.probes = append(r.probes, sp)
+		}
+		r.lastSent = e.clock.Now()
+		r.mu.Unlock()
+	}
+}
+
+// receive is the receiver: it matches replies until the round ends, dest
+// being the TTL of the target once known. While
+// probes are still going out it reads stopPoll at a time, so a round that
+// settles as its last probe goes out does not wait for the deadline.
+func (e *engine) receive(replies *correlator, r *roundState, dest uint8) error {
+	for !e.stopped() {
+		r.mu.Lock()
+		sent, lastSent := r.sent, r.lastSent
+		finished := sent && settled(r.probes, dest)
+		r.mu.Unlock()
+
+		now := e.clock.Now()
+		deadline := lastSent.Add(e.timeout)
+		if finished || sent && !now.Before(deadline) {
+			return nil
+		}
+
+		wait := e.timeout
+		if !lastSent.IsZero() {
+			wait = deadline.Sub(now)
+		}
+		if !sent || e.stop != nil {
+			wait = min(wait, stopPoll)
+		}
+		reply, err := e.conn.ReadReply(wait)
+		if errors.Is(err, capture.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read replies: %w", err)
+		}
+
+		r.mu.Lock()
+		if sp := replies.dispatch(reply); sp != nil {
+			answer(sp.flow, reply)
+			if e.reached(sp.flow) && (dest == 0 || sp.ttl < dest) {
+				dest = sp.ttl
+			}
+		}
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// sharedClock is a Clock that must know how many goroutines use it at
+// once, like the simulator's virtual clock, which only moves when all of
+// them wait on it
+type sharedClock interface {
+	Join()
+	Leave()
+}
+
+// join counts the calling goroutine as a user of a shared clock
+func (e *engine) join() {
+	if c, ok := e.clock.(sharedClock); ok {
+		c.Join()
+	}
+}
+
+// leave undoes join
+func (e *engine) leave() {
+	if c, ok := e.clock.(sharedClock); ok {
+		c.Leave()
+	}
+}
+
+// send transmits the probe of s; a probe that could not be sent carries
+// the error in its flow
+func (e *engine) send(s slot) *sentProbe {
+	sp := &sentProbe{
+		flow: &results.FlowResult{FlowID: s.id},
+		ttl:  s.ttl,
+		dst:  s.dst,
+		vary: s.vary,
+	}
+	if sp.dst == nil {
+		sp.dst = e.target
+	}
+
+	packet, err := e.craft(sp, s.flowID)
+	sp.flow.SentTime = e.clock.Now()
+	if err == nil {
+		err = e.conn.WritePacket(packet, sp.dst)
+	}
+	if err != nil {
+		sp.flow.Error = fmt.Errorf("failed to send probe (TTL=%d, FlowID=%d): %w", s.ttl, s.flowID, err).Error()
+	}
+	return sp
+}
+
+// settled reports whether every probe that can matter is answered: those
+// up to the target once it is known, all of them otherwise
+func settled(probes []*sentProbe, dest uint8) bool {
+	for _, sp := range probes {
+		if !sp.answered && (dest == 0 || sp.ttl <= dest) {
+			return false
+		}
+	}
+	return true
+}
+
+// answer records reply on the flow it answers
+func answer(flow *results.FlowResult, reply *capture.Reply) {
+	flow.RecvTime = reply.Timestamp
+	flow.RTT = flow.RecvTime.Sub(flow.SentTime)
+	flow.ResponseIP = reply.From.String()
+	flow.ICMPType = reply.ICMPType
+	flow.ICMPCode = reply.ICMPCode
+	if reply.TCP {
+		flow.TCPState = results.PortClosed
+		if reply.SYN {
+			flow.TCPState = results.PortOpen
+		}
+	}
+	recordNAT(flow, reply)
+}
+
+// storeFlow adds the flow of sp to its hop
+func storeFlow(result *results.TracerouteResult, sp *sentProbe) {
+	hop, ok := result.Hops[sp.ttl]
+	if !ok {
+		hop = &results.HopResult{
+			TTL:   sp.ttl,
+			Flows: make(map[uint16]*results.FlowResult),
+		}
+		result.Hops[sp.ttl] = hop
+	}
+	hop.Flows[sp.flow.FlowID] = sp.flow
+}
+
+// destinationTTL returns the lowest TTL at which the target answered, or 0
+func destinationTTL(result *results.TracerouteResult, reached func(*results.FlowResult) bool) uint8 {
+	var dest uint8
+	for ttl, hop := range result.Hops {
+		if dest != 0 && ttl >= dest {
+			continue
+		}
+		for _, flow := range hop.Flows {
+			if reached(flow) {
+				dest = ttl
+				break
+			}
+		}
+	}
+	return dest
+}
+
+// trim drops the hops past the target: probes sent beyond it only reach
+// the target again
+func (e *engine) trim(result *results.TracerouteResult, dest uint8) {
+	if dest == 0 {
+		return
+	}
+	for ttl := range result.Hops {
+		if ttl > dest {
+			delete(result.Hops, ttl)
+		}
+	}
+}
+
+// resolveHostnames reverse-resolves each responding address once
+func resolveHostnames(result *results.TracerouteResult) {
+	names := make(map[string]string)
+	for _, hop := range result.Hops {
+		for _, flow := range hop.Flows {
+			if flow.ResponseIP == "" {
+				continue
+			}
+			name, ok := names[flow.ResponseIP]
+			if !ok {
+				name = lookupHostname(net.ParseIP(flow.ResponseIP))
+				names[flow.ResponseIP] = name
+			}
+			flow.Hostname = name
+		}
+	}
+}
+
+// print shows the first round, one line per TTL and flow
+func (e *engine) print(result *results.TracerouteResult, dest uint8) {
+	ttls := make([]uint8, 0, len(result.Hops))
+	for ttl := range result.Hops {
+		ttls = append(ttls, ttl)
+	}
+	sort.Slice(ttls, func(i, j int) bool { return ttls[i] < ttls[j] })
+
+	for _, ttl := range ttls {
+		for flowID := uint16(0); flowID < e.numPaths; flowID++ {
+			flow, ok := result.Hops[ttl].Flows[flowID]
+			if !ok {
+				continue
+			}
+
+			switch {
+			case flow.Error == "timeout":
+				fmt.Printf("TTL=%2d Flow=%2d: *\n", ttl, flowID)
+			case flow.Error != "":
+				fmt.Printf("TTL=%2d Flow=%2d: %s\n", ttl, flowID, flow.Error)
+			default:
+				fmt.Printf("TTL=%2d Flow=%2d: %s", ttl, flowID, flow.ResponseIP)
+				if flow.Hostname != "" {
+					fmt.Printf(" (%s)", flow.Hostname)
+				}
+				fmt.Printf(" %v", flow.RTT)
+				if flow.TCPState != "" {
+					fmt.Printf(" port %s", flow.TCPState)
+				}
+				if flow.NATID != 0 {
+					fmt.Printf(" NAT ID %d", flow.NATID)
+				}
+				fmt.Println()
+			}
+		}
+	}
+
+	if dest != 0 {
+		fmt.Printf("\nReached target %s at TTL %d\n", e.target, dest)
+	}
+}
@@ -0,0 +1,165 @@
+package probe
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+// orderConn records whether each call to the wrapped fakeConn was a write or a read
+type orderConn struct {
+	*fakeConn
+	mu    sync.Mutex
+	calls []string
+	err   error
+}
+
+func (c *orderConn) record(call string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, call)
+}
+
+func (c *orderConn) WritePacket(packet []byte, dst net.IP) error {
+	c.record("write")
+	return c.fakeConn.WritePacket(packet, dst)
+}
+
+func (c *orderConn) ReadReply(timeout time.Duration) (*capture.Reply, error) {
+	c.record("read")
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.fakeConn.ReadReply(timeout)
+}
+
+func TestEngineReadsWhileSending(t *testing.T) {
+	conn := &orderConn{fakeConn: newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"8.8.8.8"},
+	})}
+	p := newTestUDPProbe(conn, 4, 10)
+	p.Delay = 2 * time.Millisecond
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	// The receiver reads replies while the sender still paces its probes
+	firstRead, lastWrite := -1, -1
+	for i, call := range conn.calls {
+		if call == "read" && firstRead < 0 {
+			firstRead = i
+		}
+		if call == "write" {
+			lastWrite = i
+		}
+	}
+	if firstRead < 0 || firstRead > lastWrite {
+		t.Errorf("first read is call %d, last write call %d: want reads among the writes", firstRead, lastWrite)
+	}
+
+	if len(result.Hops) != 2 {
+		t.Errorf("got %d hops, want the hops past the target dropped", len(result.Hops))
+	}
+	for _, flow := range result.Hops[2].Flows {
+		if flow.ResponseIP != "8.8.8.8" {
+			t.Errorf("flow %d: got %q, want the target", flow.FlowID, flow.ResponseIP)
+		}
+	}
+}
+
+func TestEngineContinuousRounds(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 2, 10)
+
+	var rounds []*results.TracerouteResult
+	err := p.TraceContinuously(0, func(round *results.TracerouteResult) bool {
+		rounds = append(rounds, round)
+		return len(rounds) < 3
+	}, nil)
+	if err != nil {
+		t.Fatalf("TraceContinuously: %v", err)
+	}
+
+	// Like MTR rounds, but each in a result of its own
+	if want := 2*10 + 2*2*2; len(conn.sent) != want {
+		t.Errorf("sent %d probes, want %d", len(conn.sent), want)
+	}
+	for i, round := range rounds {
+		if len(round.Hops) != 2 || len(round.Hops[2].Flows) != 2 {
+			t.Fatalf("round %d: got hops %+v, want 2 flows up to the target", i, round.Hops)
+		}
+		for _, flow := range round.Hops[2].Flows {
+			if flow.ResponseIP != "8.8.8.8" || flow.Error != "" {
+				t.Errorf("round %d flow %d: got %+v", i, flow.FlowID, flow)
+			}
+		}
+	}
+}
+
+func TestEngineContinuousStop(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 2, 10)
+
+	stop := make(chan struct{})
+	rounds := 0
+	start := time.Now()
+	err := p.TraceContinuously(time.Hour, func(round *results.TracerouteResult) bool {
+		rounds++
+		time.AfterFunc(10*time.Millisecond, func() { close(stop) })
+		return true
+	}, stop)
+	if err != nil {
+		t.Fatalf("TraceContinuously: %v", err)
+	}
+
+	// The wait for the next round ends with the stop, not the interval
+	if elapsed := time.Since(start); elapsed > time.Second || rounds != 1 {
+		t.Errorf("returned after %v and %d rounds, want 1 round at once", elapsed, rounds)
+	}
+}
+
+func TestEngineMTRRoundsStopAtTarget(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 2, 10)
+	p.ProbeCount = 3
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	// The first round covers every TTL, the next two only up to the target
+	if want := 2*10 + 2*2*2; len(conn.sent) != want {
+		t.Errorf("sent %d probes, want %d", len(conn.sent), want)
+	}
+	if got := len(result.Hops[2].Flows); got != 6 {
+		t.Errorf("TTL 2 has %d flows, want 2 per round", got)
+	}
+}
+
+func TestEngineReturnsReadErrors(t *testing.T) {
+	failure := errors.New("capture closed")
+	conn := &orderConn{fakeConn: newFakeConn(nil), err: failure}
+	p := newTestUDPProbe(conn, 1, 3)
+
+	if _, err := p.Traceroute(); !errors.Is(err, failure) {
+		t.Errorf("got %v, want the read error", err)
+	}
+}
@@ -0,0 +1,124 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Flow strategies, selecting where UDP and TCP probes carry their flow ID
+// and their probe identifier
+const (
+	FlowSrcPort = "sport" // Flow in the source port (default)
+	FlowDstPort = "dport" // Flow in the destination port, as the original dublin-traceroute
+	FlowParis   = "paris" // Fixed 5-tuple; probes told apart by the UDP checksum (Paris traceroute)
+	FlowIPID    = "ipid"  // Fixed 5-tuple and UDP checksum; probes told apart by the IPv4 ID only
+)
+
+// ipIDChecksum is the UDP checksum of every probe with FlowIPID
+const ipIDChecksum = 0x4450
+
+// flowStrategy places the flow ID and the probe identifier in the header of
+// a probe
+type flowStrategy interface {
+	// encode sets the fields of h that carry flowID and id, starting from
+	// the configured ports, and returns the UDP checksum to tune the
+	// payload to. TCP probes always repeat id in the sequence number.
+	encode(h *header, flowID, id uint16) uint16
+
+	// describe tells which ports the probes use
+	describe(srcPort, dstPort, numPaths uint16) string
+}
+
+var flowStrategies = map[string]flowStrategy{
+	FlowSrcPort: srcPortFlows{},
+	FlowDstPort: dstPortFlows{},
+	FlowParis:   parisFlows{},
+	FlowIPID:    ipIDFlows{},
+}
+
+// FlowStrategies returns the names of the flow strategies
+func FlowStrategies() []string {
+	names := make([]string, 0, len(flowStrategies))
+	for name := range flowStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupFlowStrategy returns the named strategy; the empty name is FlowSrcPort
+func lookupFlowStrategy(name string) (flowStrategy, error) {
+	if name == "" {
+		name = FlowSrcPort
+	}
+	strategy, ok := flowStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown flow strategy %q (want one of %v)", name, FlowStrategies())
+	}
+	return strategy, nil
+}
+
+// srcPortFlows gives each flow its own source port, and for IPv6 a flow
+// label mirroring it
+type srcPortFlows struct{}
+
+func (srcPortFlows) encode(h *header, flowID, id uint16) uint16 {
+	h.srcPort += flowID
+	h.label = flowLabel(h.srcPort)
+	h.id = id
+	return id
+}
+
+func (srcPortFlows) describe(srcPort, dstPort, numPaths uint16) string {
+	return fmt.Sprintf("source ports %d-%d to port %d", srcPort, srcPort+numPaths-1, dstPort)
+}
+
+// dstPortFlows gives each flow its own destination port
+type dstPortFlows struct{}
+
+func (dstPortFlows) encode(h *header, flowID, id uint16) uint16 {
+	h.dstPort += flowID
+	h.label = flowLabel(h.dstPort)
+	h.id = id
+	return id
+}
+
+func (dstPortFlows) describe(srcPort, dstPort, numPaths uint16) string {
+	return fmt.Sprintf("source port %d to ports %d-%d", srcPort, dstPort, dstPort+numPaths-1)
+}
+
+// parisFlows keeps the 5-tuple of every probe, so that per-flow load
+// balancers send them all down one path, and identifies probes by the UDP
+// checksum (and IPv4 ID)
+type parisFlows struct{}
+
+func (parisFlows) encode(h *header, flowID, id uint16) uint16 {
+	h.label = flowLabel(h.srcPort)
+	h.id = id
+	return id
+}
+
+func (parisFlows) describe(srcPort, dstPort, numPaths uint16) string {
+	return fmt.Sprintf("source port %d to port %d for every probe (Paris)", srcPort, dstPort)
+}
+
+// ipIDFlows keeps the whole UDP header of every probe, checksum included,
+// and identifies probes by the IPv4 ID alone. TCP probes still carry the
+// identifier in the sequence number, which makes it the same as parisFlows.
+type ipIDFlows struct{}
+
+func (ipIDFlows) encode(h *header, flowID, id uint16) uint16 {
+	h.id = id
+	return ipIDChecksum
+}
+
+func (ipIDFlows) describe(srcPort, dstPort, numPaths uint16) string {
+	return fmt.Sprintf("source port %d to port %d for every probe, told apart by IP ID", srcPort, dstPort)
+}
@@ -0,0 +1,93 @@
+package probe
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+func TestFlowStrategiesEncode(t *testing.T) {
+	for _, tc := range []struct {
+		strategy         string
+		srcPort, dstPort uint16 // Ports of flow 2
+		idInChecksum     bool
+	}{
+		{FlowSrcPort, 33436, 33434, true},
+		{FlowDstPort, 33434, 33436, true},
+		{FlowParis, 33434, 33434, true},
+		{FlowIPID, 33434, 33434, false},
+	} {
+		p := newTestUDPProbe(newFakeConn(nil), 4, 1)
+		if err := p.SetFlowStrategy(tc.strategy); err != nil {
+			t.Fatal(err)
+		}
+
+		sp := &sentProbe{flow: &results.FlowResult{}, ttl: 1, dst: testTarget}
+		packet, err := p.craft(sp, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := gopacket.NewPacket(packet, layers.LayerTypeIPv4, gopacket.Default)
+		ip := decoded.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		udp := decoded.Layer(layers.LayerTypeUDP).(*layers.UDP)
+
+		if uint16(udp.SrcPort) != tc.srcPort || uint16(udp.DstPort) != tc.dstPort {
+			t.Errorf("%s: flow 2 got ports %d->%d, want %d->%d", tc.strategy, udp.SrcPort, udp.DstPort, tc.srcPort, tc.dstPort)
+		}
+		if ip.Id != sp.id {
+			t.Errorf("%s: IP ID %d, want the probe id %d", tc.strategy, ip.Id, sp.id)
+		}
+		if (udp.Checksum == sp.id) != tc.idInChecksum || udp.Checksum != sp.flow.Checksum {
+			t.Errorf("%s: checksum %#04x for probe id %#04x (recorded %#04x)", tc.strategy, udp.Checksum, sp.id, sp.flow.Checksum)
+		}
+	}
+}
+
+func TestIPIDStrategyTraceroute(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"10.0.0.1", "10.0.0.2"},
+		3: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 4, 10)
+	if err := p.SetFlowStrategy(FlowIPID); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	// Every flow shares the 5-tuple, so a per-flow balancer keeps them together
+	for flowID, flow := range result.Hops[2].Flows {
+		if flow.ResponseIP != "10.0.0.1" {
+			t.Errorf("TTL 2 flow %d: got %q, want 10.0.0.1", flowID, flow.ResponseIP)
+		}
+		if flow.Checksum != ipIDChecksum {
+			t.Errorf("TTL 2 flow %d: checksum %#04x, want the shared %#04x", flowID, flow.Checksum, ipIDChecksum)
+		}
+	}
+	if len(result.UnmatchedReplies) != 0 {
+		t.Errorf("probes sharing a checksum must be told apart by IP ID: %+v", result.UnmatchedReplies)
+	}
+}
+
+func TestSetFlowStrategyRejects(t *testing.T) {
+	p := newTestUDPProbe(newFakeConn(nil), 4, 1)
+	if err := p.SetFlowStrategy("random"); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+
+	p.Target = net.ParseIP("2001:4860:4860::8888")
+	if err := p.SetFlowStrategy(FlowIPID); err == nil {
+		t.Error("ipid accepted for an IPv6 target, which has no IP ID")
+	}
+	if p.FlowStrategy != "" {
+		t.Errorf("rejected strategy was kept: %q", p.FlowStrategy)
+	}
+}
@@ -0,0 +1,179 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+const (
+	hashVariations = 8      // Values tried for each field at each hop
+	hashBaseID     = 0x4d44 // IPv4 ID of every probe while another field varies
+)
+
+// control is the pseudo-field of the probes that vary nothing: if they take
+// different next hops, the hop balances per packet
+const control = ""
+
+// variation sets one header field of a probe, for hash-field discovery, to
+// the index-th value tried. The IPv4 ID of the probe is fixed meanwhile so
+// that only the field varied differs between probes; the UDP checksum, TCP
+// sequence number or Echo sequence number still identifies each of them.
+type variation struct {
+	field string
+	index int
+}
+
+// apply changes h as v asks; sources are the extra local addresses tried
+// for the source address
+func (v *variation) apply(h *header, sources []net.IP) {
+	if v == nil {
+		return
+	}
+
+	h.id = hashBaseID
+	switch v.field {
+	case results.FieldSrcPort:
+		h.srcPort += uint16(v.index)
+	case results.FieldDstPort:
+		h.dstPort += uint16(v.index)
+	case results.FieldIPID:
+		h.id += uint16(v.index)
+	case results.FieldTOS:
+		h.tos = uint8(v.index) << 2 // DSCP index, ECN bits clear
+	case results.FieldFlowLabel:
+		h.label += uint32(v.index)
+	case results.FieldSrc:
+		all := append([]net.IP{h.src}, sources...)
+		h.src = all[v.index%len(all)]
+	}
+}
+
+// hashableFields returns the fields the probes of this trace can vary
+func (e *engine) hashableFields() []string {
+	var fields []string
+	if e.protocol != layers.IPProtocolICMPv4 {
+		// The Echo identifier is not a port, and it changes the checksum
+		fields = append(fields, results.FieldSrcPort, results.FieldDstPort)
+	}
+	if e.target.To4() != nil {
+		fields = append(fields, results.FieldIPID, results.FieldTOS)
+	} else {
+		fields = append(fields, results.FieldTOS, results.FieldFlowLabel)
+	}
+	if len(e.sources) > 0 {
+		fields = append(fields, results.FieldSrc)
+	}
+	return fields
+}
+
+// hashProbe is a probe of hash-field discovery
+type hashProbe struct {
+	ttl   uint8
+	field string
+}
+
+// discoverHashFields varies each header field on its own at every
+// answering hop before the target and records, for the hops where the next
+// hop changes, which fields made it change. A field that already changed
+// the previous hop is reported as inherited, since the balancer upstream
+// explains it.
+func (e *engine) discoverHashFields(result *results.TracerouteResult) error {
+	last := e.maxTTL
+	if dest := destinationTTL(result, e.reached); dest != 0 {
+		last = dest - 1
+	}
+	fields := e.hashableFields()
+
+	var slots []slot
+	var probes []hashProbe
+	var ttls []uint8
+	for ttl := e.minTTL; ttl <= last && ttl != 0; ttl++ {
+		if _, answered := census(result.Hops[ttl]); answered == 0 {
+			continue
+		}
+		ttls = append(ttls, ttl)
+		for _, field := range append([]string{control}, fields...) {
+			for i := 0; i < hashVariations; i++ {
+				vary := &variation{field: field, index: i}
+				slots = append(slots, slot{ttl: ttl, id: uint16(len(slots)), vary: vary})
+				probes = append(probes, hashProbe{ttl: ttl, field: field})
+			}
+		}
+	}
+	if len(slots) == 0 {
+		return nil
+	}
+
+	scratch := &results.TracerouteResult{Hops: make(map[uint8]*results.HopResult)}
+	if err := e.round(slots, newCorrelator(e.src, e.target, scratch), scratch); err != nil {
+		return err
+	}
+
+	// Routers answering the probes of each field at each hop
+	routers := make(map[uint8]map[string][]string)
+	for i, hp := range probes {
+		flow := scratch.Hops[hp.ttl].Flows[uint16(i)]
+		if flow.Error != "" || flow.ResponseIP == "" {
+			continue
+		}
+		if routers[hp.ttl] == nil {
+			routers[hp.ttl] = make(map[string][]string)
+		}
+		routers[hp.ttl][hp.field] = append(routers[hp.ttl][hp.field], flow.ResponseIP)
+	}
+
+	result.HashFields = nil
+	changed := make(map[uint8]map[string]bool)
+	for _, ttl := range ttls {
+		changed[ttl] = make(map[string]bool)
+		hf := results.HashFields{TTL: ttl}
+		var seen []string
+		for field, ips := range routers[ttl] {
+			changed[ttl][field] = len(distinct(ips)) > 1
+			seen = append(seen, ips...)
+			hf.Probes += len(ips)
+		}
+		hf.NextHops = distinct(seen)
+
+		if changed[ttl][control] {
+			hf.PerPacket = true
+		} else {
+			for _, field := range fields {
+				switch {
+				case len(routers[ttl][field]) < 2:
+					// Too few answers to tell
+				case !changed[ttl][field]:
+					hf.Ignored = append(hf.Ignored, field)
+				case changed[ttl-1][field]:
+					hf.Inherited = append(hf.Inherited, field)
+				default:
+					hf.Hashed = append(hf.Hashed, field)
+				}
+			}
+		}
+		if !hf.PerPacket && len(hf.Hashed) == 0 && len(hf.Inherited) == 0 {
+			continue
+		}
+
+		switch {
+		case hf.PerPacket:
+			fmt.Printf("TTL=%2d next hop changes between identical probes (per-packet)\n", ttl)
+		case len(hf.Hashed) > 0:
+			fmt.Printf("TTL=%2d hashes on %s\n", ttl, strings.Join(hf.Hashed, ", "))
+		}
+		result.HashFields = append(result.HashFields, hf)
+	}
+	return nil
+}
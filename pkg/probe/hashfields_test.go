@@ -0,0 +1,85 @@
+package probe
+
+import (
+	"net"
+	"testing"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+func TestVariationChangesOneField(t *testing.T) {
+	base := header{src: testSrc, dst: testTarget, id: 77, srcPort: 33434, dstPort: 80, label: 33434}
+	extra := net.ParseIP("192.168.1.11").To4()
+
+	for _, tc := range []struct {
+		field string
+		check func(h header) bool
+	}{
+		{results.FieldSrcPort, func(h header) bool { return h.srcPort == 33437 }},
+		{results.FieldDstPort, func(h header) bool { return h.dstPort == 83 }},
+		{results.FieldIPID, func(h header) bool { return h.id == hashBaseID+3 }},
+		{results.FieldTOS, func(h header) bool { return h.tos == 3<<2 }},
+		{results.FieldFlowLabel, func(h header) bool { return h.label == 33437 }},
+		{results.FieldSrc, func(h header) bool { return h.src.Equal(extra) }},
+	} {
+		h := base
+		(&variation{field: tc.field, index: 3}).apply(&h, []net.IP{extra})
+		if !tc.check(h) {
+			t.Errorf("%s: got %+v", tc.field, h)
+		}
+
+		// Everything else stays as in the control probes
+		ref := base
+		(&variation{field: control, index: 3}).apply(&ref, nil)
+		changed := 0
+		if h.srcPort != ref.srcPort {
+			changed++
+		}
+		if h.dstPort != ref.dstPort {
+			changed++
+		}
+		if h.id != ref.id {
+			changed++
+		}
+		if h.tos != ref.tos {
+			changed++
+		}
+		if h.label != ref.label {
+			changed++
+		}
+		if !h.src.Equal(ref.src) {
+			changed++
+		}
+		if changed != 1 {
+			t.Errorf("%s: %d fields differ from the control probe", tc.field, changed)
+		}
+	}
+}
+
+func TestFixedIPIDDoesNotIdentifyProbes(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{1: {"192.168.1.1"}})
+	p := newTestUDPProbe(conn, 1, 1)
+
+	// Two probes of one flow share the IP ID; only the checksum tells them apart
+	var probes []*sentProbe
+	for i := 0; i < 2; i++ {
+		sp := &sentProbe{flow: &results.FlowResult{}, ttl: 1, dst: testTarget, vary: &variation{field: control}}
+		packet, err := p.craft(sp, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WritePacket(packet, testTarget); err != nil {
+			t.Fatal(err)
+		}
+		probes = append(probes, sp)
+	}
+	if probes[0].flow.IPID != probes[1].flow.IPID || !probes[0].fixedID {
+		t.Fatalf("probes got IP IDs %d and %d, want both fixed", probes[0].flow.IPID, probes[1].flow.IPID)
+	}
+
+	reply := *conn.pending[1] // quotes the second probe
+	reply.InnerID = hashBaseID
+	if probes[0].identifiedBy(&reply) || !probes[1].identifiedBy(&reply) {
+		t.Error("reply must be matched on the checksum, not the shared IP ID")
+	}
+}
@@ -0,0 +1,211 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/internal/platform"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+// echoSignature starts the payload of every Echo Request (Dublin Traceroute signature)
+var echoSignature = []byte{0xDE, 0xAD, 0xBE, 0xEF, 0xCA, 0xFE, 0xBA, 0xBE}
+
+// ICMPProbe represents an ICMP Echo traceroute probe
+// Paris-style: routers that load balance ICMP hash its first bytes, including
+// the checksum, so every probe of a flow keeps the same checksum. Flows differ
+// by Echo identifier; the sequence number tells probes of one flow apart and
+// the last payload word compensates for it in the checksum.
+type ICMPProbe struct {
+	options
+	Identifier uint16 // Echo identifier of flow 0, flow N uses Identifier+N
+	seq        uint16
+}
+
+// NewICMPProbe creates a new ICMP Echo probe instance
+func NewICMPProbe(target string, numPaths uint16, minTTL, maxTTL uint8, probeCount int) (*ICMPProbe, error) {
+	// Verify admin privileges
+	if err := platform.RequireAdmin(); err != nil {
+		return nil, err
+	}
+
+	// Resolve target IP
+	targetIP, err := ResolveTarget(target, false)
+	if err != nil {
+		return nil, err
+	}
+	if targetIP.To4() == nil {
+		return nil, fmt.Errorf("ICMP probes support IPv4 targets only: %s", target)
+	}
+
+	// Get local source IP of the route to the target
+	srcIP, err := localAddressFor(targetIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local IP: %w", err)
+	}
+
+	// Create raw ICMP socket and packet capture for ICMP responses
+	conn, err := NewRawConn(platform.ProtocolICMP, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ICMP probe connection: %w", err)
+	}
+
+	// Like ping, derive the identifier from the process ID so that
+	// concurrent runs do not pick up each other's replies
+	return NewICMPProbeWithConn(conn, targetIP, srcIP, uint16(os.Getpid()), numPaths, minTTL, maxTTL, probeCount), nil
+}
+
+// NewICMPProbeWithConn creates an ICMP probe that sends and receives through conn.
+// No privilege checks or name resolution are performed.
+func NewICMPProbeWithConn(conn PacketConn, target, srcIP net.IP, identifier, numPaths uint16, minTTL, maxTTL uint8, probeCount int) *ICMPProbe {
+	return &ICMPProbe{
+		options:    newOptions(conn, target, srcIP, numPaths, minTTL, maxTTL, probeCount, 3*time.Second),
+		Identifier: identifier,
+	}
+}
+
+// echoPayload returns the signature followed by a word that cancels seq in
+// the one's complement sum: seq + ^seq is always 0xFFFF, so the checksum only
+// depends on the identifier
+func echoPayload(seq uint16) []byte {
+	payload := make([]byte, len(echoSignature)+2)
+	copy(payload, echoSignature)
+	binary.BigEndian.PutUint16(payload[len(echoSignature):], ^seq)
+	return payload
+}
+
+// flowChecksum returns the ICMP checksum shared by all probes of a flow
+func (p *ICMPProbe) flowChecksum(flowID uint16) uint16 {
+	payload := echoPayload(0)
+	msg := make([]byte, 8+len(payload))
+	msg[0] = layers.ICMPv4TypeEchoRequest
+	binary.BigEndian.PutUint16(msg[4:6], p.Identifier+flowID)
+	copy(msg[8:], payload)
+
+	return ^fold(onesSum(msg, 0))
+}
+
+// craftEchoPacket creates a raw ICMP Echo Request with the fields of h, the
+// Echo identifier in h.srcPort, and sequence number seq
+func (p *ICMPProbe) craftEchoPacket(h header, seq uint16) ([]byte, error) {
+	// Create IP layer
+	ip := newIPLayer(h, layers.IPProtocolICMPv4)
+
+	// Create ICMP layer
+	icmp := &layers.ICMPv4{
+		TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+		Id:       h.srcPort,
+		Seq:      seq,
+	}
+
+	// Serialize packet
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+
+	err := gopacket.SerializeLayers(buf, opts, ip, icmp, gopacket.Payload(echoPayload(seq)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize packet: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// craft builds the probe of flowID with the next sequence number. The flow
+// is encoded in the Echo identifier; the IP ID repeats the sequence number so
+// that NAT rewrites of the ID show up in quoted probes.
+func (p *ICMPProbe) craft(sp *sentProbe, flowID uint16) ([]byte, error) {
+	p.seq++
+	h := header{
+		src:     p.SrcIP,
+		dst:     sp.dst,
+		ttl:     sp.ttl,
+		id:      p.seq,
+		srcPort: p.Identifier + flowID,
+	}
+	sp.vary.apply(&h, p.SrcAddrs)
+
+	flow := sp.flow
+	flow.EchoID = h.srcPort
+	flow.Checksum = p.flowChecksum(flowID)
+	flow.IPID = h.id
+
+	sp.protocol = layers.IPProtocolICMPv4
+	sp.src = h.src
+	sp.srcPort = h.srcPort
+	sp.id = p.seq
+	sp.fixedID = h.id != p.seq
+
+	return p.craftEchoPacket(h, p.seq)
+}
+
+// reached reports whether the target answered flow; an Echo Reply always
+// comes from the target
+func (p *ICMPProbe) reached(flow *results.FlowResult) bool {
+	return flow.ICMPType == layers.ICMPv4TypeEchoReply || flow.ResponseIP == p.Target.String()
+}
+
+// Traceroute executes the Dublin Traceroute algorithm with ICMP Echo probes
+func (p *ICMPProbe) Traceroute() (*results.TracerouteResult, error) {
+	result := &results.TracerouteResult{
+		Target:    p.Target.String(),
+		SrcIP:     p.SrcIP.String(),
+		StartTime: p.clock.Now(),
+		Hops:      make(map[uint8]*results.HopResult),
+	}
+
+	fmt.Printf("Dublin Traceroute to %s (%s)\n", p.Target, p.Target)
+	fmt.Printf("Using ICMP Echo identifiers %d-%d, TTL %d-%d\n", p.Identifier, p.Identifier+p.NumPaths-1, p.MinTTL, p.MaxTTL)
+
+	if p.ProbeCount > 1 {
+		fmt.Printf("MTR mode: %d probes per hop for statistical analysis\n", p.ProbeCount)
+	}
+
+	if p.MDA > 0 {
+		fmt.Printf("MDA mode: flows per hop until all next hops are found with %.4g%% confidence (at most %d)\n", p.MDA*100, p.NumPaths)
+	}
+
+	fmt.Println()
+
+	err := p.engine().run(result)
+
+	result.EndTime = p.clock.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	return result, err
+}
+
+// TraceContinuously probes the target a round every interval, MTR style,
+// and hands each round to handle until it returns false or stop is closed.
+// MDA, load-balancer classification, hash-field discovery and split
+// estimates are not run.
+func (p *ICMPProbe) TraceContinuously(interval time.Duration, handle RoundHandler, stop <-chan struct{}) error {
+	return p.engine().runContinuous(interval, handle, stop)
+}
+
+// engine returns the probing engine for this probe's settings
+func (p *ICMPProbe) engine() *engine {
+	return p.options.engine(p, layers.IPProtocolICMPv4)
+}
+
+// Close cleans up resources
+func (p *ICMPProbe) Close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
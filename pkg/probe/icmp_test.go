@@ -0,0 +1,126 @@
+package probe
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+)
+
+func newTestICMPProbe(conn PacketConn, numPaths uint16, maxTTL uint8, probeCount int) *ICMPProbe {
+	p := NewICMPProbeWithConn(conn, testTarget, testSrc, 4242, numPaths, 1, maxTTL, probeCount)
+	p.Delay = 0
+	p.Timeout = 50 * time.Millisecond
+	p.ResolveNames = false
+	return p
+}
+
+func TestICMPTraceroute(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"10.0.1.1", "10.0.2.1"},
+		3: {"8.8.8.8"},
+	})
+	p := newTestICMPProbe(conn, 4, 30, 1)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	if len(result.Hops) != 3 {
+		t.Fatalf("got %d hops, want 3 (trace must stop at the Echo Reply)", len(result.Hops))
+	}
+	for flowID, flow := range result.Hops[3].Flows {
+		if flow.ICMPType != layers.ICMPv4TypeEchoReply {
+			t.Errorf("flow %d: got ICMP type %d from target, want Echo Reply", flowID, flow.ICMPType)
+		}
+		if flow.EchoID != 4242+flowID {
+			t.Errorf("flow %d: got echo id %d", flowID, flow.EchoID)
+		}
+	}
+	if !result.AnalyzeNetwork().HasLoadBalancing {
+		t.Error("expected load balancing across Echo identifiers")
+	}
+}
+
+func TestICMPChecksumConstantPerFlow(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{3: {"8.8.8.8"}})
+	p := newTestICMPProbe(conn, 3, 30, 2)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	checksums := make(map[uint16]uint16) // echo id -> checksum
+	seqs := make(map[uint16]bool)
+	for _, data := range conn.sent {
+		packet := gopacket.NewPacket(data, layers.LayerTypeIPv4, gopacket.Default)
+		echo, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+		if !ok {
+			t.Fatal("probe is not ICMP")
+		}
+		if echo.TypeCode.Type() != layers.ICMPv4TypeEchoRequest {
+			t.Fatalf("got ICMP type %d, want Echo Request", echo.TypeCode.Type())
+		}
+
+		// The serialized checksum must still be valid
+		icmp := data[20:]
+		if sum := binary.BigEndian.Uint16(icmp[2:4]); sum != echo.Checksum {
+			t.Fatalf("checksum mismatch %x != %x", sum, echo.Checksum)
+		}
+
+		if prev, ok := checksums[echo.Id]; ok && prev != echo.Checksum {
+			t.Errorf("flow %d: checksum changed from %#04x to %#04x", echo.Id, prev, echo.Checksum)
+		}
+		checksums[echo.Id] = echo.Checksum
+
+		if seqs[echo.Seq] {
+			t.Errorf("sequence %d reused", echo.Seq)
+		}
+		seqs[echo.Seq] = true
+	}
+
+	if len(checksums) != 3 {
+		t.Fatalf("got %d flows, want 3", len(checksums))
+	}
+	distinct := make(map[uint16]bool)
+	for _, sum := range checksums {
+		distinct[sum] = true
+	}
+	if len(distinct) != 3 {
+		t.Errorf("flows must differ in checksum, got %v", checksums)
+	}
+
+	for _, flow := range result.Hops[1].Flows {
+		if flow.Checksum != checksums[flow.EchoID] {
+			t.Errorf("flow %d: recorded checksum %#04x, sent %#04x", flow.FlowID, flow.Checksum, checksums[flow.EchoID])
+		}
+	}
+}
+
+func TestICMPIgnoresOtherEchoReplies(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{1: {"8.8.8.8"}})
+	conn.pending = append(conn.pending,
+		// Reply to a ping run by another process
+		&capture.Reply{From: testTarget, To: testSrc, ICMPType: layers.ICMPv4TypeEchoReply, EchoID: 1, EchoSeq: 1},
+		// Reply from a different target
+		&capture.Reply{From: testSrc, To: testSrc, ICMPType: layers.ICMPv4TypeTimeExceeded,
+			InnerSrc: testSrc, InnerDst: testSrc, EchoID: 4242, EchoSeq: 1})
+	p := newTestICMPProbe(conn, 1, 30, 1)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	flow := result.Hops[1].Flows[0]
+	if flow.ResponseIP != "8.8.8.8" || flow.ICMPType != layers.ICMPv4TypeEchoReply {
+		t.Errorf("got %s type %d, want Echo Reply from 8.8.8.8", flow.ResponseIP, flow.ICMPType)
+	}
+}
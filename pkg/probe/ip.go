@@ -0,0 +1,154 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/internal/platform"
+)
+
+// ipLayer is an IPv4 or IPv6 header that can also seed transport checksums
+type ipLayer interface {
+	gopacket.NetworkLayer
+	gopacket.SerializableLayer
+}
+
+// ResolveTarget parses or resolves target to a single address. Hostnames
+// with both A and AAAA records resolve to IPv4 unless preferIPv6 is set;
+// the other family is used when the preferred one has no address.
+func ResolveTarget(target string, preferIPv6 bool) (net.IP, error) {
+	ipv4, ipv6, err := lookupTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if (preferIPv6 && ipv6 != nil) || ipv4 == nil {
+		return ipv6, nil
+	}
+	return ipv4, nil
+}
+
+// ResolveDualStack returns both the IPv4 and the IPv6 address of target.
+// It fails unless the target has an address of each family.
+func ResolveDualStack(target string) (net.IP, net.IP, error) {
+	ipv4, ipv6, err := lookupTarget(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ipv4 == nil {
+		return nil, nil, fmt.Errorf("no IPv4 address found for target %s", target)
+	}
+	if ipv6 == nil {
+		return nil, nil, fmt.Errorf("no IPv6 address found for target %s", target)
+	}
+	return ipv4, ipv6, nil
+}
+
+// lookupTarget parses or resolves target and returns its first address of
+// each family; at least one of them is set
+func lookupTarget(target string) (net.IP, net.IP, error) {
+	if ip := net.ParseIP(target); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4, nil, nil
+		}
+		return nil, ip, nil
+	}
+
+	ips, err := net.LookupIP(target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve target %s: %w", target, err)
+	}
+	if len(ips) == 0 {
+		return nil, nil, fmt.Errorf("no IP addresses found for target %s", target)
+	}
+
+	var ipv4, ipv6 net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			if ipv4 == nil {
+				ipv4 = ip.To4()
+			}
+		} else if ipv6 == nil {
+			ipv6 = ip
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+// openRawConn opens a raw connection of the target's address family
+func openRawConn(target net.IP, protocol int) (PacketConn, error) {
+	if target.To4() == nil {
+		return NewRawConn6(protocol, "")
+	}
+	return NewRawConn(protocol, "")
+}
+
+// localAddressFor returns the source address the OS uses to reach target
+func localAddressFor(target net.IP) (net.IP, error) {
+	srcIP, err := platform.GetLocalAddress(target)
+	if err != nil {
+		return nil, err
+	}
+	if (srcIP.To4() == nil) != (target.To4() == nil) {
+		return nil, fmt.Errorf("no local address of the same family as %s", target)
+	}
+	if ip4 := srcIP.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	return srcIP, nil
+}
+
+// flowLabel returns the IPv6 flow label of a flow. It mirrors the source
+// port, so routers hashing either the flow label or the 5-tuple see one
+// stable value per flow and different values across flows.
+func flowLabel(srcPort uint16) uint32 {
+	return uint32(srcPort)
+}
+
+// header holds the fields of a probe that load balancers may hash on
+type header struct {
+	src, dst         net.IP
+	ttl              uint8
+	tos              uint8  // IPv4 TOS or IPv6 traffic class
+	id               uint16 // IPv4 ID
+	label            uint32 // IPv6 flow label
+	srcPort, dstPort uint16 // Echo identifier in srcPort for ICMP
+}
+
+// newIPLayer builds the network header of a probe: IPv4 with the ID of h
+// and Don't Fragment, or IPv6 with the flow label of h, depending on h.dst
+func newIPLayer(h header, protocol layers.IPProtocol) ipLayer {
+	if h.dst.To4() == nil {
+		return &layers.IPv6{
+			Version:      6,
+			TrafficClass: h.tos,
+			FlowLabel:    h.label,
+			NextHeader:   protocol,
+			HopLimit:     h.ttl,
+			SrcIP:        h.src,
+			DstIP:        h.dst,
+		}
+	}
+
+	return &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TOS:      h.tos,
+		Id:       h.id,
+		Flags:    layers.IPv4DontFragment,
+		TTL:      h.ttl,
+		Protocol: protocol,
+		SrcIP:    h.src,
+		DstIP:    h.dst,
+	}
+}
@@ -0,0 +1,52 @@
+package probe
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestResolveTargetLiteral(t *testing.T) {
+	ip, err := ResolveTarget("8.8.8.8", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ip) != net.IPv4len {
+		t.Errorf("IPv4 literal should resolve to a 4-byte address, got %d bytes", len(ip))
+	}
+
+	ip, err = ResolveTarget("2001:4860:4860::8888", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.To4() != nil || !ip.Equal(testTarget6) {
+		t.Errorf("got %s, want the IPv6 literal", ip)
+	}
+}
+
+func TestResolveDualStackLiteral(t *testing.T) {
+	// A literal has a single family, so it cannot be compared
+	if _, _, err := ResolveDualStack("8.8.8.8"); err == nil {
+		t.Error("IPv4 literal has no IPv6 address, expected an error")
+	}
+	if _, _, err := ResolveDualStack("2001:4860:4860::8888"); err == nil {
+		t.Error("IPv6 literal has no IPv4 address, expected an error")
+	}
+}
+
+func TestNewIPLayer(t *testing.T) {
+	h := header{src: testSrc, dst: testTarget, ttl: 5, id: 7, label: 33434}
+	if ip, ok := newIPLayer(h, layers.IPProtocolUDP).(*layers.IPv4); !ok {
+		t.Error("IPv4 destination must produce an IPv4 header")
+	} else if ip.TTL != 5 || ip.Id != 7 {
+		t.Errorf("got TTL %d ID %d", ip.TTL, ip.Id)
+	}
+
+	h.src, h.dst = testSrc6, testTarget6
+	if ip, ok := newIPLayer(h, layers.IPProtocolUDP).(*layers.IPv6); !ok {
+		t.Error("IPv6 destination must produce an IPv6 header")
+	} else if ip.HopLimit != 5 || ip.FlowLabel != 33434 || ip.NextHeader != layers.IPProtocolUDP {
+		t.Errorf("got hop limit %d flow label %d next header %d", ip.HopLimit, ip.FlowLabel, ip.NextHeader)
+	}
+}
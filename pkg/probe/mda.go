@@ -0,0 +1,286 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"math"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+// mdaStoppingPoint returns how many answered flows rule out a (k+1)th next
+// hop once k were seen, with the given confidence (Veitch et al., "Failure
+// control in multipath route tracing"). If a (k+1)th hop existed, each flow
+// would miss it with probability k/(k+1) under uniform load balancing, so n
+// flows must satisfy (k+1) * (k/(k+1))^n <= 1 - confidence. At 95% this is
+// the familiar 6, 11, 16, 21, 27... of the MDA.
+func mdaStoppingPoint(k int, confidence float64) int {
+	if k < 1 {
+		k = 1
+	}
+	alpha := 1 - confidence
+	n := math.Log(alpha/float64(k+1)) / math.Log(float64(k)/float64(k+1))
+	return int(math.Ceil(n - 1e-9))
+}
+
+// mdaConfidence returns the confidence that no next hop was missed after n
+// answered flows found k of them
+func mdaConfidence(k, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	if k < 1 {
+		k = 1
+	}
+	miss := float64(k+1) * math.Pow(float64(k)/float64(k+1), float64(n))
+	return math.Max(0, 1-miss)
+}
+
+// census counts the answered flows of a hop and the routers that answered
+// them
+func census(hop *results.HopResult) (nextHops, answered int) {
+	if hop == nil {
+		return 0, 0
+	}
+	ips := make(map[string]bool)
+	for _, flow := range hop.Flows {
+		if flow.Error != "" || flow.ResponseIP == "" {
+			continue
+		}
+		answered++
+		ips[flow.ResponseIP] = true
+	}
+	return len(ips), answered
+}
+
+// mdaVertex is what the flows through a router of the previous TTL found
+type mdaVertex struct {
+	probed, answered int
+	nextHops         map[string]bool
+}
+
+// predecessors tells which router of the previous TTL each flow crossed:
+// the one that answered it there or, if that TTL has a single router, that
+// one for every flow
+type predecessors struct {
+	prev   *results.HopResult
+	only   string // The single router of the previous TTL, if so
+	probed uint16 // Flows probed at the previous TTL
+	known  bool   // Whether flows not probed there cross a known router too
+}
+
+func newPredecessors(prev *results.HopResult, probed uint16) predecessors {
+	p := predecessors{prev: prev, probed: probed}
+	routers := make(map[string]bool)
+	if prev != nil {
+		for _, flow := range prev.Flows {
+			if flow.Error == "" && flow.ResponseIP != "" {
+				routers[flow.ResponseIP] = true
+				p.only = flow.ResponseIP
+			}
+		}
+	}
+	if len(routers) != 1 {
+		p.only = ""
+	}
+	// Behind no router, or one, every flow crosses the same vertex
+	p.known = len(routers) < 2
+	return p
+}
+
+// of returns the router flowID crossed, "" if it went unanswered there,
+// and whether that is known yet
+func (p predecessors) of(flowID uint16) (string, bool) {
+	if p.prev != nil {
+		if flow := p.prev.Flows[flowID]; flow != nil && flow.Error == "" && flow.ResponseIP != "" {
+			return flow.ResponseIP, true
+		}
+	}
+	if p.known {
+		return p.only, true
+	}
+	return "", flowID < p.probed
+}
+
+// vertices groups the flows probed at hop by the router they crossed at
+// the previous TTL
+func (p predecessors) vertices(hop *results.HopResult, probed uint16) map[string]*mdaVertex {
+	vertices := make(map[string]*mdaVertex)
+	for flowID := uint16(0); flowID < probed; flowID++ {
+		from, ok := p.of(flowID)
+		if !ok {
+			continue
+		}
+		v := vertices[from]
+		if v == nil {
+			v = &mdaVertex{nextHops: make(map[string]bool)}
+			vertices[from] = v
+		}
+		v.probed++
+		if flow := hop.Flows[flowID]; flow != nil && flow.Error == "" && flow.ResponseIP != "" {
+			v.answered++
+			v.nextHops[flow.ResponseIP] = true
+		}
+	}
+	return vertices
+}
+
+// mdaFlows returns how many flows a hop needs in total given what its probed
+// flows found. The stopping rule holds for each router of the previous TTL
+// on its own, over the flows that crossed it: a router that splits flows
+// needs enough of them for its own next hops, however many its neighbours
+// have. Flows are added until the router with the most missing has them,
+// up to the limit. A hop that answered none of its first flows is silent
+// and gets no more, and so are the flows behind a router none of whose
+// flows got an answer.
+func (e *engine) mdaFlows(hop *results.HopResult, probed uint16, prev predecessors) uint16 {
+	first := uint16(mdaStoppingPoint(1, e.mda))
+	if probed == 0 {
+		return minFlows(first, e.numPaths)
+	}
+	if _, answered := census(hop); answered == 0 {
+		return probed
+	}
+
+	// Routers of the previous TTL seen only by flows not probed here yet
+	vertices := prev.vertices(hop, probed)
+	for flowID := probed; flowID < prev.probed; flowID++ {
+		if from, ok := prev.of(flowID); ok && vertices[from] == nil {
+			vertices[from] = &mdaVertex{nextHops: make(map[string]bool)}
+		}
+	}
+
+	want := probed
+	for from, v := range vertices {
+		if v.probed > 0 && v.answered == 0 {
+			continue
+		}
+		missing := mdaStoppingPoint(len(v.nextHops), e.mda) - v.answered
+		if missing <= 0 {
+			continue
+		}
+		if n := e.flowsThrough(from, missing, probed, prev); n > want {
+			want = n
+		}
+	}
+	return minFlows(want, e.numPaths)
+}
+
+// flowsThrough returns how many flows in total bring missing more flows
+// through the router from, counting on the share of the flows it got so far
+// once the flows probed at its TTL run out
+func (e *engine) flowsThrough(from string, missing int, probed uint16, prev predecessors) uint16 {
+	flowID := probed
+	for ; missing > 0 && flowID < e.numPaths; flowID++ {
+		crossed, ok := prev.of(flowID)
+		if !ok {
+			break
+		}
+		if crossed == from {
+			missing--
+		}
+	}
+	if missing <= 0 || flowID >= e.numPaths {
+		return flowID
+	}
+
+	through := 0
+	for id := uint16(0); id < prev.probed; id++ {
+		if crossed, _ := prev.of(id); crossed == from {
+			through++
+		}
+	}
+	more := math.Ceil(float64(missing) * float64(prev.probed) / float64(through))
+	return uint16(math.Min(float64(flowID)+more, float64(e.numPaths)))
+}
+
+// mdaHopConfidence is the confidence that no next hop was missed behind
+// any router of the previous TTL, 0 if no flow got an answer
+func mdaHopConfidence(hop *results.HopResult, probed uint16, prev predecessors) float64 {
+	confidence := 0.0
+	seen := false
+	for _, v := range prev.vertices(hop, probed) {
+		if v.answered == 0 {
+			continue
+		}
+		c := mdaConfidence(len(v.nextHops), v.answered)
+		if !seen || c < confidence {
+			confidence = c
+		}
+		seen = true
+	}
+	return confidence
+}
+
+func minFlows(a, b uint16) uint16 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// predecessors returns the routers the flows of ttl crossed one TTL before
+func (e *engine) predecessors(result *results.TracerouteResult, probed map[uint8]uint16, ttl uint8) predecessors {
+	if ttl == e.minTTL {
+		return newPredecessors(nil, 0)
+	}
+	return newPredecessors(result.Hops[ttl-1], probed[ttl-1])
+}
+
+// runMDA probes each TTL with new flows, round after round, until the
+// stopping rule holds behind every router up to the target, then records
+// on each hop how many flows it took and the confidence reached
+func (e *engine) runMDA(result *results.TracerouteResult) error {
+	replies := newCorrelator(e.src, e.target, result)
+	probed := make(map[uint8]uint16)
+
+	horizon := e.maxTTL
+	for {
+		want := make(map[uint8]uint16)
+		for ttl := e.minTTL; ttl <= horizon && ttl != 0; ttl++ {
+			want[ttl] = e.mdaFlows(result.Hops[ttl], probed[ttl], e.predecessors(result, probed, ttl))
+		}
+		// A flow added behind a router that splits flows must be probed
+		// there too, to tell which of its routers it crossed
+		for ttl := horizon; ttl > e.minTTL; ttl-- {
+			if !e.predecessors(result, probed, ttl).known && want[ttl] > want[ttl-1] {
+				want[ttl-1] = want[ttl]
+			}
+		}
+
+		var slots []slot
+		for ttl := e.minTTL; ttl <= horizon && ttl != 0; ttl++ {
+			for flowID := probed[ttl]; flowID < want[ttl]; flowID++ {
+				slots = append(slots, slot{ttl: ttl, flowID: flowID, id: flowID})
+			}
+			probed[ttl] = want[ttl]
+		}
+		if len(slots) == 0 {
+			break
+		}
+
+		if err := e.round(slots, replies, result); err != nil {
+			return err
+		}
+		if dest := destinationTTL(result, e.reached); dest != 0 {
+			horizon = dest
+		}
+	}
+
+	dest := destinationTTL(result, e.reached)
+	e.trim(result, dest)
+	for _, hop := range result.Hops {
+		hop.MDAFlows = int(probed[hop.TTL])
+		hop.MDAConfidence = mdaHopConfidence(hop, probed[hop.TTL], e.predecessors(result, probed, hop.TTL))
+	}
+	if e.resolveNames {
+		resolveHostnames(result)
+	}
+	e.print(result, dest)
+	return nil
+}
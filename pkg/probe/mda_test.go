@@ -0,0 +1,124 @@
+package probe
+
+import (
+	"testing"
+)
+
+func TestMDAStoppingPoints(t *testing.T) {
+	// Published MDA tables for 95% and 99% confidence
+	tables := map[float64][]int{
+		0.95: {6, 11, 16, 21, 27},
+		0.99: {8, 15, 21, 28, 36},
+	}
+	for confidence, want := range tables {
+		for i, n := range want {
+			k := i + 1
+			if got := mdaStoppingPoint(k, confidence); got != n {
+				t.Errorf("n_%d at %.0f%%: got %d, want %d", k, confidence*100, got, n)
+			}
+			if c := mdaConfidence(k, n); c < confidence {
+				t.Errorf("n_%d at %.0f%%: %d flows only give %.4f", k, confidence*100, n, c)
+			}
+			if c := mdaConfidence(k, n-1); c >= confidence {
+				t.Errorf("n_%d at %.0f%%: %d flows already give %.4f", k, confidence*100, n-1, c)
+			}
+		}
+	}
+}
+
+func TestMDAAddsFlowsUntilConfident(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"},
+		3: {},
+		4: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 64, 10)
+	p.SetMDA(0.95)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	want := map[uint8]int{1: 6, 2: 21, 3: 6, 4: 6}
+	for ttl, flows := range want {
+		if got := result.Hops[ttl].MDAFlows; got != flows {
+			t.Errorf("TTL %d: %d flows, want %d", ttl, got, flows)
+		}
+	}
+	if c := result.Hops[2].MDAConfidence; c < 0.95 {
+		t.Errorf("TTL 2: confidence %.3f, want at least 0.95", c)
+	}
+	if c := result.Hops[3].MDAConfidence; c != 0 {
+		t.Errorf("silent TTL 3: confidence %.3f, want 0", c)
+	}
+	if len(result.Hops) != 4 {
+		t.Errorf("got %d hops, want the hops past the target dropped", len(result.Hops))
+	}
+
+	analysis := result.AnalyzeNetwork()
+	if len(analysis.LoadBalancingHops) != 1 || analysis.LoadBalancingHops[0] != 2 {
+		t.Errorf("got load balancing hops %v, want [2]", analysis.LoadBalancingHops)
+	}
+}
+
+func TestMDAStopsAtFlowLimit(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"},
+		2: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 10, 5)
+	p.SetMDA(0.99)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	hop := result.Hops[1]
+	if hop.MDAFlows != 10 || len(hop.Flows) != 10 {
+		t.Errorf("TTL 1: %d flows recorded, %d probed, want the limit of 10", hop.MDAFlows, len(hop.Flows))
+	}
+	if hop.MDAConfidence >= 0.99 {
+		t.Errorf("TTL 1: confidence %.3f, want below the 99%% asked for", hop.MDAConfidence)
+	}
+}
+
+func TestMDAAppliesStoppingRulePerRouter(t *testing.T) {
+	// Two balancers side by side: the even flows cross 192.168.1.1 to a
+	// single next hop, the odd ones 192.168.1.2 to four
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1", "192.168.1.2"},
+		2: {"10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.3", "10.0.0.1", "10.0.0.4", "10.0.0.1", "10.0.0.5"},
+		3: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 128, 10)
+	p.SetMDA(0.95)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	// Counting the five routers of TTL 2 together would stop at 27 flows,
+	// of which about 13 cross 192.168.1.2, short of the 21 its four need
+	through := 0
+	for id, flow := range result.Hops[2].Flows {
+		if result.Hops[1].Flows[id].ResponseIP == "192.168.1.2" && flow.ResponseIP != "" {
+			through++
+		}
+	}
+	if through < mdaStoppingPoint(4, 0.95) {
+		t.Errorf("TTL 2: %d answered flows behind 192.168.1.2, want at least %d", through, mdaStoppingPoint(4, 0.95))
+	}
+	for ttl := uint8(1); ttl <= 3; ttl++ {
+		hop := result.Hops[ttl]
+		if hop.MDAConfidence < 0.95 {
+			t.Errorf("TTL %d: confidence %.3f after %d flows, want at least 0.95", ttl, hop.MDAConfidence, hop.MDAFlows)
+		}
+		if ttl > 1 && len(hop.Flows) > len(result.Hops[ttl-1].Flows) {
+			t.Errorf("TTL %d: %d flows, more than the %d that crossed TTL %d", ttl, len(hop.Flows), len(result.Hops[ttl-1].Flows), ttl-1)
+		}
+	}
+}
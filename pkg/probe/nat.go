@@ -0,0 +1,111 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+// Header fields reported in FlowResult.NATRewritten
+const (
+	natFieldIPID     = "ip_id"
+	natFieldChecksum = "checksum"
+)
+
+// onesSum adds data to a running one's complement sum
+func onesSum(data []byte, sum uint32) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// fold reduces a one's complement sum to 16 bits
+func fold(sum uint32) uint16 {
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return uint16(sum)
+}
+
+// tunedUDPPayload returns the probe payload: the signature followed by a
+// word chosen so that the UDP checksum equals id. As in the original
+// dublin-traceroute, the IPv4 ID carries the same value; a NAT that rewrites
+// addresses or ports changes the checksum but not the ID, which shows up in
+// the probe quoted back by later hops.
+func tunedUDPPayload(src, dst net.IP, srcPort, dstPort, id uint16) []byte {
+	payload := make([]byte, len(echoSignature)+2)
+	copy(payload, echoSignature)
+	length := uint16(8 + len(payload))
+
+	// Pseudo-header; the IPv4 and IPv6 layouts sum to the same value
+	var sum uint32
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		sum = onesSum(src4, sum)
+		sum = onesSum(dst4, sum)
+	} else {
+		sum = onesSum(src.To16(), sum)
+		sum = onesSum(dst.To16(), sum)
+	}
+	sum += 17 + uint32(length)
+
+	// UDP header with a zero checksum, then the payload without the tune word
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:2], srcPort)
+	binary.BigEndian.PutUint16(header[2:4], dstPort)
+	binary.BigEndian.PutUint16(header[4:6], length)
+	sum = onesSum(header, sum)
+	sum = onesSum(payload, sum)
+
+	// The checksum is ^(sum + tune), so the tune word is ^id - sum
+	tune := fold(uint32(^id) + uint32(^fold(sum)))
+	binary.BigEndian.PutUint16(payload[len(echoSignature):], tune)
+	return payload
+}
+
+// natID returns the one's complement difference between the checksum sent
+// and the one quoted back. Address translation adds a constant to the
+// checksum sum, so all flows crossing the same NATs share this value.
+func natID(sent, quoted uint16) uint16 {
+	id := fold(uint32(sent) + uint32(^quoted))
+	if id == 0xffff {
+		return 0 // negative zero: unchanged
+	}
+	return id
+}
+
+// recordNAT stores the quoted IP ID and checksum of reply on flow and notes
+// which of them differ from the probe that was sent (flow.IPID and
+// flow.Checksum)
+func recordNAT(flow *results.FlowResult, reply *capture.Reply) {
+	if reply.InnerSrc == nil {
+		return // no quoted probe, e.g. an Echo Reply
+	}
+
+	if !reply.IPv6 && flow.IPID != 0 {
+		flow.QuotedIPID = reply.InnerID
+		if reply.InnerID != flow.IPID {
+			flow.NATRewritten = append(flow.NATRewritten, natFieldIPID)
+		}
+	}
+
+	if flow.Checksum != 0 && reply.InnerChecksum != 0 {
+		flow.QuotedChecksum = reply.InnerChecksum
+		flow.NATID = natID(flow.Checksum, reply.InnerChecksum)
+		if reply.InnerChecksum != flow.Checksum {
+			flow.NATRewritten = append(flow.NATRewritten, natFieldChecksum)
+		}
+	}
+}
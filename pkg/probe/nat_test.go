@@ -0,0 +1,75 @@
+package probe
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+func TestUDPChecksumEqualsID(t *testing.T) {
+	for _, family := range []struct {
+		name     string
+		src, dst net.IP
+		layer    gopacket.LayerType
+	}{
+		{"IPv4", testSrc, testTarget, layers.LayerTypeIPv4},
+		{"IPv6", testSrc6, testTarget6, layers.LayerTypeIPv6},
+	} {
+		p := NewUDPProbeWithConn(newFakeConn(nil), family.dst, family.src, 33434, 33434, 8, 1, 30, 1)
+		for _, id := range []uint16{1, 2, 0x1234, 0x8000, 0xfffe} {
+			h := header{src: family.src, dst: family.dst, ttl: 7, id: id, srcPort: 33434 + id%8, dstPort: 33434}
+			packet, err := p.craftUDPPacket(h, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := gopacket.NewPacket(packet, family.layer, gopacket.Default)
+			udp, ok := decoded.Layer(layers.LayerTypeUDP).(*layers.UDP)
+			if !ok {
+				t.Fatalf("%s: probe has no UDP layer", family.name)
+			}
+			if udp.Checksum != id {
+				t.Errorf("%s: got checksum %#04x, want the probe ID %#04x", family.name, udp.Checksum, id)
+			}
+			if ip, ok := decoded.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok && ip.Id != id {
+				t.Errorf("got IP ID %#04x, want %#04x", ip.Id, id)
+			}
+		}
+	}
+}
+
+func TestRecordNAT(t *testing.T) {
+	// Unchanged quote: no NAT
+	flow := &results.FlowResult{IPID: 0x1234, Checksum: 0x1234}
+	recordNAT(flow, &capture.Reply{InnerSrc: testSrc, InnerID: 0x1234, InnerChecksum: 0x1234})
+	if flow.NATID != 0 || flow.NATRewritten != nil {
+		t.Errorf("got NAT ID %d rewritten %v for an unchanged probe", flow.NATID, flow.NATRewritten)
+	}
+
+	// Address translation shifts the checksum by the same amount for any probe
+	a := &results.FlowResult{IPID: 0x1000, Checksum: 0x1000}
+	b := &results.FlowResult{IPID: 0x2000, Checksum: 0x2000}
+	recordNAT(a, &capture.Reply{InnerSrc: testSrc, InnerID: 0x9000, InnerChecksum: 0x0f00})
+	recordNAT(b, &capture.Reply{InnerSrc: testSrc, InnerID: 0x2000, InnerChecksum: 0x1f00})
+	if a.NATID == 0 || a.NATID != b.NATID {
+		t.Errorf("got NAT IDs %d and %d, want the same non-zero value", a.NATID, b.NATID)
+	}
+	if !reflect.DeepEqual(a.NATRewritten, []string{"ip_id", "checksum"}) {
+		t.Errorf("got rewritten %v", a.NATRewritten)
+	}
+	if !reflect.DeepEqual(b.NATRewritten, []string{"checksum"}) {
+		t.Errorf("got rewritten %v", b.NATRewritten)
+	}
+
+	// Echo Replies quote nothing
+	echo := &results.FlowResult{IPID: 5, Checksum: 0xabcd}
+	recordNAT(echo, &capture.Reply{EchoID: 1, EchoSeq: 5})
+	if echo.QuotedChecksum != 0 || echo.NATRewritten != nil {
+		t.Error("a reply without a quoted probe must not record NAT fields")
+	}
+}
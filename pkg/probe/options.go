@@ -0,0 +1,119 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// options are the settings the UDP, TCP and ICMP probes share; each probe
+// embeds them and adds the fields of its protocol
+type options struct {
+	Target       net.IP
+	SrcIP        net.IP
+	NumPaths     uint16
+	MinTTL       uint8
+	MaxTTL       uint8
+	ProbeCount   int     // Number of probes per hop for MTR-style statistics
+	MDA          float64 // MDA confidence (e.g. 0.95), 0 for a fixed NumPaths
+	Delay        time.Duration
+	Timeout      time.Duration
+	ResolveNames bool     // Reverse-resolve responding hops
+	ClassifyLB   bool     // Re-probe load-balancing hops to classify them
+	HashFields   bool     // Vary header fields one at a time to find what balancers hash on
+	SrcAddrs     []net.IP // Extra local addresses tried as source by HashFields
+	SplitFlows   uint16   // Flows sent through each load-balancing hop to estimate its split, 0 to skip
+	conn         PacketConn
+	clock        Clock
+}
+
+// newOptions returns the defaults of a probe sending through conn
+func newOptions(conn PacketConn, target, srcIP net.IP, numPaths uint16, minTTL, maxTTL uint8, probeCount int, timeout time.Duration) options {
+	return options{
+		Target:       target,
+		SrcIP:        srcIP,
+		NumPaths:     numPaths,
+		MinTTL:       minTTL,
+		MaxTTL:       maxTTL,
+		ProbeCount:   probeCount,
+		Delay:        10 * time.Millisecond,
+		Timeout:      timeout,
+		ResolveNames: true,
+		conn:         conn,
+		clock:        realClock{},
+	}
+}
+
+// engine returns the probing engine for these settings, crafting probes
+// of protocol with p
+func (o *options) engine(p prober, protocol layers.IPProtocol) *engine {
+	return &engine{
+		prober:       p,
+		conn:         o.conn,
+		clock:        o.clock,
+		src:          o.SrcIP,
+		target:       o.Target,
+		numPaths:     o.NumPaths,
+		minTTL:       o.MinTTL,
+		maxTTL:       o.MaxTTL,
+		probeCount:   o.ProbeCount,
+		mda:          o.MDA,
+		classifyLB:   o.ClassifyLB,
+		hashFields:   o.HashFields,
+		splitFlows:   o.SplitFlows,
+		protocol:     protocol,
+		sources:      o.SrcAddrs,
+		delay:        o.Delay,
+		timeout:      o.Timeout,
+		resolveNames: o.ResolveNames,
+	}
+}
+
+// SetDelay sets the delay between probe packets
+func (o *options) SetDelay(delay time.Duration) {
+	o.Delay = delay
+}
+
+// SetClock replaces the time source, e.g. with a simulator's virtual clock
+func (o *options) SetClock(clock Clock) {
+	o.clock = clock
+}
+
+// SetTimeout sets how long a round waits for replies after its last probe
+func (o *options) SetTimeout(timeout time.Duration) {
+	o.Timeout = timeout
+}
+
+// SetMDA enables the Multipath Detection Algorithm: each router gets flows
+// until all its next hops are found with the given confidence, up to
+// NumPaths per hop
+func (o *options) SetMDA(confidence float64) {
+	o.MDA = confidence
+}
+
+// SetClassifyLB enables re-probing of load-balancing hops to tell per-flow,
+// per-packet and per-destination balancing apart
+func (o *options) SetClassifyLB(classify bool) {
+	o.ClassifyLB = classify
+}
+
+// SetECMPSplit sends the given number of flows through each load-balancing
+// hop after the trace to estimate the share of flows each next hop gets
+func (o *options) SetECMPSplit(flows uint16) {
+	o.SplitFlows = flows
+}
+
+// SetHashFields enables hash-field discovery; sources are extra local
+// addresses to try as the source address, if any
+func (o *options) SetHashFields(enable bool, sources []net.IP) {
+	o.HashFields = enable
+	o.SrcAddrs = sources
+}
@@ -0,0 +1,71 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package probe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
+)
+
+// estimateSplits sends splitFlows flows through every hop with several next
+// hops before the target and records the share of flows each next hop
+// answered, with confidence intervals
+func (e *engine) estimateSplits(result *results.TracerouteResult) error {
+	last := e.maxTTL
+	if dest := destinationTTL(result, e.reached); dest != 0 {
+		last = dest - 1
+	}
+
+	var slots []slot
+	var ttls []uint8
+	for ttl := e.minTTL; ttl <= last && ttl != 0; ttl++ {
+		if nextHops, _ := census(result.Hops[ttl]); nextHops < 2 {
+			continue
+		}
+		ttls = append(ttls, ttl)
+		for flowID := uint16(0); flowID < e.splitFlows; flowID++ {
+			slots = append(slots, slot{ttl: ttl, flowID: flowID, id: flowID})
+		}
+	}
+	if len(slots) == 0 {
+		return nil
+	}
+
+	scratch := &results.TracerouteResult{Hops: make(map[uint8]*results.HopResult)}
+	if err := e.round(slots, newCorrelator(e.src, e.target, scratch), scratch); err != nil {
+		return err
+	}
+
+	result.ECMPSplits = nil
+	for _, ttl := range ttls {
+		flows := make(map[string]int)
+		for _, flow := range scratch.Hops[ttl].Flows {
+			if flow.Error == "" && flow.ResponseIP != "" {
+				flows[flow.ResponseIP]++
+			}
+		}
+		if len(flows) == 0 {
+			continue
+		}
+
+		split := results.NewECMPSplit(ttl, flows)
+		shares := make([]string, 0, len(split.Shares))
+		for _, share := range split.Shares {
+			shares = append(shares, fmt.Sprintf("%s %.0f%%", share.IP, share.Fraction*100))
+		}
+		verdict := ""
+		if split.Unequal {
+			verdict = " (unequal)"
+		}
+		fmt.Printf("TTL=%2d splits %d flows: %s%s\n", ttl, split.Flows, strings.Join(shares, ", "), verdict)
+		result.ECMPSplits = append(result.ECMPSplits, split)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package probe
+
+import "testing"
+
+func TestEstimateSplits(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		3: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 4, 10)
+	p.SetECMPSplit(30)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	if len(result.ECMPSplits) != 1 {
+		t.Fatalf("got splits %+v, want one at TTL 2", result.ECMPSplits)
+	}
+	split := result.ECMPSplits[0]
+	if split.TTL != 2 || split.Flows != 30 || len(split.Shares) != 3 || split.Unequal {
+		t.Errorf("got %+v, want an even split of 30 flows over 3 next hops at TTL 2", split)
+	}
+	for _, share := range split.Shares {
+		if share.Flows != 10 {
+			t.Errorf("%s got %d flows, want 10", share.IP, share.Flows)
+		}
+	}
+	if len(result.Hops[2].Flows) != 4 {
+		t.Errorf("got %d flows at TTL 2, want the split probes kept out of the trace", len(result.Hops[2].Flows))
+	}
+}
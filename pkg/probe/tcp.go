@@ -1,5 +1,3 @@
-// +build windows
-
 /* SPDX-License-Identifier: BSD-2-Clause */
 
 //
@@ -16,28 +14,19 @@ import (
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"golang.org/x/sys/windows"
 
 	"github.com/atlanticbb/dublin-traceroute-windows/internal/platform"
-	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
 	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
 )
 
-// TCPProbe represents a TCP-based traceroute probe for Windows
+// TCPProbe represents a TCP-based traceroute probe
 // Uses TCP SYN packets instead of UDP for better firewall traversal
 type TCPProbe struct {
-	Target     net.IP
-	SrcIP      net.IP
-	SrcPort    uint16
-	DstPort    uint16  // Target port (80, 443, etc.)
-	NumPaths   uint16
-	MinTTL     uint8
-	MaxTTL     uint8
-	ProbeCount int  // Number of probes per hop for MTR-style statistics
-	Delay      time.Duration
-	Timeout    time.Duration
-	socket     int
-	capture    *capture.WindowsCapture
+	options
+	SrcPort      uint16
+	DstPort      uint16     // Target port (80, 443, etc.)
+	FlowStrategy string     // Where flows are encoded: FlowSrcPort (default), FlowDstPort, FlowParis or FlowIPID
+	ids          idSequence // IP ID and sequence number of each probe
 }
 
 // NewTCPProbe creates a new TCP probe instance
@@ -47,107 +36,67 @@ func NewTCPProbe(target string, srcPort, dstPort, numPaths uint16, minTTL, maxTT
 		return nil, err
 	}
 
-	// Resolve target IP
-	targetIP := net.ParseIP(target)
-	if targetIP == nil {
-		ips, err := net.LookupIP(target)
-		if err != nil {
-			return nil, fmt.Errorf("failed to resolve target %s: %w", target, err)
-		}
-		if len(ips) == 0 {
-			return nil, fmt.Errorf("no IP addresses found for %s", target)
-		}
-		// Prefer IPv4
-		for _, ip := range ips {
-			if ip.To4() != nil {
-				targetIP = ip
-				break
-			}
-		}
-		if targetIP == nil {
-			targetIP = ips[0]
-		}
-	}
-
-	// Only support IPv4 for now
-	if targetIP.To4() == nil {
-		return nil, fmt.Errorf("IPv6 not supported yet")
+	// Resolve target IP (IPv4 unless only IPv6 is available)
+	targetIP, err := ResolveTarget(target, false)
+	if err != nil {
+		return nil, err
 	}
 
-	// Get local IP
-	srcIPStr, err := platform.GetLocalIPv4Address()
+	// Get local IP of the route to the target
+	srcIP, err := localAddressFor(targetIP)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get local IP: %w", err)
 	}
-	srcIP := net.ParseIP(srcIPStr)
 
-	// Create raw socket
-	sock, err := platform.CreateUDPSocket()
+	// Create raw socket and packet capture for ICMP responses
+	conn, err := openRawConn(targetIP, platform.ProtocolTCP)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create raw socket: %w", err)
+		return nil, fmt.Errorf("failed to open TCP probe connection: %w", err)
 	}
 
-	probe := &TCPProbe{
-		Target:     targetIP,
-		SrcIP:      srcIP,
-		SrcPort:    srcPort,
-		DstPort:    dstPort,
-		NumPaths:   numPaths,
-		MinTTL:     minTTL,
-		MaxTTL:     maxTTL,
-		ProbeCount: probeCount,
-		Delay:      10 * time.Millisecond,
-		Timeout:    1 * time.Second,  // Shorter timeout for TCP (more responsive)
-		socket:     sock,
-	}
-
-	return probe, nil
+	return NewTCPProbeWithConn(conn, targetIP, srcIP, srcPort, dstPort, numPaths, minTTL, maxTTL, probeCount), nil
 }
 
-// SetTimeout sets the probe timeout
-func (p *TCPProbe) SetTimeout(timeout time.Duration) {
-	p.Timeout = timeout
+// NewTCPProbeWithConn creates a TCP probe that sends and receives through conn.
+// No privilege checks or name resolution are performed.
+func NewTCPProbeWithConn(conn PacketConn, target, srcIP net.IP, srcPort, dstPort, numPaths uint16, minTTL, maxTTL uint8, probeCount int) *TCPProbe {
+	// Shorter timeout for TCP (more responsive)
+	return &TCPProbe{
+		options: newOptions(conn, target, srcIP, numPaths, minTTL, maxTTL, probeCount, time.Second),
+		SrcPort: srcPort,
+		DstPort: dstPort,
+	}
 }
 
-// SetDelay sets the delay between probes
-func (p *TCPProbe) SetDelay(delay time.Duration) {
-	p.Delay = delay
+// SetFlowStrategy selects where flows are encoded. The sequence number
+// identifies each probe whatever the strategy.
+func (p *TCPProbe) SetFlowStrategy(name string) error {
+	if _, err := lookupFlowStrategy(name); err != nil {
+		return err
+	}
+	p.FlowStrategy = name
+	return nil
 }
 
 // Close cleans up resources
 func (p *TCPProbe) Close() error {
-	if p.capture != nil {
-		p.capture.Close()
-	}
-	if p.socket != 0 {
-		return platform.CloseSocket(p.socket)
+	if p.conn != nil {
+		return p.conn.Close()
 	}
 	return nil
 }
 
-// craftTCPPacket creates a TCP SYN packet with specified parameters
-func (p *TCPProbe) craftTCPPacket(ttl uint8, flowID uint16) ([]byte, error) {
+// craftTCPPacket creates a TCP SYN packet with the fields of h and sequence
+// number seq, which ICMP errors quote back
+func (p *TCPProbe) craftTCPPacket(h header, seq uint32) ([]byte, error) {
 	// Create IP layer
-	ip := &layers.IPv4{
-		Version:  4,
-		IHL:      5,
-		TOS:      0,
-		Id:       uint16(time.Now().Unix() & 0xFFFF),
-		Flags:    layers.IPv4DontFragment,
-		TTL:      ttl,
-		Protocol: layers.IPProtocolTCP,
-		SrcIP:    p.SrcIP,
-		DstIP:    p.Target,
-	}
-
-	// Encode flow ID in source port (Dublin Traceroute style)
-	srcPort := p.SrcPort + flowID
+	ip := newIPLayer(h, layers.IPProtocolTCP)
 
 	// Create TCP layer (SYN packet)
 	tcp := &layers.TCP{
-		SrcPort: layers.TCPPort(srcPort),
-		DstPort: layers.TCPPort(p.DstPort),
-		Seq:     uint32(time.Now().Unix()),
+		SrcPort: layers.TCPPort(h.srcPort),
+		DstPort: layers.TCPPort(h.dstPort),
+		Seq:     seq,
 		SYN:     true,
 		Window:  65535,
 	}
@@ -169,160 +118,118 @@ func (p *TCPProbe) craftTCPPacket(ttl uint8, flowID uint16) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// sendProbe sends a single TCP SYN probe
-func (p *TCPProbe) sendProbe(ttl uint8, flowID uint16) error {
-	packet, err := p.craftTCPPacket(ttl, flowID)
+// craft builds the probe of flowID. The flow strategy places the flow, by
+// default in the source port (Dublin Traceroute style) and for IPv6 the flow
+// label; the probe identifier goes in the IPv4 ID and the sequence number.
+func (p *TCPProbe) craft(sp *sentProbe, flowID uint16) ([]byte, error) {
+	strategy, err := lookupFlowStrategy(p.FlowStrategy)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Create destination sockaddr
-	dest := &windows.SockaddrInet4{
-		Port: int(p.DstPort),
+	id := p.ids.next()
+	h := header{
+		src:     p.SrcIP,
+		dst:     sp.dst,
+		ttl:     sp.ttl,
+		srcPort: p.SrcPort,
+		dstPort: p.DstPort,
 	}
-	copy(dest.Addr[:], p.Target.To4())
+	strategy.encode(&h, flowID, id)
+	sp.vary.apply(&h, p.SrcAddrs)
 
-	// Send packet
-	err = platform.SendPacket(p.socket, packet, dest)
-	if err != nil {
-		return fmt.Errorf("failed to send TCP probe: %w", err)
+	flow := sp.flow
+	flow.SrcPort = h.srcPort
+	flow.DstPort = h.dstPort
+	if h.dst.To4() == nil {
+		flow.FlowLabel = h.label
 	}
 
-	return nil
+	sp.protocol = layers.IPProtocolTCP
+	sp.src = h.src
+	sp.srcPort = h.srcPort
+	sp.dstPort = h.dstPort
+	sp.id = id
+	sp.fixedID = h.id != id
+	sp.seq = uint32(id)
+
+	return p.craftTCPPacket(h, sp.seq)
 }
 
-// Traceroute performs TCP-based multipath traceroute
-func (p *TCPProbe) Traceroute() (*results.TracerouteResult, error) {
-	// Initialize packet capture
-	cap, err := capture.NewCapture("", 3*time.Second)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize capture: %w", err)
+// reached reports whether the target itself answered flow, with a SYN-ACK,
+// a RST or an ICMP error
+func (p *TCPProbe) reached(flow *results.FlowResult) bool {
+	return flow.ResponseIP == p.Target.String()
+}
+
+// portState summarizes the answers of the target to the SYN probes: one
+// SYN-ACK means the port is open
+func portState(result *results.TracerouteResult) string {
+	state := results.PortFiltered
+	for _, hop := range result.Hops {
+		for _, flow := range hop.Flows {
+			switch flow.TCPState {
+			case results.PortOpen:
+				return results.PortOpen
+			case results.PortClosed:
+				state = results.PortClosed
+			}
+		}
 	}
-	p.capture = cap
-	defer cap.Close()
+	return state
+}
 
+// Traceroute performs TCP-based multipath traceroute
+func (p *TCPProbe) Traceroute() (*results.TracerouteResult, error) {
 	result := &results.TracerouteResult{
 		Target:    p.Target.String(),
 		SrcIP:     p.SrcIP.String(),
-		StartTime: time.Now(),
+		StartTime: p.clock.Now(),
 		Hops:      make(map[uint8]*results.HopResult),
 	}
 
+	strategy, err := lookupFlowStrategy(p.FlowStrategy)
+	if err != nil {
+		return nil, err
+	}
+
 	fmt.Printf("\nDublin Traceroute (TCP) to %s (%s)\n", p.Target, p.Target)
-	fmt.Printf("Using TCP SYN to port %d, source ports %d-%d, TTL %d-%d\n", 
-		p.DstPort, p.SrcPort, p.SrcPort+p.NumPaths-1, p.MinTTL, p.MaxTTL)
-	fmt.Printf("Timeout per probe: %v\n", p.Timeout)
-	
+	fmt.Printf("Using TCP SYN from %s, TTL %d-%d\n",
+		strategy.describe(p.SrcPort, p.DstPort, p.NumPaths), p.MinTTL, p.MaxTTL)
+	fmt.Printf("Timeout after the last probe of a round: %v\n", p.Timeout)
+
 	if p.ProbeCount > 1 {
 		fmt.Printf("MTR mode: %d probes per hop for statistical analysis\n", p.ProbeCount)
 	}
-	
-	fmt.Println()
 
-	reachedTarget := false
+	if p.MDA > 0 {
+		fmt.Printf("MDA mode: flows per hop until all next hops are found with %.4g%% confidence (at most %d)\n", p.MDA*100, p.NumPaths)
+	}
 
-	// Send probes for each TTL
-	for ttl := p.MinTTL; ttl <= p.MaxTTL && !reachedTarget; ttl++ {
-		hopResult := &results.HopResult{
-			TTL:   ttl,
-			Flows: make(map[uint16]*results.FlowResult),
-		}
+	fmt.Println()
 
-		// Perform multiple probe rounds if ProbeCount > 1 (MTR mode)
-		for round := 0; round < p.ProbeCount; round++ {
-			// Send probe for each flow
-			for flowID := uint16(0); flowID < p.NumPaths; flowID++ {
-				// Calculate unique flow key for this round
-				uniqueFlowID := flowID + uint16(round)*p.NumPaths
-				srcPort := p.SrcPort + flowID
-				
-				flowResult := &results.FlowResult{
-					FlowID:   uniqueFlowID,
-					SrcPort:  srcPort,
-					DstPort:  p.DstPort,
-					SentTime: time.Now(),
-				}
-
-				// Send probe
-				sendErr := p.sendProbe(ttl, flowID)
-				if sendErr != nil {
-					if round == 0 {
-						fmt.Printf("TTL=%2d Flow=%2d: Send failed: %v\n", ttl, flowID, sendErr)
-					}
-					flowResult.Error = sendErr.Error()
-					hopResult.Flows[uniqueFlowID] = flowResult
-					continue
-				}
-
-				// Wait for ICMP response
-				packet, srcIP, err := cap.CaptureICMPResponse(p.SrcIP, p.Target, 0)
-				
-				if err == nil && packet != nil && srcIP != nil {
-					flowResult.RecvTime = time.Now()
-					flowResult.RTT = flowResult.RecvTime.Sub(flowResult.SentTime)
-					flowResult.ResponseIP = srcIP.String()
-					
-					// Only lookup hostname on first round to avoid delays
-					if round == 0 {
-						flowResult.Hostname = p.lookupHostname(srcIP)
-					}
-					
-					hopResult.Flows[uniqueFlowID] = flowResult
-
-					// Print result (only first round in MTR mode for cleaner output)
-					if round == 0 {
-						fmt.Printf("TTL=%2d Flow=%2d: %s (%s) %.4fms\n",
-							ttl, flowID, flowResult.ResponseIP, flowResult.Hostname, flowResult.RTT.Seconds()*1000)
-					}
-
-					// Check if we reached the target
-					if srcIP.Equal(p.Target) {
-						reachedTarget = true
-					}
-				} else {
-					// Timeout or no response
-					flowResult.Error = "timeout"
-					hopResult.Flows[uniqueFlowID] = flowResult
-					if round == 0 {
-						fmt.Printf("TTL=%2d Flow=%2d: *\n", ttl, flowID)
-					}
-				}
-
-				time.Sleep(p.Delay)
-			}
-		}
+	err = p.engine().run(result)
+	result.PortState = portState(result)
+	fmt.Printf("Port %d on %s: %s\n", p.DstPort, p.Target, result.PortState)
 
-		if len(hopResult.Flows) > 0 {
-			result.Hops[ttl] = hopResult
-		}
-	}
-
-	result.EndTime = time.Now()
+	result.EndTime = p.clock.Now()
 	result.Duration = result.EndTime.Sub(result.StartTime)
 
-	if reachedTarget {
-		fmt.Printf("\nReached target %s\n", p.Target)
-	}
-
-	return result, nil
+	return result, err
 }
 
-// captureTCPResponse attempts to capture TCP SYN-ACK or RST from target
-func (p *TCPProbe) captureTCPResponse(srcPort uint16, timeout time.Duration) (net.IP, time.Duration) {
-	// This would require capturing TCP packets in addition to ICMP
-	// For now, return nil - can be enhanced later
-	// Full implementation would listen for TCP packets with:
-	// - Source: target IP, port p.DstPort
-	// - Dest: source IP, port srcPort
-	// - Flags: SYN-ACK or RST
-	return nil, 0
+// TraceContinuously probes the target a round every interval, MTR style,
+// and hands each round to handle until it returns false or stop is closed.
+// MDA, load-balancer classification, hash-field discovery and split
+// estimates are not run.
+func (p *TCPProbe) TraceContinuously(interval time.Duration, handle RoundHandler, stop <-chan struct{}) error {
+	if _, err := lookupFlowStrategy(p.FlowStrategy); err != nil {
+		return err
+	}
+	return p.engine().runContinuous(interval, handle, stop)
 }
 
-// lookupHostname performs reverse DNS lookup
-func (p *TCPProbe) lookupHostname(ip net.IP) string {
-	names, err := net.LookupAddr(ip.String())
-	if err != nil || len(names) == 0 {
-		return ""
-	}
-	return names[0]
+// engine returns the probing engine for this probe's settings
+func (p *TCPProbe) engine() *engine {
+	return p.options.engine(p, layers.IPProtocolTCP)
 }
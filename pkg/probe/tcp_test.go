@@ -2,8 +2,120 @@ package probe
 
 import (
 	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
 )
 
 func TestDummyTCP(t *testing.T) {
 	// Dummy test to verify test setup
 }
+
+func TestTCPTraceroute(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"10.0.0.1"},
+		3: {"8.8.8.8"},
+	})
+	p := NewTCPProbeWithConn(conn, testTarget, testSrc, 50000, 443, 2, 1, 10, 1)
+	p.Delay = 0
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+	if len(result.Hops) != 3 {
+		t.Fatalf("got %d hops, want 3", len(result.Hops))
+	}
+	if got := result.Hops[2].Flows[1].ResponseIP; got != "10.0.0.1" {
+		t.Errorf("TTL 2 flow 1: got %q, want 10.0.0.1", got)
+	}
+
+	// Every probe must be a SYN to the configured port
+	for _, raw := range conn.sent {
+		packet := gopacket.NewPacket(raw, layers.LayerTypeIPv4, gopacket.Default)
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok {
+			t.Fatal("probe is not TCP")
+		}
+		if !tcp.SYN || tcp.DstPort != 443 {
+			t.Errorf("got flags SYN=%v dport=%d, want SYN to 443", tcp.SYN, tcp.DstPort)
+		}
+	}
+}
+
+func TestTCPPortStates(t *testing.T) {
+	for _, tt := range []struct {
+		port string
+		hops int
+	}{
+		{results.PortOpen, 2},
+		{results.PortClosed, 2},
+		{results.PortFiltered, 10}, // the target never answers, so nothing ends the trace
+	} {
+		conn := newFakeConn(map[uint8][]string{
+			1: {"192.168.1.1"},
+			2: {"8.8.8.8"},
+		})
+		conn.port = tt.port
+		p := NewTCPProbeWithConn(conn, testTarget, testSrc, 50000, 443, 2, 1, 10, 1)
+		p.Delay = 0
+		p.Timeout = 50 * time.Millisecond
+		p.ResolveNames = false
+
+		result, err := p.Traceroute()
+		if err != nil {
+			t.Fatalf("%s: Traceroute: %v", tt.port, err)
+		}
+		if result.PortState != tt.port {
+			t.Errorf("%s: got port state %q", tt.port, result.PortState)
+		}
+		if len(result.Hops) != tt.hops {
+			t.Errorf("%s: got %d hops, want %d", tt.port, len(result.Hops), tt.hops)
+		}
+		if tt.port == results.PortFiltered {
+			continue
+		}
+		for _, flow := range result.Hops[2].Flows {
+			if flow.ResponseIP != "8.8.8.8" || flow.TCPState != tt.port {
+				t.Errorf("%s: flow %d got %s state %q", tt.port, flow.FlowID, flow.ResponseIP, flow.TCPState)
+			}
+		}
+	}
+}
+
+func TestTCPTracerouteIPv6(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"2001:db8::1"},
+		2: {"2001:4860:4860::8888"},
+	})
+	p := NewTCPProbeWithConn(conn, testTarget6, testSrc6, 50000, 443, 2, 1, 10, 1)
+	p.Delay = 0
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+	if len(result.Hops) != 2 {
+		t.Fatalf("got %d hops, want 2", len(result.Hops))
+	}
+	if got := result.Hops[1].Flows[0].ResponseIP; got != "2001:db8::1" {
+		t.Errorf("TTL 1: got %q, want 2001:db8::1", got)
+	}
+
+	for _, raw := range conn.sent {
+		packet := gopacket.NewPacket(raw, layers.LayerTypeIPv6, gopacket.Default)
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok {
+			t.Fatal("probe is not TCP over IPv6")
+		}
+		if !tcp.SYN || tcp.DstPort != 443 {
+			t.Errorf("got flags SYN=%v dport=%d, want SYN to 443", tcp.SYN, tcp.DstPort)
+		}
+	}
+}
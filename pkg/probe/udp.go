@@ -1,5 +1,3 @@
-// +build windows
-
 /* SPDX-License-Identifier: BSD-2-Clause */
 
 //
@@ -16,27 +14,18 @@ import (
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"golang.org/x/sys/windows"
 
 	"github.com/atlanticbb/dublin-traceroute-windows/internal/platform"
-	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
 	"github.com/atlanticbb/dublin-traceroute-windows/pkg/results"
 )
 
-// UDPProbe represents a UDP-based traceroute probe for Windows
+// UDPProbe represents a UDP-based traceroute probe
 type UDPProbe struct {
-	Target     net.IP
-	SrcIP      net.IP
-	SrcPort    uint16
-	DstPort    uint16
-	NumPaths   uint16
-	MinTTL     uint8
-	MaxTTL     uint8
-	ProbeCount int  // Number of probes per hop for MTR-style statistics
-	Delay      time.Duration
-	Timeout    time.Duration
-	socket     int
-	capture    *capture.WindowsCapture
+	options
+	SrcPort      uint16
+	DstPort      uint16
+	FlowStrategy string     // Where flows are encoded: FlowSrcPort (default), FlowDstPort, FlowParis or FlowIPID
+	ids          idSequence // IP ID and UDP checksum of each probe
 }
 
 // NewUDPProbe creates a new UDP probe instance
@@ -46,94 +35,47 @@ func NewUDPProbe(target string, srcPort, dstPort, numPaths uint16, minTTL, maxTT
 		return nil, err
 	}
 
-	// Resolve target IP
-	targetIP := net.ParseIP(target)
-	if targetIP == nil {
-		ips, err := net.LookupIP(target)
-		if err != nil {
-			return nil, fmt.Errorf("failed to resolve target %s: %w", target, err)
-		}
-		if len(ips) == 0 {
-			return nil, fmt.Errorf("no IP addresses found for target %s", target)
-		}
-		// Use first IPv4 address
-		for _, ip := range ips {
-			if ip.To4() != nil {
-				targetIP = ip
-				break
-			}
-		}
-		if targetIP == nil {
-			return nil, fmt.Errorf("no IPv4 address found for target %s", target)
-		}
-	} else {
-		targetIP = targetIP.To4()
-	}
-
-	if targetIP == nil {
-		return nil, fmt.Errorf("invalid IPv4 target: %s", target)
-	}
-
-	// Get local source IP
-	srcIP, err := platform.GetLocalIPv4Address()
+	// Resolve target IP (IPv4 unless only IPv6 is available)
+	targetIP, err := ResolveTarget(target, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get local IP: %w", err)
+		return nil, err
 	}
 
-	// Create raw UDP socket
-	sock, err := platform.CreateUDPSocket()
+	// Get local source IP of the route to the target
+	srcIP, err := localAddressFor(targetIP)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create UDP socket: %w", err)
+		return nil, fmt.Errorf("failed to get local IP: %w", err)
 	}
 
-	// Create packet capture for ICMP responses
-	cap, err := capture.NewCapture("", 3*time.Second)
+	// Create raw UDP socket and packet capture for ICMP responses
+	conn, err := openRawConn(targetIP, platform.ProtocolUDP)
 	if err != nil {
-		platform.CloseSocket(sock)
-		return nil, fmt.Errorf("failed to create packet capture: %w", err)
+		return nil, fmt.Errorf("failed to open UDP probe connection: %w", err)
 	}
 
-	// BPF filter disabled - Npcap on Windows seems to have issues with "icmp" filter
-	// We'll filter ICMP in software which is slightly less efficient but works reliably
+	return NewUDPProbeWithConn(conn, targetIP, srcIP, srcPort, dstPort, numPaths, minTTL, maxTTL, probeCount), nil
+}
 
+// NewUDPProbeWithConn creates a UDP probe that sends and receives through conn.
+// No privilege checks or name resolution are performed.
+func NewUDPProbeWithConn(conn PacketConn, target, srcIP net.IP, srcPort, dstPort, numPaths uint16, minTTL, maxTTL uint8, probeCount int) *UDPProbe {
 	return &UDPProbe{
-		Target:     targetIP,
-		SrcIP:      net.ParseIP(srcIP),
-		SrcPort:    srcPort,
-		DstPort:    dstPort,
-		NumPaths:   numPaths,
-		MinTTL:     minTTL,
-		MaxTTL:     maxTTL,
-		ProbeCount: probeCount,
-		Delay:      time.Millisecond * 10,
-		Timeout:    time.Second * 3,
-		socket:     sock,
-		capture:    cap,
-	}, nil
+		options: newOptions(conn, target, srcIP, numPaths, minTTL, maxTTL, probeCount, 3*time.Second),
+		SrcPort: srcPort,
+		DstPort: dstPort,
+	}
 }
 
-// craftUDPPacket creates a raw UDP/IP packet with specified TTL and flow ID
-func (p *UDPProbe) craftUDPPacket(ttl uint8, flowID uint16) ([]byte, error) {
+// craftUDPPacket creates a raw UDP/IP packet with the fields of h. The
+// payload is tuned so that the UDP checksum equals checksum.
+func (p *UDPProbe) craftUDPPacket(h header, checksum uint16) ([]byte, error) {
 	// Create IP layer
-	ip := &layers.IPv4{
-		Version:  4,
-		IHL:      5,
-		TOS:      0,
-		Length:   0, // Will be set automatically
-		Id:       flowID,
-		Flags:    layers.IPv4DontFragment,
-		FragOffset: 0,
-		TTL:      ttl,
-		Protocol: layers.IPProtocolUDP,
-		SrcIP:    p.SrcIP,
-		DstIP:    p.Target,
-	}
+	ip := newIPLayer(h, layers.IPProtocolUDP)
 
-	// Create UDP layer with flowID encoded in source port
-	srcPort := p.SrcPort + flowID
+	// Create UDP layer
 	udp := &layers.UDP{
-		SrcPort: layers.UDPPort(srcPort),
-		DstPort: layers.UDPPort(p.DstPort),
+		SrcPort: layers.UDPPort(h.srcPort),
+		DstPort: layers.UDPPort(h.dstPort),
 	}
 
 	// Set checksum computation
@@ -146,8 +88,8 @@ func (p *UDPProbe) craftUDPPacket(ttl uint8, flowID uint16) ([]byte, error) {
 		ComputeChecksums: true,
 	}
 
-	// Add 8-byte payload (Dublin Traceroute signature)
-	payload := []byte{0xDE, 0xAD, 0xBE, 0xEF, 0xCA, 0xFE, 0xBA, 0xBE}
+	// Dublin Traceroute signature, tuned so that the checksum is as asked
+	payload := tunedUDPPayload(h.src, h.dst, h.srcPort, h.dstPort, checksum)
 
 	err := gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload(payload))
 	if err != nil {
@@ -157,26 +99,51 @@ func (p *UDPProbe) craftUDPPacket(ttl uint8, flowID uint16) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// sendProbe sends a single probe packet
-func (p *UDPProbe) sendProbe(ttl uint8, flowID uint16) error {
-	packet, err := p.craftUDPPacket(ttl, flowID)
+// craft builds the probe of flowID. The flow strategy places the flow, by
+// default in the source port and for IPv6 the flow label, and the probe
+// identifier, by default in the UDP checksum and the IPv4 ID.
+func (p *UDPProbe) craft(sp *sentProbe, flowID uint16) ([]byte, error) {
+	strategy, err := lookupFlowStrategy(p.FlowStrategy)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Create destination sockaddr
-	dest := &windows.SockaddrInet4{
-		Port: int(p.DstPort),
+	id := p.ids.next()
+	h := header{
+		src:     p.SrcIP,
+		dst:     sp.dst,
+		ttl:     sp.ttl,
+		srcPort: p.SrcPort,
+		dstPort: p.DstPort,
 	}
-	copy(dest.Addr[:], p.Target.To4())
+	checksum := strategy.encode(&h, flowID, id)
+	sp.vary.apply(&h, p.SrcAddrs)
 
-	// Send packet
-	err = platform.SendPacket(p.socket, packet, dest)
-	if err != nil {
-		return fmt.Errorf("failed to send probe (TTL=%d, FlowID=%d): %w", ttl, flowID, err)
+	flow := sp.flow
+	flow.SrcPort = h.srcPort
+	flow.DstPort = h.dstPort
+	flow.Checksum = checksum
+	if h.dst.To4() != nil {
+		flow.IPID = h.id
+	} else {
+		flow.FlowLabel = h.label
 	}
 
-	return nil
+	sp.protocol = layers.IPProtocolUDP
+	sp.src = h.src
+	sp.srcPort = h.srcPort
+	sp.dstPort = h.dstPort
+	sp.id = id
+	sp.checksum = checksum
+	sp.fixedID = h.id != id
+	sp.fixedSum = checksum != id
+
+	return p.craftUDPPacket(h, checksum)
+}
+
+// reached reports whether the target itself answered flow
+func (p *UDPProbe) reached(flow *results.FlowResult) bool {
+	return flow.ResponseIP == p.Target.String()
 }
 
 // Traceroute executes the Dublin Traceroute algorithm
@@ -184,156 +151,82 @@ func (p *UDPProbe) Traceroute() (*results.TracerouteResult, error) {
 	result := &results.TracerouteResult{
 		Target:    p.Target.String(),
 		SrcIP:     p.SrcIP.String(),
-		StartTime: time.Now(),
+		StartTime: p.clock.Now(),
 		Hops:      make(map[uint8]*results.HopResult),
 	}
 
+	strategy, err := lookupFlowStrategy(p.FlowStrategy)
+	if err != nil {
+		return nil, err
+	}
+
 	fmt.Printf("Dublin Traceroute to %s (%s)\n", p.Target, p.Target)
-	fmt.Printf("Using UDP ports %d-%d, TTL %d-%d\n", p.SrcPort, p.SrcPort+p.NumPaths-1, p.MinTTL, p.MaxTTL)
-	
+	fmt.Printf("Using UDP %s, TTL %d-%d\n", strategy.describe(p.SrcPort, p.DstPort, p.NumPaths), p.MinTTL, p.MaxTTL)
+	if p.Target.To4() == nil {
+		fmt.Println("IPv6: flow labels follow the ports")
+	}
+
 	if p.ProbeCount > 1 {
 		fmt.Printf("MTR mode: %d probes per hop for statistical analysis\n", p.ProbeCount)
 	}
-	
-	fmt.Println()
 
-	// For each TTL level
-	for ttl := p.MinTTL; ttl <= p.MaxTTL; ttl++ {
-		hopResult := &results.HopResult{
-			TTL:   ttl,
-			Flows: make(map[uint16]*results.FlowResult),
-		}
-
-		// Perform multiple probe rounds if ProbeCount > 1 (MTR mode)
-		for round := 0; round < p.ProbeCount; round++ {
-			// Send probes for each flow
-			for flowID := uint16(0); flowID < p.NumPaths; flowID++ {
-				// Calculate unique flow key for this round
-				uniqueFlowID := flowID + uint16(round)*p.NumPaths
-				
-				flowResult := &results.FlowResult{
-					FlowID:   uniqueFlowID,
-					SrcPort:  p.SrcPort + flowID,
-					DstPort:  p.DstPort,
-					SentTime: time.Now(),
-				}
-
-				// Send probe
-				err := p.sendProbe(ttl, flowID)
-				if err != nil {
-					if round == 0 {
-						fmt.Printf("TTL=%2d Flow=%2d: Failed to send probe: %v\n", ttl, flowID, err)
-					}
-					flowResult.Error = err.Error()
-					hopResult.Flows[uniqueFlowID] = flowResult
-					continue
-				}
-				
-				// Wait for response
-				packet, srcIP, err := p.capture.CaptureICMPResponse(p.SrcIP, p.Target, 0)
-				if err != nil {
-					// Timeout or no response
-					flowResult.Error = "timeout"
-					hopResult.Flows[uniqueFlowID] = flowResult
-					if round == 0 {
-						fmt.Printf("TTL=%2d Flow=%2d: *\n", ttl, flowID)
-					}
-					continue
-				}
-				
-				flowResult.RecvTime = time.Now()
-				flowResult.RTT = flowResult.RecvTime.Sub(flowResult.SentTime)
-				flowResult.ResponseIP = srcIP.String()
-
-				// Parse ICMP response
-				icmpLayer := packet.Layer(layers.LayerTypeICMPv4)
-				if icmpLayer != nil {
-					icmp, _ := icmpLayer.(*layers.ICMPv4)
-					flowResult.ICMPType = uint8(icmp.TypeCode.Type())
-					flowResult.ICMPCode = uint8(icmp.TypeCode.Code())
-				}
-
-				// Try to get hostname (only on first round to avoid delays)
-				if round == 0 {
-					names, err := net.LookupAddr(srcIP.String())
-					if err == nil && len(names) > 0 {
-						flowResult.Hostname = names[0]
-					}
-				}
-
-				hopResult.Flows[uniqueFlowID] = flowResult
-
-				// Print result (only first round in MTR mode for cleaner output)
-				if round == 0 {
-					fmt.Printf("TTL=%2d Flow=%2d: %s", ttl, flowID, srcIP)
-					if flowResult.Hostname != "" {
-						fmt.Printf(" (%s)", flowResult.Hostname)
-					}
-					fmt.Printf(" %v\n", flowResult.RTT)
-				}
-
-				// Small delay between probes
-				time.Sleep(p.Delay)
-			}
-		}
-
-		result.Hops[ttl] = hopResult
-
-		// Check if we reached the destination
-		reachedTarget := false
-		for _, flow := range hopResult.Flows {
-			if flow.ResponseIP == p.Target.String() {
-				reachedTarget = true
-				break
-			}
-		}
-
-		if reachedTarget {
-			fmt.Printf("\nReached target %s at TTL %d\n", p.Target, ttl)
-			break
-		}
+	if p.MDA > 0 {
+		fmt.Printf("MDA mode: flows per hop until all next hops are found with %.4g%% confidence (at most %d)\n", p.MDA*100, p.NumPaths)
 	}
 
-	result.EndTime = time.Now()
+	fmt.Println()
+
+	err = p.engine().run(result)
+
+	result.EndTime = p.clock.Now()
 	result.Duration = result.EndTime.Sub(result.StartTime)
 
-	return result, nil
+	return result, err
 }
 
-// Close cleans up resources
-func (p *UDPProbe) Close() {
-	if p.capture != nil {
-		p.capture.Close()
-	}
-	if p.socket != 0 {
-		platform.CloseSocket(p.socket)
+// TraceContinuously probes the target a round every interval, MTR style,
+// and hands each round to handle until it returns false or stop is closed.
+// MDA, load-balancer classification, hash-field discovery and split
+// estimates are not run.
+func (p *UDPProbe) TraceContinuously(interval time.Duration, handle RoundHandler, stop <-chan struct{}) error {
+	if _, err := lookupFlowStrategy(p.FlowStrategy); err != nil {
+		return err
 	}
+	return p.engine().runContinuous(interval, handle, stop)
 }
 
-// SetDelay sets the delay between probe packets
-func (p *UDPProbe) SetDelay(delay time.Duration) {
-	p.Delay = delay
+// engine returns the probing engine for this probe's settings
+func (p *UDPProbe) engine() *engine {
+	return p.options.engine(p, layers.IPProtocolUDP)
+}
+
+// Close cleans up resources
+func (p *UDPProbe) Close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
 }
 
-// SetTimeout sets the timeout for waiting for responses
-func (p *UDPProbe) SetTimeout(timeout time.Duration) {
-	p.Timeout = timeout
-	// Recreate capture with new timeout
-	if p.capture != nil {
-		iface := p.capture.GetInterface()
-		p.capture.Close()
-		cap, err := capture.NewCapture(iface, timeout)
-		if err == nil {
-			cap.SetBPFFilter("icmp")
-			p.capture = cap
-		}
+// SetFlowStrategy selects where flows and probe identifiers are encoded.
+// FlowIPID needs IPv4, since IPv6 has no ID field.
+func (p *UDPProbe) SetFlowStrategy(name string) error {
+	if _, err := lookupFlowStrategy(name); err != nil {
+		return err
 	}
+	if name == FlowIPID && p.Target.To4() == nil {
+		return fmt.Errorf("flow strategy %s needs an IPv4 target", FlowIPID)
+	}
+	p.FlowStrategy = name
+	return nil
 }
 
 // GetStats returns probe statistics
 func (p *UDPProbe) GetStats() (received, dropped, ifDropped uint, err error) {
-	if p.capture == nil {
+	stats, ok := p.conn.(interface {
+		GetStats() (uint, uint, uint, error)
+	})
+	if !ok {
 		return 0, 0, 0, fmt.Errorf("capture not initialized")
 	}
-	return p.capture.GetStats()
+	return stats.GetStats()
 }
@@ -1,9 +1,182 @@
 package probe
 
 import (
+	"net"
 	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/atlanticbb/dublin-traceroute-windows/pkg/capture"
 )
 
 func TestDummyUDP(t *testing.T) {
 	// Dummy test to verify test setup
 }
+
+func newTestUDPProbe(conn PacketConn, numPaths uint16, maxTTL uint8) *UDPProbe {
+	p := NewUDPProbeWithConn(conn, testTarget, testSrc, 33434, 33434, numPaths, 1, maxTTL, 1)
+	p.Delay = 0
+	p.Timeout = 50 * time.Millisecond
+	p.ResolveNames = false
+	return p
+}
+
+func TestUDPTracerouteSinglePath(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"10.0.0.1"},
+		3: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 2, 30)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	if len(result.Hops) != 3 {
+		t.Fatalf("got %d hops, want 3 (trace must stop at the target)", len(result.Hops))
+	}
+	want := map[uint8]string{1: "192.168.1.1", 2: "10.0.0.1", 3: "8.8.8.8"}
+	for ttl, ip := range want {
+		for flowID, flow := range result.Hops[ttl].Flows {
+			if flow.ResponseIP != ip {
+				t.Errorf("TTL %d flow %d: got %q, want %q", ttl, flowID, flow.ResponseIP, ip)
+			}
+			if flow.RTT <= 0 {
+				t.Errorf("TTL %d flow %d: RTT not recorded", ttl, flowID)
+			}
+		}
+	}
+	if flow := result.Hops[3].Flows[0]; flow.ICMPType != 3 || flow.ICMPCode != 3 {
+		t.Errorf("target reply: got ICMP %d/%d, want 3/3", flow.ICMPType, flow.ICMPCode)
+	}
+	// The first round probes every TTL at once
+	if len(conn.sent) != 2*30 {
+		t.Errorf("sent %d probes, want 60", len(conn.sent))
+	}
+}
+
+func TestUDPTracerouteLoadBalancing(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		2: {"10.0.1.1", "10.0.2.1"},
+		3: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 4, 30)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	analysis := result.AnalyzeNetwork()
+	if !analysis.HasLoadBalancing {
+		t.Fatal("expected load balancing to be detected")
+	}
+	if len(analysis.LoadBalancingHops) != 1 || analysis.LoadBalancingHops[0] != 2 {
+		t.Errorf("got load balancing hops %v, want [2]", analysis.LoadBalancingHops)
+	}
+}
+
+func TestUDPTracerouteSilentHop(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"192.168.1.1"},
+		3: {"8.8.8.8"},
+	})
+	p := newTestUDPProbe(conn, 1, 30)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	flow := result.Hops[2].Flows[0]
+	if flow.Error != "timeout" || flow.ResponseIP != "" {
+		t.Errorf("silent hop: got error %q response %q, want timeout", flow.Error, flow.ResponseIP)
+	}
+	if result.Hops[3].Flows[0].ResponseIP != "8.8.8.8" {
+		t.Error("trace should continue past a silent hop")
+	}
+}
+
+func TestUDPTracerouteIgnoresOtherProbes(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{1: {"8.8.8.8"}})
+	// A stale Time Exceeded for a different destination is already queued
+	conn.pending = append(conn.pending, &capture.Reply{
+		From:      net.ParseIP("10.9.9.9"),
+		To:        testSrc,
+		Timestamp: time.Now(),
+		ICMPType:  layers.ICMPv4TypeTimeExceeded,
+		InnerSrc:  testSrc,
+		InnerDst:  net.ParseIP("1.1.1.1"),
+	})
+	p := newTestUDPProbe(conn, 1, 5)
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+	if got := result.Hops[1].Flows[0].ResponseIP; got != "8.8.8.8" {
+		t.Errorf("got %q, want 8.8.8.8", got)
+	}
+}
+
+func TestUDPProbeClose(t *testing.T) {
+	conn := newFakeConn(nil)
+	newTestUDPProbe(conn, 1, 1).Close()
+	if !conn.closed {
+		t.Error("Close must close the connection")
+	}
+}
+
+func TestUDPTracerouteIPv6(t *testing.T) {
+	conn := newFakeConn(map[uint8][]string{
+		1: {"2001:db8::1"},
+		2: {"2001:db8:1::1", "2001:db8:2::1"},
+		3: {"2001:4860:4860::8888"},
+	})
+	p := NewUDPProbeWithConn(conn, testTarget6, testSrc6, 33434, 33434, 4, 1, 30, 1)
+	p.Delay = 0
+	p.ResolveNames = false
+
+	result, err := p.Traceroute()
+	if err != nil {
+		t.Fatalf("Traceroute: %v", err)
+	}
+
+	if len(result.Hops) != 3 {
+		t.Fatalf("got %d hops, want 3 (trace must stop at the target)", len(result.Hops))
+	}
+	flow := result.Hops[3].Flows[0]
+	if flow.ICMPType != layers.ICMPv6TypeDestinationUnreachable || flow.ICMPCode != layers.ICMPv6CodePortUnreachable {
+		t.Errorf("target reply: got ICMPv6 %d/%d, want port unreachable", flow.ICMPType, flow.ICMPCode)
+	}
+	if flow.FlowLabel != uint32(flow.SrcPort) {
+		t.Errorf("flow label %d does not follow source port %d", flow.FlowLabel, flow.SrcPort)
+	}
+	if !result.AnalyzeNetwork().HasLoadBalancing {
+		t.Error("expected load balancing at TTL 2")
+	}
+
+	// Probes are IPv6 with the hop limit as TTL and a per-flow flow label
+	for _, raw := range conn.sent {
+		packet := gopacket.NewPacket(raw, layers.LayerTypeIPv6, gopacket.Default)
+		ip, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+		if !ok {
+			t.Fatal("probe is not IPv6")
+		}
+		udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if !ok {
+			t.Fatal("probe is not UDP")
+		}
+		if ip.HopLimit < 1 || ip.HopLimit > 30 {
+			t.Errorf("unexpected hop limit %d", ip.HopLimit)
+		}
+		if ip.FlowLabel != uint32(udp.SrcPort) {
+			t.Errorf("flow label %d, want source port %d", ip.FlowLabel, udp.SrcPort)
+		}
+	}
+}
@@ -15,6 +15,11 @@ import (
 
 // AnalyzeNetwork performs comprehensive analysis of the traceroute results
 func (tr *TracerouteResult) AnalyzeNetwork() *NetworkAnalysis {
+	return tr.analyze(tr.Graph())
+}
+
+// analyze analyzes the trace, whose graph is g
+func (tr *TracerouteResult) analyze(g *NetworkGraph) *NetworkAnalysis {
 	analysis := &NetworkAnalysis{
 		LoadBalancingHops: make([]uint8, 0),
 		HighLatencyHops:   make([]LatencyIssue, 0),
@@ -25,7 +30,6 @@ func (tr *TracerouteResult) AnalyzeNetwork() *NetworkAnalysis {
 	totalProbes := 0
 	successfulProbes := 0
 	var rtts []time.Duration
-	uniqueRouters := make(map[string]bool)
 
 	for _, hopResult := range tr.Hops {
 		for _, flowResult := range hopResult.Flows {
@@ -34,13 +38,10 @@ func (tr *TracerouteResult) AnalyzeNetwork() *NetworkAnalysis {
 				successfulProbes++
 				rtts = append(rtts, flowResult.RTT)
 			}
-			if flowResult.ResponseIP != "" {
-				uniqueRouters[flowResult.ResponseIP] = true
-			}
 		}
 	}
 
-	analysis.UniqueRouters = len(uniqueRouters)
+	analysis.UniqueRouters = len(g.UniqueRouters())
 
 	if totalProbes > 0 {
 		analysis.PacketLossRate = float64(totalProbes-successfulProbes) / float64(totalProbes) * 100
@@ -63,27 +64,16 @@ func (tr *TracerouteResult) AnalyzeNetwork() *NetworkAnalysis {
 		analysis.AverageRTT = total / time.Duration(len(rtts))
 	}
 
-	// Detect load balancing (multiple IPs at same TTL)
-	for ttl, hopResult := range tr.Hops {
-		ips := make(map[string]bool)
-		for _, flowResult := range hopResult.Flows {
-			if flowResult.ResponseIP != "" && flowResult.Error == "" {
-				ips[flowResult.ResponseIP] = true
-			}
-		}
-		if len(ips) > 1 {
-			analysis.HasLoadBalancing = true
-			analysis.LoadBalancingHops = append(analysis.LoadBalancingHops, ttl)
-		}
-	}
+	// Detect load balancing (multiple routers at the same TTL) and the
+	// diamonds it forms
+	analysis.LoadBalancingHops = append(analysis.LoadBalancingHops, g.MultipathTTLs()...)
+	analysis.HasLoadBalancing = len(analysis.LoadBalancingHops) > 0
+	analysis.Diamonds = g.Diamonds
 
 	// Detect high latency hops
 	prevRTT := time.Duration(0)
-	for ttl := uint8(1); ttl != 0; ttl++ {
-		hopResult, ok := tr.Hops[ttl]
-		if !ok {
-			break
-		}
+	for _, ttl := range tr.sortedTTLs() {
+		hopResult := tr.Hops[ttl]
 
 		avgHopRTT := tr.GetAverageRTT(ttl)
 		if avgHopRTT == 0 {
@@ -123,21 +113,18 @@ func (tr *TracerouteResult) AnalyzeNetwork() *NetworkAnalysis {
 		}
 	}
 
-	// Detect asymmetric routing (common with load balancing)
-	paths := tr.GetPaths()
-	if len(paths) > 1 {
-		// Check if paths have different intermediate hops
-		different := false
-		for i := 0; i < len(paths)-1; i++ {
-			for j := i + 1; j < len(paths); j++ {
-				if !pathsEqual(paths[i], paths[j]) {
-					different = true
-					break
-				}
-			}
-		}
-		analysis.AsymmetricRouting = different
-	}
+	// Detect asymmetric routing (common with load balancing). Silent hops
+	// match any router, so flows take several paths only through a TTL that
+	// several routers answered, one of the LoadBalancingHops.
+	analysis.AsymmetricRouting = len(g.Paths()) > 1
+
+	analysis.LoadBalancers = tr.LoadBalancers
+	analysis.HashFields = tr.HashFields
+	analysis.ECMPSplits = tr.ECMPSplits
+
+	// Detect NAT from the probes quoted back by routers
+	analysis.NATHops = tr.detectNATs()
+	analysis.NATDetected = len(analysis.NATHops) > 0
 
 	// Generate recommendations
 	if analysis.PacketLossRate > 20 {
@@ -150,6 +137,21 @@ func (tr *TracerouteResult) AnalyzeNetwork() *NetworkAnalysis {
 			"Load balancing detected - your traffic takes multiple paths, which can improve reliability and performance")
 	}
 
+	if ttls := perPacketHops(analysis.LoadBalancers); len(ttls) > 0 {
+		analysis.Recommendations = append(analysis.Recommendations,
+			fmt.Sprintf("Per-packet load balancing at hop(s) %v reorders TCP segments - report it to the carrier operating that hop", ttls))
+	}
+
+	if ttls := unequalSplitHops(analysis.ECMPSplits); len(ttls) > 0 {
+		analysis.Recommendations = append(analysis.Recommendations,
+			fmt.Sprintf("Unequal ECMP split at hop(s) %v - weighted (UCMP) balancing or a failed member link; the busiest next hop congests first", ttls))
+	}
+
+	if len(analysis.NATHops) > 1 {
+		analysis.Recommendations = append(analysis.Recommendations,
+			fmt.Sprintf("%d NAT layers detected - a carrier-grade NAT is likely in front of your router", len(analysis.NATHops)))
+	}
+
 	if len(analysis.HighLatencyHops) > 0 {
 		analysis.Recommendations = append(analysis.Recommendations,
 			fmt.Sprintf("Found %d high-latency hop(s) - review LatencyIssue details below", len(analysis.HighLatencyHops)))
@@ -183,6 +185,24 @@ func (tr *TracerouteResult) PrintNetworkAnalysis(analysis *NetworkAnalysis) {
 		fmt.Printf("   Your traffic is distributed across multiple network paths.\n")
 		fmt.Printf("   This is NORMAL and GOOD - it improves reliability and performance.\n")
 		fmt.Printf("   Load balancing occurs at hop(s): %v\n", analysis.LoadBalancingHops)
+		graph := tr.Graph()
+		for _, ttl := range analysis.LoadBalancingHops {
+			hop := tr.Hops[ttl]
+			if hop.MDAFlows == 0 {
+				continue
+			}
+			fmt.Printf("   Hop %d: %d next hops found with %d flows (%.1f%% confidence)\n",
+				ttl, len(graph.Routers(ttl)), hop.MDAFlows, hop.MDAConfidence*100)
+		}
+		for _, d := range analysis.Diamonds {
+			if d.Convergence == nil {
+				fmt.Printf("   Diamond at hop %d (%s): %d wide, branches stay apart for the last %d hops\n",
+					d.Divergence.TTL, d.Divergence.IP, d.Width, d.Length)
+				continue
+			}
+			fmt.Printf("   Diamond at hop %d (%s) to hop %d (%s): %d wide, %d hops long\n",
+				d.Divergence.TTL, d.Divergence.IP, d.Convergence.TTL, d.Convergence.IP, d.Width, d.Length)
+		}
 		fmt.Println()
 	} else {
 		fmt.Println("🛣️  Single Path Routing:")
@@ -190,6 +210,70 @@ func (tr *TracerouteResult) PrintNetworkAnalysis(analysis *NetworkAnalysis) {
 		fmt.Println()
 	}
 
+	if len(analysis.ECMPSplits) > 0 {
+		fmt.Println("📐 ECMP Split:")
+		for _, split := range analysis.ECMPSplits {
+			verdict := "even"
+			if split.Unequal {
+				verdict = "UNEQUAL"
+			}
+			fmt.Printf("   Hop %d: %s split over %d flows\n", split.TTL, verdict, split.Flows)
+			for _, share := range split.Shares {
+				fmt.Printf("   └─ %-15s %5.1f%% (joint 95%% CI %.1f-%.1f%%)\n",
+					share.IP, share.Fraction*100, share.Low*100, share.High*100)
+			}
+		}
+		fmt.Println()
+	}
+
+	if len(analysis.LoadBalancers) > 0 {
+		fmt.Println("⚖️  Load Balancer Types:")
+		for _, lb := range analysis.LoadBalancers {
+			fmt.Printf("   Hop %d: %s across %d next hops\n", lb.TTL, lb.Kind, len(lb.NextHops))
+			fmt.Printf("   └─ %s\n", lb.Evidence)
+		}
+		fmt.Println()
+	}
+
+	if len(analysis.HashFields) > 0 {
+		fmt.Println("🔑 ECMP Hash Fields:")
+		for _, hf := range analysis.HashFields {
+			switch {
+			case hf.PerPacket:
+				fmt.Printf("   Hop %d: identical probes took different next hops (per-packet)\n", hf.TTL)
+			case len(hf.Hashed) == 0:
+				fmt.Printf("   Hop %d: follows the previous hop (%s)\n", hf.TTL, strings.Join(hf.Inherited, ", "))
+			default:
+				fmt.Printf("   Hop %d: hashes on %s\n", hf.TTL, strings.Join(hf.Hashed, ", "))
+				if len(hf.Ignored) > 0 {
+					fmt.Printf("   └─ ignores %s\n", strings.Join(hf.Ignored, ", "))
+				}
+			}
+		}
+		fmt.Println()
+	}
+
+	// NAT analysis
+	if analysis.NATDetected {
+		fmt.Println("🔁 NAT Detected:")
+		fmt.Println("   Routers behind these hops quote your probes with rewritten headers.")
+		for _, nat := range analysis.NATHops {
+			where := "before the first hop (local host or VM)"
+			if nat.TTL > 0 {
+				where = nat.IP
+				if nat.Hostname != "" {
+					where = fmt.Sprintf("%s (%s)", nat.Hostname, nat.IP)
+				}
+				if where == "" {
+					where = "unresponsive router"
+				}
+			}
+			fmt.Printf("   Hop %d: %s\n", nat.TTL, where)
+			fmt.Printf("   └─ NAT ID %d, rewrites %s\n", nat.NATID, strings.Join(nat.Rewritten, ", "))
+		}
+		fmt.Println()
+	}
+
 	// Latency analysis
 	if len(analysis.HighLatencyHops) > 0 {
 		fmt.Println("⚠️  High Latency Hops:")
@@ -298,15 +382,22 @@ func PrintComparisonHelp() {
 	fmt.Println("To detect route changes or diagnose intermittent issues:")
 	fmt.Println()
 	fmt.Println("  1. Save results to JSON:")
-	fmt.Println("     dublin-traceroute -target example.com -output-json baseline.json")
+	fmt.Println("     dublin-traceroute -target example.com -count 5 -output-json baseline.json")
 	fmt.Println()
 	fmt.Println("  2. Run again during issues:")
-	fmt.Println("     dublin-traceroute -target example.com -output-json problem.json")
+	fmt.Println("     dublin-traceroute -target example.com -count 5 -output-json problem.json")
+	fmt.Println()
+	fmt.Println("  3. Compare with the baseline, live or from the saved file:")
+	fmt.Println("     dublin-traceroute -target example.com -count 5 -compare baseline.json")
+	fmt.Println("     dublin-traceroute -input-json problem.json -compare baseline.json")
 	fmt.Println()
-	fmt.Println("  3. Compare the JSON files to see:")
-	fmt.Println("     • Route changes (different IPs at same hop)")
-	fmt.Println("     • Latency increases")
-	fmt.Println("     • New load balancing behavior")
+	fmt.Println("     The report shows:")
+	fmt.Println("     • Route changes (different IPs at same hop, hops added or removed)")
+	fmt.Println("     • Latency shifts per hop that are statistically significant")
+	fmt.Println("       (use -count 5 or more in both runs so the tests can tell)")
+	fmt.Println("     • Loss increases per hop")
+	fmt.Println("     • New or vanished load balancing (diamonds)")
+	fmt.Println("     • Whether the target is still reached")
 	fmt.Println()
 	fmt.Println("Route changes are normal, but sudden changes during problems can indicate:")
 	fmt.Println("  • Network failover (a link went down)")
@@ -1,9 +1,34 @@
 package results
 
 import (
+	"strings"
 	"testing"
 )
 
 func TestDummyAnalysis(t *testing.T) {
 	// Dummy test to verify test setup
 }
+
+func TestPerPacketBalancingIsRecommendedForEscalation(t *testing.T) {
+	tr := &TracerouteResult{
+		Hops: map[uint8]*HopResult{},
+		LoadBalancers: []LoadBalancer{
+			{TTL: 3, Kind: PerFlow},
+			{TTL: 6, Kind: PerPacket},
+		},
+	}
+
+	analysis := tr.AnalyzeNetwork()
+	if len(analysis.LoadBalancers) != 2 {
+		t.Fatalf("got %d balancers, want the classified ones", len(analysis.LoadBalancers))
+	}
+	found := false
+	for _, rec := range analysis.Recommendations {
+		if strings.Contains(rec, "Per-packet load balancing at hop(s) [6]") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no per-packet recommendation in %q", analysis.Recommendations)
+	}
+}
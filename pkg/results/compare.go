@@ -0,0 +1,381 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Changes of a hop between two traces
+const (
+	HopSame    = "same"
+	HopChanged = "changed" // Other routers answer at this TTL
+	HopAdded   = "added"   // Only the current trace has this TTL
+	HopRemoved = "removed" // Only the baseline has this TTL
+)
+
+// Smallest changes reported as findings: loss must grow by the percentage
+// points; latency shifts must be significant (see CompareLatency), or for
+// samples too small to test, grow by both the absolute and the relative
+// amount to be mentioned
+const (
+	latencyChange         = 10 * time.Millisecond
+	latencyChangeRelative = 0.5
+	lossChange            = 10.0
+)
+
+// TraceDiff is what changed between a baseline trace and a later one to
+// the same target
+type TraceDiff struct {
+	Target        string    `json:"target"`
+	BaselineStart time.Time `json:"baseline_start"`
+	CurrentStart  time.Time `json:"current_start"`
+
+	Baseline FamilySummary `json:"baseline"` // Reachability, hop count, end-to-end RTT and loss
+	Current  FamilySummary `json:"current"`
+
+	Hops             []HopDiff `json:"hops"`
+	AddedPaths       []Path    `json:"added_paths,omitempty"`
+	RemovedPaths     []Path    `json:"removed_paths,omitempty"`
+	AddedDiamonds    []Diamond `json:"added_diamonds,omitempty"`
+	RemovedDiamonds  []Diamond `json:"removed_diamonds,omitempty"`
+	ReachabilityLost bool      `json:"reachability_lost"`
+	ReachabilityWon  bool      `json:"reachability_won"` // The baseline did not reach the target, the current trace does
+
+	Latency  *LatencyComparison `json:"latency"` // Significance of the RTT shifts, per hop and end to end
+	Findings []string           `json:"findings"`
+}
+
+// HopDiff compares the routers, latency and loss of both traces at one TTL
+type HopDiff struct {
+	TTL             uint8         `json:"ttl"`
+	Status          string        `json:"status"` // HopSame, HopChanged, HopAdded or HopRemoved
+	BaselineRouters []string      `json:"baseline_routers,omitempty"`
+	CurrentRouters  []string      `json:"current_routers,omitempty"`
+	NewRouters      []string      `json:"new_routers,omitempty"`  // Answering now, not in the baseline
+	GoneRouters     []string      `json:"gone_routers,omitempty"` // Answering in the baseline, not now
+	BaselineRTT     time.Duration `json:"baseline_rtt,omitempty"`
+	CurrentRTT      time.Duration `json:"current_rtt,omitempty"`
+	RTTDelta        time.Duration `json:"rtt_delta"`
+	BaselineLoss    float64       `json:"baseline_loss"` // Percent of probes without an answer
+	CurrentLoss     float64       `json:"current_loss"`
+	LossDelta       float64       `json:"loss_delta"` // Percentage points
+}
+
+// CompareTraces aligns two traces by TTL and by path and reports what
+// changed from baseline to current
+func CompareTraces(baseline, current *TracerouteResult) *TraceDiff {
+	d := &TraceDiff{
+		Target:        current.Target,
+		BaselineStart: baseline.StartTime,
+		CurrentStart:  current.StartTime,
+		Baseline:      summarizeFamily("", baseline),
+		Current:       summarizeFamily("", current),
+		Latency:       CompareLatency(baseline, current, LatencyAlpha),
+		Findings:      make([]string, 0),
+	}
+	before, after := baseline.Graph(), current.Graph()
+
+	d.compareHops(baseline, current, before, after)
+	d.AddedPaths, d.RemovedPaths = diffPaths(before.Paths(), after.Paths())
+	d.AddedDiamonds, d.RemovedDiamonds = diffDiamonds(before.Diamonds, after.Diamonds)
+	d.ReachabilityLost = d.Baseline.Reached && !d.Current.Reached
+	d.ReachabilityWon = !d.Baseline.Reached && d.Current.Reached
+
+	d.findings(baseline)
+	return d
+}
+
+// compareHops fills in Hops for every TTL either graph has
+func (d *TraceDiff) compareHops(baseline, current *TracerouteResult, before, after *NetworkGraph) {
+	statsBefore := baseline.CalculateHopStatistics()
+	statsAfter := current.CalculateHopStatistics()
+
+	for _, ttl := range mergeTTLs(before.ttls, after.ttls) {
+		hop := HopDiff{
+			TTL:             ttl,
+			BaselineRouters: routerIPs(before.Routers(ttl)),
+			CurrentRouters:  routerIPs(after.Routers(ttl)),
+		}
+		_, inBefore := statsBefore[ttl]
+		_, inAfter := statsAfter[ttl]
+		hop.NewRouters = missingFrom(hop.CurrentRouters, hop.BaselineRouters)
+		hop.GoneRouters = missingFrom(hop.BaselineRouters, hop.CurrentRouters)
+
+		switch {
+		case !before.hasTTL(ttl):
+			hop.Status = HopAdded
+		case !after.hasTTL(ttl):
+			hop.Status = HopRemoved
+		case len(hop.NewRouters) > 0 || len(hop.GoneRouters) > 0:
+			hop.Status = HopChanged
+		default:
+			hop.Status = HopSame
+		}
+
+		if inBefore {
+			hop.BaselineRTT = statsBefore[ttl].AvgRTT
+			hop.BaselineLoss = statsBefore[ttl].LossPercent
+		}
+		if inAfter {
+			hop.CurrentRTT = statsAfter[ttl].AvgRTT
+			hop.CurrentLoss = statsAfter[ttl].LossPercent
+		}
+		if inBefore && inAfter {
+			if hop.BaselineRTT > 0 && hop.CurrentRTT > 0 {
+				hop.RTTDelta = hop.CurrentRTT - hop.BaselineRTT
+			}
+			hop.LossDelta = hop.CurrentLoss - hop.BaselineLoss
+		}
+		d.Hops = append(d.Hops, hop)
+	}
+}
+
+// hasTTL reports whether any probe visited the graph at ttl
+func (g *NetworkGraph) hasTTL(ttl uint8) bool {
+	for _, t := range g.ttls {
+		if t == ttl {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeTTLs returns the sorted union of two sorted TTL lists
+func mergeTTLs(a, b []uint8) []uint8 {
+	var ttls []uint8
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0] < b[0]):
+			ttls, a = append(ttls, a[0]), a[1:]
+		case len(a) == 0 || b[0] < a[0]:
+			ttls, b = append(ttls, b[0]), b[1:]
+		default:
+			ttls, a, b = append(ttls, a[0]), a[1:], b[1:]
+		}
+	}
+	return ttls
+}
+
+func routerIPs(nodes []*GraphNode) []string {
+	var ips []string
+	for _, node := range nodes {
+		ips = append(ips, node.IP)
+	}
+	return ips
+}
+
+// missingFrom returns the strings of list that other lacks
+func missingFrom(list, other []string) []string {
+	var missing []string
+	for _, s := range list {
+		if !contains(other, s) {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// diffPaths returns the paths only in after, and those only in before
+func diffPaths(before, after []Path) (added, removed []Path) {
+	keys := func(paths []Path) []string {
+		var k []string
+		for _, p := range paths {
+			k = append(k, routeKey(p.Hops))
+		}
+		return k
+	}
+	beforeKeys, afterKeys := keys(before), keys(after)
+	for i, p := range after {
+		if !contains(beforeKeys, afterKeys[i]) {
+			added = append(added, p)
+		}
+	}
+	for i, p := range before {
+		if !contains(afterKeys, beforeKeys[i]) {
+			removed = append(removed, p)
+		}
+	}
+	return added, removed
+}
+
+// diffDiamonds matches diamonds by where they open and close
+func diffDiamonds(before, after []Diamond) (added, removed []Diamond) {
+	key := func(d Diamond) string {
+		k := fmt.Sprintf("%d %s", d.Divergence.TTL, d.Divergence.IP)
+		if d.Convergence != nil {
+			k += fmt.Sprintf(" %d %s", d.Convergence.TTL, d.Convergence.IP)
+		}
+		return k
+	}
+	var beforeKeys, afterKeys []string
+	for _, d := range before {
+		beforeKeys = append(beforeKeys, key(d))
+	}
+	for _, d := range after {
+		afterKeys = append(afterKeys, key(d))
+	}
+	for i, d := range after {
+		if !contains(beforeKeys, afterKeys[i]) {
+			added = append(added, d)
+		}
+	}
+	for i, d := range before {
+		if !contains(afterKeys, beforeKeys[i]) {
+			removed = append(removed, d)
+		}
+	}
+	return added, removed
+}
+
+// findings summarizes the diff, most serious first
+func (d *TraceDiff) findings(baseline *TracerouteResult) {
+	if baseline.Target != d.Target {
+		d.Findings = append(d.Findings, fmt.Sprintf("The traces are to different targets (%s, then %s): hops are compared by TTL only", baseline.Target, d.Target))
+	}
+
+	switch {
+	case d.ReachabilityLost:
+		d.Findings = append(d.Findings, fmt.Sprintf("The target is no longer reached: probes stopped answering after TTL %d (reached at TTL %d in the baseline)",
+			lastAnswer(d.Hops), d.Baseline.HopCount))
+	case d.ReachabilityWon:
+		d.Findings = append(d.Findings, fmt.Sprintf("The target is reached again, at TTL %d", d.Current.HopCount))
+	case d.Baseline.Reached && d.Current.HopCount != d.Baseline.HopCount:
+		d.Findings = append(d.Findings, fmt.Sprintf("The target is %d hops away, %d in the baseline", d.Current.HopCount, d.Baseline.HopCount))
+	}
+
+	if shift := d.Latency.EndToEnd; shift != nil {
+		if finding := shift.finding("End-to-end latency", d.Latency.Alpha); finding != "" {
+			d.Findings = append(d.Findings, finding)
+		}
+	}
+
+	for _, hop := range d.Hops {
+		switch hop.Status {
+		case HopChanged:
+			d.Findings = append(d.Findings, fmt.Sprintf("Hop %d changed: %s now, %s in the baseline",
+				hop.TTL, routerList(hop.CurrentRouters), routerList(hop.BaselineRouters)))
+		case HopAdded:
+			d.Findings = append(d.Findings, fmt.Sprintf("Hop %d is new: %s", hop.TTL, routerList(hop.CurrentRouters)))
+		case HopRemoved:
+			d.Findings = append(d.Findings, fmt.Sprintf("Hop %d is gone: %s in the baseline", hop.TTL, routerList(hop.BaselineRouters)))
+		}
+		if shift := d.Latency.Hop(hop.TTL); shift != nil {
+			if finding := shift.finding(fmt.Sprintf("Hop %d latency", hop.TTL), d.Latency.Alpha); finding != "" {
+				d.Findings = append(d.Findings, finding)
+			}
+		}
+		if hop.LossDelta >= lossChange {
+			d.Findings = append(d.Findings, fmt.Sprintf("Hop %d loss rose from %.1f%% to %.1f%%", hop.TTL, hop.BaselineLoss, hop.CurrentLoss))
+		}
+	}
+
+	for _, diamond := range d.AddedDiamonds {
+		d.Findings = append(d.Findings, fmt.Sprintf("New load balancing: %s", describeDiamond(diamond)))
+	}
+	for _, diamond := range d.RemovedDiamonds {
+		d.Findings = append(d.Findings, fmt.Sprintf("Load balancing gone: %s", describeDiamond(diamond)))
+	}
+	if len(d.AddedPaths) > 0 || len(d.RemovedPaths) > 0 {
+		d.Findings = append(d.Findings, fmt.Sprintf("%d new and %d vanished path(s)", len(d.AddedPaths), len(d.RemovedPaths)))
+	}
+
+	if len(d.Findings) == 0 {
+		d.Findings = append(d.Findings, "No significant change: same routers, latency and loss as the baseline")
+	}
+}
+
+// significantRTT reports whether a latency increase looks worth a mention
+// when there are too few probes to test it
+func significantRTT(base, delta time.Duration) bool {
+	return base > 0 && delta >= latencyChange && float64(delta) >= latencyChangeRelative*float64(base)
+}
+
+// lastAnswer returns the highest TTL where the current trace got an answer
+func lastAnswer(hops []HopDiff) uint8 {
+	var last uint8
+	for _, hop := range hops {
+		if len(hop.CurrentRouters) > 0 {
+			last = hop.TTL
+		}
+	}
+	return last
+}
+
+func routerList(ips []string) string {
+	if len(ips) == 0 {
+		return "no answer"
+	}
+	return strings.Join(ips, ", ")
+}
+
+func describeDiamond(d Diamond) string {
+	if d.Convergence == nil {
+		return fmt.Sprintf("%d-wide split at hop %d (%s)", d.Width, d.Divergence.TTL, d.Divergence.IP)
+	}
+	return fmt.Sprintf("%d-wide diamond from hop %d (%s) to hop %d (%s)",
+		d.Width, d.Divergence.TTL, d.Divergence.IP, d.Convergence.TTL, d.Convergence.IP)
+}
+
+// ToJSON converts the diff to JSON format
+func (d *TraceDiff) ToJSON() (string, error) {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal to JSON: %w", err)
+	}
+	return string(data), nil
+}
+
+// PrintReport prints the findings and the hop-by-hop comparison
+func (d *TraceDiff) PrintReport() {
+	fmt.Println("\n" + strings.Repeat("=", 100))
+	fmt.Printf("Trace Comparison: %s\n", d.Target)
+	fmt.Println(strings.Repeat("=", 100))
+
+	fmt.Printf("%-22s %-36s %-36s\n", "", "Baseline", "Current")
+	fmt.Printf("%-22s %-36s %-36s\n", "Started", d.BaselineStart.Format(time.RFC1123), d.CurrentStart.Format(time.RFC1123))
+	fmt.Printf("%-22s %-36s %-36s\n", "Reached", yesNo(d.Baseline.Reached), yesNo(d.Current.Reached))
+	fmt.Printf("%-22s %-36d %-36d\n", "Hops", d.Baseline.HopCount, d.Current.HopCount)
+	fmt.Printf("%-22s %-36s %-36s\n", "End-to-end RTT", formatRTT(d.Baseline.EndToEndRTT), formatRTT(d.Current.EndToEndRTT))
+	fmt.Printf("%-22s %-36s %-36s\n", "Packet loss",
+		fmt.Sprintf("%.1f%%", d.Baseline.PacketLossRate), fmt.Sprintf("%.1f%%", d.Current.PacketLossRate))
+	fmt.Println()
+
+	fmt.Printf("%-3s %-8s %-30s %-30s %9s %9s\n", "TTL", "Change", "Baseline", "Current", "ΔRTT", "ΔLoss")
+	fmt.Println(strings.Repeat("-", 100))
+	for _, hop := range d.Hops {
+		delta := "---"
+		if hop.BaselineRTT > 0 && hop.CurrentRTT > 0 {
+			delta = fmt.Sprintf("%+.1fms", milliseconds(hop.RTTDelta))
+		}
+		fmt.Printf("%-3d %-8s %-30s %-30s %9s %8.1f%%\n", hop.TTL, hop.Status,
+			fit(routerList(hop.BaselineRouters), 30), fit(routerList(hop.CurrentRouters), 30), delta, hop.LossDelta)
+	}
+	fmt.Println()
+
+	d.Latency.Print()
+	fmt.Println()
+
+	fmt.Println("Findings:")
+	for _, finding := range d.Findings {
+		fmt.Printf("  • %s\n", finding)
+	}
+	fmt.Println(strings.Repeat("=", 100))
+}
@@ -0,0 +1,118 @@
+package results
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withRTT sets the RTT of every answer at ttl
+func withRTT(tr *TracerouteResult, ttl uint8, rtt time.Duration) *TracerouteResult {
+	for _, flow := range tr.Hops[ttl].Flows {
+		flow.RTT = rtt
+	}
+	return tr
+}
+
+func baselineTrace() *TracerouteResult {
+	tr := trace(1, 2, [][]string{
+		{"192.168.1.1", "10.0.0.1", "10.1.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.2", "8.8.8.8"},
+	})
+	for ttl := uint8(1); ttl <= 4; ttl++ {
+		withRTT(tr, ttl, time.Duration(ttl)*5*time.Millisecond)
+	}
+	return tr
+}
+
+func TestCompareIdenticalTraces(t *testing.T) {
+	d := CompareTraces(baselineTrace(), baselineTrace())
+
+	for _, hop := range d.Hops {
+		if hop.Status != HopSame || hop.RTTDelta != 0 || hop.LossDelta != 0 {
+			t.Errorf("got hop %+v", hop)
+		}
+	}
+	if len(d.AddedPaths)+len(d.RemovedPaths)+len(d.AddedDiamonds)+len(d.RemovedDiamonds) != 0 {
+		t.Errorf("got path or diamond changes: %+v", d)
+	}
+	if len(d.Findings) != 1 || !strings.HasPrefix(d.Findings[0], "No significant change") {
+		t.Errorf("got findings %v", d.Findings)
+	}
+}
+
+func TestCompareReportsChanges(t *testing.T) {
+	// The diamond collapsed to 10.1.0.9, hop 2 got slow and lossy, and the
+	// target stopped answering
+	current := trace(1, 2, [][]string{
+		{"192.168.1.1", "10.0.0.1", "10.1.0.9", ""},
+		{"192.168.1.1", "", "10.1.0.9", ""},
+	})
+	withRTT(current, 1, 5*time.Millisecond)
+	withRTT(current, 2, 40*time.Millisecond)
+	withRTT(current, 3, 45*time.Millisecond)
+
+	d := CompareTraces(baselineTrace(), current)
+
+	want := []HopDiff{
+		{TTL: 1, Status: HopSame, BaselineRouters: []string{"192.168.1.1"}, CurrentRouters: []string{"192.168.1.1"},
+			BaselineRTT: 5 * time.Millisecond, CurrentRTT: 5 * time.Millisecond},
+		{TTL: 2, Status: HopSame, BaselineRouters: []string{"10.0.0.1"}, CurrentRouters: []string{"10.0.0.1"},
+			BaselineRTT: 10 * time.Millisecond, CurrentRTT: 40 * time.Millisecond, RTTDelta: 30 * time.Millisecond,
+			CurrentLoss: 50, LossDelta: 50},
+		{TTL: 3, Status: HopChanged, BaselineRouters: []string{"10.1.0.1", "10.1.0.2"}, CurrentRouters: []string{"10.1.0.9"},
+			NewRouters: []string{"10.1.0.9"}, GoneRouters: []string{"10.1.0.1", "10.1.0.2"},
+			BaselineRTT: 15 * time.Millisecond, CurrentRTT: 45 * time.Millisecond, RTTDelta: 30 * time.Millisecond},
+		{TTL: 4, Status: HopChanged, BaselineRouters: []string{"8.8.8.8"}, GoneRouters: []string{"8.8.8.8"},
+			BaselineRTT: 20 * time.Millisecond, CurrentLoss: 100, LossDelta: 100},
+	}
+	if !reflect.DeepEqual(d.Hops, want) {
+		t.Errorf("got hops\n%+v\nwant\n%+v", d.Hops, want)
+	}
+
+	if !d.ReachabilityLost || d.ReachabilityWon {
+		t.Errorf("got reachability lost %v, won %v", d.ReachabilityLost, d.ReachabilityWon)
+	}
+	if len(d.RemovedDiamonds) != 1 || d.RemovedDiamonds[0].Divergence != (NodeKey{2, "10.0.0.1"}) || len(d.AddedDiamonds) != 0 {
+		t.Errorf("got diamonds added %+v, removed %+v", d.AddedDiamonds, d.RemovedDiamonds)
+	}
+	// The silent hop 2 of the second flow matches 10.0.0.1: one path
+	if len(d.RemovedPaths) != 2 || len(d.AddedPaths) != 1 {
+		t.Errorf("got %d added and %d removed paths, want 1 and 2", len(d.AddedPaths), len(d.RemovedPaths))
+	}
+
+	for _, want := range []string{
+		"The target is no longer reached: probes stopped answering after TTL 3 (reached at TTL 4 in the baseline)",
+		"Hop 2 latency rose by 30.0ms (median 10.0ms to 40.0ms), but 2 and 1 probes cannot tell it from noise: use at least 4 probes per hop in each trace (-count)",
+		"Hop 2 loss rose from 0.0% to 50.0%",
+		"Hop 3 changed: 10.1.0.9 now, 10.1.0.1, 10.1.0.2 in the baseline",
+		"Load balancing gone: 2-wide diamond from hop 2 (10.0.0.1) to hop 4 (8.8.8.8)",
+	} {
+		if !contains(d.Findings, want) {
+			t.Errorf("findings lack %q: %v", want, d.Findings)
+		}
+	}
+}
+
+func TestCompareLongerPath(t *testing.T) {
+	current := trace(1, 2, [][]string{
+		{"192.168.1.1", "10.0.0.1", "10.1.0.1", "10.5.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.2", "10.5.0.1", "8.8.8.8"},
+	})
+	d := CompareTraces(baselineTrace(), current)
+
+	if hop := d.Hops[4]; hop.TTL != 5 || hop.Status != HopAdded || !reflect.DeepEqual(hop.CurrentRouters, []string{"8.8.8.8"}) {
+		t.Errorf("got hop %+v", hop)
+	}
+	if !contains(d.Findings, "The target is 5 hops away, 4 in the baseline") {
+		t.Errorf("got findings %v", d.Findings)
+	}
+}
+
+func TestMergeTTLs(t *testing.T) {
+	got := mergeTTLs([]uint8{1, 2, 5}, []uint8{2, 3, 6, 7})
+	if !reflect.DeepEqual(got, []uint8{1, 2, 3, 5, 6, 7}) {
+		t.Errorf("got %v", got)
+	}
+}
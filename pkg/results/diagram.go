@@ -0,0 +1,280 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Layout of the terminal diagram, in characters
+const (
+	diagramMargin   = 4  // TTL numbers left of the boxes
+	diagramMinWidth = 40 // Narrower terminals get a diagram wider than the screen
+	diagramMinCol   = 12 // Narrowest column per router
+	diagramBox      = 4  // Lines of a box: border, address, RTT or loss, border
+	diagramStride   = 6  // Lines from one TTL to the next
+)
+
+// Directions of a line leaving a cell of the canvas
+const (
+	lineUp = 1 << iota
+	lineDown
+	lineLeft
+	lineRight
+)
+
+// boxRunes draws the cells where lines meet
+var boxRunes = map[uint8]rune{
+	lineUp: '│', lineDown: '│', lineUp | lineDown: '│',
+	lineLeft: '─', lineRight: '─', lineLeft | lineRight: '─',
+	lineDown | lineRight: '┌', lineDown | lineLeft: '┐',
+	lineUp | lineRight: '└', lineUp | lineLeft: '┘',
+	lineUp | lineDown | lineRight: '├', lineUp | lineDown | lineLeft: '┤',
+	lineLeft | lineRight | lineDown: '┬', lineLeft | lineRight | lineUp: '┴',
+	lineUp | lineDown | lineLeft | lineRight: '┼',
+}
+
+// canvas is a grid of characters where lines merge into box-drawing runes
+type canvas struct {
+	lines [][]uint8
+	text  [][]rune
+}
+
+func newCanvas(width, height int) *canvas {
+	c := &canvas{lines: make([][]uint8, height), text: make([][]rune, height)}
+	for y := range c.lines {
+		c.lines[y] = make([]uint8, width)
+		c.text[y] = make([]rune, width)
+	}
+	return c
+}
+
+// hline draws a horizontal line between x1 and x2 on row y
+func (c *canvas) hline(y, x1, x2 int) {
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	for x := x1; x <= x2 && x1 != x2; x++ {
+		if x > x1 {
+			c.lines[y][x] |= lineLeft
+		}
+		if x < x2 {
+			c.lines[y][x] |= lineRight
+		}
+	}
+}
+
+// vline draws a vertical line between y1 and y2 in column x
+func (c *canvas) vline(x, y1, y2 int) {
+	for y := y1; y <= y2; y++ {
+		if y > y1 {
+			c.lines[y][x] |= lineUp
+		}
+		if y < y2 {
+			c.lines[y][x] |= lineDown
+		}
+	}
+}
+
+// box draws a box of width w centred on x, from row y
+func (c *canvas) box(x, y, w int) {
+	left, right := x-w/2, x-w/2+w-1
+	c.hline(y, left, right)
+	c.hline(y+diagramBox-1, left, right)
+	c.vline(left, y, y+diagramBox-1)
+	c.vline(right, y, y+diagramBox-1)
+}
+
+// write puts s centred on x, on row y
+func (c *canvas) write(x, y int, s string) {
+	runes := []rune(s)
+	start := x - len(runes)/2
+	for i, r := range runes {
+		if start+i >= 0 && start+i < len(c.text[y]) {
+			c.text[y][start+i] = r
+		}
+	}
+}
+
+// String renders the canvas, without trailing spaces
+func (c *canvas) String() string {
+	var b strings.Builder
+	for y := range c.lines {
+		row := make([]rune, len(c.lines[y]))
+		for x := range row {
+			switch {
+			case c.text[y][x] != 0:
+				row[x] = c.text[y][x]
+			case c.lines[y][x] != 0:
+				row[x] = boxRunes[c.lines[y][x]]
+			default:
+				row[x] = ' '
+			}
+		}
+		b.WriteString(strings.TrimRight(string(row), " "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// fit shortens s to n characters
+func fit(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	if n < 1 {
+		return ""
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// diagramNode is a box of the diagram
+type diagramNode struct {
+	x     int
+	label [2]string
+}
+
+// Diagram draws the multipath topology as boxes and lines, one row of
+// routers per TTL and one column per branch: lines fan out where a diamond
+// opens and merge back where it closes. Each box shows the router and its
+// mean RTT, or for probes without an answer the share lost at that TTL.
+// The diagram fits width columns when it can.
+func (tr *TracerouteResult) Diagram(width int) string {
+	if width < diagramMinWidth {
+		width = diagramMinWidth
+	}
+	t := tr.topology()
+
+	// One row per TTL, the source first
+	var rows [][]*GraphNode
+	for i, node := range t.nodes {
+		if i == 0 || node.TTL != t.nodes[i-1].TTL {
+			rows = append(rows, nil)
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], node)
+	}
+	widest := 1
+	for _, row := range rows {
+		if len(row) > widest {
+			widest = len(row)
+		}
+	}
+	column := (width - diagramMargin) / widest
+	if column < diagramMinCol {
+		column = diagramMinCol
+	}
+
+	c := newCanvas(diagramMargin+widest*column, len(rows)*diagramStride-(diagramStride-diagramBox))
+	placed := make(map[NodeKey]*diagramNode)
+	for r, row := range rows {
+		row = orderByParents(row, t.edges, placed)
+		offset := diagramMargin + (widest-len(row))*column/2
+		y := r * diagramStride
+		if row[0].TTL > 0 {
+			c.write(1, y+1, fmt.Sprintf("%2d", row[0].TTL))
+		}
+		for i, node := range row {
+			n := &diagramNode{x: offset + i*column + column/2, label: t.diagramLabel(node, tr)}
+			placed[node.NodeKey] = n
+
+			w := len([]rune(n.label[0]))
+			if l := len([]rune(n.label[1])); l > w {
+				w = l
+			}
+			w += 4
+			if w > column-1 {
+				w = column - 1
+			}
+			c.box(n.x, y, w)
+			c.write(n.x, y+1, fit(n.label[0], w-4))
+			c.write(n.x, y+2, fit(n.label[1], w-4))
+		}
+	}
+
+	// Lines from the bottom of each box to the top of its next hops
+	for _, edge := range t.edges {
+		from, to := placed[edge.From], placed[edge.To]
+		if from == nil || to == nil {
+			continue
+		}
+		bottom := rowOf(rows, edge.From)*diagramStride + diagramBox - 1
+		top := rowOf(rows, edge.To) * diagramStride
+		c.vline(from.x, bottom, bottom+1)
+		c.hline(bottom+1, from.x, to.x)
+		c.vline(to.x, bottom+1, top)
+	}
+	return c.String()
+}
+
+// diagramLabel returns the two lines of text in the box of node
+func (t *topology) diagramLabel(node *GraphNode, tr *TracerouteResult) [2]string {
+	switch {
+	case node.TTL == 0 && tr.SrcIP == "":
+		return [2]string{"source", ""}
+	case node.TTL == 0:
+		return [2]string{tr.SrcIP, "source"}
+	case node.IP == AnonymousIP:
+		return [2]string{"*", fmt.Sprintf("%.0f%% lost", t.lossRate(node)*100)}
+	}
+	second := fmt.Sprintf("%.1f ms", milliseconds(node.RTT))
+	if _, ok := t.nats[node.NodeKey]; ok {
+		second += " NAT"
+	}
+	return [2]string{node.IP, second}
+}
+
+// orderByParents sorts the nodes of a row by the mean column of the boxes
+// leading to them, so that branches run straight down; silent hops go
+// after the routers they tie with
+func orderByParents(row []*GraphNode, edges []*GraphEdge, placed map[NodeKey]*diagramNode) []*GraphNode {
+	centre := make(map[NodeKey]float64)
+	for _, node := range row {
+		sum, n := 0, 0
+		for _, edge := range edges {
+			if parent, ok := placed[edge.From]; ok && edge.To == node.NodeKey {
+				sum += parent.x
+				n++
+			}
+		}
+		if n > 0 {
+			centre[node.NodeKey] = float64(sum) / float64(n)
+		}
+	}
+	sorted := append([]*GraphNode(nil), row...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].NodeKey, sorted[j].NodeKey
+		if centre[a] != centre[b] {
+			return centre[a] < centre[b]
+		}
+		return a.IP != AnonymousIP && b.IP == AnonymousIP
+	})
+	return sorted
+}
+
+// rowOf returns the row of the diagram holding key
+func rowOf(rows [][]*GraphNode, key NodeKey) int {
+	for r, row := range rows {
+		if row[0].TTL == key.TTL {
+			return r
+		}
+	}
+	return -1
+}
+
+// PrintDiagram prints the multipath diagram of the trace, width columns wide
+func (tr *TracerouteResult) PrintDiagram(width int) {
+	fmt.Println()
+	fmt.Println(strings.Repeat("─", 80))
+	fmt.Printf("🗺️  PATH DIAGRAM: %s\n", tr.Target)
+	fmt.Println(strings.Repeat("─", 80))
+	fmt.Print(tr.Diagram(width))
+	fmt.Println()
+}
@@ -0,0 +1,70 @@
+package results
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDiagramDrawsDiamond(t *testing.T) {
+	tr := trace(1, 8, [][]string{
+		{"192.168.1.1", "10.0.0.1", "10.1.0.1", "10.1.1.1", "10.2.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.2", "10.1.1.2", "10.2.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.3", "", "10.2.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.1", "10.1.1.1", "10.2.0.1", "8.8.8.8"},
+	})
+	lines := strings.Split(tr.Diagram(60), "\n")
+
+	has := func(want string) int {
+		for i, line := range lines {
+			if strings.Contains(line, want) {
+				return i
+			}
+		}
+		t.Errorf("diagram lacks %q:\n%s", want, strings.Join(lines, "\n"))
+		return -1
+	}
+	// The diamond opens under 10.0.0.1 and closes over 10.2.0.1
+	fanOut := has("┌─────────────────┼─────────────────┐")
+	merge := has("└─────────────────┼─────────────────┘")
+	if fanOut > has("│ 10.1.0.1 │      │ 10.1.0.2 │      │ 10.1.0.3 │") || merge < has("│ 10.1.1.1 │      │ 10.1.1.2 │      │     *    │") {
+		t.Error("branches are not between the fan-out and the merge")
+	}
+	has("│ 25% lost │")
+	if has(" 6 ") < merge {
+		t.Error("target row is not last")
+	}
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > 60 {
+			t.Errorf("line of %d columns: %q", n, line)
+		}
+	}
+}
+
+func TestDiagramNarrowTerminal(t *testing.T) {
+	tr := trace(1, 4, [][]string{
+		{"10.0.0.1", "2001:db8:aaaa:bbbb:cccc:dddd:eeee:1"},
+		{"10.0.0.1", "2001:db8:aaaa:bbbb:cccc:dddd:eeee:2"},
+		{"10.0.0.1", "2001:db8:aaaa:bbbb:cccc:dddd:eeee:3"},
+		{"10.0.0.1", "2001:db8:aaaa:bbbb:cccc:dddd:eeee:4"},
+	})
+	diagram := tr.Diagram(20) // below the minimum, so 40 columns
+	if !strings.Contains(diagram, "│ 2001:d… │") {
+		t.Errorf("long addresses are not shortened:\n%s", diagram)
+	}
+	for _, line := range strings.Split(diagram, "\n") {
+		if n := utf8.RuneCountInString(line); n > diagramMargin+4*diagramMinCol {
+			t.Errorf("line of %d columns: %q", n, line)
+		}
+	}
+}
+
+func TestCanvasJunctions(t *testing.T) {
+	c := newCanvas(5, 3)
+	c.hline(1, 0, 4)
+	c.vline(2, 0, 2)
+	c.vline(0, 1, 2)
+	if got := c.String(); got != "  │\n┌─┼──\n│ │\n" {
+		t.Errorf("got\n%s", got)
+	}
+}
@@ -0,0 +1,336 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prefix lengths treated as "the destination network" when no hostname
+// matches: roughly the size of a provider allocation in each family
+const (
+	destinationPrefixIPv4 = 16
+	destinationPrefixIPv6 = 32
+)
+
+// similarRTT is the end-to-end difference below which neither family is
+// reported as faster
+const similarRTT = time.Millisecond
+
+// DualStackComparison compares traces to the IPv4 and IPv6 addresses of
+// the same hostname
+type DualStackComparison struct {
+	Hostname string            `json:"hostname"`
+	IPv4     *TracerouteResult `json:"ipv4"`
+	IPv6     *TracerouteResult `json:"ipv6"`
+
+	IPv4Summary FamilySummary  `json:"ipv4_summary"`
+	IPv6Summary FamilySummary  `json:"ipv6_summary"`
+	Hops        []DualStackHop `json:"hops"`
+
+	Faster        string        `json:"faster,omitempty"` // "ipv4", "ipv6", or empty when similar or unknown
+	RTTDifference time.Duration `json:"rtt_difference"`   // IPv6 minus IPv4 end-to-end RTT
+	Findings      []string      `json:"findings,omitempty"`
+}
+
+// FamilySummary condenses one family's trace for the comparison
+type FamilySummary struct {
+	Target         string        `json:"target"`
+	Reached        bool          `json:"reached"`
+	HopCount       int           `json:"hop_count"` // TTL of the target, or the last TTL probed
+	EndToEndRTT    time.Duration `json:"end_to_end_rtt,omitempty"`
+	PacketLossRate float64       `json:"packet_loss_rate"`
+	EntryTTL       uint8         `json:"entry_ttl,omitempty"` // First hop inside the destination network
+	EntryIP        string        `json:"entry_ip,omitempty"`
+}
+
+// DualStackHop puts the most common responder of each family at one TTL side by side
+type DualStackHop struct {
+	TTL      uint8         `json:"ttl"`
+	IPv4Host string        `json:"ipv4_host,omitempty"`
+	IPv4RTT  time.Duration `json:"ipv4_rtt,omitempty"`
+	IPv6Host string        `json:"ipv6_host,omitempty"`
+	IPv6RTT  time.Duration `json:"ipv6_rtt,omitempty"`
+}
+
+// CompareDualStack builds the side-by-side comparison of an IPv4 and an
+// IPv6 trace of hostname
+func CompareDualStack(hostname string, ipv4, ipv6 *TracerouteResult) *DualStackComparison {
+	c := &DualStackComparison{
+		Hostname:    hostname,
+		IPv4:        ipv4,
+		IPv6:        ipv6,
+		IPv4Summary: summarizeFamily(hostname, ipv4),
+		IPv6Summary: summarizeFamily(hostname, ipv6),
+		Hops:        make([]DualStackHop, 0),
+		Findings:    make([]string, 0),
+	}
+
+	stats4 := ipv4.CalculateHopStatistics()
+	stats6 := ipv6.CalculateHopStatistics()
+	last := c.IPv4Summary.HopCount
+	if c.IPv6Summary.HopCount > last {
+		last = c.IPv6Summary.HopCount
+	}
+	for ttl := 1; ttl <= last; ttl++ {
+		hop := DualStackHop{TTL: uint8(ttl)}
+		if stat, ok := stats4[uint8(ttl)]; ok {
+			hop.IPv4Host, hop.IPv4RTT = stat.IP, stat.AvgRTT
+		}
+		if stat, ok := stats6[uint8(ttl)]; ok {
+			hop.IPv6Host, hop.IPv6RTT = stat.IP, stat.AvgRTT
+		}
+		c.Hops = append(c.Hops, hop)
+	}
+
+	c.compare()
+	return c
+}
+
+// compare fills in the faster family and the findings
+func (c *DualStackComparison) compare() {
+	v4, v6 := c.IPv4Summary, c.IPv6Summary
+
+	switch {
+	case v4.Reached && !v6.Reached:
+		c.Findings = append(c.Findings, fmt.Sprintf(
+			"Only IPv6 is broken: %s was reached over IPv4 but IPv6 probes stopped answering after TTL %d",
+			c.Hostname, lastResponsiveTTL(c.IPv6)))
+	case !v4.Reached && v6.Reached:
+		c.Findings = append(c.Findings, fmt.Sprintf(
+			"Only IPv4 is broken: %s was reached over IPv6 but IPv4 probes stopped answering after TTL %d",
+			c.Hostname, lastResponsiveTTL(c.IPv4)))
+	case !v4.Reached && !v6.Reached:
+		c.Findings = append(c.Findings, "Neither family reached the target - the problem is not specific to IPv6")
+	}
+
+	if v4.Reached && v6.Reached {
+		c.RTTDifference = v6.EndToEndRTT - v4.EndToEndRTT
+		switch {
+		case c.RTTDifference <= -similarRTT:
+			c.Faster = "ipv6"
+		case c.RTTDifference >= similarRTT:
+			c.Faster = "ipv4"
+		}
+
+		if c.Faster == "" {
+			c.Findings = append(c.Findings, "Both families have similar end-to-end latency")
+		} else {
+			diff := c.RTTDifference
+			if diff < 0 {
+				diff = -diff
+			}
+			c.Findings = append(c.Findings, fmt.Sprintf("%s is faster end to end by %v",
+				familyName(c.Faster), diff.Round(10*time.Microsecond)))
+		}
+
+		if v4.HopCount != v6.HopCount {
+			c.Findings = append(c.Findings, fmt.Sprintf("Hop counts differ: %d over IPv4, %d over IPv6 - the families take different routes",
+				v4.HopCount, v6.HopCount))
+		}
+	}
+
+	if v4.EntryTTL != 0 && v6.EntryTTL != 0 && v4.EntryTTL != v6.EntryTTL {
+		c.Findings = append(c.Findings, fmt.Sprintf("Paths enter the destination network at different hops: TTL %d over IPv4, TTL %d over IPv6",
+			v4.EntryTTL, v6.EntryTTL))
+	}
+}
+
+// summarizeFamily extracts hop count, end-to-end latency and the entry point
+// into the destination network from a trace
+func summarizeFamily(hostname string, tr *TracerouteResult) FamilySummary {
+	summary := FamilySummary{
+		Target:         tr.Target,
+		HopCount:       tr.GetHopCount(),
+		PacketLossRate: tr.AnalyzeNetwork().PacketLossRate,
+	}
+
+	var total time.Duration
+	count := 0
+	for ttl, hopResult := range tr.Hops {
+		for _, flow := range hopResult.Flows {
+			if flow.Error != "" || flow.ResponseIP != tr.Target {
+				continue
+			}
+			if !summary.Reached || int(ttl) < summary.HopCount {
+				summary.HopCount = int(ttl)
+			}
+			summary.Reached = true
+			if flow.RTT > 0 {
+				total += flow.RTT
+				count++
+			}
+		}
+	}
+	if count > 0 {
+		summary.EndToEndRTT = total / time.Duration(count)
+	}
+
+	summary.EntryTTL, summary.EntryIP = destinationEntry(hostname, tr, summary.HopCount)
+	return summary
+}
+
+// destinationEntry finds the first hop from which every responding hop up
+// to lastTTL belongs to the destination network. A hop belongs to it if it
+// shares the target's prefix or its reverse DNS name ends in the domain of
+// hostname. This is a heuristic: without routing data, networks that
+// number their routers from unrelated space are not recognised.
+func destinationEntry(hostname string, tr *TracerouteResult, lastTTL int) (uint8, string) {
+	target := net.ParseIP(tr.Target)
+	if target == nil {
+		return 0, ""
+	}
+	addr, prefix, bits := target.To4(), destinationPrefixIPv4, 32
+	if addr == nil {
+		addr, prefix, bits = target.To16(), destinationPrefixIPv6, 128
+	}
+	mask := net.CIDRMask(prefix, bits)
+	network := &net.IPNet{IP: addr.Mask(mask), Mask: mask}
+	domain := registeredDomain(hostname)
+
+	stats := tr.CalculateHopStatistics()
+	var entryTTL uint8
+	var entryIP string
+	for ttl := 1; ttl <= lastTTL; ttl++ {
+		stat, ok := stats[uint8(ttl)]
+		if !ok || stat.IP == "" {
+			continue // Silent hops do not break the run
+		}
+
+		inside := false
+		if ip := net.ParseIP(stat.IP); ip != nil && network.Contains(ip) {
+			inside = true
+		}
+		if domain != "" && strings.HasSuffix(strings.TrimSuffix(stat.Hostname, "."), "."+domain) {
+			inside = true
+		}
+
+		if !inside {
+			entryTTL, entryIP = 0, ""
+		} else if entryTTL == 0 {
+			entryTTL, entryIP = uint8(ttl), stat.IP
+		}
+	}
+	return entryTTL, entryIP
+}
+
+// registeredDomain returns the last two labels of hostname, or "" for IP
+// literals and single-label names
+func registeredDomain(hostname string) string {
+	if net.ParseIP(hostname) != nil {
+		return ""
+	}
+	labels := strings.Split(strings.TrimSuffix(hostname, "."), ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// lastResponsiveTTL returns the highest TTL with any reply
+func lastResponsiveTTL(tr *TracerouteResult) uint8 {
+	ttls := make([]int, 0, len(tr.Hops))
+	for ttl := range tr.Hops {
+		ttls = append(ttls, int(ttl))
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ttls)))
+
+	for _, ttl := range ttls {
+		for _, flow := range tr.Hops[uint8(ttl)].Flows {
+			if flow.Error == "" && flow.ResponseIP != "" {
+				return uint8(ttl)
+			}
+		}
+	}
+	return 0
+}
+
+func familyName(family string) string {
+	if family == "ipv6" {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// ToJSON converts the comparison, including both traces, to JSON format
+func (c *DualStackComparison) ToJSON() (string, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal to JSON: %w", err)
+	}
+	return string(data), nil
+}
+
+// PrintReport prints the side-by-side comparison
+func (c *DualStackComparison) PrintReport() {
+	fmt.Println("\n" + strings.Repeat("=", 100))
+	fmt.Printf("Dual-Stack Comparison: %s\n", c.Hostname)
+	fmt.Println(strings.Repeat("=", 100))
+
+	fmt.Printf("%-22s %-36s %-36s\n", "", "IPv4", "IPv6")
+	fmt.Printf("%-22s %-36s %-36s\n", "Target", c.IPv4Summary.Target, c.IPv6Summary.Target)
+	fmt.Printf("%-22s %-36s %-36s\n", "Reached", yesNo(c.IPv4Summary.Reached), yesNo(c.IPv6Summary.Reached))
+	fmt.Printf("%-22s %-36d %-36d\n", "Hops", c.IPv4Summary.HopCount, c.IPv6Summary.HopCount)
+	fmt.Printf("%-22s %-36s %-36s\n", "End-to-end RTT", formatRTT(c.IPv4Summary.EndToEndRTT), formatRTT(c.IPv6Summary.EndToEndRTT))
+	fmt.Printf("%-22s %-36s %-36s\n", "Packet loss",
+		fmt.Sprintf("%.1f%%", c.IPv4Summary.PacketLossRate), fmt.Sprintf("%.1f%%", c.IPv6Summary.PacketLossRate))
+	fmt.Printf("%-22s %-36s %-36s\n", "Enters dest. network", formatEntry(c.IPv4Summary), formatEntry(c.IPv6Summary))
+	fmt.Println()
+
+	fmt.Printf("%-3s %-40s %8s   %-40s %8s\n", "TTL", "IPv4 hop", "RTT", "IPv6 hop", "RTT")
+	fmt.Println(strings.Repeat("-", 100))
+	for _, hop := range c.Hops {
+		fmt.Printf("%-3d %-40s %8s   %-40s %8s\n",
+			hop.TTL, formatHost(hop.IPv4Host), formatRTT(hop.IPv4RTT), formatHost(hop.IPv6Host), formatRTT(hop.IPv6RTT))
+	}
+	fmt.Println()
+
+	if len(c.Findings) > 0 {
+		fmt.Println("Findings:")
+		for _, finding := range c.Findings {
+			fmt.Printf("  • %s\n", finding)
+		}
+	}
+	fmt.Println(strings.Repeat("=", 100))
+}
+
+func yesNo(ok bool) string {
+	if ok {
+		return "yes"
+	}
+	return "no"
+}
+
+func formatRTT(rtt time.Duration) string {
+	if rtt <= 0 {
+		return "---"
+	}
+	return fmt.Sprintf("%.1fms", float64(rtt.Microseconds())/1000.0)
+}
+
+func formatHost(host string) string {
+	if host == "" {
+		return "???"
+	}
+	if len(host) > 40 {
+		return host[:37] + "..."
+	}
+	return host
+}
+
+func formatEntry(summary FamilySummary) string {
+	if summary.EntryTTL == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("TTL %d (%s)", summary.EntryTTL, summary.EntryIP)
+}
@@ -0,0 +1,95 @@
+package results
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildTrace returns a single-flow trace where hops[i] answered at TTL i+1
+// after rtts[i]; an empty hop is a timeout
+func buildTrace(target string, hops []string, rtts []time.Duration) *TracerouteResult {
+	tr := &TracerouteResult{Target: target, Hops: make(map[uint8]*HopResult)}
+	for i, ip := range hops {
+		ttl := uint8(i + 1)
+		flow := &FlowResult{FlowID: 0, ResponseIP: ip, RTT: rtts[i]}
+		if ip == "" {
+			flow.Error = "timeout"
+			flow.RTT = 0
+		}
+		tr.Hops[ttl] = &HopResult{TTL: ttl, Flows: map[uint16]*FlowResult{0: flow}}
+	}
+	return tr
+}
+
+func ms(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
+
+func TestCompareDualStack(t *testing.T) {
+	v4 := buildTrace("93.184.216.34",
+		[]string{"192.168.1.1", "10.0.0.1", "93.184.1.1", "93.184.216.34"},
+		[]time.Duration{ms(1), ms(5), ms(20), ms(22)})
+	v6 := buildTrace("2606:2800:220:1::1",
+		[]string{"2001:db8::1", "2001:db8:ff::1", "2606:2800:1::1", "2606:2800:2::1", "2606:2800:220:1::1"},
+		[]time.Duration{ms(1), ms(4), ms(12), ms(13), ms(14)})
+
+	c := CompareDualStack("www.example.com", v4, v6)
+
+	if !c.IPv4Summary.Reached || !c.IPv6Summary.Reached {
+		t.Fatal("both families reached the target")
+	}
+	if c.IPv4Summary.HopCount != 4 || c.IPv6Summary.HopCount != 5 {
+		t.Errorf("got hop counts %d/%d, want 4/5", c.IPv4Summary.HopCount, c.IPv6Summary.HopCount)
+	}
+	if c.Faster != "ipv6" || c.RTTDifference != -ms(8) {
+		t.Errorf("got faster %q by %v, want ipv6 by -8ms", c.Faster, c.RTTDifference)
+	}
+	if c.IPv4Summary.EntryTTL != 3 || c.IPv4Summary.EntryIP != "93.184.1.1" {
+		t.Errorf("IPv4 entry: got TTL %d (%s), want TTL 3", c.IPv4Summary.EntryTTL, c.IPv4Summary.EntryIP)
+	}
+	if c.IPv6Summary.EntryTTL != 3 {
+		t.Errorf("IPv6 entry: got TTL %d, want TTL 3", c.IPv6Summary.EntryTTL)
+	}
+
+	if len(c.Hops) != 5 {
+		t.Fatalf("got %d side-by-side rows, want 5", len(c.Hops))
+	}
+	if row := c.Hops[4]; row.IPv4Host != "" || row.IPv6Host != "2606:2800:220:1::1" {
+		t.Errorf("TTL 5: got %q / %q", row.IPv4Host, row.IPv6Host)
+	}
+	if c.Hops[1].IPv4RTT != ms(5) || c.Hops[1].IPv6RTT != ms(4) {
+		t.Errorf("TTL 2 latency: got %v / %v", c.Hops[1].IPv4RTT, c.Hops[1].IPv6RTT)
+	}
+}
+
+func TestCompareDualStackBrokenIPv6(t *testing.T) {
+	v4 := buildTrace("93.184.216.34",
+		[]string{"192.168.1.1", "93.184.216.34"},
+		[]time.Duration{ms(1), ms(10)})
+	v6 := buildTrace("2606:2800:220:1::1",
+		[]string{"2001:db8::1", "2001:db8:ff::1", "", ""},
+		[]time.Duration{ms(1), ms(3), 0, 0})
+
+	c := CompareDualStack("www.example.com", v4, v6)
+
+	if c.IPv6Summary.Reached || c.Faster != "" {
+		t.Errorf("IPv6 did not reach the target: reached=%v faster=%q", c.IPv6Summary.Reached, c.Faster)
+	}
+	if len(c.Findings) == 0 || !strings.Contains(c.Findings[0], "Only IPv6 is broken") ||
+		!strings.Contains(c.Findings[0], "after TTL 2") {
+		t.Errorf("got findings %v", c.Findings)
+	}
+}
+
+func TestDestinationEntryByHostname(t *testing.T) {
+	tr := buildTrace("198.51.100.7",
+		[]string{"192.168.1.1", "203.0.113.9", "198.51.100.7"},
+		[]time.Duration{ms(1), ms(2), ms(3)})
+	tr.Hops[2].Flows[0].Hostname = "edge1.fra.example.net."
+
+	ttl, ip := destinationEntry("www.example.net", tr, 3)
+	if ttl != 2 || ip != "203.0.113.9" {
+		t.Errorf("got TTL %d (%s), want the router named in the target's domain", ttl, ip)
+	}
+}
@@ -0,0 +1,305 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// topology is the graph of a trace as exported: the routers plus a source
+// node at TTL 0, with the NAT translations and losses marked
+type topology struct {
+	source NodeKey
+	nodes  []*GraphNode
+	edges  []*GraphEdge
+	nats   map[NodeKey][]string // Fields each NAT rewrites
+	sent   map[uint8]int        // Probes at each TTL, to rate the losses
+}
+
+// topology builds the exported view of the trace
+func (tr *TracerouteResult) topology() *topology {
+	g := tr.Graph()
+	t := &topology{
+		source: NodeKey{TTL: 0, IP: tr.SrcIP},
+		nats:   make(map[NodeKey][]string),
+		sent:   make(map[uint8]int),
+	}
+
+	src := &GraphNode{NodeKey: t.source}
+	t.nodes = append([]*GraphNode{src}, g.Nodes...)
+	for _, node := range g.Nodes {
+		t.sent[node.TTL] += node.Probes
+		if len(g.ttls) > 0 && node.TTL == g.ttls[0] {
+			t.edges = append(t.edges, &GraphEdge{From: t.source, To: node.NodeKey, Flows: node.Flows, Count: node.Probes})
+		}
+	}
+	t.edges = append(t.edges, g.Edges...)
+
+	for _, nat := range tr.detectNATs() {
+		key := NodeKey{TTL: nat.TTL, IP: nat.IP}
+		if nat.TTL == 0 {
+			key = t.source // Translated before the first hop
+		}
+		t.nats[key] = append(t.nats[key], nat.Rewritten...)
+	}
+	return t
+}
+
+// nodeID names a node in the exported graph
+func nodeID(key NodeKey) string {
+	switch {
+	case key.TTL == 0:
+		return "source"
+	case key.IP == AnonymousIP:
+		return fmt.Sprintf("ttl%d_anonymous", key.TTL)
+	}
+	return fmt.Sprintf("ttl%d_%s", key.TTL, key.IP)
+}
+
+// lossRate is the share of the probes at the TTL of node that it lost
+func (t *topology) lossRate(node *GraphNode) float64 {
+	if node.IP != AnonymousIP || t.sent[node.TTL] == 0 {
+		return 0
+	}
+	return float64(node.Probes) / float64(t.sent[node.TTL])
+}
+
+// rttDelta is the RTT added by an edge, when both ends answered
+func (t *topology) rttDelta(edge *GraphEdge, nodes map[NodeKey]*GraphNode) (time.Duration, bool) {
+	from, to := nodes[edge.From], nodes[edge.To]
+	if edge.From.TTL == 0 || from.IP == AnonymousIP || to.IP == AnonymousIP {
+		return 0, false
+	}
+	return to.RTT - from.RTT, true
+}
+
+// flowLabel describes the flows of an edge: port ranges, or ICMP checksums
+func flowLabel(flows []FlowTuple) string {
+	if len(flows) == 0 {
+		return ""
+	}
+	if flows[0].SrcPort == 0 && flows[0].DstPort == 0 {
+		sums := make([]string, len(flows))
+		for i, tuple := range flows {
+			sums[i] = fmt.Sprintf("%#04x", tuple.Checksum)
+		}
+		return "checksum " + strings.Join(sums, ",")
+	}
+	var src, dst []uint16
+	for _, tuple := range flows {
+		src = append(src, tuple.SrcPort)
+		dst = append(dst, tuple.DstPort)
+	}
+	return portRanges(src) + " → " + portRanges(dst)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// ToDOT renders the multipath topology in Graphviz DOT: one node per router
+// and TTL with its mean RTT, edges labelled with the flows that took them
+// and the RTT they add. NATs are drawn orange, lost probes as dashed red
+// anonymous nodes.
+func (tr *TracerouteResult) ToDOT() (string, error) {
+	t := tr.topology()
+	nodes := make(map[NodeKey]*GraphNode)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote("dublin-traceroute "+tr.Target))
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=box, fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n\n")
+
+	var rank []string
+	for i, node := range t.nodes {
+		nodes[node.NodeKey] = node
+		var label []string
+		var attrs []string
+
+		switch {
+		case node.TTL == 0:
+			label = append(label, "source", tr.SrcIP)
+			attrs = append(attrs, "shape=ellipse")
+		case node.IP == AnonymousIP:
+			label = append(label, "*", fmt.Sprintf("%d lost (%.0f%%)", node.Probes, t.lossRate(node)*100))
+			attrs = append(attrs, "shape=ellipse", "style=dashed", "color=red", "fontcolor=red")
+		default:
+			label = append(label, node.IP)
+			if node.Hostname != "" && node.Hostname != node.IP {
+				label = append(label, node.Hostname)
+			}
+			label = append(label, fmt.Sprintf("TTL %d, %.2f ms", node.TTL, milliseconds(node.RTT)))
+			if node.IP == tr.Target {
+				attrs = append(attrs, "style=filled", "fillcolor=palegreen")
+			}
+		}
+		if fields, ok := t.nats[node.NodeKey]; ok {
+			label = append(label, "NAT: "+strings.Join(fields, ", "))
+			attrs = append(attrs, "color=orange", "penwidth=2")
+		}
+
+		attrs = append([]string{"label=" + dotQuote(strings.Join(label, "\n"))}, attrs...)
+		fmt.Fprintf(&b, "  %s [%s];\n", dotQuote(nodeID(node.NodeKey)), strings.Join(attrs, ", "))
+
+		// Keep each TTL on one rank, even where a flow skipped a hop
+		rank = append(rank, dotQuote(nodeID(node.NodeKey)))
+		if i == len(t.nodes)-1 || t.nodes[i+1].TTL != node.TTL {
+			fmt.Fprintf(&b, "  { rank=same; %s; }\n", strings.Join(rank, "; "))
+			rank = nil
+		}
+	}
+	b.WriteString("\n")
+
+	for _, edge := range t.edges {
+		label := []string{flowLabel(edge.Flows), plural(len(edge.Flows), "flow")}
+		if edge.Count > len(edge.Flows) {
+			label[1] += ", " + plural(edge.Count, "probe") // MTR rounds
+		}
+		if delta, ok := t.rttDelta(edge, nodes); ok {
+			label = append(label, fmt.Sprintf("%+.2f ms", milliseconds(delta)))
+		}
+		attrs := []string{"label=" + dotQuote(strings.Join(label, "\n"))}
+		if edge.To.IP == AnonymousIP {
+			attrs = append(attrs, "style=dashed", "color=red")
+		}
+		fmt.Fprintf(&b, "  %s -> %s [%s];\n", dotQuote(nodeID(edge.From)), dotQuote(nodeID(edge.To)), strings.Join(attrs, ", "))
+	}
+	b.WriteString("}\n")
+
+	return b.String(), nil
+}
+
+// plural formats n things, e.g. "1 flow" or "3 flows"
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// dotQuote quotes s as a DOT string; newlines become centred line breaks
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// GraphML document, as read by yEd, Gephi and networkx
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string         `xml:"id,attr"`
+	Data []graphMLDatum `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string         `xml:"source,attr"`
+	Target string         `xml:"target,attr"`
+	Data   []graphMLDatum `xml:"data"`
+}
+
+type graphMLDatum struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// graphMLKeys declares the attributes of nodes and edges
+var graphMLKeys = []graphMLKey{
+	{"ip", "node", "ip", "string"},
+	{"ttl", "node", "ttl", "int"},
+	{"hostname", "node", "hostname", "string"},
+	{"rtt_ms", "node", "rtt_ms", "double"},
+	{"probes", "node", "probes", "int"},
+	{"anonymous", "node", "anonymous", "boolean"},
+	{"loss", "node", "loss", "double"},
+	{"target", "node", "target", "boolean"},
+	{"nat", "node", "nat", "string"},
+	{"flows", "edge", "flows", "string"},
+	{"flow_count", "edge", "flow_count", "int"},
+	{"edge_probes", "edge", "probes", "int"},
+	{"rtt_delta_ms", "edge", "rtt_delta_ms", "double"},
+}
+
+// ToGraphML renders the multipath topology in GraphML, with the same
+// annotations as ToDOT stored as node and edge attributes
+func (tr *TracerouteResult) ToGraphML() (string, error) {
+	t := tr.topology()
+	nodes := make(map[NodeKey]*GraphNode)
+
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  graphMLKeys,
+		Graph: graphMLGraph{ID: tr.Target, EdgeDefault: "directed"},
+	}
+
+	for _, node := range t.nodes {
+		nodes[node.NodeKey] = node
+		data := []graphMLDatum{
+			{"ip", node.IP},
+			{"ttl", fmt.Sprint(node.TTL)},
+			{"probes", fmt.Sprint(node.Probes)},
+			{"anonymous", fmt.Sprint(node.IP == AnonymousIP)},
+			{"target", fmt.Sprint(node.IP == tr.Target)},
+		}
+		if node.Hostname != "" {
+			data = append(data, graphMLDatum{"hostname", node.Hostname})
+		}
+		if node.TTL > 0 && node.IP != AnonymousIP {
+			data = append(data, graphMLDatum{"rtt_ms", fmt.Sprintf("%.3f", milliseconds(node.RTT))})
+		}
+		if node.IP == AnonymousIP {
+			data = append(data, graphMLDatum{"loss", fmt.Sprintf("%.3f", t.lossRate(node))})
+		}
+		if fields, ok := t.nats[node.NodeKey]; ok {
+			data = append(data, graphMLDatum{"nat", strings.Join(fields, ",")})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: nodeID(node.NodeKey), Data: data})
+	}
+
+	for _, edge := range t.edges {
+		data := []graphMLDatum{
+			{"flows", flowLabel(edge.Flows)},
+			{"flow_count", fmt.Sprint(len(edge.Flows))},
+			{"edge_probes", fmt.Sprint(edge.Count)},
+		}
+		if delta, ok := t.rttDelta(edge, nodes); ok {
+			data = append(data, graphMLDatum{"rtt_delta_ms", fmt.Sprintf("%.3f", milliseconds(delta))})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: nodeID(edge.From), Target: nodeID(edge.To), Data: data})
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal to GraphML: %w", err)
+	}
+	return xml.Header + string(data) + "\n", nil
+}
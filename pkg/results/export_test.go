@@ -0,0 +1,87 @@
+package results
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportTrace is a diamond at TTL 2 with a silent hop on one branch and a
+// NAT at 10.0.0.1: the probes quoted from TTL 3 on have a new checksum
+func exportTrace() *TracerouteResult {
+	tr := trace(1, 4, [][]string{
+		{"192.168.1.1", "10.0.0.1", "10.1.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.2", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.1", "8.8.8.8"},
+	})
+	tr.SrcIP = "192.168.1.10"
+	for _, hop := range tr.Hops {
+		for _, flow := range hop.Flows {
+			flow.RTT = time.Duration(hop.TTL) * time.Millisecond
+			if hop.TTL < 4 && flow.Error == "" {
+				flow.ICMPType = 11 // Time Exceeded
+			}
+			if hop.TTL >= 3 && flow.Error == "" {
+				flow.QuotedChecksum = 0x1234
+				flow.NATID = 5
+			}
+		}
+	}
+	return tr
+}
+
+func TestToDOT(t *testing.T) {
+	dot, err := exportTrace().ToDOT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`digraph "dublin-traceroute 8.8.8.8" {`,
+		`"source" -> "ttl1_192.168.1.1" [label="33434-33437 → 33434\n4 flows"];`,
+		`"ttl2_10.0.0.1" -> "ttl3_10.1.0.1" [label="33434,33437 → 33434\n2 flows\n+1.00 ms"];`,
+		`"ttl2_10.0.0.1" -> "ttl3_anonymous" [label="33436 → 33434\n1 flow", style=dashed, color=red];`,
+		`"ttl3_anonymous" [label="*\n1 lost (25%)"`,
+		`"ttl2_10.0.0.1" [label="10.0.0.1\nTTL 2, 2.00 ms\nNAT: checksum", color=orange, penwidth=2];`,
+		`"ttl4_8.8.8.8" [label="8.8.8.8\nTTL 4, 4.00 ms", style=filled, fillcolor=palegreen];`,
+		`{ rank=same; "ttl3_anonymous"; "ttl3_10.1.0.1"; "ttl3_10.1.0.2"; }`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output lacks %s:\n%s", want, dot)
+		}
+	}
+}
+
+func TestToGraphML(t *testing.T) {
+	out, err := exportTrace().ToGraphML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("output does not parse: %v", err)
+	}
+
+	// Source, 192.168.1.1, 10.0.0.1, three nodes at TTL 3 and the target
+	if len(doc.Graph.Nodes) != 7 || len(doc.Graph.Edges) != 8 {
+		t.Errorf("got %d nodes and %d edges, want 7 and 8", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+
+	attrs := make(map[string]map[string]string)
+	for _, node := range doc.Graph.Nodes {
+		attrs[node.ID] = make(map[string]string)
+		for _, d := range node.Data {
+			attrs[node.ID][d.Key] = d.Value
+		}
+	}
+	if nat := attrs["ttl2_10.0.0.1"]["nat"]; nat != "checksum" {
+		t.Errorf("got nat %q at 10.0.0.1, want checksum", nat)
+	}
+	if a := attrs["ttl3_anonymous"]; a["anonymous"] != "true" || a["loss"] != "0.250" {
+		t.Errorf("got anonymous node %v", a)
+	}
+	if a := attrs["ttl4_8.8.8.8"]; a["target"] != "true" || a["rtt_ms"] != "4.000" {
+		t.Errorf("got target node %v", a)
+	}
+}
@@ -0,0 +1,227 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FlowTuple is the part of the 5-tuple that differs between flows: the
+// ports, or for ICMP probes the Echo checksum
+type FlowTuple struct {
+	SrcPort  uint16 `json:"src_port"`
+	DstPort  uint16 `json:"dst_port"`
+	Checksum uint16 `json:"checksum,omitempty"` // ICMP probes only
+}
+
+// FlowPaths maps each probed 5-tuple to the path it took, grouped by path,
+// so that application owners can pick source ports that avoid a branch
+type FlowPaths struct {
+	Target string          `json:"target"`
+	Paths  []PathWithFlows `json:"paths"`
+
+	// Tuples whose probes were answered by different routers at a hop, as
+	// behind per-packet balancing; they are grouped by their usual router
+	Unstable []UnstableFlow `json:"unstable,omitempty"`
+}
+
+// PathWithFlows is a distinct path and the 5-tuples that took it
+type PathWithFlows struct {
+	PathID   int         `json:"path_id"`
+	Hops     []PathHop   `json:"hops"` // "*" for hops that did not answer
+	Flows    []FlowTuple `json:"flows"`
+	SrcPorts string      `json:"src_ports"` // Source ports of the flows, as ranges
+	DstPorts string      `json:"dst_ports"`
+	Reached  bool        `json:"reached"`
+}
+
+// UnstableFlow is a 5-tuple answered by several routers at one hop
+type UnstableFlow struct {
+	Flow    FlowTuple `json:"flow"`
+	TTL     uint8     `json:"ttl"`
+	Routers []string  `json:"routers"`
+}
+
+// tupleOf returns the 5-tuple of a probe
+func tupleOf(flow *FlowResult) FlowTuple {
+	if flow.EchoID != 0 {
+		return FlowTuple{Checksum: flow.Checksum}
+	}
+	return FlowTuple{SrcPort: flow.SrcPort, DstPort: flow.DstPort}
+}
+
+// String formats the tuple as src->dst ports, or the ICMP checksum
+func (t FlowTuple) String() string {
+	if t.SrcPort == 0 && t.DstPort == 0 {
+		return fmt.Sprintf("checksum %#04x", t.Checksum)
+	}
+	return fmt.Sprintf("%d->%d", t.SrcPort, t.DstPort)
+}
+
+// MapFlows groups the probes of the trace by 5-tuple, whatever round of an
+// MTR trace sent them, and groups the 5-tuples by the path they took in the
+// graph of the trace. At each hop a tuple takes the router that answered
+// most of its probes.
+func (tr *TracerouteResult) MapFlows() *FlowPaths {
+	paths, unstable := tr.Graph().flowPaths()
+	report := &FlowPaths{Target: tr.Target, Paths: paths, Unstable: unstable}
+	for i := range report.Paths {
+		path := &report.Paths[i]
+		var src, dst []uint16
+		for _, tuple := range path.Flows {
+			src = append(src, tuple.SrcPort)
+			dst = append(dst, tuple.DstPort)
+		}
+		path.SrcPorts = portRanges(src)
+		path.DstPorts = portRanges(dst)
+	}
+	return report
+}
+
+func tupleLess(a, b FlowTuple) bool {
+	if a.SrcPort != b.SrcPort {
+		return a.SrcPort < b.SrcPort
+	}
+	if a.DstPort != b.DstPort {
+		return a.DstPort < b.DstPort
+	}
+	return a.Checksum < b.Checksum
+}
+
+// routeKey identifies a path by the routers at each TTL
+func routeKey(hops []PathHop) string {
+	ips := make([]string, len(hops))
+	for i, hop := range hops {
+		ips[i] = hop.IP
+	}
+	return strings.Join(ips, " ")
+}
+
+// sameRoute reports whether two routes never cross different routers at a
+// TTL. Silent hops, and TTLs a route has no hop at, match any router, but a
+// route that reached the target ends there.
+func sameRoute(a []PathHop, aReached bool, b []PathHop, bReached bool) bool {
+	return routeAgrees(a, b, aReached) && routeAgrees(b, a, bReached)
+}
+
+// routeAgrees reports whether the answers of hops match route
+func routeAgrees(route, hops []PathHop, reached bool) bool {
+	at := make(map[uint8]string, len(route))
+	for _, hop := range route {
+		at[hop.TTL] = hop.IP
+	}
+	for _, hop := range hops {
+		if hop.IP == AnonymousIP {
+			continue
+		}
+		ip, ok := at[hop.TTL]
+		if !ok && reached && hop.TTL > route[len(route)-1].TTL {
+			return false
+		}
+		if ok && ip != AnonymousIP && ip != hop.IP {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeRoutes returns route with the routers that answered in hops at its
+// silent hops and at the TTLs it has no hop at
+func mergeRoutes(route, hops []PathHop) []PathHop {
+	at := make(map[uint8]int, len(route))
+	for i, hop := range route {
+		at[hop.TTL] = i
+	}
+	merged := append([]PathHop(nil), route...)
+	for _, hop := range hops {
+		if i, ok := at[hop.TTL]; !ok {
+			merged = append(merged, hop)
+		} else if merged[i].IP == AnonymousIP {
+			merged[i] = hop
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].TTL < merged[j].TTL })
+	return merged
+}
+
+// portRanges formats ports as sorted, comma-separated ranges, e.g.
+// "33434-33436,33440"
+func portRanges(ports []uint16) string {
+	sorted := append([]uint16(nil), ports...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var ranges []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			ranges = append(ranges, fmt.Sprint(sorted[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
+// ToJSON converts the report to JSON format
+func (r *FlowPaths) ToJSON() (string, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal to JSON: %w", err)
+	}
+	return string(data), nil
+}
+
+// PrintReport prints the paths as a table of the ports that take each one,
+// followed by the routers of each path
+func (r *FlowPaths) PrintReport() {
+	fmt.Println()
+	fmt.Println(strings.Repeat("─", 80))
+	fmt.Printf("🧭 FLOW-TO-PATH MAP: %s\n", r.Target)
+	fmt.Println(strings.Repeat("─", 80))
+
+	fmt.Printf("%-6s %-6s %-28s %-20s %s\n", "Path", "Flows", "Source ports", "Destination ports", "Reached")
+	for _, path := range r.Paths {
+		src, dst := path.SrcPorts, path.DstPorts
+		if path.Flows[0].Checksum != 0 {
+			src, dst = "-", "-" // ICMP
+		}
+		fmt.Printf("%-6d %-6d %-28s %-20s %s\n", path.PathID, len(path.Flows), src, dst, yesNo(path.Reached))
+	}
+	fmt.Println()
+
+	for _, path := range r.Paths {
+		ips := make([]string, len(path.Hops))
+		for i, hop := range path.Hops {
+			ips[i] = hop.IP
+		}
+		fmt.Printf("Path %d: %s\n", path.PathID, strings.Join(ips, " → "))
+		if path.Flows[0].Checksum != 0 {
+			flows := make([]string, len(path.Flows))
+			for i, tuple := range path.Flows {
+				flows[i] = tuple.String()
+			}
+			fmt.Printf("   └─ %s\n", strings.Join(flows, ", "))
+		}
+	}
+
+	if len(r.Unstable) > 0 {
+		fmt.Println()
+		fmt.Println("⚠️  Flows answered by several routers at one hop (per-packet balancing):")
+		for _, u := range r.Unstable {
+			fmt.Printf("   %s at hop %d: %s\n", u.Flow, u.TTL, strings.Join(u.Routers, ", "))
+		}
+	}
+	fmt.Println()
+}
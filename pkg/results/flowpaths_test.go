@@ -0,0 +1,144 @@
+package results
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mtrTrace builds two rounds of a 4-flow trace: FlowIDs 4-7 reuse the ports
+// of flows 0-3. Even source ports cross 10.0.0.1, odd ones 10.0.0.2.
+func mtrTrace() *TracerouteResult {
+	tr := &TracerouteResult{Target: "8.8.8.8", Hops: make(map[uint8]*HopResult)}
+	for ttl := uint8(1); ttl <= 3; ttl++ {
+		tr.Hops[ttl] = &HopResult{TTL: ttl, Flows: make(map[uint16]*FlowResult)}
+		for id := uint16(0); id < 8; id++ {
+			srcPort := 33434 + id%4
+			ip := map[uint8]string{1: "192.168.1.1", 2: "10.0.0.1", 3: "8.8.8.8"}[ttl]
+			if ttl == 2 && srcPort%2 == 1 {
+				ip = "10.0.0.2"
+			}
+			tr.Hops[ttl].Flows[id] = &FlowResult{
+				FlowID: id, SrcPort: srcPort, DstPort: 33434,
+				ResponseIP: ip, RTT: time.Duration(ttl) * time.Millisecond,
+			}
+		}
+	}
+	return tr
+}
+
+func TestMapFlowsGroupsRoundsByPorts(t *testing.T) {
+	report := mtrTrace().MapFlows()
+
+	if len(report.Paths) != 2 {
+		t.Fatalf("got %d paths, want 2", len(report.Paths))
+	}
+	for i, want := range []struct {
+		src   string
+		route []string
+	}{
+		{"33434,33436", []string{"192.168.1.1", "10.0.0.1", "8.8.8.8"}},
+		{"33435,33437", []string{"192.168.1.1", "10.0.0.2", "8.8.8.8"}},
+	} {
+		path := report.Paths[i]
+		if path.SrcPorts != want.src || path.DstPorts != "33434" || len(path.Flows) != 2 {
+			t.Errorf("path %d: ports %s -> %s over %d flows, want %s -> 33434 over 2", i, path.SrcPorts, path.DstPorts, len(path.Flows), want.src)
+		}
+		var route []string
+		for _, hop := range path.Hops {
+			route = append(route, hop.IP)
+		}
+		if !reflect.DeepEqual(route, want.route) || !path.Reached {
+			t.Errorf("path %d: route %v (reached %v), want %v", i, route, path.Reached, want.route)
+		}
+	}
+	if len(report.Unstable) != 0 {
+		t.Errorf("got unstable flows %+v", report.Unstable)
+	}
+}
+
+func TestMapFlowsFlagsUnstableFlows(t *testing.T) {
+	tr := mtrTrace()
+	tr.Hops[2].Flows[4].ResponseIP = "10.0.0.3" // second round of 33434 took another router
+	tr.Hops[3].Flows[1].Error = "timeout"
+	tr.Hops[3].Flows[5].Error = "timeout"
+
+	report := tr.MapFlows()
+	want := []UnstableFlow{{Flow: FlowTuple{SrcPort: 33434, DstPort: 33434}, TTL: 2, Routers: []string{"10.0.0.1", "10.0.0.3"}}}
+	if !reflect.DeepEqual(report.Unstable, want) {
+		t.Errorf("got unstable %+v, want %+v", report.Unstable, want)
+	}
+
+	// 33435 went silent before the target, which does not set it apart
+	// from 33437: a silent hop matches any router
+	if len(report.Paths) != 2 {
+		t.Fatalf("got %d paths, want 2", len(report.Paths))
+	}
+	if path := report.Paths[1]; path.SrcPorts != "33435,33437" || !path.Reached || len(path.Hops) != 3 {
+		t.Errorf("got path %+v, want 33435 and 33437 to the target", path)
+	}
+}
+
+func TestMapFlowsTakesRTTsOfTheFlows(t *testing.T) {
+	tr := mtrTrace()
+	// The odd source ports take longer to the shared first hop, and flow 4
+	// more than flow 0 in the second round
+	for id, flow := range tr.Hops[1].Flows {
+		if flow.SrcPort%2 == 1 {
+			flow.RTT = 5 * time.Millisecond
+		} else if id == 4 {
+			flow.RTT = 3 * time.Millisecond
+		}
+	}
+	report := tr.MapFlows()
+
+	if len(report.Paths) != 2 {
+		t.Fatalf("got %d paths, want 2", len(report.Paths))
+	}
+	// Path 0 is ports 33434 and 33436: 1ms and 3ms for 33434
+	for i, want := range []time.Duration{2 * time.Millisecond, 5 * time.Millisecond} {
+		if got := report.Paths[i].Hops[0].RTT; got != want {
+			t.Errorf("path %d: hop 1 RTT %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestMapFlowsMatchesSilentHops(t *testing.T) {
+	// One route whose hops each went silent for some flows
+	tr := trace(1, 4, [][]string{
+		{"192.168.1.1", "", "10.1.0.1", "", "8.8.8.8"},
+		{"", "10.0.0.1", "", "10.2.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.1"},
+		{"192.168.1.1", "", "", "", "8.8.8.8"},
+	})
+
+	report := tr.MapFlows()
+	if len(report.Paths) != 1 {
+		t.Fatalf("got %d paths, want 1: %+v", len(report.Paths), report.Paths)
+	}
+	want := []string{"192.168.1.1", "10.0.0.1", "10.1.0.1", "10.2.0.1", "8.8.8.8"}
+	if path := report.Paths[0]; !reflect.DeepEqual(routeOf(Path{Hops: path.Hops}), want) || !path.Reached || len(path.Flows) != 4 {
+		t.Errorf("got path %+v, want %v for all four flows", path, want)
+	}
+
+	analysis := tr.AnalyzeNetwork()
+	if analysis.HasLoadBalancing || analysis.AsymmetricRouting || tr.HasMultiplePaths() {
+		t.Errorf("load balancing %v, asymmetric routing %v, several paths %v, want a single path",
+			analysis.HasLoadBalancing, analysis.AsymmetricRouting, tr.HasMultiplePaths())
+	}
+
+	// A router of its own at a TTL still makes a second path
+	tr.Hops[3].Flows[2].ResponseIP = "10.1.0.2"
+	analysis = tr.AnalyzeNetwork()
+	if len(tr.MapFlows().Paths) != 2 || !analysis.HasLoadBalancing || !analysis.AsymmetricRouting {
+		t.Errorf("got %d paths, load balancing %v, asymmetric routing %v, want 2 paths over a balancer",
+			len(tr.MapFlows().Paths), analysis.HasLoadBalancing, analysis.AsymmetricRouting)
+	}
+}
+
+func TestPortRanges(t *testing.T) {
+	got := portRanges([]uint16{33440, 33434, 33436, 33435, 33434, 33442, 33441})
+	if got != "33434-33436,33440-33442" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,442 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AnonymousIP is the address of the placeholder node standing, at each TTL,
+// for the routers that did not answer
+const AnonymousIP = "*"
+
+// NodeKey identifies a node of the graph: the router answering at a TTL
+type NodeKey struct {
+	TTL uint8  `json:"ttl"`
+	IP  string `json:"ip"` // AnonymousIP for probes without an answer
+}
+
+// GraphNode is a router seen at one TTL, with the flows it answered
+type GraphNode struct {
+	NodeKey
+	Hostname string        `json:"hostname,omitempty"`
+	Probes   int           `json:"probes"`        // Probes it answered, or that got no answer
+	RTT      time.Duration `json:"rtt,omitempty"` // Mean RTT of its answers
+	Flows    []FlowTuple   `json:"flows"`
+
+	perFlow    map[FlowTuple]int           // Probes of each flow
+	perFlowRTT map[FlowTuple]time.Duration // Sum of the RTTs of each flow
+	rttSum     time.Duration
+}
+
+// GraphEdge links the routers a flow crossed at consecutive TTLs
+type GraphEdge struct {
+	From  NodeKey     `json:"from"`
+	To    NodeKey     `json:"to"`
+	Flows []FlowTuple `json:"flows"`
+	Count int         `json:"count"` // Probes of a flow seen at both ends, round by round
+}
+
+// Diamond is a region where flows split over several routers: it opens at
+// a router with several next hops and closes where the flows through it
+// meet again at a single router
+type Diamond struct {
+	Divergence  NodeKey  `json:"divergence"`
+	Convergence *NodeKey `json:"convergence,omitempty"` // Nil when the branches never meet again
+	Width       int      `json:"width"`                 // Most routers at one TTL in between
+	Length      int      `json:"length"`                // TTLs from divergence to convergence, or to the last hop
+}
+
+// NetworkGraph is the multipath topology of a trace. Probes of the same
+// ports are the same flow, whichever MTR round sent them.
+type NetworkGraph struct {
+	Target   string       `json:"target"`
+	Nodes    []*GraphNode `json:"nodes"` // By TTL, then address
+	Edges    []*GraphEdge `json:"edges"` // By TTL of the origin, then addresses
+	Diamonds []Diamond    `json:"diamonds,omitempty"`
+
+	ttls  []uint8 // TTLs with nodes, ascending
+	nodes map[NodeKey]*GraphNode
+	edges map[[2]NodeKey]*GraphEdge
+}
+
+// Graph builds the graph of the trace. Each probe is a visit of its flow to
+// the router that answered it, or to the anonymous node of its TTL; probes
+// of one FlowID at consecutive TTLs make an edge. A flow stops at the
+// target.
+func (tr *TracerouteResult) Graph() *NetworkGraph {
+	g := &NetworkGraph{
+		Target: tr.Target,
+		nodes:  make(map[NodeKey]*GraphNode),
+		edges:  make(map[[2]NodeKey]*GraphEdge),
+	}
+
+	reached := make(map[uint16]bool) // FlowIDs that got to the target
+	previous := make(map[uint16]NodeKey)
+	for _, ttl := range tr.sortedTTLs() {
+		current := make(map[uint16]NodeKey)
+		for _, flowID := range sortedFlowIDs(tr.Hops[ttl]) {
+			if reached[flowID] {
+				continue
+			}
+			flow := tr.Hops[ttl].Flows[flowID]
+			key := NodeKey{TTL: ttl, IP: AnonymousIP}
+			if flow.Error == "" && flow.ResponseIP != "" {
+				key.IP = flow.ResponseIP
+			}
+			g.visit(key, tupleOf(flow), flow)
+			current[flowID] = key
+
+			if from, ok := previous[flowID]; ok && from.TTL == ttl-1 {
+				g.link(from, key, tupleOf(flow))
+			}
+			reached[flowID] = key.IP == tr.Target
+		}
+		previous = current
+	}
+
+	g.sort()
+	g.findDiamonds()
+	return g
+}
+
+// sortedFlowIDs returns the FlowIDs of a hop in ascending order
+func sortedFlowIDs(hop *HopResult) []uint16 {
+	ids := make([]uint16, 0, len(hop.Flows))
+	for id := range hop.Flows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// visit records a probe of tuple at the node key
+func (g *NetworkGraph) visit(key NodeKey, tuple FlowTuple, flow *FlowResult) {
+	node := g.nodes[key]
+	if node == nil {
+		node = &GraphNode{NodeKey: key, perFlow: make(map[FlowTuple]int), perFlowRTT: make(map[FlowTuple]time.Duration)}
+		g.nodes[key] = node
+		g.Nodes = append(g.Nodes, node)
+	}
+	if node.perFlow[tuple] == 0 {
+		node.Flows = append(node.Flows, tuple)
+	}
+	node.perFlow[tuple]++
+	node.Probes++
+	if key.IP != AnonymousIP {
+		node.rttSum += flow.RTT
+		node.RTT = node.rttSum / time.Duration(node.Probes)
+		node.perFlowRTT[tuple] += flow.RTT
+		if node.Hostname == "" {
+			node.Hostname = flow.Hostname
+		}
+	}
+}
+
+// link records that a probe of tuple went from one node to the next
+func (g *NetworkGraph) link(from, to NodeKey, tuple FlowTuple) {
+	edge := g.edges[[2]NodeKey{from, to}]
+	if edge == nil {
+		edge = &GraphEdge{From: from, To: to}
+		g.edges[[2]NodeKey{from, to}] = edge
+		g.Edges = append(g.Edges, edge)
+	}
+	if !containsTuple(edge.Flows, tuple) {
+		edge.Flows = append(edge.Flows, tuple)
+	}
+	edge.Count++
+}
+
+func containsTuple(tuples []FlowTuple, tuple FlowTuple) bool {
+	for _, t := range tuples {
+		if t == tuple {
+			return true
+		}
+	}
+	return false
+}
+
+func keyLess(a, b NodeKey) bool {
+	if a.TTL != b.TTL {
+		return a.TTL < b.TTL
+	}
+	return a.IP < b.IP
+}
+
+// sort orders nodes, edges and flows, and lists the TTLs
+func (g *NetworkGraph) sort() {
+	sort.Slice(g.Nodes, func(i, j int) bool { return keyLess(g.Nodes[i].NodeKey, g.Nodes[j].NodeKey) })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return keyLess(a.From, b.From)
+		}
+		return keyLess(a.To, b.To)
+	})
+	for _, node := range g.Nodes {
+		sortTuples(node.Flows)
+		if len(g.ttls) == 0 || g.ttls[len(g.ttls)-1] != node.TTL {
+			g.ttls = append(g.ttls, node.TTL)
+		}
+	}
+	for _, edge := range g.Edges {
+		sortTuples(edge.Flows)
+	}
+}
+
+func sortTuples(tuples []FlowTuple) {
+	sort.Slice(tuples, func(i, j int) bool { return tupleLess(tuples[i], tuples[j]) })
+}
+
+// Node returns the node of ip at ttl, or nil
+func (g *NetworkGraph) Node(ttl uint8, ip string) *GraphNode {
+	return g.nodes[NodeKey{TTL: ttl, IP: ip}]
+}
+
+// Routers returns the nodes that answered at ttl, without the anonymous one
+func (g *NetworkGraph) Routers(ttl uint8) []*GraphNode {
+	var routers []*GraphNode
+	for _, node := range g.Nodes {
+		if node.TTL == ttl && node.IP != AnonymousIP {
+			routers = append(routers, node)
+		}
+	}
+	return routers
+}
+
+// Successors returns the nodes an edge leads to from key
+func (g *NetworkGraph) Successors(key NodeKey) []NodeKey {
+	var next []NodeKey
+	for _, edge := range g.Edges {
+		if edge.From == key {
+			next = append(next, edge.To)
+		}
+	}
+	return next
+}
+
+// MultipathTTLs returns the TTLs where several routers answered
+func (g *NetworkGraph) MultipathTTLs() []uint8 {
+	var ttls []uint8
+	for _, ttl := range g.ttls {
+		if len(g.Routers(ttl)) > 1 {
+			ttls = append(ttls, ttl)
+		}
+	}
+	return ttls
+}
+
+// UniqueRouters returns the addresses of every router that answered, sorted
+func (g *NetworkGraph) UniqueRouters() []string {
+	var ips []string
+	for _, node := range g.Nodes {
+		if node.IP != AnonymousIP {
+			ips = append(ips, node.IP)
+		}
+	}
+	return distinctStrings(ips)
+}
+
+// Fingerprint identifies the routers that answered at each TTL: traces
+// along the same paths share it, whatever their RTTs, flows and silent hops.
+// A router that answers one trace and not the next still changes it.
+func (g *NetworkGraph) Fingerprint() string {
+	hash := sha256.New()
+	for _, ttl := range g.ttls {
+		if ips := routerIPs(g.Routers(ttl)); len(ips) > 0 {
+			fmt.Fprintf(hash, "%d %s\n", ttl, strings.Join(ips, ","))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// distinctStrings returns the sorted unique strings of list
+func distinctStrings(list []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// findDiamonds opens a diamond at every router with several answering next
+// hops and follows the flows leaving it until they meet at one router again
+func (g *NetworkGraph) findDiamonds() {
+	g.Diamonds = nil
+	for _, node := range g.Nodes {
+		if node.IP == AnonymousIP {
+			continue
+		}
+		var flows []FlowTuple
+		branches := 0
+		for _, edge := range g.Edges {
+			if edge.From != node.NodeKey {
+				continue
+			}
+			flows = append(flows, edge.Flows...)
+			if edge.To.IP != AnonymousIP {
+				branches++
+			}
+		}
+		if branches < 2 {
+			continue
+		}
+
+		diamond := Diamond{Divergence: node.NodeKey}
+		for _, ttl := range g.ttls {
+			if ttl <= node.TTL {
+				continue
+			}
+			var routers []NodeKey
+			seen := false
+			for _, next := range g.Nodes {
+				if next.TTL != ttl || !visitedBy(next, flows) {
+					continue
+				}
+				seen = true
+				if next.IP != AnonymousIP {
+					routers = append(routers, next.NodeKey)
+				}
+			}
+			if !seen {
+				break // Every flow ended, at the target or in silence
+			}
+			diamond.Length = int(ttl - node.TTL)
+			if len(routers) == 1 {
+				diamond.Convergence = &routers[0]
+				break
+			}
+			if len(routers) > diamond.Width {
+				diamond.Width = len(routers)
+			}
+		}
+		g.Diamonds = append(g.Diamonds, diamond)
+	}
+}
+
+// visitedBy reports whether any of flows visited node
+func visitedBy(node *GraphNode, flows []FlowTuple) bool {
+	for _, tuple := range flows {
+		if node.perFlow[tuple] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the path of one flow: at each TTL the router that answered
+// most of its probes, ties going to the lowest address, or the anonymous
+// node if none did, with the mean RTT of the flow's probes there. Hops where
+// the flow visited several routers are reported as unstable.
+func (g *NetworkGraph) route(tuple FlowTuple) (hops []PathHop, reached bool, unstable []UnstableFlow) {
+	for _, ttl := range g.ttls {
+		var best *GraphNode
+		var routers []string
+		for _, node := range g.Nodes {
+			n := node.perFlow[tuple]
+			if node.TTL != ttl || n == 0 {
+				continue
+			}
+			if node.IP == AnonymousIP {
+				if best == nil {
+					best = node
+				}
+				continue
+			}
+			routers = append(routers, node.IP)
+			if best == nil || best.IP == AnonymousIP || n > best.perFlow[tuple] {
+				best = node
+			}
+		}
+		if best == nil {
+			continue
+		}
+		if len(routers) > 1 {
+			unstable = append(unstable, UnstableFlow{Flow: tuple, TTL: ttl, Routers: routers})
+		}
+		rtt := best.perFlowRTT[tuple] / time.Duration(best.perFlow[tuple])
+		hops = append(hops, PathHop{TTL: ttl, IP: best.IP, Hostname: best.Hostname, RTT: rtt})
+		if best.IP == g.Target {
+			reached = true
+			break
+		}
+	}
+	// Drop the silent hops after the last answer
+	for len(hops) > 0 && hops[len(hops)-1].IP == AnonymousIP {
+		hops = hops[:len(hops)-1]
+	}
+	return hops, reached, unstable
+}
+
+// flowPaths groups the flows of the graph by route, in the order of their
+// first flow. A silent hop matches any router, so flows that differ only in
+// hops that did not answer share a path, which shows the routers that did:
+// flows take several paths only where several routers answered a TTL.
+func (g *NetworkGraph) flowPaths() ([]PathWithFlows, []UnstableFlow) {
+	var tuples []FlowTuple
+	seen := make(map[FlowTuple]bool)
+	for _, node := range g.Nodes {
+		for _, tuple := range node.Flows {
+			if !seen[tuple] {
+				seen[tuple] = true
+				tuples = append(tuples, tuple)
+			}
+		}
+	}
+	sortTuples(tuples)
+
+	var paths []PathWithFlows
+	var unstable []UnstableFlow
+	for _, tuple := range tuples {
+		hops, reached, changes := g.route(tuple)
+		unstable = append(unstable, changes...)
+
+		i := 0
+		for i < len(paths) && !sameRoute(paths[i].Hops, paths[i].Reached, hops, reached) {
+			i++
+		}
+		if i == len(paths) {
+			paths = append(paths, PathWithFlows{PathID: i, Hops: hops, Reached: reached})
+		} else {
+			paths[i].Hops = mergeRoutes(paths[i].Hops, hops)
+			paths[i].Reached = paths[i].Reached || reached
+		}
+		paths[i].Flows = append(paths[i].Flows, tuple)
+	}
+	return paths, unstable
+}
+
+// Paths returns the distinct routes taken by the flows of the trace
+func (g *NetworkGraph) Paths() []Path {
+	flowPaths, _ := g.flowPaths()
+	paths := make([]Path, len(flowPaths))
+	for i, fp := range flowPaths {
+		paths[i] = Path{PathID: fp.PathID, Hops: fp.Hops}
+	}
+	return paths
+}
+
+// ToJSON converts the graph to JSON format
+func (g *NetworkGraph) ToJSON() (string, error) {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal to JSON: %w", err)
+	}
+	return string(data), nil
+}
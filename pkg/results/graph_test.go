@@ -0,0 +1,149 @@
+package results
+
+import (
+	"reflect"
+	"testing"
+)
+
+// trace builds a trace from the routers answering each flow at each TTL,
+// "" for no answer; FlowID i uses source port 33434 + i%ports
+func trace(minTTL uint8, ports uint16, routes [][]string) *TracerouteResult {
+	tr := &TracerouteResult{Target: "8.8.8.8", Hops: make(map[uint8]*HopResult)}
+	for id, route := range routes {
+		for i, ip := range route {
+			ttl := minTTL + uint8(i)
+			if tr.Hops[ttl] == nil {
+				tr.Hops[ttl] = &HopResult{TTL: ttl, Flows: make(map[uint16]*FlowResult)}
+			}
+			flow := &FlowResult{FlowID: uint16(id), SrcPort: 33434 + uint16(id)%ports, DstPort: 33434, ResponseIP: ip}
+			if ip == "" {
+				flow.Error = "timeout"
+			}
+			tr.Hops[ttl].Flows[uint16(id)] = flow
+		}
+	}
+	return tr
+}
+
+func routeOf(path Path) []string {
+	var ips []string
+	for _, hop := range path.Hops {
+		ips = append(ips, hop.IP)
+	}
+	return ips
+}
+
+func TestGraphFromMinTTL(t *testing.T) {
+	// -min-ttl 3: no Hops[1] to seed the flows from
+	g := trace(3, 2, [][]string{
+		{"10.0.0.1", "8.8.8.8"},
+		{"10.0.0.1", "8.8.8.8"},
+	}).Graph()
+
+	paths := g.Paths()
+	if len(paths) != 1 || !reflect.DeepEqual(routeOf(paths[0]), []string{"10.0.0.1", "8.8.8.8"}) {
+		t.Fatalf("got paths %+v, want one through 10.0.0.1", paths)
+	}
+	if paths[0].Hops[0].TTL != 3 {
+		t.Errorf("path starts at TTL %d, want 3", paths[0].Hops[0].TTL)
+	}
+	edge := g.Edges[0]
+	if edge.From != (NodeKey{3, "10.0.0.1"}) || edge.To != (NodeKey{4, "8.8.8.8"}) || edge.Count != 2 || len(edge.Flows) != 2 {
+		t.Errorf("got edge %+v", edge)
+	}
+}
+
+func TestGraphMergesMTRRounds(t *testing.T) {
+	// Two rounds of two flows: FlowIDs 2 and 3 reuse the ports of 0 and 1
+	g := trace(1, 2, [][]string{
+		{"192.168.1.1", "10.0.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.2", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.2", "8.8.8.8"},
+	}).Graph()
+
+	if paths := g.Paths(); len(paths) != 2 {
+		t.Errorf("got %d paths, want one per branch rather than per round", len(paths))
+	}
+	node := g.Node(2, "10.0.0.1")
+	if node == nil || node.Probes != 2 || len(node.Flows) != 1 {
+		t.Errorf("got node %+v, want 2 probes of a single flow", node)
+	}
+	if !reflect.DeepEqual(g.MultipathTTLs(), []uint8{2}) {
+		t.Errorf("got multipath TTLs %v, want [2]", g.MultipathTTLs())
+	}
+}
+
+func TestGraphDiamonds(t *testing.T) {
+	g := trace(1, 8, [][]string{
+		{"192.168.1.1", "10.0.0.1", "10.1.0.1", "10.1.1.1", "10.2.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.2", "10.1.1.2", "10.2.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.3", "", "10.2.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.1", "10.1.0.1", "10.1.1.1", "10.2.0.1", "8.8.8.8"},
+	}).Graph()
+
+	want := []Diamond{{
+		Divergence:  NodeKey{2, "10.0.0.1"},
+		Convergence: &NodeKey{5, "10.2.0.1"},
+		Width:       3,
+		Length:      3,
+	}}
+	if !reflect.DeepEqual(g.Diamonds, want) {
+		t.Errorf("got diamonds %+v, want %+v", g.Diamonds, want)
+	}
+
+	// The silent hop is an anonymous node on the path of its flow
+	if node := g.Node(4, AnonymousIP); node == nil || node.Probes != 1 {
+		t.Errorf("got anonymous node %+v at TTL 4", node)
+	}
+	paths := g.Paths()
+	if len(paths) != 3 || routeOf(paths[2])[3] != AnonymousIP {
+		t.Errorf("got paths %+v", paths)
+	}
+}
+
+func TestGraphOpenDiamond(t *testing.T) {
+	// The target never answers and the branches stay apart to the end
+	g := trace(1, 2, [][]string{
+		{"192.168.1.1", "10.0.0.1", "10.0.1.1", ""},
+		{"192.168.1.1", "10.0.0.2", "10.0.1.2", ""},
+	}).Graph()
+
+	want := []Diamond{{Divergence: NodeKey{1, "192.168.1.1"}, Width: 2, Length: 3}}
+	if !reflect.DeepEqual(g.Diamonds, want) {
+		t.Errorf("got diamonds %+v, want %+v", g.Diamonds, want)
+	}
+}
+
+func TestGraphStopsAtTarget(t *testing.T) {
+	// Flow 0 reaches the target a hop early; the probe after it is not a hop
+	g := trace(1, 2, [][]string{
+		{"192.168.1.1", "8.8.8.8", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.2", "8.8.8.8"},
+	}).Graph()
+
+	if next := g.Successors(NodeKey{2, "8.8.8.8"}); len(next) != 0 {
+		t.Errorf("edges out of the target: %v", next)
+	}
+	if node := g.Node(3, "8.8.8.8"); node == nil || node.Probes != 1 {
+		t.Errorf("got target node %+v at TTL 3, want flow 1 only", node)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	routes := [][]string{
+		{"192.168.1.1", "10.0.0.1", "8.8.8.8"},
+		{"192.168.1.1", "10.0.0.2", "8.8.8.8"},
+	}
+	fingerprint := trace(1, 2, routes).Graph().Fingerprint()
+
+	// Silent probes and other ports do not change it; other routers do
+	same := trace(1, 4, append(routes, []string{"192.168.1.1", "", "8.8.8.8"}))
+	if got := same.Graph().Fingerprint(); got != fingerprint {
+		t.Errorf("got %s, want %s", got, fingerprint)
+	}
+	other := trace(1, 2, [][]string{routes[0], {"192.168.1.1", "10.0.0.3", "8.8.8.8"}})
+	if got := other.Graph().Fingerprint(); got == fingerprint {
+		t.Errorf("different routers share fingerprint %s", got)
+	}
+}
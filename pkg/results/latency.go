@@ -0,0 +1,402 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Verdicts of a latency test
+const (
+	LatencySlower    = "slower"
+	LatencyFaster    = "faster"
+	LatencyUnchanged = "unchanged"      // No significant shift, or one too small to matter
+	LatencyTooFew    = "too few probes" // Not even the most extreme outcome would be significant
+)
+
+// LatencyAlpha is the significance level of the latency tests of
+// CompareTraces
+const LatencyAlpha = 0.05
+
+const (
+	exactTestLimit     = 20    // Larger samples, and samples with ties, use the normal approximation of U
+	bootstrapResamples = 2000  // Resamples for the confidence interval of the median shift
+	bootstrapSeed      = 1     // Fixed, so that the same traces give the same report
+	negligibleEffect   = 0.147 // |Cliff's delta| below which a significant shift is still noise
+)
+
+// LatencyComparison tests whether the RTTs of two traces differ, per hop
+// and end to end
+type LatencyComparison struct {
+	Alpha    float64        `json:"alpha"`
+	EndToEnd *LatencyShift  `json:"end_to_end,omitempty"` // Nil unless both traces reached the target
+	Hops     []LatencyShift `json:"hops"`
+}
+
+// LatencyShift is the outcome of a Mann-Whitney U test between the probe
+// RTTs of the baseline and of the current trace. EffectSize is Cliff's
+// delta: the share of (baseline, current) probe pairs where the current
+// probe was slower, minus the share where it was faster.
+type LatencyShift struct {
+	TTL            uint8         `json:"ttl"` // 0 for the end-to-end RTT
+	BaselineProbes int           `json:"baseline_probes"`
+	CurrentProbes  int           `json:"current_probes"`
+	BaselineMedian time.Duration `json:"baseline_median"`
+	CurrentMedian  time.Duration `json:"current_median"`
+	MedianShift    time.Duration `json:"median_shift"`
+	ShiftLow       time.Duration `json:"shift_low"`  // Bootstrap confidence interval of the shift,
+	ShiftHigh      time.Duration `json:"shift_high"` // at 1 - Alpha
+	U              float64       `json:"u"`
+	PValue         float64       `json:"p_value"`
+	AdjustedP      float64       `json:"adjusted_p"` // Hops: Holm-corrected across the hops tested
+	EffectSize     float64       `json:"effect_size"`
+	Magnitude      string        `json:"magnitude"` // negligible, small, medium or large
+	Verdict        string        `json:"verdict"`
+}
+
+// CompareLatency tests, at every TTL where both traces got answers and for
+// the target itself, whether the current RTTs are shifted from the baseline
+// ones. Probe RTTs are rarely normal, so the test is Mann-Whitney U (exact
+// for small samples without ties) and the shift is the difference of the
+// medians, with a bootstrap confidence interval. A shift counts only if it
+// is significant at alpha after a Holm correction across hops, so that a
+// 30-hop trace does not flag a hop by chance, and its effect size is not
+// negligible (Romano et al.), so that large samples do not flag jitter.
+func CompareLatency(baseline, current *TracerouteResult, alpha float64) *LatencyComparison {
+	c := &LatencyComparison{Alpha: alpha, Hops: make([]LatencyShift, 0)}
+
+	before, after := baseline.CalculateHopStatistics(), current.CalculateHopStatistics()
+	for _, ttl := range mergeTTLs(baseline.sortedTTLs(), current.sortedTTLs()) {
+		if before[ttl] == nil || after[ttl] == nil || len(before[ttl].RTTs) == 0 || len(after[ttl].RTTs) == 0 {
+			continue
+		}
+		c.Hops = append(c.Hops, testShift(ttl, before[ttl].RTTs, after[ttl].RTTs, alpha))
+	}
+	holm(c.Hops, alpha)
+	for i := range c.Hops {
+		c.Hops[i].judge(alpha)
+	}
+
+	if base, cur := targetRTTs(baseline), targetRTTs(current); len(base) > 0 && len(cur) > 0 {
+		shift := testShift(0, base, cur, alpha)
+		shift.AdjustedP = shift.PValue
+		shift.judge(alpha)
+		c.EndToEnd = &shift
+	}
+	return c
+}
+
+// Hop returns the test at ttl, or nil if the hop was not tested
+func (c *LatencyComparison) Hop(ttl uint8) *LatencyShift {
+	for i := range c.Hops {
+		if c.Hops[i].TTL == ttl {
+			return &c.Hops[i]
+		}
+	}
+	return nil
+}
+
+// targetRTTs returns the RTTs of the answers from the target
+func targetRTTs(tr *TracerouteResult) []time.Duration {
+	var rtts []time.Duration
+	for _, ttl := range tr.sortedTTLs() {
+		for _, id := range sortedFlowIDs(tr.Hops[ttl]) {
+			flow := tr.Hops[ttl].Flows[id]
+			if flow.Error == "" && flow.ResponseIP == tr.Target && flow.RTT > 0 {
+				rtts = append(rtts, flow.RTT)
+			}
+		}
+	}
+	return rtts
+}
+
+// testShift compares the baseline and current RTTs of one hop
+func testShift(ttl uint8, base, cur []time.Duration, alpha float64) LatencyShift {
+	// Hop RTTs come in map order: sort them so the bootstrap is repeatable
+	base, cur = sortedRTTs(base), sortedRTTs(cur)
+	s := LatencyShift{
+		TTL:            ttl,
+		BaselineProbes: len(base),
+		CurrentProbes:  len(cur),
+		BaselineMedian: medianRTT(base),
+		CurrentMedian:  medianRTT(cur),
+	}
+	s.MedianShift = s.CurrentMedian - s.BaselineMedian
+	s.ShiftLow, s.ShiftHigh = bootstrapShift(base, cur, alpha)
+	s.U, s.PValue = mannWhitney(base, cur)
+	s.EffectSize = 2*s.U/float64(len(base)*len(cur)) - 1
+	s.Magnitude = effectMagnitude(s.EffectSize)
+	s.AdjustedP = s.PValue
+	return s
+}
+
+// judge sets the verdict once AdjustedP is known
+func (s *LatencyShift) judge(alpha float64) {
+	switch {
+	case minPValue(s.BaselineProbes, s.CurrentProbes) > alpha:
+		s.Verdict = LatencyTooFew
+	case s.AdjustedP >= alpha || math.Abs(s.EffectSize) < negligibleEffect:
+		s.Verdict = LatencyUnchanged
+	case s.EffectSize > 0:
+		s.Verdict = LatencySlower
+	default:
+		s.Verdict = LatencyFaster
+	}
+}
+
+// mannWhitney returns U, the number of (x, y) pairs where y is larger
+// (ties count half), and the two-sided p-value of the hypothesis that x and
+// y come from the same distribution
+func mannWhitney(x, y []time.Duration) (u, p float64) {
+	m, n := len(x), len(y)
+	if m == 0 || n == 0 {
+		return 0, 1
+	}
+	for _, a := range x {
+		for _, b := range y {
+			switch {
+			case b > a:
+				u++
+			case b == a:
+				u += 0.5
+			}
+		}
+	}
+
+	ties := tieSizes(x, y)
+	if len(ties) == 0 && m <= exactTestLimit && n <= exactTestLimit {
+		counts := uCounts(n, m)
+		total, below := 0.0, 0.0
+		for k, c := range counts {
+			total += c
+			if float64(k) <= u {
+				below += c
+			}
+		}
+		// Two-sided: twice the smaller tail
+		lower := below / total
+		upper := 1 - lower + counts[int(u)]/total
+		return u, math.Min(1, 2*math.Min(lower, upper))
+	}
+
+	// Normal approximation, with tie and continuity corrections
+	size := float64(m + n)
+	correction := 0.0
+	for _, t := range ties {
+		correction += float64(t*t*t - t)
+	}
+	variance := float64(m*n) / 12 * (size + 1 - correction/(size*(size-1)))
+	if variance <= 0 {
+		return u, 1
+	}
+	z := math.Max(0, math.Abs(u-float64(m*n)/2)-0.5) / math.Sqrt(variance)
+	return u, math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// tieSizes returns how many values share each value found more than once
+// in x and y together
+func tieSizes(x, y []time.Duration) []int {
+	seen := make(map[time.Duration]int)
+	for _, v := range append(append([]time.Duration(nil), x...), y...) {
+		seen[v]++
+	}
+	var ties []int
+	for _, count := range seen {
+		if count > 1 {
+			ties = append(ties, count)
+		}
+	}
+	return ties
+}
+
+// uCounts returns, for each u, in how many orderings of m values from one
+// sample and n from the other exactly u pairs have the first sample's value
+// larger. The largest value belongs to either sample, which gives the
+// recurrence.
+func uCounts(m, n int) []float64 {
+	prev := make([][]float64, n+1) // prev[j]: i-1 values from the first sample, j from the second
+	for j := range prev {
+		prev[j] = []float64{1}
+	}
+	for i := 1; i <= m; i++ {
+		row := make([][]float64, n+1)
+		row[0] = []float64{1}
+		for j := 1; j <= n; j++ {
+			counts := make([]float64, i*j+1)
+			for u, c := range prev[j] {
+				counts[u+j] += c // Largest from the first sample: larger than all j
+			}
+			for u, c := range row[j-1] {
+				counts[u] += c // Largest from the second sample
+			}
+			row[j] = counts
+		}
+		prev = row
+	}
+	return prev[n]
+}
+
+// minPValue returns the smallest two-sided p-value samples of m and n
+// probes can give: that of complete separation
+func minPValue(m, n int) float64 {
+	if m == 0 || n == 0 {
+		return 1
+	}
+	orderings := 1.0
+	for k := 1; k <= m; k++ {
+		orderings = orderings * float64(n+k) / float64(k)
+	}
+	return math.Min(1, 2/orderings)
+}
+
+// ProbesNeeded returns how many probes per hop each trace needs before a
+// latency test at alpha can be significant at all
+func ProbesNeeded(alpha float64) int {
+	n := 1
+	for minPValue(n, n) > alpha {
+		n++
+	}
+	return n
+}
+
+// holm sets AdjustedP by the Holm-Bonferroni method. Hops with too few
+// probes to ever be significant are left out of the family (Tarone), so
+// that they do not make the others harder to flag.
+func holm(shifts []LatencyShift, alpha float64) {
+	var tested []int
+	for i := range shifts {
+		if minPValue(shifts[i].BaselineProbes, shifts[i].CurrentProbes) <= alpha {
+			tested = append(tested, i)
+		}
+	}
+	sort.SliceStable(tested, func(a, b int) bool { return shifts[tested[a]].PValue < shifts[tested[b]].PValue })
+
+	running := 0.0
+	for rank, i := range tested {
+		adjusted := math.Min(1, float64(len(tested)-rank)*shifts[i].PValue)
+		running = math.Max(running, adjusted)
+		shifts[i].AdjustedP = running
+	}
+}
+
+// bootstrapShift returns the confidence interval at 1 - alpha of the
+// difference of the medians, by the percentile bootstrap
+func bootstrapShift(base, cur []time.Duration, alpha float64) (time.Duration, time.Duration) {
+	rng := rand.New(rand.NewSource(bootstrapSeed))
+	resample := func(sample []time.Duration) time.Duration {
+		drawn := make([]time.Duration, len(sample))
+		for i := range drawn {
+			drawn[i] = sample[rng.Intn(len(sample))]
+		}
+		return medianRTT(drawn)
+	}
+
+	shifts := make([]time.Duration, bootstrapResamples)
+	for i := range shifts {
+		shifts[i] = resample(cur) - resample(base)
+	}
+	sort.Slice(shifts, func(i, j int) bool { return shifts[i] < shifts[j] })
+	low := int(float64(bootstrapResamples) * alpha / 2)
+	return shifts[low], shifts[bootstrapResamples-1-low]
+}
+
+func sortedRTTs(rtts []time.Duration) []time.Duration {
+	sorted := append([]time.Duration(nil), rtts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// medianRTT returns the median of rtts, or 0 if there are none
+func medianRTT(rtts []time.Duration) time.Duration {
+	if len(rtts) == 0 {
+		return 0
+	}
+	sorted := sortedRTTs(rtts)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// effectMagnitude names a Cliff's delta by the thresholds of Romano et al.
+func effectMagnitude(delta float64) string {
+	switch d := math.Abs(delta); {
+	case d < negligibleEffect:
+		return "negligible"
+	case d < 0.33:
+		return "small"
+	case d < 0.474:
+		return "medium"
+	default:
+		return "large"
+	}
+}
+
+// finding describes a shift worth reporting about subject ("Hop 3
+// latency"), or returns "" for noise
+func (s *LatencyShift) finding(subject string, alpha float64) string {
+	medians := fmt.Sprintf("median %s to %s", formatRTT(s.BaselineMedian), formatRTT(s.CurrentMedian))
+	switch s.Verdict {
+	case LatencySlower, LatencyFaster:
+		direction := "rose"
+		if s.Verdict == LatencyFaster {
+			direction = "fell"
+		}
+		return fmt.Sprintf("%s %s by %s (%s, %.0f%% CI %s to %s; p=%.2g, %s effect)",
+			subject, direction, formatRTT(absDuration(s.MedianShift)), medians, (1-alpha)*100,
+			formatShift(s.ShiftLow), formatShift(s.ShiftHigh), s.AdjustedP, s.Magnitude)
+	case LatencyTooFew:
+		if !significantRTT(s.BaselineMedian, s.MedianShift) {
+			return ""
+		}
+		return fmt.Sprintf("%s rose by %s (%s), but %d and %d probes cannot tell it from noise: use at least %d probes per hop in each trace (-count)",
+			subject, formatRTT(s.MedianShift), medians, s.BaselineProbes, s.CurrentProbes, ProbesNeeded(alpha))
+	}
+	return ""
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func formatShift(d time.Duration) string {
+	return fmt.Sprintf("%+.1fms", milliseconds(d))
+}
+
+// Print prints the latency tests as a table
+func (c *LatencyComparison) Print() {
+	fmt.Printf("Latency tests (Mann-Whitney U, Holm-corrected across hops, alpha %.2g):\n", c.Alpha)
+	fmt.Printf("%-4s %-7s %9s %9s %9s %-19s %7s %-16s %s\n",
+		"TTL", "Probes", "Baseline", "Current", "Shift", "CI", "p", "Effect", "Verdict")
+	fmt.Println(strings.Repeat("-", 100))
+
+	rows := c.Hops
+	if c.EndToEnd != nil {
+		rows = append(append([]LatencyShift(nil), rows...), *c.EndToEnd)
+	}
+	for _, s := range rows {
+		ttl := fmt.Sprintf("%d", s.TTL)
+		if s.TTL == 0 {
+			ttl = "end"
+		}
+		fmt.Printf("%-4s %-7s %9s %9s %9s %-19s %7.2g %-16s %s\n", ttl,
+			fmt.Sprintf("%d/%d", s.BaselineProbes, s.CurrentProbes),
+			formatRTT(s.BaselineMedian), formatRTT(s.CurrentMedian), formatShift(s.MedianShift),
+			fmt.Sprintf("[%s, %s]", formatShift(s.ShiftLow), formatShift(s.ShiftHigh)),
+			s.AdjustedP, fmt.Sprintf("%+.2f %s", s.EffectSize, s.Magnitude), s.Verdict)
+	}
+}
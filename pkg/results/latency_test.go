@@ -0,0 +1,172 @@
+package results
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func millis(values ...float64) []time.Duration {
+	rtts := make([]time.Duration, len(values))
+	for i, v := range values {
+		rtts[i] = time.Duration(v * float64(time.Millisecond))
+	}
+	return rtts
+}
+
+// rttTrace returns a single-path trace with one flow per RTT at each hop;
+// the last hop is the target
+func rttTrace(hops ...[]time.Duration) *TracerouteResult {
+	tr := &TracerouteResult{Target: "8.8.8.8", Hops: make(map[uint8]*HopResult)}
+	for i, rtts := range hops {
+		ttl := uint8(i + 1)
+		ip := fmt.Sprintf("10.0.0.%d", i+1)
+		if i == len(hops)-1 {
+			ip = tr.Target
+		}
+		tr.Hops[ttl] = &HopResult{TTL: ttl, Flows: make(map[uint16]*FlowResult)}
+		for id, rtt := range rtts {
+			tr.Hops[ttl].Flows[uint16(id)] = &FlowResult{FlowID: uint16(id), ResponseIP: ip, RTT: rtt}
+		}
+	}
+	return tr
+}
+
+func TestMannWhitney(t *testing.T) {
+	tests := []struct {
+		name string
+		x, y []time.Duration
+		u, p float64
+	}{
+		{"exact, separated", millis(1, 2, 3, 4, 5), millis(6, 7, 8, 9, 10), 25, 2.0 / 252},
+		{"exact, overlapping", millis(1, 3, 5, 7), millis(2, 8, 9, 10), 13, 0.2},
+		{"normal, ties", millis(1, 2, 2, 3, 4), millis(3, 4, 5, 5, 6), 23, 0.034454},
+		{"identical", millis(5, 5, 5), millis(5, 5, 5), 4.5, 1},
+	}
+	for _, test := range tests {
+		u, p := mannWhitney(test.x, test.y)
+		if u != test.u || math.Abs(p-test.p) > 1e-6 {
+			t.Errorf("%s: got U=%v p=%v, want U=%v p=%v", test.name, u, p, test.u, test.p)
+		}
+	}
+}
+
+func TestUCounts(t *testing.T) {
+	if got := uCounts(2, 2); !reflect.DeepEqual(got, []float64{1, 1, 2, 1, 1}) {
+		t.Errorf("got %v", got)
+	}
+	total := 0.0
+	for _, c := range uCounts(6, 9) {
+		total += c
+	}
+	if total != 5005 {
+		t.Errorf("got %v orderings of 6 and 9 values, want 5005", total)
+	}
+}
+
+func TestProbesNeeded(t *testing.T) {
+	// 3 against 3 can at best give p = 2/20
+	if got := ProbesNeeded(0.05); got != 4 {
+		t.Errorf("got %d, want 4", got)
+	}
+}
+
+func TestHolm(t *testing.T) {
+	shifts := []LatencyShift{
+		{BaselineProbes: 10, CurrentProbes: 10, PValue: 0.01},
+		{BaselineProbes: 10, CurrentProbes: 10, PValue: 0.04},
+		{BaselineProbes: 2, CurrentProbes: 2, PValue: 0.3, AdjustedP: 0.3}, // Untestable: not in the family
+		{BaselineProbes: 10, CurrentProbes: 10, PValue: 0.03},
+	}
+	holm(shifts, 0.05)
+
+	var got []float64
+	for _, s := range shifts {
+		got = append(got, math.Round(s.AdjustedP*1000)/1000)
+	}
+	if want := []float64{0.03, 0.06, 0.3, 0.06}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCompareLatency(t *testing.T) {
+	baseline := rttTrace(
+		millis(1.0, 1.2, 0.9, 1.1, 1.3, 0.8, 1.4, 0.7),
+		millis(10, 11, 9, 10.5, 12, 9.5, 10.2, 11.3),
+		millis(20, 21, 19, 22, 20.5, 19.5, 21.3, 20.2),
+	)
+	// Hop 1 jitters, hop 2 is untouched and the target is 15ms slower
+	current := rttTrace(
+		millis(1.05, 0.85, 1.25, 0.95, 1.15, 1.35, 0.75, 1.45),
+		millis(10, 11, 9, 10.5, 12, 9.5, 10.2, 11.3),
+		millis(35, 36, 34, 37, 35.5, 34.5, 36.3, 35.2),
+	)
+	c := CompareLatency(baseline, current, 0.05)
+
+	var verdicts []string
+	for _, s := range c.Hops {
+		verdicts = append(verdicts, s.Verdict)
+	}
+	if want := []string{LatencyUnchanged, LatencyUnchanged, LatencySlower}; !reflect.DeepEqual(verdicts, want) {
+		t.Errorf("got verdicts %v, want %v", verdicts, want)
+	}
+
+	hop := c.Hop(3)
+	if hop.MedianShift != 15*time.Millisecond || hop.EffectSize != 1 || hop.Magnitude != "large" {
+		t.Errorf("got hop 3 %+v", hop)
+	}
+	if hop.ShiftLow > hop.MedianShift || hop.ShiftHigh < hop.MedianShift {
+		t.Errorf("confidence interval [%v, %v] misses the shift %v", hop.ShiftLow, hop.ShiftHigh, hop.MedianShift)
+	}
+	if c.EndToEnd == nil || c.EndToEnd.Verdict != LatencySlower {
+		t.Errorf("got end to end %+v", c.EndToEnd)
+	}
+
+	// The bootstrap is seeded: the same traces give the same report
+	if again := CompareLatency(baseline, current, 0.05); !reflect.DeepEqual(again, c) {
+		t.Errorf("comparison is not repeatable")
+	}
+}
+
+func TestCompareLatencyTooFewProbes(t *testing.T) {
+	baseline := rttTrace(millis(1, 1.1), millis(20, 21))
+	current := rttTrace(millis(1, 1.1), millis(60, 61))
+	d := CompareTraces(baseline, current)
+
+	if shift := d.Latency.EndToEnd; shift == nil || shift.Verdict != LatencyTooFew {
+		t.Fatalf("got end to end %+v", shift)
+	}
+	want := "End-to-end latency rose by 40.0ms (median 20.5ms to 60.5ms), but 2 and 2 probes cannot tell it from noise"
+	if !strings.HasPrefix(findingAbout(d.Findings, "End-to-end latency"), want) {
+		t.Errorf("findings lack %q: %v", want, d.Findings)
+	}
+}
+
+func TestCompareTracesSignificantShift(t *testing.T) {
+	// 4 probes would do for one hop, but not once corrected for two
+	baseline := rttTrace(millis(1.0, 1.2, 0.9, 1.1, 1.3), millis(20, 21, 19, 22, 20.5))
+	current := rttTrace(millis(1.1, 0.95, 1.15, 1.05, 1.25), millis(30, 31, 29, 32, 30.5))
+	d := CompareTraces(baseline, current)
+
+	for _, finding := range d.Findings {
+		if strings.HasPrefix(finding, "Hop 1 latency") {
+			t.Errorf("hop 1 jitter reported: %q", finding)
+		}
+	}
+	want := "Hop 2 latency rose by 10.0ms (median 20.5ms to 30.5ms, 95% CI"
+	if !strings.HasPrefix(findingAbout(d.Findings, "Hop 2 latency"), want) {
+		t.Errorf("findings lack %q: %v", want, d.Findings)
+	}
+}
+
+func findingAbout(findings []string, prefix string) string {
+	for _, finding := range findings {
+		if strings.HasPrefix(finding, prefix) {
+			return finding
+		}
+	}
+	return ""
+}
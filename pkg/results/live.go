@@ -0,0 +1,315 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sparkWidth is the number of recent probes in the sparkline of a row
+const sparkWidth = 20
+
+// sparkRunes draw an RTT from the lowest to the highest of a sparkline;
+// sparkLost marks a probe that was not answered
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+const sparkLost = '·'
+
+// lostRTT stands for a lost probe among the recent RTTs
+const lostRTT = time.Duration(-1)
+
+// LiveStats accumulates the rounds of a continuous trace (-continuous) into
+// running per-hop and per-flow statistics, like mtr
+type LiveStats struct {
+	Target  string
+	SrcIP   string
+	Started time.Time
+	Rounds  int
+
+	hops map[uint8]*liveHop
+}
+
+// LiveView selects how LiveStats.Render draws the table
+type LiveView struct {
+	PerFlow   bool                   // One row per flow instead of per hop
+	ShowNames bool                   // Host names instead of addresses
+	Names     func(ip string) string // Host name of ip, "" if unknown
+	Width     int                    // Columns of the terminal, 0 for no limit
+}
+
+// liveHop is the statistics of a TTL
+type liveHop struct {
+	all   liveSeries
+	ips   map[string]int // Answers per router
+	flows map[FlowTuple]*liveFlow
+}
+
+// liveFlow is the statistics of a flow at a TTL
+type liveFlow struct {
+	id     uint16 // Flow ID in the round, for ordering
+	series liveSeries
+	ips    map[string]int
+}
+
+// liveSeries is a running summary of the probes of a hop or flow
+type liveSeries struct {
+	sent, received    int
+	last, best, worst time.Duration
+	mean, m2          float64 // Welford's running mean and squared deviations, in ms
+	recent            []time.Duration
+}
+
+// NewLiveStats returns empty statistics
+func NewLiveStats() *LiveStats {
+	return &LiveStats{hops: make(map[uint8]*liveHop)}
+}
+
+// Add accumulates a round of probes
+func (s *LiveStats) Add(round *TracerouteResult) {
+	if s.Rounds == 0 {
+		s.Target, s.SrcIP, s.Started = round.Target, round.SrcIP, round.StartTime
+	}
+	s.Rounds++
+
+	for ttl, hop := range round.Hops {
+		h := s.hops[ttl]
+		if h == nil {
+			h = &liveHop{ips: make(map[string]int), flows: make(map[FlowTuple]*liveFlow)}
+			s.hops[ttl] = h
+		}
+		for _, id := range sortedFlowIDs(hop) {
+			flow := hop.Flows[id]
+			tuple := tupleOf(flow)
+			f := h.flows[tuple]
+			if f == nil {
+				f = &liveFlow{id: id, ips: make(map[string]int)}
+				h.flows[tuple] = f
+			}
+
+			answered := flow.Error == "" && flow.ResponseIP != ""
+			h.all.add(answered, flow.RTT)
+			f.series.add(answered, flow.RTT)
+			if answered {
+				h.ips[flow.ResponseIP]++
+				f.ips[flow.ResponseIP]++
+			}
+		}
+	}
+}
+
+// Reset forgets every round, as the r key does
+func (s *LiveStats) Reset() {
+	*s = *NewLiveStats()
+}
+
+func (l *liveSeries) add(answered bool, rtt time.Duration) {
+	l.sent++
+	if !answered {
+		l.recent = appendRecent(l.recent, lostRTT)
+		return
+	}
+	l.received++
+	l.last = rtt
+	if l.received == 1 || rtt < l.best {
+		l.best = rtt
+	}
+	if rtt > l.worst {
+		l.worst = rtt
+	}
+
+	x := milliseconds(rtt)
+	delta := x - l.mean
+	l.mean += delta / float64(l.received)
+	l.m2 += delta * (x - l.mean)
+	l.recent = appendRecent(l.recent, rtt)
+}
+
+// appendRecent keeps the last sparkWidth probes
+func appendRecent(recent []time.Duration, rtt time.Duration) []time.Duration {
+	recent = append(recent, rtt)
+	if len(recent) > sparkWidth {
+		recent = recent[len(recent)-sparkWidth:]
+	}
+	return recent
+}
+
+func (l *liveSeries) loss() float64 {
+	if l.sent == 0 {
+		return 0
+	}
+	return float64(l.sent-l.received) / float64(l.sent) * 100
+}
+
+func (l *liveSeries) avg() time.Duration {
+	return time.Duration(l.mean * float64(time.Millisecond))
+}
+
+// stdDev is the sample standard deviation of the answered probes
+func (l *liveSeries) stdDev() time.Duration {
+	if l.received < 2 {
+		return 0
+	}
+	return time.Duration(math.Sqrt(l.m2/float64(l.received-1)) * float64(time.Millisecond))
+}
+
+// formatStdDev shows a deviation of zero, which formatRTT would not
+func (l *liveSeries) formatStdDev() string {
+	if l.received < 2 {
+		return "---"
+	}
+	return fmt.Sprintf("%.1fms", milliseconds(l.stdDev()))
+}
+
+// sparkline draws the recent RTTs scaled between their lowest and highest
+func (l *liveSeries) sparkline() string {
+	low, high := lostRTT, lostRTT
+	for _, rtt := range l.recent {
+		if rtt == lostRTT {
+			continue
+		}
+		if low == lostRTT || rtt < low {
+			low = rtt
+		}
+		if rtt > high {
+			high = rtt
+		}
+	}
+
+	var b strings.Builder
+	for _, rtt := range l.recent {
+		switch {
+		case rtt == lostRTT:
+			b.WriteRune(sparkLost)
+		case high == low:
+			b.WriteRune(sparkRunes[0])
+		default:
+			level := int(float64(rtt-low) / float64(high-low) * float64(len(sparkRunes)-1))
+			b.WriteRune(sparkRunes[level])
+		}
+	}
+	return b.String()
+}
+
+// Render draws the statistics as a table, mtr style. Hops answered by
+// several routers list the others below, with their share of the answers.
+func (s *LiveStats) Render(view LiveView) string {
+	var lines []string
+	started := "not yet"
+	if s.Rounds > 0 {
+		started = s.Started.Format(time.DateTime)
+	}
+	lines = append(lines,
+		fmt.Sprintf("Dublin Traceroute to %s from %s: %s since %s", s.Target, s.SrcIP, plural(s.Rounds, "round"), started),
+		"Keys: r reset, n names, d per-flow/per-hop, q quit",
+		"",
+		fmt.Sprintf("%-3s %-32s %6s %5s %8s %8s %8s %8s %8s  %s",
+			"TTL", "Host", "Loss%", "Snt", "Last", "Avg", "Best", "Wrst", "StDev", "Recent"))
+
+	for _, ttl := range s.sortedTTLs() {
+		h := s.hops[ttl]
+		if !view.PerFlow {
+			lines = append(lines, liveRow(fmt.Sprintf("%d", ttl), view.host(h.ips), &h.all))
+			lines = append(lines, view.others(h.ips)...)
+			continue
+		}
+		for i, tuple := range h.sortedFlows() {
+			f := h.flows[tuple]
+			label := ""
+			if i == 0 {
+				label = fmt.Sprintf("%d", ttl)
+			}
+			lines = append(lines, liveRow(label, tuple.String()+" "+view.host(f.ips), &f.series))
+			lines = append(lines, view.others(f.ips)...)
+		}
+	}
+
+	if view.Width > 0 {
+		for i := range lines {
+			lines[i] = fit(lines[i], view.Width)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func liveRow(label, host string, l *liveSeries) string {
+	return fmt.Sprintf("%-3s %-32s %5.1f%% %5d %8s %8s %8s %8s %8s  %s",
+		label, fit(host, 32), l.loss(), l.sent, formatRTT(l.last), formatRTT(l.avg()),
+		formatRTT(l.best), formatRTT(l.worst), l.formatStdDev(), l.sparkline())
+}
+
+func (s *LiveStats) sortedTTLs() []uint8 {
+	ttls := make([]uint8, 0, len(s.hops))
+	for ttl := range s.hops {
+		ttls = append(ttls, ttl)
+	}
+	sort.Slice(ttls, func(i, j int) bool { return ttls[i] < ttls[j] })
+	return ttls
+}
+
+func (h *liveHop) sortedFlows() []FlowTuple {
+	tuples := make([]FlowTuple, 0, len(h.flows))
+	for tuple := range h.flows {
+		tuples = append(tuples, tuple)
+	}
+	sort.Slice(tuples, func(i, j int) bool { return h.flows[tuples[i]].id < h.flows[tuples[j]].id })
+	return tuples
+}
+
+// routersByAnswers orders the routers of a hop by answers, then address
+func routersByAnswers(ips map[string]int) []string {
+	routers := make([]string, 0, len(ips))
+	for ip := range ips {
+		routers = append(routers, ip)
+	}
+	sort.Slice(routers, func(i, j int) bool {
+		if ips[routers[i]] != ips[routers[j]] {
+			return ips[routers[i]] > ips[routers[j]]
+		}
+		return routers[i] < routers[j]
+	})
+	return routers
+}
+
+// host names the router that answered most, "???" if none did
+func (v LiveView) host(ips map[string]int) string {
+	routers := routersByAnswers(ips)
+	if len(routers) == 0 {
+		return "???"
+	}
+	return v.name(routers[0])
+}
+
+// others lists the routers after the first, with their share of answers
+func (v LiveView) others(ips map[string]int) []string {
+	routers := routersByAnswers(ips)
+	if len(routers) < 2 {
+		return nil
+	}
+	total := 0
+	for _, n := range ips {
+		total += n
+	}
+	lines := make([]string, 0, len(routers)-1)
+	for _, ip := range routers[1:] {
+		lines = append(lines, fmt.Sprintf("    %-32s %5.1f%% of answers", fit(v.name(ip), 32), float64(ips[ip])/float64(total)*100))
+	}
+	return lines
+}
+
+func (v LiveView) name(ip string) string {
+	if v.ShowNames && v.Names != nil {
+		if name := v.Names(ip); name != "" {
+			return name
+		}
+	}
+	return ip
+}
@@ -0,0 +1,133 @@
+package results
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// liveRounds returns rounds of two flows to 8.8.8.8 through a diamond at
+// hop 2; hop 1 answers with the given RTTs, 0 for lost
+func liveRounds(rtts ...int) []*TracerouteResult {
+	rounds := make([]*TracerouteResult, len(rtts))
+	for i, rtt := range rtts {
+		first := "192.168.1.1"
+		if rtt == 0 {
+			first = ""
+		}
+		round := trace(1, 2, [][]string{
+			{first, "10.0.0.1", "8.8.8.8"},
+			{first, "10.0.0.2", "8.8.8.8"},
+		})
+		withRTT(round, 1, ms(rtt))
+		withRTT(round, 2, ms(10))
+		withRTT(round, 3, ms(20))
+		rounds[i] = round
+	}
+	return rounds
+}
+
+func TestLiveStatsAdd(t *testing.T) {
+	s := NewLiveStats()
+	for _, round := range liveRounds(2, 4, 0, 6) {
+		s.Add(round)
+	}
+
+	if s.Rounds != 4 || s.Target != "8.8.8.8" {
+		t.Fatalf("got %d rounds to %s", s.Rounds, s.Target)
+	}
+	hop := s.hops[1].all
+	if hop.sent != 8 || hop.received != 6 || hop.loss() != 25 {
+		t.Errorf("hop 1: sent %d, received %d, loss %.1f%%", hop.sent, hop.received, hop.loss())
+	}
+	if hop.last != ms(6) || hop.best != ms(2) || hop.worst != ms(6) || hop.avg() != ms(4) {
+		t.Errorf("hop 1: last %v, best %v, worst %v, avg %v", hop.last, hop.best, hop.worst, hop.avg())
+	}
+	// Sample standard deviation of 2, 2, 4, 4, 6, 6
+	if got := milliseconds(hop.stdDev()); got < 1.788 || got > 1.789 {
+		t.Errorf("hop 1: stddev %.3fms, want 1.789ms", got)
+	}
+	if got := hop.sparkline(); got != "▁▁▄▄··██" {
+		t.Errorf("hop 1: sparkline %q", got)
+	}
+
+	if len(s.hops[2].flows) != 2 || s.hops[2].ips["10.0.0.1"] != 4 || s.hops[2].ips["10.0.0.2"] != 4 {
+		t.Errorf("hop 2: got flows %+v, routers %v", s.hops[2].flows, s.hops[2].ips)
+	}
+
+	s.Reset()
+	if s.Rounds != 0 || len(s.hops) != 0 {
+		t.Errorf("after reset: %d rounds, %d hops", s.Rounds, len(s.hops))
+	}
+}
+
+func TestLiveSparklineKeepsRecent(t *testing.T) {
+	var l liveSeries
+	for i := 1; i <= sparkWidth+5; i++ {
+		l.add(true, ms(i))
+	}
+	if len(l.recent) != sparkWidth || l.recent[0] != ms(6) {
+		t.Errorf("got %d recent probes from %v", len(l.recent), l.recent[0])
+	}
+	if l.sent != sparkWidth+5 {
+		t.Errorf("got %d sent", l.sent)
+	}
+}
+
+func TestLiveStatsRender(t *testing.T) {
+	s := NewLiveStats()
+	for _, round := range liveRounds(2, 4) {
+		s.Add(round)
+	}
+	names := func(ip string) string {
+		if ip == "8.8.8.8" {
+			return "dns.google"
+		}
+		return ""
+	}
+
+	perHop := s.Render(LiveView{Names: names})
+	for _, want := range []string{
+		"2 rounds since",
+		"1   192.168.1.1",
+		"2   10.0.0.1",
+		"    10.0.0.2                          50.0% of answers",
+		"3   8.8.8.8                            0.0%     4   20.0ms   20.0ms   20.0ms   20.0ms    0.0ms  ▁▁▁▁",
+	} {
+		if !strings.Contains(perHop, want) {
+			t.Errorf("per-hop view lacks %q:\n%s", want, perHop)
+		}
+	}
+
+	perFlow := s.Render(LiveView{PerFlow: true, ShowNames: true, Names: names})
+	for _, want := range []string{
+		"2   33434->33434 10.0.0.1",
+		"    33435->33434 10.0.0.2",
+		"3   33434->33434 dns.google",
+	} {
+		if !strings.Contains(perFlow, want) {
+			t.Errorf("per-flow view lacks %q:\n%s", want, perFlow)
+		}
+	}
+
+	for _, line := range strings.Split(s.Render(LiveView{Width: 40}), "\n") {
+		if n := len([]rune(line)); n > 40 {
+			t.Errorf("line of %d columns: %q", n, line)
+		}
+	}
+}
+
+func TestLiveStatsAddsTimeouts(t *testing.T) {
+	s := NewLiveStats()
+	round := trace(1, 1, [][]string{{"", "8.8.8.8"}})
+	round.StartTime = time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	s.Add(round)
+
+	if !s.Started.Equal(round.StartTime) {
+		t.Errorf("started %v", s.Started)
+	}
+	out := s.Render(LiveView{})
+	if !strings.Contains(out, "1   ???                              100.0%     1      ---") || !strings.HasSuffix(out, "  ▁\n") {
+		t.Errorf("got\n%s", out)
+	}
+}
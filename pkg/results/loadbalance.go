@@ -0,0 +1,153 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"math"
+	"sort"
+)
+
+// Kinds of load balancing told apart by re-probing
+const (
+	PerFlow        = "per-flow"        // A flow keeps its next hop; flows spread
+	PerPacket      = "per-packet"      // Probes of one flow take different next hops
+	PerDestination = "per-destination" // Only addresses near the target take other next hops
+)
+
+// LoadBalancer is a hop whose next hops were classified by re-probing
+type LoadBalancer struct {
+	TTL      uint8    `json:"ttl"`
+	Kind     string   `json:"kind"`      // PerFlow, PerPacket or PerDestination
+	NextHops []string `json:"next_hops"` // Routers seen at this TTL
+	Probes   int      `json:"probes"`    // Probes the classification is based on
+	Evidence string   `json:"evidence"`
+}
+
+// perPacketHops returns the TTLs balanced per packet
+func perPacketHops(balancers []LoadBalancer) []uint8 {
+	var ttls []uint8
+	for _, lb := range balancers {
+		if lb.Kind == PerPacket {
+			ttls = append(ttls, lb.TTL)
+		}
+	}
+	return ttls
+}
+
+// Header fields varied to find what load balancers hash on
+const (
+	FieldSrcPort   = "sport"      // UDP/TCP source port
+	FieldDstPort   = "dport"      // UDP/TCP destination port
+	FieldIPID      = "ip_id"      // IPv4 identification
+	FieldTOS       = "tos"        // IPv4 TOS or IPv6 traffic class
+	FieldFlowLabel = "flow_label" // IPv6 flow label
+	FieldSrc       = "src"        // Source address
+)
+
+// HashFields tells which header fields decide the next hop at a
+// load-balancing hop. Each field is varied on its own while the others stay
+// constant.
+type HashFields struct {
+	TTL       uint8    `json:"ttl"`
+	Hashed    []string `json:"hashed"`              // Fields that change the next hop
+	Ignored   []string `json:"ignored"`             // Fields varied without effect
+	Inherited []string `json:"inherited,omitempty"` // Fields that already changed the previous hop
+	PerPacket bool     `json:"per_packet,omitempty"`
+	NextHops  []string `json:"next_hops"`
+	Probes    int      `json:"probes"`
+}
+
+// splitAlpha is the chance that an even split is reported unequal: the
+// intervals of the shares hold together with 95% confidence
+const splitAlpha = 0.05
+
+// splitZ is the normal quantile of the intervals of k shares. Bonferroni
+// leaves each interval alpha/k, so that an even split over many next hops
+// is not flagged because one of its intervals missed by chance.
+func splitZ(k int) float64 {
+	return math.Sqrt2 * math.Erfinv(1-splitAlpha/float64(k))
+}
+
+// NextHopShare is the fraction of flows one next hop receives, with its
+// confidence interval
+type NextHopShare struct {
+	IP       string  `json:"ip"`
+	Flows    int     `json:"flows"`
+	Fraction float64 `json:"fraction"`
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+}
+
+// ECMPSplit is how a load-balancing hop spreads flows over its next hops
+type ECMPSplit struct {
+	TTL     uint8          `json:"ttl"`
+	Flows   int            `json:"flows"`   // Answered flows the shares are estimated from
+	Shares  []NextHopShare `json:"shares"`  // Largest share first
+	Unequal bool           `json:"unequal"` // An equal share lies outside the interval of some next hop
+}
+
+// NewECMPSplit estimates the split of the hop at ttl from the number of
+// flows each next hop answered. The split is unequal when the equal share
+// falls outside the Wilson score interval of a next hop, as with weighted
+// (UCMP) balancing or a failed member of a bundle; the intervals are
+// widened for the number of next hops, so they hold at 95% together.
+func NewECMPSplit(ttl uint8, flows map[string]int) ECMPSplit {
+	split := ECMPSplit{TTL: ttl}
+	for _, n := range flows {
+		split.Flows += n
+	}
+	if split.Flows == 0 {
+		return split
+	}
+
+	equal := 1 / float64(len(flows))
+	z := splitZ(len(flows))
+	for ip, n := range flows {
+		low, high := wilsonInterval(n, split.Flows, z)
+		split.Shares = append(split.Shares, NextHopShare{
+			IP:       ip,
+			Flows:    n,
+			Fraction: float64(n) / float64(split.Flows),
+			Low:      low,
+			High:     high,
+		})
+		if equal < low || equal > high {
+			split.Unequal = true
+		}
+	}
+	sort.Slice(split.Shares, func(i, j int) bool {
+		a, b := split.Shares[i], split.Shares[j]
+		if a.Flows != b.Flows {
+			return a.Flows > b.Flows
+		}
+		return a.IP < b.IP
+	})
+	return split
+}
+
+// wilsonInterval returns the Wilson score interval of a proportion of k
+// successes in n trials, which unlike the normal approximation stays within
+// [0, 1] and holds for shares near 0 or 1
+func wilsonInterval(k, n int, z float64) (low, high float64) {
+	p := float64(k) / float64(n)
+	z2n := z * z / float64(n)
+	centre := (p + z2n/2) / (1 + z2n)
+	margin := z * math.Sqrt(p*(1-p)/float64(n)+z2n/(4*float64(n))) / (1 + z2n)
+	return math.Max(0, centre-margin), math.Min(1, centre+margin)
+}
+
+// unequalSplitHops returns the TTLs whose split is unequal
+func unequalSplitHops(splits []ECMPSplit) []uint8 {
+	var ttls []uint8
+	for _, split := range splits {
+		if split.Unequal {
+			ttls = append(ttls, split.TTL)
+		}
+	}
+	return ttls
+}
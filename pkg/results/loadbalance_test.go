@@ -0,0 +1,105 @@
+package results
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestWilsonInterval(t *testing.T) {
+	// 48 of 64 flows: 75% with the textbook Wilson interval
+	low, high := wilsonInterval(48, 64, 1.96)
+	if math.Abs(low-0.632) > 0.001 || math.Abs(high-0.840) > 0.001 {
+		t.Errorf("got %.3f-%.3f, want 0.632-0.840", low, high)
+	}
+
+	// No successes still leaves room above zero
+	low, high = wilsonInterval(0, 20, 1.96)
+	if low != 0 || high <= 0 || high > 0.2 {
+		t.Errorf("got %.3f-%.3f for 0 of 20", low, high)
+	}
+}
+
+func TestECMPSplit(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		flows   map[string]int
+		unequal bool
+	}{
+		{"even", map[string]int{"10.0.0.1": 33, "10.0.0.2": 31}, false},
+		{"weighted", map[string]int{"10.0.0.1": 48, "10.0.0.2": 16}, true},
+		{"three even", map[string]int{"10.0.0.1": 22, "10.0.0.2": 20, "10.0.0.3": 22}, false},
+	} {
+		split := NewECMPSplit(3, tc.flows)
+		if split.Unequal != tc.unequal {
+			t.Errorf("%s: unequal = %v, want %v (%+v)", tc.name, split.Unequal, tc.unequal, split.Shares)
+		}
+		if split.Flows != 64 || len(split.Shares) != len(tc.flows) {
+			t.Errorf("%s: got %d flows over %d shares", tc.name, split.Flows, len(split.Shares))
+		}
+		sum := 0.0
+		for i, share := range split.Shares {
+			sum += share.Fraction
+			if share.Low > share.Fraction || share.High < share.Fraction {
+				t.Errorf("%s: %s share %.3f outside its interval %.3f-%.3f", tc.name, share.IP, share.Fraction, share.Low, share.High)
+			}
+			if i > 0 && share.Flows > split.Shares[i-1].Flows {
+				t.Errorf("%s: shares not sorted largest first", tc.name)
+			}
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("%s: fractions sum to %v", tc.name, sum)
+		}
+	}
+}
+
+func TestSplitZ(t *testing.T) {
+	// Two-sided normal quantiles of 0.05/k
+	for k, want := range map[int]float64{1: 1.960, 4: 2.498, 16: 2.955} {
+		if got := splitZ(k); math.Abs(got-want) > 0.001 {
+			t.Errorf("k=%d: got %.3f, want %.3f", k, got, want)
+		}
+	}
+}
+
+func TestEvenSplitsAreRarelyUnequal(t *testing.T) {
+	// Flows spread uniformly at random: whatever the number of next hops,
+	// at most about 5% of the splits may be flagged
+	rng := rand.New(rand.NewSource(1))
+	for _, tc := range []struct{ nextHops, flows int }{{2, 64}, {4, 64}, {16, 256}} {
+		const trials = 2000
+		flagged := 0
+		for trial := 0; trial < trials; trial++ {
+			counts := make(map[string]int)
+			for i := 0; i < tc.flows; i++ {
+				counts[fmt.Sprintf("10.0.0.%d", rng.Intn(tc.nextHops)+1)]++
+			}
+			if NewECMPSplit(3, counts).Unequal {
+				flagged++
+			}
+		}
+		if rate := float64(flagged) / trials; rate > 0.06 {
+			t.Errorf("%d-way split of %d flows: %.1f%% of even splits flagged unequal", tc.nextHops, tc.flows, rate*100)
+		}
+	}
+}
+
+func TestUnequalSplitIsRecommended(t *testing.T) {
+	tr := &TracerouteResult{
+		Hops:       map[uint8]*HopResult{},
+		ECMPSplits: []ECMPSplit{NewECMPSplit(3, map[string]int{"10.0.0.1": 48, "10.0.0.2": 16})},
+	}
+	analysis := tr.AnalyzeNetwork()
+	if len(analysis.ECMPSplits) != 1 {
+		t.Fatalf("got %d splits in the analysis, want 1", len(analysis.ECMPSplits))
+	}
+	found := false
+	for _, rec := range analysis.Recommendations {
+		found = found || strings.Contains(rec, "Unequal ECMP split at hop(s) [3]")
+	}
+	if !found {
+		t.Errorf("no recommendation for the unequal split: %v", analysis.Recommendations)
+	}
+}
@@ -0,0 +1,128 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	"sort"
+)
+
+// NATHop identifies a hop that translates probes
+type NATHop struct {
+	TTL       uint8    `json:"ttl"` // 0 when the translation happens before the first hop
+	IP        string   `json:"ip,omitempty"`
+	Hostname  string   `json:"hostname,omitempty"`
+	NATID     uint16   `json:"nat_id"`    // NAT ID seen by the hops behind this one
+	Rewritten []string `json:"rewritten"` // Probe fields this hop changes
+}
+
+// detectNATs walks the hops in TTL order and reports where the quoted probes
+// change. A NAT rewrites probes it forwards, so its own Time Exceeded still
+// quotes the original and the change appears one hop further: the NAT is the
+// hop just before the first TTL showing a new NAT ID or a rewritten IP ID.
+func (tr *TracerouteResult) detectNATs() []NATHop {
+	var hops []NATHop
+	var prevNATID uint16
+	prevIDRewritten := false
+
+	for _, ttl := range tr.sortedTTLs() {
+		natID, idRewritten, ok := quotedState(tr.Hops[ttl])
+		if !ok {
+			continue // nothing quoted at this TTL
+		}
+
+		var rewritten []string
+		if natID != prevNATID {
+			rewritten = append(rewritten, "checksum")
+		}
+		if idRewritten && !prevIDRewritten {
+			rewritten = append(rewritten, "ip_id")
+		}
+
+		if len(rewritten) > 0 {
+			nat := NATHop{TTL: ttl - 1, NATID: natID, Rewritten: rewritten}
+			if prev, ok := tr.Hops[ttl-1]; ok && ttl > 1 {
+				nat.IP, nat.Hostname = prev.responder()
+			}
+			hops = append(hops, nat)
+		}
+
+		prevNATID = natID
+		prevIDRewritten = idRewritten
+	}
+
+	return hops
+}
+
+// quotedState returns the most common NAT ID among the flows of a hop that
+// quoted the probe, and whether most of them had their IP ID rewritten
+func quotedState(hop *HopResult) (uint16, bool, bool) {
+	counts := make(map[uint16]int)
+	quoted, withID, idRewritten := 0, 0, 0
+
+	for _, flow := range hop.Flows {
+		if flow.QuotedChecksum == 0 && flow.QuotedIPID == 0 {
+			continue
+		}
+		quoted++
+		if flow.QuotedChecksum != 0 {
+			counts[flow.NATID]++
+		}
+		if flow.QuotedIPID != 0 {
+			withID++
+			if flow.QuotedIPID != flow.IPID {
+				idRewritten++
+			}
+		}
+	}
+	if quoted == 0 {
+		return 0, false, false
+	}
+
+	var natID uint16
+	best := 0
+	for id, n := range counts {
+		if n > best || (n == best && id < natID) {
+			natID, best = id, n
+		}
+	}
+	return natID, withID > 0 && idRewritten*2 > withID, true
+}
+
+// responder returns the address and name of the router answering most
+// flows at this hop
+func (hop *HopResult) responder() (string, string) {
+	counts := make(map[string]int)
+	hostnames := make(map[string]string)
+	for _, flow := range hop.Flows {
+		if flow.Error != "" || flow.ResponseIP == "" {
+			continue
+		}
+		counts[flow.ResponseIP]++
+		if flow.Hostname != "" {
+			hostnames[flow.ResponseIP] = flow.Hostname
+		}
+	}
+
+	ip := ""
+	for candidate, n := range counts {
+		if n > counts[ip] || (n == counts[ip] && candidate < ip) {
+			ip = candidate
+		}
+	}
+	return ip, hostnames[ip]
+}
+
+// sortedTTLs returns the TTLs of the trace in ascending order
+func (tr *TracerouteResult) sortedTTLs() []uint8 {
+	ttls := make([]uint8, 0, len(tr.Hops))
+	for ttl := range tr.Hops {
+		ttls = append(ttls, ttl)
+	}
+	sort.Slice(ttls, func(i, j int) bool { return ttls[i] < ttls[j] })
+	return ttls
+}
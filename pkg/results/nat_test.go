@@ -0,0 +1,50 @@
+package results
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// natTrace builds a trace where each TTL has one flow quoting the given NAT
+// ID; a negative value is a hop that quoted nothing
+func natTrace(natIDs []int) *TracerouteResult {
+	tr := &TracerouteResult{Hops: make(map[uint8]*HopResult)}
+	for i, id := range natIDs {
+		ttl := uint8(i + 1)
+		flow := &FlowResult{ResponseIP: fmt.Sprintf("10.0.0.%d", ttl), Checksum: 0x1000, IPID: 0x1000}
+		if id < 0 {
+			flow.Error = "timeout"
+			flow.ResponseIP = ""
+		} else {
+			flow.QuotedIPID = 0x1000
+			flow.QuotedChecksum = 0x1000 - uint16(id)
+			flow.NATID = uint16(id)
+		}
+		tr.Hops[ttl] = &HopResult{TTL: ttl, Flows: map[uint16]*FlowResult{0: flow}}
+	}
+	return tr
+}
+
+func TestDetectNATs(t *testing.T) {
+	// NAT at TTL 2; TTL 4 is silent, so the second change is pinned on it
+	nats := natTrace([]int{0, 0, 7, -1, 9}).detectNATs()
+	want := []NATHop{
+		{TTL: 2, IP: "10.0.0.2", NATID: 7, Rewritten: []string{"checksum"}},
+		{TTL: 4, NATID: 9, Rewritten: []string{"checksum"}},
+	}
+	if !reflect.DeepEqual(nats, want) {
+		t.Errorf("got %+v, want %+v", nats, want)
+	}
+}
+
+func TestDetectNATBeforeFirstHop(t *testing.T) {
+	tr := natTrace([]int{5, 5})
+	nats := tr.detectNATs()
+	if len(nats) != 1 || nats[0].TTL != 0 || nats[0].IP != "" {
+		t.Errorf("got %+v, want a single NAT before the first hop", nats)
+	}
+	if !tr.AnalyzeNetwork().NATDetected {
+		t.Error("NetworkAnalysis should report the NAT")
+	}
+}
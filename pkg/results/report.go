@@ -0,0 +1,212 @@
+/* SPDX-License-Identifier: BSD-2-Clause */
+
+//
+// This file is based on code from the original dublin-traceroute project:
+//   https://github.com/insomniacslk/dublin-traceroute
+// Copyright (c) insomniacslk (https://github.com/insomniacslk)
+
+package results
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net"
+	"strings"
+	"time"
+)
+
+//go:embed report.html
+var reportHTML string
+
+// reportTemplate renders the HTML report; the graph goes into the page
+// script as JSON, escaped by html/template
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms": func(d time.Duration) string {
+		if d <= 0 {
+			return "---"
+		}
+		return fmt.Sprintf("%.1f ms", milliseconds(d))
+	},
+	"pct":  func(f float64) float64 { return f * 100 },
+	"join": func(list []string) string { return strings.Join(list, ", ") },
+}).Parse(reportHTML))
+
+// Layout of the report graph, in SVG units
+const (
+	reportColumn = 200 // Between routers of one TTL
+	reportRow    = 110 // Between TTLs
+	reportMargin = 90
+)
+
+// htmlReport is what the report template renders
+type htmlReport struct {
+	Result   *TracerouteResult
+	Analysis *NetworkAnalysis
+	Stats    []*HopStatistics // By TTL
+	Graph    reportGraph
+}
+
+// reportGraph is the topology laid out for the page: one row per TTL
+type reportGraph struct {
+	Width  int          `json:"width"`
+	Height int          `json:"height"`
+	Nodes  []reportNode `json:"nodes"`
+	Edges  []reportEdge `json:"edges"`
+}
+
+type reportNode struct {
+	ID        string        `json:"id"`
+	X         int           `json:"x"`
+	Y         int           `json:"y"`
+	TTL       uint8         `json:"ttl"`
+	IP        string        `json:"ip"`
+	Hostname  string        `json:"hostname,omitempty"`
+	RTT       float64       `json:"rtt"` // Mean, in milliseconds
+	Anonymous bool          `json:"anonymous"`
+	Target    bool          `json:"target"`
+	Source    bool          `json:"source"`
+	NAT       string        `json:"nat,omitempty"`  // Fields the NAT rewrites
+	Loss      float64       `json:"loss,omitempty"` // Anonymous nodes: share of the TTL's probes lost
+	Probes    []reportProbe `json:"probes"`
+}
+
+// reportProbe is one probe answered by a node, shown when it is clicked
+type reportProbe struct {
+	FlowID uint16  `json:"flow_id"`
+	Flow   string  `json:"flow"`
+	RTT    float64 `json:"rtt"` // Milliseconds
+	ICMP   string  `json:"icmp,omitempty"`
+	TCP    string  `json:"tcp,omitempty"`
+	Error  string  `json:"error,omitempty"`
+	NAT    string  `json:"nat,omitempty"`
+}
+
+type reportEdge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Label  string `json:"label"`
+	Dashed bool   `json:"dashed"`
+}
+
+// ToHTML renders a self-contained HTML report: the multipath graph with
+// zoom, pan and per-probe details on click, the MTR statistics table and
+// the network analysis. Scripts and styles are inlined, so the file opens
+// offline.
+func (tr *TracerouteResult) ToHTML() (string, error) {
+	report := htmlReport{
+		Result:   tr,
+		Analysis: tr.AnalyzeNetwork(),
+		Graph:    tr.reportGraph(),
+	}
+
+	stats := tr.CalculateHopStatistics()
+	for _, ttl := range tr.sortedTTLs() {
+		report.Stats = append(report.Stats, stats[ttl])
+	}
+
+	var b strings.Builder
+	if err := reportTemplate.Execute(&b, report); err != nil {
+		return "", fmt.Errorf("failed to render HTML report: %w", err)
+	}
+	return b.String(), nil
+}
+
+// reportGraph lays the topology out one TTL per row, centring each row
+func (tr *TracerouteResult) reportGraph() reportGraph {
+	t := tr.topology()
+
+	widest := 0
+	rows := make(map[uint8]int)
+	for _, node := range t.nodes {
+		rows[node.TTL]++
+		if rows[node.TTL] > widest {
+			widest = rows[node.TTL]
+		}
+	}
+
+	g := reportGraph{
+		Width:  2*reportMargin + (widest-1)*reportColumn,
+		Height: 2*reportMargin + (len(rows)-1)*reportRow,
+	}
+	row, column := -1, 0
+	for i, node := range t.nodes {
+		if i == 0 || node.TTL != t.nodes[i-1].TTL {
+			row++
+			column = 0
+		}
+		offset := (widest - rows[node.TTL]) * reportColumn / 2
+		n := reportNode{
+			ID:        nodeID(node.NodeKey),
+			X:         reportMargin + offset + column*reportColumn,
+			Y:         reportMargin + row*reportRow,
+			TTL:       node.TTL,
+			IP:        node.IP,
+			Hostname:  node.Hostname,
+			RTT:       milliseconds(node.RTT),
+			Anonymous: node.IP == AnonymousIP,
+			Target:    node.IP == tr.Target,
+			Source:    node.TTL == 0,
+			NAT:       strings.Join(t.nats[node.NodeKey], ", "),
+			Loss:      t.lossRate(node),
+			Probes:    tr.probesAt(node.NodeKey),
+		}
+		g.Nodes = append(g.Nodes, n)
+		column++
+	}
+
+	for _, edge := range t.edges {
+		g.Edges = append(g.Edges, reportEdge{
+			From:   nodeID(edge.From),
+			To:     nodeID(edge.To),
+			Label:  flowLabel(edge.Flows) + " (" + plural(len(edge.Flows), "flow") + ")",
+			Dashed: edge.To.IP == AnonymousIP,
+		})
+	}
+	return g
+}
+
+// probesAt returns the probes answered by the node key, or lost at its TTL
+// for the anonymous node, by FlowID
+func (tr *TracerouteResult) probesAt(key NodeKey) []reportProbe {
+	hop, ok := tr.Hops[key.TTL]
+	if !ok {
+		return nil
+	}
+	var probes []reportProbe
+	for _, flowID := range sortedFlowIDs(hop) {
+		flow := hop.Flows[flowID]
+		answered := flow.Error == "" && flow.ResponseIP != ""
+		if (key.IP == AnonymousIP && answered) || (key.IP != AnonymousIP && (!answered || flow.ResponseIP != key.IP)) {
+			continue
+		}
+		probe := reportProbe{
+			FlowID: flow.FlowID,
+			Flow:   tupleOf(flow).String(),
+			TCP:    flow.TCPState,
+			Error:  flow.Error,
+			NAT:    strings.Join(flow.NATRewritten, ", "),
+		}
+		if answered {
+			probe.RTT = milliseconds(flow.RTT)
+			probe.ICMP = icmpName(flow)
+		}
+		probes = append(probes, probe)
+	}
+	return probes
+}
+
+// icmpName describes the ICMP message that answered a probe
+func icmpName(flow *FlowResult) string {
+	if flow.ICMPType == 0 && flow.ICMPCode == 0 && flow.TCPState != "" {
+		return "" // Answered over TCP
+	}
+	names := map[uint8]string{0: "Echo Reply", 3: "Destination Unreachable", 11: "Time Exceeded"}
+	if ip := net.ParseIP(flow.ResponseIP); ip != nil && ip.To4() == nil {
+		names = map[uint8]string{1: "Destination Unreachable", 3: "Time Exceeded", 129: "Echo Reply"}
+	}
+	if name, ok := names[flow.ICMPType]; ok {
+		return fmt.Sprintf("%s (type %d, code %d)", name, flow.ICMPType, flow.ICMPCode)
+	}
+	return fmt.Sprintf("type %d, code %d", flow.ICMPType, flow.ICMPCode)
+}
@@ -0,0 +1,58 @@
+package results
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToHTMLIsSelfContained(t *testing.T) {
+	tr := exportTrace()
+	tr.Hops[2].Flows[0].Hostname = `</script><script>alert(1)</script>`
+
+	page, err := tr.ToHTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, external := range []string{`src="http`, `href="http`, "<link", "<script src", "@import"} {
+		if strings.Contains(page, external) {
+			t.Errorf("report loads %s", external)
+		}
+	}
+	if strings.Contains(page, "<script>alert(1)") {
+		t.Error("hostname was not escaped")
+	}
+	for _, want := range []string{
+		"<h1>Dublin Traceroute to 8.8.8.8</h1>",
+		`"id":"ttl3_anonymous"`,
+		`"flow":"33436-\u003e33434"`, // the probe lost at TTL 3, in the graph data
+		"<td>8.8.8.8</td>",           // MTR table
+		"NAT at hop 2 (10.0.0.1): rewrites checksum.",
+		"Load balancing at hop(s) 3.",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("report lacks %s", want)
+		}
+	}
+}
+
+func TestReportGraphLayout(t *testing.T) {
+	g := exportTrace().reportGraph()
+
+	// Source and four TTLs; three nodes at TTL 3 set the width
+	if g.Width != 2*reportMargin+2*reportColumn || g.Height != 2*reportMargin+4*reportRow {
+		t.Errorf("got %dx%d", g.Width, g.Height)
+	}
+	at := make(map[string]reportNode)
+	for _, n := range g.Nodes {
+		at[n.ID] = n
+	}
+	if n := at["ttl1_192.168.1.1"]; n.X != reportMargin+reportColumn || n.Y != reportMargin+reportRow {
+		t.Errorf("single router not centred: %+v", n)
+	}
+	if n := at["ttl3_anonymous"]; n.X != reportMargin || len(n.Probes) != 1 || n.Probes[0].Error != "timeout" {
+		t.Errorf("got anonymous node %+v", n)
+	}
+	if n := at["ttl3_10.1.0.1"]; len(n.Probes) != 2 || n.Probes[0].ICMP != "Time Exceeded (type 11, code 0)" {
+		t.Errorf("got probes %+v at 10.1.0.1", n.Probes)
+	}
+}
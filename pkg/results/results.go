@@ -11,10 +11,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"os"
 	"strings"
 	"time"
 )
 
+// States of the destination port of a TCP trace
+const (
+	PortOpen     = "open"     // The target answered SYN-ACK
+	PortClosed   = "closed"   // The target answered RST
+	PortFiltered = "filtered" // The target never answered the SYN
+)
+
 // TracerouteResult represents the complete result of a Dublin Traceroute
 type TracerouteResult struct {
 	Target    string               `json:"target"`
@@ -23,12 +31,30 @@ type TracerouteResult struct {
 	EndTime   time.Time            `json:"end_time"`
 	Duration  time.Duration        `json:"duration"`
 	Hops      map[uint8]*HopResult `json:"hops"`
+	PortState string               `json:"port_state,omitempty"` // TCP traces: state of the destination port
+
+	// Load-balancing hops classified by re-probing (-classify-lb), the
+	// header fields they hash on (-hash-fields) and how they split flows
+	// (-ecmp-split)
+	LoadBalancers []LoadBalancer `json:"load_balancers,omitempty"`
+	HashFields    []HashFields   `json:"hash_fields,omitempty"`
+	ECMPSplits    []ECMPSplit    `json:"ecmp_splits,omitempty"`
+
+	// Replies that arrived after their probe timed out, and replies that
+	// could not be attributed to any probe. Neither is counted in Hops.
+	LateReplies      []StrayReply `json:"late_replies,omitempty"`
+	UnmatchedReplies []StrayReply `json:"unmatched_replies,omitempty"`
 }
 
 // HopResult represents all flows at a specific TTL level
 type HopResult struct {
 	TTL   uint8                  `json:"ttl"`
 	Flows map[uint16]*FlowResult `json:"flows"`
+
+	// MDA traces: flows probed before the stopping rule was met (or the flow
+	// limit reached), and the confidence that no next hop was missed
+	MDAFlows      int     `json:"mda_flows,omitempty"`
+	MDAConfidence float64 `json:"mda_confidence,omitempty"`
 }
 
 // FlowResult represents a single probe flow result
@@ -36,6 +62,10 @@ type FlowResult struct {
 	FlowID     uint16        `json:"flow_id"`
 	SrcPort    uint16        `json:"src_port"`
 	DstPort    uint16        `json:"dst_port"`
+	FlowLabel  uint32        `json:"flow_label,omitempty"` // IPv6 probes: flow label of the flow
+	EchoID     uint16        `json:"echo_id,omitempty"`    // ICMP probes: Echo identifier of the flow
+	Checksum   uint16        `json:"checksum,omitempty"`   // ICMP: constant for the flow; UDP: equals the IP ID
+	IPID       uint16        `json:"ip_id,omitempty"`      // IPv4 ID of the probe
 	SentTime   time.Time     `json:"sent_time"`
 	RecvTime   time.Time     `json:"recv_time"`
 	RTT        time.Duration `json:"rtt"`
@@ -43,7 +73,29 @@ type FlowResult struct {
 	Hostname   string        `json:"hostname,omitempty"`
 	ICMPType   uint8         `json:"icmp_type,omitempty"`
 	ICMPCode   uint8         `json:"icmp_code,omitempty"`
+	TCPState   string        `json:"tcp_state,omitempty"` // TCP probes answered by the target: open or closed
 	Error      string        `json:"error,omitempty"`
+
+	// NAT detection: the probe as quoted back in the ICMP error. NATID is
+	// the change made to the checksum by NATs before the responding hop;
+	// NATRewritten lists the quoted fields ("ip_id", "checksum") that differ
+	// from the probe sent.
+	QuotedIPID     uint16   `json:"quoted_ip_id,omitempty"`
+	QuotedChecksum uint16   `json:"quoted_checksum,omitempty"`
+	NATID          uint16   `json:"nat_id,omitempty"`
+	NATRewritten   []string `json:"nat_rewritten,omitempty"`
+}
+
+// StrayReply is a reply that did not answer the probe being waited for
+type StrayReply struct {
+	From     string        `json:"from"`
+	RecvTime time.Time     `json:"recv_time"`
+	ICMPType uint8         `json:"icmp_type"`
+	ICMPCode uint8         `json:"icmp_code"`
+	TTL      uint8         `json:"ttl,omitempty"`    // Late replies: TTL of the probe answered
+	FlowID   uint16        `json:"flow_id"`          // Late replies: flow of the probe answered
+	RTT      time.Duration `json:"rtt,omitempty"`    // Late replies: time since that probe was sent
+	Reason   string        `json:"reason,omitempty"` // Unmatched replies: why no probe was found
 }
 
 // Path represents a unique path through the network
@@ -64,6 +116,10 @@ type PathHop struct {
 type NetworkAnalysis struct {
 	HasLoadBalancing  bool           `json:"has_load_balancing"`
 	LoadBalancingHops []uint8        `json:"load_balancing_hops,omitempty"`
+	Diamonds          []Diamond      `json:"diamonds,omitempty"`       // Where flows split and meet again
+	ECMPSplits        []ECMPSplit    `json:"ecmp_splits,omitempty"`    // Share of flows each next hop gets
+	LoadBalancers     []LoadBalancer `json:"load_balancers,omitempty"` // Kind of each balancer, with evidence
+	HashFields        []HashFields   `json:"hash_fields,omitempty"`    // Fields each balancer hashes on
 	PacketLossRate    float64        `json:"packet_loss_rate"`
 	AverageRTT        time.Duration  `json:"average_rtt"`
 	MinRTT            time.Duration  `json:"min_rtt"`
@@ -71,6 +127,8 @@ type NetworkAnalysis struct {
 	HighLatencyHops   []LatencyIssue `json:"high_latency_hops,omitempty"`
 	AsymmetricRouting bool           `json:"asymmetric_routing_detected"`
 	UniqueRouters     int            `json:"unique_routers"`
+	NATDetected       bool           `json:"nat_detected"`
+	NATHops           []NATHop       `json:"nat_hops,omitempty"`
 	Recommendations   []string       `json:"recommendations,omitempty"`
 }
 
@@ -110,59 +168,6 @@ func (tr *TracerouteResult) ToJSON() (string, error) {
 	return string(data), nil
 }
 
-// GetPaths extracts unique paths from the traceroute results
-func (tr *TracerouteResult) GetPaths() []Path {
-	paths := make([]Path, 0)
-
-	// Get all flow IDs from the first hop
-	var flowIDs []uint16
-	if firstHop, ok := tr.Hops[1]; ok {
-		for flowID := range firstHop.Flows {
-			flowIDs = append(flowIDs, flowID)
-		}
-	}
-
-	// Build a path for each flow
-	for pathID, flowID := range flowIDs {
-		path := Path{
-			PathID: pathID,
-			Hops:   make([]PathHop, 0),
-		}
-
-		// Collect all hops for this flow
-		for ttlInt := 1; ttlInt <= 255; ttlInt++ {
-			ttl := uint8(ttlInt)
-			hopResult, ok := tr.Hops[ttl]
-			if !ok {
-				break
-			}
-
-			flowResult, ok := hopResult.Flows[flowID]
-			if !ok || flowResult.Error != "" {
-				continue
-			}
-
-			pathHop := PathHop{
-				TTL:      ttl,
-				IP:       flowResult.ResponseIP,
-				Hostname: flowResult.Hostname,
-				RTT:      flowResult.RTT,
-			}
-
-			path.Hops = append(path.Hops, pathHop)
-
-			// Stop if we reached the target
-			if flowResult.ResponseIP == tr.Target {
-				break
-			}
-		}
-
-		paths = append(paths, path)
-	}
-
-	return paths
-}
-
 // PrintSummary prints a human-readable summary of the results
 func (tr *TracerouteResult) PrintSummary() {
 	fmt.Println("\n" + strings.Repeat("=", 80))
@@ -171,12 +176,16 @@ func (tr *TracerouteResult) PrintSummary() {
 	fmt.Printf("Target:   %s\n", tr.Target)
 	fmt.Printf("Source:   %s\n", tr.SrcIP)
 	fmt.Printf("Duration: %v\n", tr.Duration)
+	if tr.PortState != "" {
+		fmt.Printf("Port:     %s\n", tr.PortState)
+	}
 	fmt.Println()
 
 	// Get analysis
-	analysis := tr.AnalyzeNetwork()
+	graph := tr.Graph()
+	analysis := tr.analyze(graph)
 
-	paths := tr.GetPaths()
+	paths := graph.Paths()
 	fmt.Printf("Discovered %d unique path(s):\n", len(paths))
 	if len(paths) > 1 {
 		fmt.Printf("  ℹ️  Multiple paths detected - your traffic is load-balanced across %d routes\n", len(paths))
@@ -186,6 +195,10 @@ func (tr *TracerouteResult) PrintSummary() {
 	for _, path := range paths {
 		fmt.Printf("Path %d (%d hops):\n", path.PathID, len(path.Hops))
 		for _, hop := range path.Hops {
+			if hop.IP == AnonymousIP {
+				fmt.Printf("  %2d: *\n", hop.TTL)
+				continue
+			}
 			hostname := hop.IP
 			if hop.Hostname != "" {
 				hostname = fmt.Sprintf("%s (%s)", hop.Hostname, hop.IP)
@@ -223,6 +236,10 @@ func (tr *TracerouteResult) PrintSummary() {
 		fmt.Printf("  Min/Max Latency:   %v / %v\n", analysis.MinRTT, analysis.MaxRTT)
 	}
 	fmt.Printf("  Unique Routers:    %d\n", analysis.UniqueRouters)
+	if len(tr.LateReplies) > 0 || len(tr.UnmatchedReplies) > 0 {
+		fmt.Printf("  Late Replies:      %d (arrived after their probe timed out)\n", len(tr.LateReplies))
+		fmt.Printf("  Unmatched Replies: %d\n", len(tr.UnmatchedReplies))
+	}
 
 	// Print network analysis
 	tr.PrintNetworkAnalysis(analysis)
@@ -241,58 +258,28 @@ func (tr *TracerouteResult) GetHopCount() int {
 	return int(maxTTL)
 }
 
-// GetUniqueHosts returns a list of unique IP addresses seen in the trace
+// GetUniqueHosts returns the addresses of the routers seen in the trace
 func (tr *TracerouteResult) GetUniqueHosts() []string {
-	seen := make(map[string]bool)
-	hosts := make([]string, 0)
-
-	for _, hopResult := range tr.Hops {
-		for _, flowResult := range hopResult.Flows {
-			if flowResult.ResponseIP != "" && !seen[flowResult.ResponseIP] {
-				seen[flowResult.ResponseIP] = true
-				hosts = append(hosts, flowResult.ResponseIP)
-			}
-		}
-	}
-
-	return hosts
+	return tr.Graph().UniqueRouters()
 }
 
-// HasMultiplePaths checks if multiple paths were detected
+// HasMultiplePaths checks if the flows of the trace took different routes
 func (tr *TracerouteResult) HasMultiplePaths() bool {
-	paths := tr.GetPaths()
-
-	// Check if any two paths differ
-	if len(paths) <= 1 {
-		return false
-	}
+	return len(tr.Graph().Paths()) > 1
+}
 
-	for i := 0; i < len(paths)-1; i++ {
-		for j := i + 1; j < len(paths); j++ {
-			if !pathsEqual(paths[i], paths[j]) {
+// ReachedTarget reports whether the target answered any probe
+func (tr *TracerouteResult) ReachedTarget() bool {
+	for _, hopResult := range tr.Hops {
+		for _, flow := range hopResult.Flows {
+			if flow.Error == "" && flow.ResponseIP == tr.Target {
 				return true
 			}
 		}
 	}
-
 	return false
 }
 
-// pathsEqual compares two paths for equality
-func pathsEqual(p1, p2 Path) bool {
-	if len(p1.Hops) != len(p2.Hops) {
-		return false
-	}
-
-	for i := range p1.Hops {
-		if p1.Hops[i].IP != p2.Hops[i].IP {
-			return false
-		}
-	}
-
-	return true
-}
-
 // GetAverageRTT calculates the average RTT for a specific TTL
 func (tr *TracerouteResult) GetAverageRTT(ttl uint8) time.Duration {
 	hopResult, ok := tr.Hops[ttl]
@@ -323,11 +310,69 @@ func (tr *TracerouteResult) SaveToFile(filename string) error {
 	if err != nil {
 		return err
 	}
+	if err := os.WriteFile(filename, []byte(jsonData), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
+
+// FromJSON parses a trace saved by ToJSON and checks that its hops and
+// flows are consistent, so that a damaged or hand-edited file is rejected
+// rather than analyzed
+func FromJSON(data []byte) (*TracerouteResult, error) {
+	var tr TracerouteResult
+	if err := json.Unmarshal(data, &tr); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	if err := tr.validate(); err != nil {
+		return nil, fmt.Errorf("invalid trace: %w", err)
+	}
+	return &tr, nil
+}
 
-	// Note: Would need to import io/ioutil or os for actual file writing
-	// For now, return the JSON string
-	_ = jsonData
-	return fmt.Errorf("file saving not yet implemented")
+// LoadFromFile reads a trace saved by SaveToFile or -output-json
+func LoadFromFile(filename string) (*TracerouteResult, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	tr, err := FromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return tr, nil
+}
+
+// validate checks that every hop is stored under its TTL and every flow
+// under its FlowID
+func (tr *TracerouteResult) validate() error {
+	if tr.Target == "" {
+		return fmt.Errorf("no target")
+	}
+	if tr.Hops == nil {
+		return fmt.Errorf("no hops (not a trace saved with -output-json?)")
+	}
+	for ttl, hop := range tr.Hops {
+		switch {
+		case ttl == 0:
+			return fmt.Errorf("hop with TTL 0")
+		case hop == nil:
+			return fmt.Errorf("hop %d is empty", ttl)
+		case hop.TTL != ttl:
+			return fmt.Errorf("hop %d is stored under TTL %d", hop.TTL, ttl)
+		case hop.Flows == nil:
+			return fmt.Errorf("hop %d has no flows", ttl)
+		}
+		for id, flow := range hop.Flows {
+			if flow == nil {
+				return fmt.Errorf("hop %d: flow %d is empty", ttl, id)
+			}
+			if flow.FlowID != id {
+				return fmt.Errorf("hop %d: flow %d is stored under FlowID %d", ttl, flow.FlowID, id)
+			}
+		}
+	}
+	return nil
 }
 
 // CalculateHopStatistics computes per-hop statistics from multiple probe rounds
@@ -1,9 +1,67 @@
 package results
 
 import (
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDummyResults(t *testing.T) {
 	// Dummy test to verify test setup
 }
+
+func TestSaveAndLoadTrace(t *testing.T) {
+	tr := exportTrace()
+	tr.StartTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tr.EndTime = tr.StartTime.Add(3 * time.Second)
+	tr.Duration = tr.EndTime.Sub(tr.StartTime)
+
+	filename := filepath.Join(t.TempDir(), "trace.json")
+	if err := tr.SaveToFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFromFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, tr) {
+		t.Errorf("loaded trace differs from the saved one")
+	}
+
+	// The analysis of the loaded trace is that of the live one
+	if !reflect.DeepEqual(loaded.AnalyzeNetwork(), tr.AnalyzeNetwork()) {
+		t.Errorf("got analysis %+v, want %+v", loaded.AnalyzeNetwork(), tr.AnalyzeNetwork())
+	}
+}
+
+func TestFromJSONRejectsInconsistentTraces(t *testing.T) {
+	for _, tc := range []struct {
+		name, json, want string
+	}{
+		{"not JSON", `{"target": `, "failed to parse JSON"},
+		{"no target", `{"hops": {}}`, "no target"},
+		{"no hops", `{"target": "8.8.8.8", "ipv4": {}}`, "no hops"},
+		{"TTL 0", `{"target": "8.8.8.8", "hops": {"0": {"ttl": 0, "flows": {}}}}`, "TTL 0"},
+		{"null hop", `{"target": "8.8.8.8", "hops": {"1": null}}`, "hop 1 is empty"},
+		{"hop under another TTL", `{"target": "8.8.8.8", "hops": {"1": {"ttl": 2, "flows": {}}}}`, "hop 2 is stored under TTL 1"},
+		{"no flows", `{"target": "8.8.8.8", "hops": {"1": {"ttl": 1}}}`, "hop 1 has no flows"},
+		{"flow under another id", `{"target": "8.8.8.8", "hops": {"1": {"ttl": 1, "flows": {"0": {"flow_id": 3}}}}}`, "flow 3 is stored under FlowID 0"},
+		{"TTL out of range", `{"target": "8.8.8.8", "hops": {"300": {"ttl": 44, "flows": {}}}}`, "failed to parse JSON"},
+	} {
+		_, err := FromJSON([]byte(tc.json))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestReachedTarget(t *testing.T) {
+	if !trace(1, 1, [][]string{{"10.0.0.1", "8.8.8.8"}}).ReachedTarget() {
+		t.Error("trace reaching 8.8.8.8 not reached")
+	}
+	if trace(1, 1, [][]string{{"10.0.0.1", ""}}).ReachedTarget() {
+		t.Error("silent target reached")
+	}
+}